@@ -2,12 +2,22 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"Drop-Key/internal/blob"
 	"Drop-Key/internal/db"
+	custom_middleware "Drop-Key/internal/middleware"
 	"Drop-Key/internal/paste"
 	"Drop-Key/internal/router"
+	"Drop-Key/internal/signing"
+	"Drop-Key/internal/transparency"
 	"Drop-Key/internal/user"
 
 	"github.com/joho/godotenv"
@@ -18,24 +28,95 @@ func main() {
 	if err != nil {
 		slog.Error("ERROR Failed to get DSN from .env file.")
 	}
-	ctx := context.Background()
-	db, err := db.InitDB(ctx)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(ctx, os.Args[2:], os.Stdout); err != nil {
+			slog.Error("Error migrating database.", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+	var pasteRepo paste.PasteRepository
+	var userRepo user.UserRepository
+	var logRepo transparency.LogRepository
+	if db.Driver() == db.DriverMemory {
+		slog.Info("Keeping all data in memory, it is lost when the server stops.")
+		keyLog := transparency.NewInMemoryLogRepository()
+		pasteRepo = paste.NewInMemoryPasteRepository()
+		userRepo = user.NewInMemoryUserRepository(keyLog)
+		logRepo = keyLog
+	} else {
+		db, err := db.InitDB(ctx)
+		if err != nil {
+			slog.Error("Error initialising database.", "error", err)
+			os.Exit(1)
+		}
+		blobs, err := blob.LoadStore()
+		if err != nil {
+			slog.Error("Error configuring blob store.", "error", err)
+			os.Exit(1)
+		}
+		pasteRepo = paste.NewPasteRepository(db, blobs)
+		userRepo = user.NewUserRepository(db)
+		logRepo = transparency.NewLogRepository(db)
+	}
+	pasteConfig := paste.LoadConfig()
+	pasteService := paste.NewPasteService(pasteRepo, userRepo, pasteConfig)
+	userService := user.NewUserService(userRepo, user.NewInMemoryChallengeStore())
+	webAuthnService := user.NewWebAuthnService(userRepo, user.NewInMemoryChallengeStore(), user.LoadWebAuthnConfig())
+
+	signingKeys, err := signing.LoadKeySet()
 	if err != nil {
-		slog.Error("Error initialising database.", "error", err)
+		slog.Error("Error loading JWT signing keys.", "error", err)
+		os.Exit(1)
 	}
-	pasteRepo := paste.NewPasteRepository(db)
-	userRepo := user.NewUserRepository(db)
-	pasteService := paste.NewPasteService(pasteRepo, userRepo)
-	userService := user.NewUserService(userRepo)
+	tokenConfig := user.LoadTokenConfig()
+	tokenStore := user.NewInMemoryTokenStore()
+	tokenService := user.NewTokenService(userRepo, tokenStore, signingKeys, tokenConfig)
+	logService := transparency.NewLogService(logRepo, signingKeys)
 
 	pasteHandler := paste.NewPasteHandler(pasteService)
-	userHandler := user.NewUserHandler(userService)
+	userHandler := user.NewUserHandler(userService, tokenService, logService)
+	webAuthnHandler := user.NewWebAuthnHandler(webAuthnService, tokenService)
+	keysHandler := signing.NewKeysHandler(signingKeys)
+	logHandler := transparency.NewLogHandler(logService)
+
+	jwtAuth := custom_middleware.JwtAuth(custom_middleware.JwtConfig{
+		Keys:        signingKeys,
+		Issuer:      tokenConfig.Issuer,
+		Audience:    tokenConfig.Audience,
+		Revocations: tokenStore,
+	})
 
-	e := router.Router(pasteHandler, userHandler)
+	e := router.Router(pasteHandler, userHandler, webAuthnHandler, keysHandler, logHandler, jwtAuth, paste.BodyLimit(pasteConfig))
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8081"
 	}
-	e.Logger.Fatal(e.Start("0.0.0.0:" + port))
+
+	reaper := paste.NewReaper(pasteRepo, paste.LoadReaperConfig())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		reaper.Run(ctx)
+	}()
+
+	go func() {
+		if err := e.Start("0.0.0.0:" + port); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Error starting server.", "error", err)
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error shutting down server.", "error", err)
+	}
+	wg.Wait()
+	slog.Info("Server stopped.", "pastes_purged", reaper.Purged())
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"Drop-Key/internal/db"
+)
+
+// runMigrate runs the migrate subcommand against the database selected with
+// DB_DRIVER and DSN:
+//
+//	api migrate [up]   apply pending migrations
+//	api migrate down   roll back the migrations applied by the last up
+//	api migrate status list migrations and whether they are applied
+//	api migrate unlock release the lock left by a migration that crashed
+func runMigrate(ctx context.Context, args []string, out io.Writer) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up", "down", "status", "unlock":
+	default:
+		return fmt.Errorf("Unknown migrate command %q, expected up, down, status or unlock", command)
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("Unexpected arguments after migrate %s: %v", command, args[1:])
+	}
+	if db.Driver() == db.DriverMemory {
+		return fmt.Errorf("DB_DRIVER %q keeps no schema to migrate", db.DriverMemory)
+	}
+
+	database, err := db.Connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	switch command {
+	case "up":
+		group, err := db.Migrate(ctx, database)
+		if err != nil {
+			return err
+		}
+		if group.IsZero() {
+			fmt.Fprintln(out, "Database is up to date.")
+			return nil
+		}
+		fmt.Fprintf(out, "Applied %s.\n", group)
+	case "down":
+		group, err := db.Rollback(ctx, database)
+		if err != nil {
+			return err
+		}
+		if group.IsZero() {
+			fmt.Fprintln(out, "No migrations to roll back.")
+			return nil
+		}
+		fmt.Fprintf(out, "Rolled back %s.\n", group)
+	case "status":
+		migrator, err := db.NewMigrator(ctx, database)
+		if err != nil {
+			return err
+		}
+		migrations, err := migrator.MigrationsWithStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			status := "pending"
+			if migration.IsApplied() {
+				status = fmt.Sprintf("applied %s", migration.MigratedAt.UTC().Format("2006-01-02 15:04:05"))
+			}
+			fmt.Fprintf(out, "%s\t%s\n", migration, status)
+		}
+	case "unlock":
+		migrator, err := db.NewMigrator(ctx, database)
+		if err != nil {
+			return err
+		}
+		if err := migrator.Unlock(ctx); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "Released the migration lock.")
+	}
+	return nil
+}
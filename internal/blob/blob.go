@@ -0,0 +1,54 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// BlobStore keeps opaque content outside the relational database, addressed
+// by a key chosen by the caller. Keys are slash separated paths.
+type BlobStore interface {
+	// Put stores size bytes read from r under key, replacing any blob with
+	// that key. A blob is only visible once it was written completely.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get opens the blob stored under key, or returns ErrBlobNotFound. The
+	// caller closes the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key. Deleting a missing blob is
+	// not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// Blob stores selectable with BLOB_STORE.
+const (
+	StoreDatabase   = "database"
+	StoreFilesystem = "filesystem"
+	StoreS3         = "s3"
+)
+
+// LoadStore returns the blob store selected with BLOB_STORE, or nil for the
+// default "database", which keeps content inline in the relational database.
+// "filesystem" stores blobs under BLOB_DIR; "s3" stores them in the bucket
+// BLOB_S3_BUCKET of any S3-compatible service, see LoadS3Config.
+func LoadStore() (BlobStore, error) {
+	switch store := os.Getenv("BLOB_STORE"); store {
+	case "", StoreDatabase:
+		return nil, nil
+	case StoreFilesystem:
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "blobs"
+		}
+		return NewFileStore(dir)
+	case StoreS3:
+		config, err := LoadS3Config()
+		if err != nil {
+			return nil, err
+		}
+		return NewS3Store(config)
+	default:
+		return nil, fmt.Errorf("Unknown BLOB_STORE %q, expected %s, %s or %s", store, StoreDatabase, StoreFilesystem, StoreS3)
+	}
+}
@@ -0,0 +1,85 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"Drop-Key/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testStore runs the behaviour every BlobStore shares against store.
+func testStore(t *testing.T, store BlobStore) {
+	ctx := context.Background()
+	content := []byte{0x00, 0xff, 'c', 'i', 'p', 'h', 'e', 'r', '\n'}
+
+	read := func(key string) ([]byte, error) {
+		body, err := store.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return io.ReadAll(body)
+	}
+
+	assert.NoError(t, store.Put(ctx, "pastes/one/first", bytes.NewReader(content), int64(len(content))), "should store blob")
+	got, err := read("pastes/one/first")
+	assert.NoError(t, err, "should read blob")
+	assert.Equal(t, content, got, "should return the stored bytes")
+
+	assert.NoError(t, store.Put(ctx, "pastes/one/first", bytes.NewReader([]byte("replaced")), 8), "should replace blob")
+	got, err = read("pastes/one/first")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("replaced"), got)
+
+	assert.NoError(t, store.Put(ctx, "pastes/one/empty", bytes.NewReader(nil), 0), "should store an empty blob")
+	got, err = read("pastes/one/empty")
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+
+	_, err = read("pastes/missing")
+	assert.ErrorIs(t, err, utils.ErrBlobNotFound)
+
+	assert.NoError(t, store.Delete(ctx, "pastes/one/first"), "should delete blob")
+	_, err = read("pastes/one/first")
+	assert.ErrorIs(t, err, utils.ErrBlobNotFound, "should not read a deleted blob")
+	assert.NoError(t, store.Delete(ctx, "pastes/one/first"), "deleting twice should succeed")
+	got, err = read("pastes/one/empty")
+	assert.NoError(t, err, "should keep other blobs")
+	assert.Empty(t, got)
+
+	assert.ErrorIs(t, store.Put(ctx, "", bytes.NewReader(nil), 0), utils.ErrInvalidBlobKey)
+}
+
+func TestLoadStore(t *testing.T) {
+	t.Setenv("BLOB_STORE", "")
+	store, err := LoadStore()
+	assert.NoError(t, err)
+	assert.Nil(t, store, "should keep content in the database by default")
+
+	t.Setenv("BLOB_STORE", StoreFilesystem)
+	t.Setenv("BLOB_DIR", t.TempDir())
+	store, err = LoadStore()
+	assert.NoError(t, err)
+	assert.IsType(t, &fileStore{}, store)
+
+	t.Setenv("BLOB_STORE", StoreS3)
+	_, err = LoadStore()
+	assert.Error(t, err, "should require a bucket")
+	t.Setenv("BLOB_S3_ENDPOINT", "http://localhost:9000")
+	t.Setenv("BLOB_S3_BUCKET", "dropkey")
+	t.Setenv("BLOB_S3_ACCESS_KEY_ID", "access")
+	t.Setenv("BLOB_S3_SECRET_ACCESS_KEY", "secret")
+	store, err = LoadStore()
+	assert.NoError(t, err)
+	if assert.IsType(t, &s3Store{}, store) {
+		assert.Equal(t, "us-east-1", store.(*s3Store).config.Region, "should default the region")
+	}
+
+	t.Setenv("BLOB_STORE", "tape")
+	_, err = LoadStore()
+	assert.Error(t, err, "should reject unknown stores")
+}
@@ -0,0 +1,109 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"Drop-Key/internal/utils"
+)
+
+type fileStore struct {
+	dir string
+}
+
+// NewFileStore returns a blob store keeping every blob in its own file under
+// dir, which is created if missing.
+func NewFileStore(dir string) (*fileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("Error while creating blob directory, error: %w", err)
+	}
+	return &fileStore{
+		dir: dir,
+	}, nil
+}
+
+// path maps key to a file under the store directory, refusing keys that
+// would resolve outside of it.
+func (s *fileStore) path(key string) (string, error) {
+	name := filepath.FromSlash(key)
+	if key == "" || !filepath.IsLocal(name) {
+		return "", utils.ErrInvalidBlobKey
+	}
+	return filepath.Join(s.dir, name), nil
+}
+
+// Put writes the blob to a temporary file next to its destination and renames
+// it into place, so readers never see a partly written blob.
+func (s *fileStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	// Delete removes directories it empties, possibly between creating the
+	// directory and the file, so that is retried.
+	var file *os.File
+	for attempt := 0; ; attempt++ {
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return err
+		}
+		file, err = os.CreateTemp(filepath.Dir(path), ".tmp-*")
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) || attempt == 2 {
+			return err
+		}
+	}
+	defer os.Remove(file.Name())
+
+	n, err := io.Copy(file, r)
+	if err == nil && n != size {
+		err = utils.ErrBlobSizeInvalid
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func (s *fileStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, utils.ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Delete removes the blob file, and its directory once that is empty.
+func (s *fileStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != filepath.Clean(s.dir) {
+		// Fails, as intended, while other blobs are left in the directory.
+		_ = os.Remove(dir)
+	}
+	return nil
+}
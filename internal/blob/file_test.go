@@ -0,0 +1,35 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"Drop-Key/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(filepath.Join(dir, "blobs"))
+	if !assert.NoError(t, err, "should create the blob directory") {
+		return
+	}
+	testStore(t, store)
+
+	ctx := context.Background()
+	for _, key := range []string{"../escape", "/etc/passwd", "pastes/../../escape"} {
+		assert.ErrorIs(t, store.Put(ctx, key, bytes.NewReader([]byte("x")), 1), utils.ErrInvalidBlobKey, "should refuse key %q", key)
+	}
+	assert.ErrorIs(t, store.Put(ctx, "short", bytes.NewReader([]byte("x")), 2), utils.ErrBlobSizeInvalid, "should refuse a truncated body")
+	_, err = store.Get(ctx, "short")
+	assert.ErrorIs(t, err, utils.ErrBlobNotFound, "should not keep a truncated blob")
+
+	assert.NoError(t, store.Delete(ctx, "pastes/one/empty"))
+	entries, err := os.ReadDir(filepath.Join(dir, "blobs", "pastes"))
+	assert.NoError(t, err)
+	assert.Empty(t, entries, "should remove directories left empty and temporary files")
+}
@@ -0,0 +1,245 @@
+package blob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"Drop-Key/internal/utils"
+)
+
+// S3Config addresses a bucket on Amazon S3 or any service speaking its API,
+// such as MinIO or Ceph.
+type S3Config struct {
+	// Endpoint is the base URL of the service, for example
+	// https://s3.eu-central-1.amazonaws.com. Buckets are addressed by path,
+	// which every S3-compatible service supports.
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// LoadS3Config reads BLOB_S3_ENDPOINT, BLOB_S3_REGION (default us-east-1),
+// BLOB_S3_BUCKET, BLOB_S3_ACCESS_KEY_ID and BLOB_S3_SECRET_ACCESS_KEY.
+func LoadS3Config() (S3Config, error) {
+	config := S3Config{
+		Endpoint:        os.Getenv("BLOB_S3_ENDPOINT"),
+		Region:          os.Getenv("BLOB_S3_REGION"),
+		Bucket:          os.Getenv("BLOB_S3_BUCKET"),
+		AccessKeyID:     os.Getenv("BLOB_S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("BLOB_S3_SECRET_ACCESS_KEY"),
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Endpoint == "" || config.Bucket == "" || config.AccessKeyID == "" || config.SecretAccessKey == "" {
+		return S3Config{}, fmt.Errorf("BLOB_STORE %s needs BLOB_S3_ENDPOINT, BLOB_S3_BUCKET, BLOB_S3_ACCESS_KEY_ID and BLOB_S3_SECRET_ACCESS_KEY", StoreS3)
+	}
+	return config, nil
+}
+
+type s3Store struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3Store returns a blob store keeping every blob as an object in the
+// configured bucket, which has to exist.
+func NewS3Store(config S3Config) (*s3Store, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(config.Endpoint, "/"))
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("Invalid S3 endpoint %q, expected an http or https URL", config.Endpoint)
+	}
+	return &s3Store{
+		config:   config,
+		endpoint: endpoint,
+		client:   http.DefaultClient,
+		now:      time.Now,
+	}, nil
+}
+
+// Put uploads the blob in a single request. The body is streamed, so it is
+// not part of the signature; TLS protects it in transit.
+func (s *s3Store) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if size == 0 {
+		// Any other body of length 0 would be sent chunked.
+		r = http.NoBody
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, r, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(http.MethodPut, key, resp)
+	}
+	return nil
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, utils.ErrBlobNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, s3Error(http.MethodGet, key, resp)
+	}
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return s3Error(http.MethodDelete, key, resp)
+	}
+}
+
+// do sends a signed request for the object stored under key.
+func (s *s3Store) do(ctx context.Context, method, key string, body io.Reader, size int64) (*http.Response, error) {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return nil, utils.ErrInvalidBlobKey
+	}
+	objectURL := *s.endpoint
+	objectURL.Path = s.endpoint.Path + "/" + s.config.Bucket + "/" + key
+	objectURL.RawPath = uriEncode(objectURL.Path, false)
+
+	req, err := http.NewRequestWithContext(ctx, method, objectURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	signV4(req, unsignedPayload, s.config.AccessKeyID, s.config.SecretAccessKey, s.config.Region, "s3", s.now())
+	return s.client.Do(req)
+}
+
+func s3Error(method, key string, resp *http.Response) error {
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("S3 %s of blob %s failed with %s: %s", method, key, resp.Status, strings.TrimSpace(string(message)))
+}
+
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// signV4 adds an AWS Signature Version 4 Authorization header to req, which
+// signs the host and every header already set on req. payloadHash is the hex
+// encoded SHA-256 of the body, or UNSIGNED-PAYLOAD.
+func signV4(req *http.Request, payloadHash, accessKeyID, secretAccessKey, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	scope := amzDate[:8] + "/" + region + "/" + service + "/aws4_request"
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := []byte("AWS4" + secretAccessKey)
+	for _, part := range []string{amzDate[:8], region, service, "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKeyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes every byte of s except the unreserved characters
+// of RFC 3986, and slashes unless encodeSlash is set, as Signature Version 4
+// requires.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -6,54 +6,137 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
-
-	"Drop-Key/internal/models"
+	"strings"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/dialect/mysqldialect"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+	_ "github.com/uptrace/bun/driver/pgdriver"
+	"github.com/uptrace/bun/schema"
+	_ "modernc.org/sqlite"
 )
 
-func InitDB(ctx context.Context) (*bun.DB, error) {
+// Database drivers selectable with DB_DRIVER.
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+	// DriverMemory keeps everything in memory and opens no database; the
+	// caller uses in-memory repositories instead of calling InitDB.
+	DriverMemory = "memory"
+
+	DefaultSQLiteDSN = "file:dropkey.db"
+)
+
+// Driver returns the database driver selected with DB_DRIVER, MySQL by
+// default.
+func Driver() string {
+	if driver := os.Getenv("DB_DRIVER"); driver != "" {
+		return driver
+	}
+	return DriverMySQL
+}
+
+// sqliteDSN adds the connection settings DropKey relies on to a SQLite DSN,
+// unless it sets them itself: writers wait for each other instead of failing
+// with SQLITE_BUSY, transactions take the write lock up front so a read
+// followed by a write cannot deadlock, and readers do not block the writer.
+func sqliteDSN(dsn string) string {
+	var params []string
+	if !strings.Contains(dsn, "busy_timeout") {
+		params = append(params, "_pragma=busy_timeout(5000)")
+	}
+	if !strings.Contains(dsn, "journal_mode") {
+		params = append(params, "_pragma=journal_mode(WAL)")
+	}
+	if !strings.Contains(dsn, "_txlock") {
+		params = append(params, "_txlock=immediate")
+	}
+	if len(params) == 0 {
+		return dsn
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + strings.Join(params, "&")
+}
+
+// Open returns a connection pool to the database of driver at dsn without
+// connecting to it.
+func Open(driver, dsn string) (*bun.DB, error) {
+	var sqldb *sql.DB
+	var sqlDialect schema.Dialect
+	var err error
+	switch driver {
+	case DriverMySQL:
+		sqldb, err = sql.Open("mysql", dsn)
+		sqlDialect = mysqldialect.New()
+	case DriverPostgres:
+		sqldb, err = sql.Open("pg", dsn)
+		sqlDialect = pgdialect.New()
+	case DriverSQLite:
+		sqldb, err = sql.Open("sqlite", sqliteDSN(dsn))
+		sqlDialect = sqlitedialect.New()
+	default:
+		slog.Error("Unsupported database driver.", "driver", driver)
+		return nil, fmt.Errorf("Unsupported DB_DRIVER %q, expected %q, %q or %q", driver, DriverMySQL, DriverPostgres, DriverSQLite)
+	}
+	if err != nil {
+		slog.Error("Failed to open database connection: ", "driver", driver, "error", err)
+		return nil, fmt.Errorf("Error while opening %s connection, error: %w", driver, err)
+	}
+
+	// setting connection pool
+	db := bun.NewDB(sqldb, sqlDialect)
+	db.SetMaxIdleConns(10)
+	db.SetMaxOpenConns(100)
+	return db, nil
+}
+
+// Connect opens and pings the database selected with DB_DRIVER and DSN,
+// without migrating it.
+func Connect(ctx context.Context) (*bun.DB, error) {
 	// Setup Connection to database
+	driver := Driver()
 	DSN := os.Getenv("DSN")
+	if DSN == "" && driver == DriverSQLite {
+		DSN = DefaultSQLiteDSN
+	}
 	if DSN == "" {
 		slog.Error("Failed to get DSN from .env file.")
 		return nil, fmt.Errorf("Failed to get DSN from .env file")
 	}
-	sqldb, err := sql.Open("mysql", DSN)
+
+	db, err := Open(driver, DSN)
 	if err != nil {
-		slog.Error("Failed to open MySQL connection: ", "error", err)
-		return nil, fmt.Errorf("Error while opening MySQL connection, error: %w", err)
+		return nil, err
 	}
 
 	// ping database for verification
-	err = sqldb.PingContext(ctx)
+	err = db.PingContext(ctx)
 	if err != nil {
 		slog.Error("Connection to database failed.", "error", err)
 		return nil, fmt.Errorf("Error while connecting to database, error: %w", err)
 	}
+	return db, nil
+}
 
-	// setting connection pool
-	var db *bun.DB
-	db = bun.NewDB(sqldb, mysqldialect.New())
-	db.SetMaxIdleConns(10)
-	db.SetMaxOpenConns(100)
-
-	// Initialise Database tables
-	var paste models.Paste
-	_, err = db.NewCreateTable().Model(&paste).IfNotExists().Exec(ctx)
+// InitDB connects to the database and applies pending schema migrations,
+// unless DB_AUTO_MIGRATE is false and they are left to the migrate command.
+func InitDB(ctx context.Context) (*bun.DB, error) {
+	db, err := Connect(ctx)
 	if err != nil {
-		slog.Error("Error while creating Paste table", "table", "paste", "error", err)
-		return nil, fmt.Errorf("Error while creating paste table, error %w", err)
+		return nil, err
 	}
-
-	var user models.User
-	_, err = db.NewCreateTable().Model(&user).IfNotExists().Exec(ctx)
-	if err != nil {
-		slog.Error("Error while creating User table", "table", "user", "error", err)
-		return nil, fmt.Errorf("Error while creating users table, error %w", err)
+	if os.Getenv("DB_AUTO_MIGRATE") == "false" {
+		return db, nil
+	}
+	if _, err := Migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, err
 	}
-
 	return db, nil
 }
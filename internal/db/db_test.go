@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"Drop-Key/internal/db/dbtest"
 	"Drop-Key/internal/models"
 
 	"github.com/stretchr/testify/assert"
@@ -19,8 +20,8 @@ func TestInitDB(t *testing.T) {
 
 	// Test case 1: Successful database initialization
 	t.Run("successful initialization", func(t *testing.T) {
-		// Set the DSN for your test database (update credentials if needed)
-		os.Setenv("DSN", "testuser:testpass@tcp(localhost:3306)/testdb?parseTime=true")
+		// Use the test database, SQLite unless TEST_DSN is set
+		dbtest.Setenv(t)
 
 		// Call InitDB
 		db, err := InitDB(ctx)
@@ -50,10 +51,7 @@ func TestInitDB(t *testing.T) {
 		assert.NoError(t, err, "should insert a paste without error")
 
 		// Clean up: Drop tables to reset for the next test
-		_, err = db.NewDropTable().Model(paste).IfExists().Exec(ctx)
-		assert.NoError(t, err, "should drop Paste table")
-		_, err = db.NewDropTable().Model(user).IfExists().Exec(ctx)
-		assert.NoError(t, err, "should drop User table")
+		dbtest.Reset(t, db)
 		// Close the database connection
 		err = db.Close()
 		assert.NoError(t, err, "should close database connection")
@@ -74,7 +72,24 @@ func TestInitDB(t *testing.T) {
 		assert.Nil(t, db, "should return nil database object on error")
 	})
 
-	// Test case 3: Invalid DSN
+	// Test case 3: Unsupported driver
+	t.Run("unsupported driver", func(t *testing.T) {
+		t.Setenv("DB_DRIVER", "oracle")
+		os.Setenv("DSN", "testuser:testpass@tcp(localhost:3306)/testdb?parseTime=true")
+
+		db, err := InitDB(ctx)
+		assert.Error(t, err, "should return an error for an unknown driver")
+		assert.Contains(t, err.Error(), "Unsupported DB_DRIVER", "error should name the setting")
+		assert.Nil(t, db, "should return nil database object on error")
+	})
+
+	// Test case 4: SQLite connection settings
+	t.Run("sqlite dsn", func(t *testing.T) {
+		assert.Equal(t, "file:dropkey.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate", sqliteDSN("file:dropkey.db"))
+		assert.Equal(t, "file:dropkey.db?mode=ro&_pragma=journal_mode(DELETE)&_pragma=busy_timeout(5000)&_txlock=immediate", sqliteDSN("file:dropkey.db?mode=ro&_pragma=journal_mode(DELETE)"), "should keep settings the DSN makes itself")
+	})
+
+	// Test case 5: Invalid DSN
 	t.Run("invalid DSN", func(t *testing.T) {
 		// Set an invalid DSN
 		os.Setenv("DSN", "invalid:dsn@tcp(wronghost)/testdb")
@@ -89,7 +104,7 @@ func TestInitDB(t *testing.T) {
 		assert.Nil(t, db, "should return nil database object on error")
 	})
 
-	// Test case 4: Connection failure
+	// Test case 6: Connection failure
 	t.Run("connection failure", func(t *testing.T) {
 		// Set a DSN with wrong credentials
 		os.Setenv("DSN", "wronguser:wrongpass@tcp(localhost:3306)/testdb?parseTime=true")
@@ -0,0 +1,42 @@
+package dbtest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+)
+
+// baseline is the schema the last release before migrations created from its
+// models, as that release's bun would have created it on each dialect.
+var baseline = map[dialect.Name][]string{
+	dialect.MySQL: {
+		"CREATE TABLE `pastes` (`id` VARCHAR(255) NOT NULL, `ciphertext` MEDIUMTEXT NOT NULL, `signature` VARCHAR(255) NOT NULL, `public_key` VARCHAR(255) NOT NULL, `expires_at` DATETIME NOT NULL, PRIMARY KEY (`id`))",
+		"CREATE TABLE `users` (`id` VARCHAR(255) NOT NULL, `public_key` VARCHAR(255) NOT NULL, PRIMARY KEY (`id`), UNIQUE (`public_key`))",
+	},
+	dialect.PG: {
+		`CREATE TABLE "pastes" ("id" VARCHAR NOT NULL, "ciphertext" TEXT NOT NULL, "signature" VARCHAR NOT NULL, "public_key" VARCHAR NOT NULL, "expires_at" TIMESTAMPTZ NOT NULL, PRIMARY KEY ("id"))`,
+		`CREATE TABLE "users" ("id" VARCHAR NOT NULL, "public_key" VARCHAR NOT NULL, PRIMARY KEY ("id"), UNIQUE ("public_key"))`,
+	},
+	dialect.SQLite: {
+		`CREATE TABLE "pastes" ("id" VARCHAR NOT NULL, "ciphertext" TEXT NOT NULL, "signature" VARCHAR NOT NULL, "public_key" VARCHAR NOT NULL, "expires_at" TIMESTAMP NOT NULL, PRIMARY KEY ("id"))`,
+		`CREATE TABLE "users" ("id" VARCHAR NOT NULL, "public_key" VARCHAR NOT NULL, PRIMARY KEY ("id"), UNIQUE ("public_key"))`,
+	},
+}
+
+// CreateBaseline creates the tables of a database set up by the last release
+// before migrations, without going through them, for tests upgrading such a
+// database.
+func CreateBaseline(t testing.TB, db *bun.DB) {
+	t.Helper()
+	statements, ok := baseline[db.Dialect().Name()]
+	if !ok {
+		t.Fatalf("no baseline schema for dialect %s", db.Dialect().Name())
+	}
+	for _, statement := range statements {
+		if _, err := db.ExecContext(context.Background(), statement); err != nil {
+			t.Fatalf("should create baseline table: %v", err)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+	"github.com/uptrace/bun/migrate"
+)
+
+// migrationFiles holds the SQL migrations of every dialect, in a directory
+// named after its driver. Files are named NNNN_description.up.sql and
+// NNNN_description.down.sql, with .tx before .up or .down to run the file in
+// a transaction; statements are separated by --bun:split lines. Applied
+// migrations must never be edited, schema changes go into a new migration for
+// every dialect.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// How long Migrate waits for a migration lock held by another server, and
+// how often it checks whether the lock was released.
+var (
+	lockTimeout      = 2 * time.Minute
+	lockPollInterval = time.Second
+)
+
+// goMigrations are the migrations registered with RegisterMigration.
+var (
+	goMigrationsMu sync.Mutex
+	goMigrations   []migrate.Migration
+)
+
+// RegisterMigration adds a migration written in Go, for data changes SQL
+// cannot express, to the migrations of every dialect. It is called from the
+// init function of the package whose code the migration needs, so the
+// migration is part of every binary linking that package; name is the
+// version it runs at among the SQL migrations. up and down run outside a
+// transaction unless they start one.
+func RegisterMigration(name, comment string, up, down migrate.MigrationFunc) {
+	goMigrationsMu.Lock()
+	defer goMigrationsMu.Unlock()
+	goMigrations = append(goMigrations, migrate.Migration{
+		Name:    name,
+		Comment: comment,
+		Up: func(ctx context.Context, db *bun.DB, _ any) error {
+			return up(ctx, db)
+		},
+		Down: func(ctx context.Context, db *bun.DB, _ any) error {
+			return down(ctx, db)
+		},
+	})
+}
+
+// Migrations returns the schema migrations for the dialect of db, followed
+// by the registered Go migrations.
+func Migrations(db *bun.DB) (*migrate.Migrations, error) {
+	var driver string
+	switch db.Dialect().Name() {
+	case dialect.MySQL:
+		driver = DriverMySQL
+	case dialect.PG:
+		driver = DriverPostgres
+	case dialect.SQLite:
+		driver = DriverSQLite
+	default:
+		return nil, fmt.Errorf("No migrations for dialect %s", db.Dialect().Name())
+	}
+	files, err := fs.Sub(migrationFiles, "migrations/"+driver)
+	if err != nil {
+		return nil, err
+	}
+	migrations := migrate.NewMigrations()
+	if err := migrations.Discover(files); err != nil {
+		return nil, fmt.Errorf("Error while loading %s migrations, error: %w", driver, err)
+	}
+	goMigrationsMu.Lock()
+	defer goMigrationsMu.Unlock()
+	for _, migration := range goMigrations {
+		for _, existing := range migrations.Sorted() {
+			if existing.Name == migration.Name {
+				return nil, fmt.Errorf("Migration %s_%s has the version of %s", migration.Name, migration.Comment, existing)
+			}
+		}
+		migrations.Add(migration)
+	}
+	return migrations, nil
+}
+
+// NewMigrator returns a migrator for the schema of db, recording applied
+// migrations in the bun_migrations table. A migration is only recorded once
+// it succeeded, so one that failed is retried by the next run.
+func NewMigrator(ctx context.Context, db *bun.DB) (*migrate.Migrator, error) {
+	migrations, err := Migrations(db)
+	if err != nil {
+		return nil, err
+	}
+	migrator := migrate.NewMigrator(db, migrations, migrate.WithMarkAppliedOnSuccess(true))
+	if err := migrator.Init(ctx); err != nil {
+		return nil, fmt.Errorf("Error while creating migration tables, error: %w", err)
+	}
+	return migrator, nil
+}
+
+// Migrate applies every pending migration to db and returns them.
+func Migrate(ctx context.Context, db *bun.DB) (*migrate.MigrationGroup, error) {
+	migrator, err := NewMigrator(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	var group *migrate.MigrationGroup
+	err = withLock(ctx, migrator, func() error {
+		group, err = migrator.Migrate(ctx)
+		return err
+	})
+	if err != nil {
+		slog.Error("Error while migrating database", "error", err)
+		return group, fmt.Errorf("Error while migrating database, error: %w", err)
+	}
+	if !group.IsZero() {
+		slog.Info("Migrated database.", "migrations", group.Migrations.String())
+	}
+	return group, nil
+}
+
+// Rollback reverts the migrations applied by the last Migrate and returns
+// them. The pastes and users tables of migration 0001 are kept, since they
+// may have been adopted from a database older than the migrations.
+func Rollback(ctx context.Context, db *bun.DB) (*migrate.MigrationGroup, error) {
+	migrator, err := NewMigrator(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	var group *migrate.MigrationGroup
+	err = withLock(ctx, migrator, func() error {
+		group, err = migrator.Rollback(ctx)
+		return err
+	})
+	if err != nil {
+		slog.Error("Error while rolling back database migrations", "error", err)
+		return group, fmt.Errorf("Error while rolling back migrations, error: %w", err)
+	}
+	if !group.IsZero() {
+		slog.Info("Rolled back database migrations.", "migrations", group.Migrations.String())
+	}
+	return group, nil
+}
+
+// withLock runs fn while holding the migration lock. Servers started
+// together take turns: the first one migrates and the others wait for its
+// lock, then find nothing left to do. A lock left behind by a migration that
+// crashed has to be released with the migrate unlock command.
+func withLock(ctx context.Context, migrator *migrate.Migrator, fn func() error) error {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		err := migrator.Lock(ctx)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w, if no migration is running release the lock with the migrate unlock command", err)
+		}
+		slog.Info("Waiting for another server to finish migrating the database.")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+	defer func() {
+		if err := migrator.Unlock(context.WithoutCancel(ctx)); err != nil {
+			slog.Error("Error while releasing migration lock", "error", err)
+		}
+	}()
+	return fn()
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"Drop-Key/internal/db/dbtest"
+	"Drop-Key/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// schemaModels are the models stored by the database repositories.
+var schemaModels = []any{
+	(*models.Paste)(nil),
+	(*models.PasteRevision)(nil),
+	(*models.PasteRecipient)(nil),
+	(*models.User)(nil),
+	(*models.UserKey)(nil),
+	(*models.DeviceKey)(nil),
+	(*models.EncryptionKey)(nil),
+	(*models.Passkey)(nil),
+	(*models.LogEntry)(nil),
+}
+
+func TestMigrate(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		db, err := Connect(ctx)
+		if !assert.NoError(t, err, "should connect to database") {
+			return
+		}
+		defer db.Close()
+		defer dbtest.Reset(t, db)
+
+		group, err := Migrate(ctx, db)
+		assert.NoError(t, err, "should migrate an empty database")
+		assert.False(t, group.IsZero(), "should apply the migrations")
+		// Selecting a model names every column it has, so this fails when
+		// a model gains a column without a migration adding it.
+		for _, model := range schemaModels {
+			_, err := db.NewSelect().Model(model).Limit(1).Exec(ctx)
+			assert.NoError(t, err, "migrated schema should have every column of %T", model)
+		}
+
+		group, err = Migrate(ctx, db)
+		assert.NoError(t, err, "should migrate again")
+		assert.True(t, group.IsZero(), "should have nothing left to apply")
+
+		group, err = Rollback(ctx, db)
+		assert.NoError(t, err, "should roll back")
+		assert.False(t, group.IsZero(), "should roll back the migrations")
+		_, err = db.NewSelect().Model((*models.Paste)(nil)).Limit(1).Exec(ctx)
+		assert.Error(t, err, "rolling back should revert the schema")
+
+		_, err = Migrate(ctx, db)
+		assert.NoError(t, err, "should migrate after a rollback")
+	})
+}
+
+// Databases set up by the last release before migrations, which created
+// the pastes and users tables from its models at startup, are brought up to
+// date by the migrations.
+func TestMigrateExistingSchema(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		db, err := Connect(ctx)
+		if !assert.NoError(t, err, "should connect to database") {
+			return
+		}
+		defer db.Close()
+		defer dbtest.Reset(t, db)
+
+		dbtest.CreateBaseline(t, db)
+		_, err = db.ExecContext(ctx, "INSERT INTO users (id, public_key) VALUES (?, ?)", "existing", "existing_key")
+		assert.NoError(t, err, "should insert a user")
+		_, err = db.ExecContext(ctx, "INSERT INTO pastes (id, ciphertext, signature, public_key, expires_at) VALUES (?, ?, ?, ?, ?)",
+			"existing", "ciphertext", "signature", "existing_key", time.Now().UTC().Add(time.Hour))
+		assert.NoError(t, err, "should insert a paste")
+
+		_, err = Migrate(ctx, db)
+		if !assert.NoError(t, err, "should migrate an existing database") {
+			return
+		}
+		for _, model := range schemaModels {
+			_, err := db.NewSelect().Model(model).Limit(1).Exec(ctx)
+			assert.NoError(t, err, "migrated schema should have every column of %T", model)
+		}
+
+		var user models.User
+		err = db.NewSelect().Model(&user).Where("id = ?", "existing").Scan(ctx)
+		assert.NoError(t, err, "should keep existing users")
+		assert.Equal(t, "ed25519", user.Algorithm, "existing users should get the default algorithm")
+		var paste models.Paste
+		err = db.NewSelect().Model(&paste).Where("id = ?", "existing").Scan(ctx)
+		assert.NoError(t, err, "should keep existing pastes")
+		assert.Equal(t, "ciphertext", paste.Ciphertext)
+		assert.Equal(t, "ed25519", paste.Algorithm, "existing pastes should get the default algorithm")
+		assert.Equal(t, 1, paste.SignatureVersion, "existing pastes should keep the v1 signature format")
+		assert.Equal(t, 1, paste.Revision)
+		assert.False(t, paste.BurnAfterRead)
+
+		group, err := Rollback(ctx, db)
+		assert.NoError(t, err, "should roll back")
+		assert.False(t, group.IsZero())
+		// The adopted tables are rolled back together with the migrations
+		// that came after them, but have to keep the data they had before.
+		var count int
+		err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE id = ?", "existing").Scan(&count)
+		assert.NoError(t, err, "rolling back should keep the users table")
+		assert.Equal(t, 1, count, "rolling back should keep existing users")
+		err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pastes WHERE id = ?", "existing").Scan(&count)
+		assert.NoError(t, err, "rolling back should keep the pastes table")
+		assert.Equal(t, 1, count, "rolling back should keep existing pastes")
+
+		_, err = Migrate(ctx, db)
+		assert.NoError(t, err, "should migrate after a rollback")
+		err = db.NewSelect().Model(&paste).Where("id = ?", "existing").Scan(ctx)
+		assert.NoError(t, err, "should keep existing pastes after migrating again")
+	})
+}
+
+func TestMigrateLock(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		db, err := Connect(ctx)
+		if !assert.NoError(t, err, "should connect to database") {
+			return
+		}
+		defer db.Close()
+		defer dbtest.Reset(t, db)
+
+		timeout, interval := lockTimeout, lockPollInterval
+		lockTimeout, lockPollInterval = 50*time.Millisecond, 10*time.Millisecond
+		defer func() { lockTimeout, lockPollInterval = timeout, interval }()
+
+		migrator, err := NewMigrator(ctx, db)
+		assert.NoError(t, err, "should create migrator")
+		assert.NoError(t, migrator.Lock(ctx), "should take the lock")
+		_, err = Migrate(ctx, db)
+		if assert.Error(t, err, "should not migrate while another server holds the lock") {
+			assert.Contains(t, err.Error(), "unlock")
+		}
+		assert.NoError(t, migrator.Unlock(ctx), "should release the lock")
+
+		lockTimeout = 10 * time.Second
+		var wg sync.WaitGroup
+		errs := make(chan error, 3)
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := Migrate(ctx, db)
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			assert.NoError(t, err, "concurrent servers should take turns migrating")
+		}
+		migrations, err := Migrations(db)
+		assert.NoError(t, err)
+		applied, err := migrator.AppliedMigrations(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, applied, len(migrations.Sorted()), "should apply every migration once")
+	})
+}
+
+// Every dialect has to be migrated through the same versions, each of which
+// can be rolled back. The baseline 0001 has no down migration, since its
+// tables may hold data from before migrations.
+func TestMigrationFiles(t *testing.T) {
+	versions := make(map[string][]string)
+	for _, driver := range []string{DriverMySQL, DriverPostgres, DriverSQLite} {
+		files, err := fs.ReadDir(migrationFiles, "migrations/"+driver)
+		if !assert.NoError(t, err, "should have %s migrations", driver) {
+			continue
+		}
+		for _, file := range files {
+			if strings.HasPrefix(file.Name(), "0001_") {
+				assert.NotContains(t, file.Name(), ".down.", "%s baseline should not be rolled back", driver)
+				continue
+			}
+			name := strings.TrimSuffix(file.Name(), ".sql")
+			name = strings.TrimSuffix(strings.TrimSuffix(name, ".up"), ".down")
+			versions[driver] = append(versions[driver], strings.TrimSuffix(name, ".tx"))
+		}
+		sort.Strings(versions[driver])
+		for i := 0; i < len(versions[driver]); i += 2 {
+			if assert.Less(t, i+1, len(versions[driver]), "%s migrations should come in pairs", driver) {
+				assert.Equal(t, versions[driver][i], versions[driver][i+1], "%s migration should have up and down files", driver)
+			}
+		}
+	}
+	assert.Equal(t, versions[DriverMySQL], versions[DriverPostgres], "postgres should have the mysql migrations")
+	assert.Equal(t, versions[DriverMySQL], versions[DriverSQLite], "sqlite should have the mysql migrations")
+}
@@ -0,0 +1,47 @@
+DROP TABLE `log_entries`;
+
+--bun:split
+
+DROP TABLE `passkeys`;
+
+--bun:split
+
+DROP TABLE `encryption_keys`;
+
+--bun:split
+
+DROP TABLE `device_keys`;
+
+--bun:split
+
+DROP TABLE `user_keys`;
+
+--bun:split
+
+DROP TABLE `paste_recipients`;
+
+--bun:split
+
+DROP TABLE `paste_revisions`;
+
+--bun:split
+
+ALTER TABLE `users`
+    DROP COLUMN `fingerprint`,
+    DROP COLUMN `algorithm`;
+
+--bun:split
+
+ALTER TABLE `pastes`
+    DROP COLUMN `delete_signature`,
+    DROP COLUMN `deleted_at`,
+    DROP COLUMN `tombstone`,
+    DROP COLUMN `views`,
+    DROP COLUMN `max_views`,
+    DROP COLUMN `burn_after_read`,
+    DROP COLUMN `revision`,
+    DROP COLUMN `nonce`,
+    DROP COLUMN `signature_version`,
+    DROP COLUMN `device_id`,
+    DROP COLUMN `user_id`,
+    DROP COLUMN `algorithm`;
@@ -0,0 +1,124 @@
+-- Everything DropKey gained between its last release without migrations
+-- and the introduction of migrations: paste signatures v2, view limits,
+-- tombstones, revisions and recipients, and key algorithms, fingerprints,
+-- key history, devices, encryption keys, passkeys and the key transparency
+-- log. The fingerprints and log entries of existing users are backfilled by
+-- the Go migration that follows.
+
+ALTER TABLE `pastes`
+    ADD COLUMN `algorithm` VARCHAR(255) NOT NULL DEFAULT 'ed25519',
+    ADD COLUMN `user_id` VARCHAR(255),
+    ADD COLUMN `device_id` VARCHAR(255),
+    ADD COLUMN `signature_version` BIGINT NOT NULL DEFAULT 1,
+    ADD COLUMN `nonce` VARCHAR(255),
+    ADD COLUMN `revision` BIGINT NOT NULL DEFAULT 1,
+    ADD COLUMN `burn_after_read` BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN `max_views` BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN `views` BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN `tombstone` VARCHAR(255),
+    ADD COLUMN `deleted_at` DATETIME,
+    ADD COLUMN `delete_signature` VARCHAR(255),
+    ADD UNIQUE (`nonce`);
+
+--bun:split
+
+ALTER TABLE `users`
+    ADD COLUMN `algorithm` VARCHAR(255) NOT NULL DEFAULT 'ed25519',
+    ADD COLUMN `fingerprint` VARCHAR(255),
+    ADD UNIQUE (`fingerprint`);
+
+--bun:split
+
+CREATE TABLE `paste_revisions` (
+    `paste_id` VARCHAR(255) NOT NULL,
+    `revision` BIGINT NOT NULL,
+    `ciphertext` MEDIUMTEXT NOT NULL,
+    `signature` VARCHAR(255) NOT NULL,
+    `signature_version` BIGINT NOT NULL DEFAULT 1,
+    `nonce` VARCHAR(255),
+    `public_key` VARCHAR(255) NOT NULL,
+    `algorithm` VARCHAR(255) NOT NULL DEFAULT 'ed25519',
+    `expires_at` DATETIME NOT NULL,
+    `replaced_at` DATETIME NOT NULL,
+    PRIMARY KEY (`paste_id`, `revision`)
+);
+
+--bun:split
+
+CREATE TABLE `paste_recipients` (
+    `paste_id` VARCHAR(255) NOT NULL,
+    `public_key` VARCHAR(255) NOT NULL,
+    `user_id` VARCHAR(255) NOT NULL,
+    `envelope` TEXT NOT NULL,
+    `received_at` DATETIME NOT NULL,
+    `read_at` DATETIME,
+    PRIMARY KEY (`paste_id`, `public_key`)
+);
+
+--bun:split
+
+CREATE TABLE `user_keys` (
+    `user_id` VARCHAR(255) NOT NULL,
+    `sequence` BIGINT NOT NULL,
+    `public_key` VARCHAR(255) NOT NULL,
+    `signature` VARCHAR(255),
+    `created_at` DATETIME NOT NULL,
+    `retired_at` DATETIME,
+    PRIMARY KEY (`user_id`, `sequence`),
+    UNIQUE (`public_key`)
+);
+
+--bun:split
+
+CREATE TABLE `device_keys` (
+    `id` VARCHAR(255) NOT NULL,
+    `user_id` VARCHAR(255) NOT NULL,
+    `name` VARCHAR(255) NOT NULL,
+    `public_key` VARCHAR(255) NOT NULL,
+    `authorized_by` VARCHAR(255) NOT NULL,
+    `signature` VARCHAR(255) NOT NULL,
+    `created_at` DATETIME NOT NULL,
+    `revoked_at` DATETIME,
+    PRIMARY KEY (`id`),
+    UNIQUE (`public_key`)
+);
+
+--bun:split
+
+CREATE TABLE `encryption_keys` (
+    `id` VARCHAR(255) NOT NULL,
+    `user_id` VARCHAR(255) NOT NULL,
+    `public_key` VARCHAR(255) NOT NULL,
+    `signature` VARCHAR(255) NOT NULL,
+    `signed_by` VARCHAR(255) NOT NULL,
+    `created_at` DATETIME NOT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE (`public_key`)
+);
+
+--bun:split
+
+CREATE TABLE `passkeys` (
+    `id` VARCHAR(344) NOT NULL,
+    `user_id` VARCHAR(255) NOT NULL,
+    `public_key` TEXT NOT NULL,
+    `algorithm` BIGINT NOT NULL,
+    `sign_count` INTEGER NOT NULL,
+    `created_at` DATETIME NOT NULL,
+    `last_used_at` DATETIME,
+    PRIMARY KEY (`id`)
+);
+
+--bun:split
+
+CREATE TABLE `log_entries` (
+    `leaf_index` BIGINT NOT NULL,
+    `type` VARCHAR(255) NOT NULL,
+    `user_id` VARCHAR(255) NOT NULL,
+    `device_id` VARCHAR(255),
+    `algorithm` VARCHAR(255) NOT NULL,
+    `public_key` VARCHAR(255) NOT NULL,
+    `leaf_hash` VARCHAR(255) NOT NULL,
+    `created_at` DATETIME NOT NULL,
+    PRIMARY KEY (`leaf_index`)
+);
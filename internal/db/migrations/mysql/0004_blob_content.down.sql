@@ -0,0 +1,9 @@
+ALTER TABLE `paste_revisions`
+    DROP COLUMN `content_size`,
+    DROP COLUMN `content_ref`;
+
+--bun:split
+
+ALTER TABLE `pastes`
+    DROP COLUMN `content_size`,
+    DROP COLUMN `content_ref`;
@@ -0,0 +1,124 @@
+-- Everything DropKey gained between its last release without migrations
+-- and the introduction of migrations: paste signatures v2, view limits,
+-- tombstones, revisions and recipients, and key algorithms, fingerprints,
+-- key history, devices, encryption keys, passkeys and the key transparency
+-- log. The fingerprints and log entries of existing users are backfilled by
+-- the Go migration that follows.
+
+ALTER TABLE "pastes"
+    ADD COLUMN "algorithm" VARCHAR NOT NULL DEFAULT 'ed25519',
+    ADD COLUMN "user_id" VARCHAR,
+    ADD COLUMN "device_id" VARCHAR,
+    ADD COLUMN "signature_version" BIGINT NOT NULL DEFAULT 1,
+    ADD COLUMN "nonce" VARCHAR,
+    ADD COLUMN "revision" BIGINT NOT NULL DEFAULT 1,
+    ADD COLUMN "burn_after_read" BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN "max_views" BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN "views" BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN "tombstone" VARCHAR,
+    ADD COLUMN "deleted_at" TIMESTAMPTZ,
+    ADD COLUMN "delete_signature" VARCHAR,
+    ADD UNIQUE ("nonce");
+
+--bun:split
+
+ALTER TABLE "users"
+    ADD COLUMN "algorithm" VARCHAR NOT NULL DEFAULT 'ed25519',
+    ADD COLUMN "fingerprint" VARCHAR,
+    ADD UNIQUE ("fingerprint");
+
+--bun:split
+
+CREATE TABLE "paste_revisions" (
+    "paste_id" VARCHAR NOT NULL,
+    "revision" BIGINT NOT NULL,
+    "ciphertext" TEXT NOT NULL,
+    "signature" VARCHAR NOT NULL,
+    "signature_version" BIGINT NOT NULL DEFAULT 1,
+    "nonce" VARCHAR,
+    "public_key" VARCHAR NOT NULL,
+    "algorithm" VARCHAR NOT NULL DEFAULT 'ed25519',
+    "expires_at" TIMESTAMPTZ NOT NULL,
+    "replaced_at" TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY ("paste_id", "revision")
+);
+
+--bun:split
+
+CREATE TABLE "paste_recipients" (
+    "paste_id" VARCHAR NOT NULL,
+    "public_key" VARCHAR NOT NULL,
+    "user_id" VARCHAR NOT NULL,
+    "envelope" TEXT NOT NULL,
+    "received_at" TIMESTAMPTZ NOT NULL,
+    "read_at" TIMESTAMPTZ,
+    PRIMARY KEY ("paste_id", "public_key")
+);
+
+--bun:split
+
+CREATE TABLE "user_keys" (
+    "user_id" VARCHAR NOT NULL,
+    "sequence" BIGINT NOT NULL,
+    "public_key" VARCHAR NOT NULL,
+    "signature" VARCHAR,
+    "created_at" TIMESTAMPTZ NOT NULL,
+    "retired_at" TIMESTAMPTZ,
+    PRIMARY KEY ("user_id", "sequence"),
+    UNIQUE ("public_key")
+);
+
+--bun:split
+
+CREATE TABLE "device_keys" (
+    "id" VARCHAR NOT NULL,
+    "user_id" VARCHAR NOT NULL,
+    "name" VARCHAR NOT NULL,
+    "public_key" VARCHAR NOT NULL,
+    "authorized_by" VARCHAR NOT NULL,
+    "signature" VARCHAR NOT NULL,
+    "created_at" TIMESTAMPTZ NOT NULL,
+    "revoked_at" TIMESTAMPTZ,
+    PRIMARY KEY ("id"),
+    UNIQUE ("public_key")
+);
+
+--bun:split
+
+CREATE TABLE "encryption_keys" (
+    "id" VARCHAR NOT NULL,
+    "user_id" VARCHAR NOT NULL,
+    "public_key" VARCHAR NOT NULL,
+    "signature" VARCHAR NOT NULL,
+    "signed_by" VARCHAR NOT NULL,
+    "created_at" TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY ("id"),
+    UNIQUE ("public_key")
+);
+
+--bun:split
+
+CREATE TABLE "passkeys" (
+    "id" VARCHAR(344) NOT NULL,
+    "user_id" VARCHAR NOT NULL,
+    "public_key" TEXT NOT NULL,
+    "algorithm" BIGINT NOT NULL,
+    "sign_count" INTEGER NOT NULL,
+    "created_at" TIMESTAMPTZ NOT NULL,
+    "last_used_at" TIMESTAMPTZ,
+    PRIMARY KEY ("id")
+);
+
+--bun:split
+
+CREATE TABLE "log_entries" (
+    "leaf_index" BIGINT NOT NULL,
+    "type" VARCHAR NOT NULL,
+    "user_id" VARCHAR NOT NULL,
+    "device_id" VARCHAR,
+    "algorithm" VARCHAR NOT NULL,
+    "public_key" VARCHAR NOT NULL,
+    "leaf_hash" VARCHAR NOT NULL,
+    "created_at" TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY ("leaf_index")
+);
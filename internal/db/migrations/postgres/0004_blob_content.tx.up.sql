@@ -0,0 +1,12 @@
+-- Paste content can live in a blob store (BLOB_STORE), in which case the
+-- row references the blob instead of holding the ciphertext.
+
+ALTER TABLE "pastes"
+    ADD COLUMN "content_ref" VARCHAR,
+    ADD COLUMN "content_size" BIGINT NOT NULL DEFAULT 0;
+
+--bun:split
+
+ALTER TABLE "paste_revisions"
+    ADD COLUMN "content_ref" VARCHAR,
+    ADD COLUMN "content_size" BIGINT NOT NULL DEFAULT 0;
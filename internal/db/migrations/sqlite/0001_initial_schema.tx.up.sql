@@ -0,0 +1,24 @@
+-- The schema of the last release before migrations, which created the
+-- pastes and users tables from its models at startup. Tables are only
+-- created if missing, so databases set up by that release are adopted as
+-- they are and brought up to date by the following migrations.
+-- There is no down migration: the tables may hold data from before
+-- migrations, so rolling back 0001 leaves them in place.
+
+CREATE TABLE IF NOT EXISTS "pastes" (
+    "id" VARCHAR NOT NULL,
+    "ciphertext" TEXT NOT NULL,
+    "signature" VARCHAR NOT NULL,
+    "public_key" VARCHAR NOT NULL,
+    "expires_at" TIMESTAMP NOT NULL,
+    PRIMARY KEY ("id")
+);
+
+--bun:split
+
+CREATE TABLE IF NOT EXISTS "users" (
+    "id" VARCHAR NOT NULL,
+    "public_key" VARCHAR NOT NULL,
+    PRIMARY KEY ("id"),
+    UNIQUE ("public_key")
+);
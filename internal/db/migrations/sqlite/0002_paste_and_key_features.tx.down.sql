@@ -0,0 +1,89 @@
+DROP TABLE "log_entries";
+
+--bun:split
+
+DROP TABLE "passkeys";
+
+--bun:split
+
+DROP TABLE "encryption_keys";
+
+--bun:split
+
+DROP TABLE "device_keys";
+
+--bun:split
+
+DROP TABLE "user_keys";
+
+--bun:split
+
+DROP TABLE "paste_recipients";
+
+--bun:split
+
+DROP TABLE "paste_revisions";
+
+--bun:split
+
+DROP INDEX "users_fingerprint_key";
+
+--bun:split
+
+ALTER TABLE "users" DROP COLUMN "fingerprint";
+
+--bun:split
+
+ALTER TABLE "users" DROP COLUMN "algorithm";
+
+--bun:split
+
+DROP INDEX "pastes_nonce_key";
+
+--bun:split
+
+ALTER TABLE "pastes" DROP COLUMN "delete_signature";
+
+--bun:split
+
+ALTER TABLE "pastes" DROP COLUMN "deleted_at";
+
+--bun:split
+
+ALTER TABLE "pastes" DROP COLUMN "tombstone";
+
+--bun:split
+
+ALTER TABLE "pastes" DROP COLUMN "views";
+
+--bun:split
+
+ALTER TABLE "pastes" DROP COLUMN "max_views";
+
+--bun:split
+
+ALTER TABLE "pastes" DROP COLUMN "burn_after_read";
+
+--bun:split
+
+ALTER TABLE "pastes" DROP COLUMN "revision";
+
+--bun:split
+
+ALTER TABLE "pastes" DROP COLUMN "nonce";
+
+--bun:split
+
+ALTER TABLE "pastes" DROP COLUMN "signature_version";
+
+--bun:split
+
+ALTER TABLE "pastes" DROP COLUMN "device_id";
+
+--bun:split
+
+ALTER TABLE "pastes" DROP COLUMN "user_id";
+
+--bun:split
+
+ALTER TABLE "pastes" DROP COLUMN "algorithm";
@@ -0,0 +1,166 @@
+-- Everything DropKey gained between its last release without migrations
+-- and the introduction of migrations: paste signatures v2, view limits,
+-- tombstones, revisions and recipients, and key algorithms, fingerprints,
+-- key history, devices, encryption keys, passkeys and the key transparency
+-- log. The fingerprints and log entries of existing users are backfilled by
+-- the Go migration that follows.
+-- SQLite adds one column per statement and cannot add UNIQUE columns, so
+-- those get a unique index.
+
+ALTER TABLE "pastes" ADD COLUMN "algorithm" VARCHAR NOT NULL DEFAULT 'ed25519';
+
+--bun:split
+
+ALTER TABLE "pastes" ADD COLUMN "user_id" VARCHAR;
+
+--bun:split
+
+ALTER TABLE "pastes" ADD COLUMN "device_id" VARCHAR;
+
+--bun:split
+
+ALTER TABLE "pastes" ADD COLUMN "signature_version" INTEGER NOT NULL DEFAULT 1;
+
+--bun:split
+
+ALTER TABLE "pastes" ADD COLUMN "nonce" VARCHAR;
+
+--bun:split
+
+ALTER TABLE "pastes" ADD COLUMN "revision" INTEGER NOT NULL DEFAULT 1;
+
+--bun:split
+
+ALTER TABLE "pastes" ADD COLUMN "burn_after_read" BOOLEAN NOT NULL DEFAULT false;
+
+--bun:split
+
+ALTER TABLE "pastes" ADD COLUMN "max_views" INTEGER NOT NULL DEFAULT 0;
+
+--bun:split
+
+ALTER TABLE "pastes" ADD COLUMN "views" INTEGER NOT NULL DEFAULT 0;
+
+--bun:split
+
+ALTER TABLE "pastes" ADD COLUMN "tombstone" VARCHAR;
+
+--bun:split
+
+ALTER TABLE "pastes" ADD COLUMN "deleted_at" TIMESTAMP;
+
+--bun:split
+
+ALTER TABLE "pastes" ADD COLUMN "delete_signature" VARCHAR;
+
+--bun:split
+
+CREATE UNIQUE INDEX "pastes_nonce_key" ON "pastes" ("nonce");
+
+--bun:split
+
+ALTER TABLE "users" ADD COLUMN "algorithm" VARCHAR NOT NULL DEFAULT 'ed25519';
+
+--bun:split
+
+ALTER TABLE "users" ADD COLUMN "fingerprint" VARCHAR;
+
+--bun:split
+
+CREATE UNIQUE INDEX "users_fingerprint_key" ON "users" ("fingerprint");
+
+--bun:split
+
+CREATE TABLE "paste_revisions" (
+    "paste_id" VARCHAR NOT NULL,
+    "revision" INTEGER NOT NULL,
+    "ciphertext" TEXT NOT NULL,
+    "signature" VARCHAR NOT NULL,
+    "signature_version" INTEGER NOT NULL DEFAULT 1,
+    "nonce" VARCHAR,
+    "public_key" VARCHAR NOT NULL,
+    "algorithm" VARCHAR NOT NULL DEFAULT 'ed25519',
+    "expires_at" TIMESTAMP NOT NULL,
+    "replaced_at" TIMESTAMP NOT NULL,
+    PRIMARY KEY ("paste_id", "revision")
+);
+
+--bun:split
+
+CREATE TABLE "paste_recipients" (
+    "paste_id" VARCHAR NOT NULL,
+    "public_key" VARCHAR NOT NULL,
+    "user_id" VARCHAR NOT NULL,
+    "envelope" TEXT NOT NULL,
+    "received_at" TIMESTAMP NOT NULL,
+    "read_at" TIMESTAMP,
+    PRIMARY KEY ("paste_id", "public_key")
+);
+
+--bun:split
+
+CREATE TABLE "user_keys" (
+    "user_id" VARCHAR NOT NULL,
+    "sequence" INTEGER NOT NULL,
+    "public_key" VARCHAR NOT NULL,
+    "signature" VARCHAR,
+    "created_at" TIMESTAMP NOT NULL,
+    "retired_at" TIMESTAMP,
+    PRIMARY KEY ("user_id", "sequence"),
+    UNIQUE ("public_key")
+);
+
+--bun:split
+
+CREATE TABLE "device_keys" (
+    "id" VARCHAR NOT NULL,
+    "user_id" VARCHAR NOT NULL,
+    "name" VARCHAR NOT NULL,
+    "public_key" VARCHAR NOT NULL,
+    "authorized_by" VARCHAR NOT NULL,
+    "signature" VARCHAR NOT NULL,
+    "created_at" TIMESTAMP NOT NULL,
+    "revoked_at" TIMESTAMP,
+    PRIMARY KEY ("id"),
+    UNIQUE ("public_key")
+);
+
+--bun:split
+
+CREATE TABLE "encryption_keys" (
+    "id" VARCHAR NOT NULL,
+    "user_id" VARCHAR NOT NULL,
+    "public_key" VARCHAR NOT NULL,
+    "signature" VARCHAR NOT NULL,
+    "signed_by" VARCHAR NOT NULL,
+    "created_at" TIMESTAMP NOT NULL,
+    PRIMARY KEY ("id"),
+    UNIQUE ("public_key")
+);
+
+--bun:split
+
+CREATE TABLE "passkeys" (
+    "id" VARCHAR(344) NOT NULL,
+    "user_id" VARCHAR NOT NULL,
+    "public_key" TEXT NOT NULL,
+    "algorithm" INTEGER NOT NULL,
+    "sign_count" INTEGER NOT NULL,
+    "created_at" TIMESTAMP NOT NULL,
+    "last_used_at" TIMESTAMP,
+    PRIMARY KEY ("id")
+);
+
+--bun:split
+
+CREATE TABLE "log_entries" (
+    "leaf_index" INTEGER NOT NULL,
+    "type" VARCHAR NOT NULL,
+    "user_id" VARCHAR NOT NULL,
+    "device_id" VARCHAR,
+    "algorithm" VARCHAR NOT NULL,
+    "public_key" VARCHAR NOT NULL,
+    "leaf_hash" VARCHAR NOT NULL,
+    "created_at" TIMESTAMP NOT NULL,
+    PRIMARY KEY ("leaf_index")
+);
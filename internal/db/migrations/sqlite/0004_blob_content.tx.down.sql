@@ -0,0 +1,13 @@
+ALTER TABLE "paste_revisions" DROP COLUMN "content_size";
+
+--bun:split
+
+ALTER TABLE "paste_revisions" DROP COLUMN "content_ref";
+
+--bun:split
+
+ALTER TABLE "pastes" DROP COLUMN "content_size";
+
+--bun:split
+
+ALTER TABLE "pastes" DROP COLUMN "content_ref";
@@ -0,0 +1,17 @@
+-- Paste content can live in a blob store (BLOB_STORE), in which case the
+-- row references the blob instead of holding the ciphertext.
+-- SQLite adds one column per statement.
+
+ALTER TABLE "pastes" ADD COLUMN "content_ref" VARCHAR;
+
+--bun:split
+
+ALTER TABLE "pastes" ADD COLUMN "content_size" INTEGER NOT NULL DEFAULT 0;
+
+--bun:split
+
+ALTER TABLE "paste_revisions" ADD COLUMN "content_ref" VARCHAR;
+
+--bun:split
+
+ALTER TABLE "paste_revisions" ADD COLUMN "content_size" INTEGER NOT NULL DEFAULT 0;
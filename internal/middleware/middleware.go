@@ -1,10 +1,12 @@
 package custom_middleware
 
 import (
+	"context"
+	"crypto/ed25519"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
@@ -35,6 +37,10 @@ func Logger(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
+// UserInfo is the caller of an authenticated request. Publickey is the key
+// the session was opened with, which is a device key for device logins, so
+// it only decides which key may sign; everything kept per user is looked up
+// by UserID.
 type UserInfo struct {
 	UserID    string `json:"userID"`
 	Publickey string `json:"public_key"`
@@ -45,44 +51,84 @@ type JwtCustomClaims struct {
 	jwt.RegisteredClaims
 }
 
-func JwtAuth(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		jwtSecret := os.Getenv("JWTSECRET")
-		authHeader := c.Request().Header.Get("Authorization")
-		if authHeader == "" {
-			return echo.NewHTTPError(http.StatusUnauthorized, "Authorization header required")
-		}
+// RevocationList reports whether an access token, identified by its jti,
+// has been revoked before its natural expiry.
+type RevocationList interface {
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid Authorization header format")
-		}
-		tokenString := parts[1]
+// KeyResolver returns the Ed25519 public key for a token's kid header.
+type KeyResolver interface {
+	PublicKey(kid string) (ed25519.PublicKey, error)
+}
 
-		claims := new(JwtCustomClaims)
+type JwtConfig struct {
+	Keys        KeyResolver
+	Issuer      string
+	Audience    string
+	Revocations RevocationList
+}
 
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+func JwtAuth(config JwtConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authHeader := c.Request().Header.Get("Authorization")
+			if authHeader == "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Authorization header required")
 			}
 
-			return []byte(jwtSecret), nil
-		})
-		if err != nil {
-			slog.Error("JWT parsing error (Echo): %v", "error", err)
-			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
-		}
+			parts := strings.Split(authHeader, " ")
+			if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid Authorization header format")
+			}
+			tokenString := parts[1]
+
+			claims := new(JwtCustomClaims)
+
+			token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+				if _, ok := token.Method.(*jwt.SigningMethodEd25519); !ok {
+					return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+				}
+				kid, ok := token.Header["kid"].(string)
+				if !ok || kid == "" {
+					return nil, fmt.Errorf("missing kid header")
+				}
+
+				return config.Keys.PublicKey(kid)
+			},
+				jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}),
+				jwt.WithExpirationRequired(),
+				jwt.WithIssuedAt(),
+				jwt.WithIssuer(config.Issuer),
+				jwt.WithAudience(config.Audience),
+			)
+			if errors.Is(err, jwt.ErrTokenExpired) {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Token expired")
+			}
+			if err != nil {
+				slog.Error("JWT parsing error (Echo)", "error", err)
+				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
+			}
 
-		if !token.Valid {
-			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
-		}
+			if !token.Valid || claims.ID == "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
+			}
 
-		if claims.ExpiresAt != nil && claims.ExpiresAt.Time.Before(time.Now()) {
-			return echo.NewHTTPError(http.StatusUnauthorized, "Token expired")
-		}
+			if config.Revocations != nil {
+				revoked, err := config.Revocations.IsRevoked(c.Request().Context(), claims.ID)
+				if err != nil {
+					slog.Error("Error while checking token revocation", "jti", claims.ID, "error", err)
+					return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error")
+				}
+				if revoked {
+					return echo.NewHTTPError(http.StatusUnauthorized, "Token revoked")
+				}
+			}
 
-		c.Set("userInfo", claims.UserInfo)
+			c.Set("userInfo", claims.UserInfo)
+			c.Set("claims", claims)
 
-		return next(c)
+			return next(c)
+		}
 	}
 }
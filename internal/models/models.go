@@ -7,14 +7,174 @@ import (
 type User struct {
 	ID        string `bun:"id,pk" json:"user_id"`
 	PublicKey string `bun:"public_key,notnull,unique" json:"public_key"`
+	// Algorithm of PublicKey, see package verifier. Device keys are Ed25519.
+	Algorithm string `bun:"algorithm,notnull,default:'ed25519'" json:"algorithm"`
+
+	// Fingerprint of PublicKey, kept so users can be looked up by it. Users
+	// registered before fingerprints were stored get theirs from the 0003
+	// migration.
+	Fingerprint string `bun:"fingerprint,nullzero,unique" json:"fingerprint"`
+
+	// X25519PublicKey is derived from PublicKey for responses, not stored.
+	X25519PublicKey string `bun:"-" json:"x25519_public_key,omitempty"`
+
+	EncryptionKeys []*EncryptionKey `bun:"rel:has-many,join:id=user_id" json:"encryption_keys,omitempty"`
+	KeyHistory     []*UserKey       `bun:"rel:has-many,join:id=user_id" json:"key_history,omitempty"`
+	Devices        []*DeviceKey     `bun:"rel:has-many,join:id=user_id" json:"devices,omitempty"`
+}
+
+// DeviceKey is an additional Ed25519 key that lets another device act for a
+// user next to their primary identity key. Devices are added and revoked with
+// a signature of a key the user already holds; revoked keys are kept so that
+// pastes signed by them still name their device.
+type DeviceKey struct {
+	ID           string    `bun:"id,pk" json:"id"`
+	UserID       string    `bun:"user_id,notnull" json:"user_id"`
+	Name         string    `bun:"name,notnull" json:"name"`
+	PublicKey    string    `bun:"public_key,notnull,unique" json:"public_key"`
+	AuthorizedBy string    `bun:"authorized_by,notnull" json:"authorized_by"`
+	Signature    string    `bun:"signature,notnull" json:"signature"`
+	CreatedAt    time.Time `bun:"created_at,notnull" json:"created_at"`
+	RevokedAt    time.Time `bun:"revoked_at,nullzero" json:"revoked_at,omitempty"`
+}
+
+// Passkey is a WebAuthn credential that lets a user log in from a browser,
+// which cannot hold their Ed25519 key safely. ID is the base64url credential
+// ID and PublicKey the base64 COSE key the authenticator registered.
+type Passkey struct {
+	ID         string    `bun:"id,pk,type:VARCHAR(344)" json:"id"`
+	UserID     string    `bun:"user_id,notnull" json:"user_id"`
+	PublicKey  string    `bun:"public_key,type:TEXT,notnull" json:"public_key"`
+	Algorithm  int       `bun:"algorithm,notnull" json:"algorithm"`
+	SignCount  uint32    `bun:"sign_count,notnull" json:"sign_count"`
+	CreatedAt  time.Time `bun:"created_at,notnull" json:"created_at"`
+	LastUsedAt time.Time `bun:"last_used_at,nullzero" json:"last_used_at,omitempty"`
 }
 
+// UserKey is one identity key in a user's succession chain. Every key after
+// the first is endorsed by a signature of the key it replaced.
+type UserKey struct {
+	UserID    string    `bun:"user_id,pk" json:"user_id"`
+	Sequence  int       `bun:"sequence,pk" json:"sequence"`
+	PublicKey string    `bun:"public_key,notnull,unique" json:"public_key"`
+	Signature string    `bun:"signature,nullzero" json:"signature,omitempty"`
+	CreatedAt time.Time `bun:"created_at,notnull" json:"created_at"`
+	RetiredAt time.Time `bun:"retired_at,nullzero" json:"retired_at,omitempty"`
+}
+
+// EncryptionKey is an X25519 key a user publishes for others to wrap secrets
+// to, bound to the user by a signature of their Ed25519 identity key.
+type EncryptionKey struct {
+	ID        string    `bun:"id,pk" json:"id"`
+	UserID    string    `bun:"user_id,notnull" json:"user_id"`
+	PublicKey string    `bun:"public_key,notnull,unique" json:"public_key"`
+	Signature string    `bun:"signature,notnull" json:"signature"`
+	SignedBy  string    `bun:"signed_by,notnull" json:"signed_by"`
+	CreatedAt time.Time `bun:"created_at,notnull" json:"created_at"`
+}
+
+// Tombstone reasons recorded on a paste whose content has been removed
+// before it expired.
+const (
+	TombstoneDeleted   = "deleted"
+	TombstoneBurned    = "burned"
+	TombstoneExhausted = "exhausted"
+)
+
 type Paste struct {
 	ID         string    `bun:"id,pk" json:"id"`
 	Ciphertext string    `bun:"type:MEDIUMTEXT,notnull" json:"ciphertext"`
 	Signature  string    `bun:"signature,notnull" json:"signature"`
 	PublicKey  string    `bun:"public_key,notnull" json:"public_key"`
+	Algorithm  string    `bun:"algorithm,notnull,default:'ed25519'" json:"algorithm"`
 	ExpiresAt  time.Time `bun:"expires_at,notnull" json:"expires_at"`
 
-	User *User `bun:"rel:belongs-to,join:public_key=public_key" json:"-"`
+	// ContentRef is the blob store key of the ciphertext when it is kept
+	// outside the database, in which case the ciphertext column is empty
+	// and ContentSize is the length of the decoded content.
+	ContentRef  string `bun:"content_ref,nullzero" json:"-"`
+	ContentSize int64  `bun:"content_size,notnull,default:0" json:"-"`
+
+	// Fingerprint of PublicKey, filled in for responses.
+	Fingerprint string `bun:"-" json:"fingerprint,omitempty"`
+
+	// UserID is the owner; PublicKey is only the key the paste was signed
+	// with, which changes when the owner rotates their identity key.
+	UserID string `bun:"user_id,nullzero" json:"user_id,omitempty"`
+	// DeviceID names the device key that signed the paste, if it was not
+	// signed with the owner's primary identity key.
+	DeviceID string `bun:"device_id,nullzero" json:"device_id,omitempty"`
+
+	SignatureVersion int    `bun:"signature_version,notnull,default:1" json:"signature_version"`
+	Nonce            string `bun:"nonce,nullzero,unique" json:"nonce,omitempty"`
+	Revision         int    `bun:"revision,notnull,default:1" json:"revision"`
+
+	BurnAfterRead bool `bun:"burn_after_read,notnull,default:false" json:"burn_after_read"`
+	MaxViews      int  `bun:"max_views,notnull,default:0" json:"max_views,omitempty"`
+	Views         int  `bun:"views,notnull,default:0" json:"views"`
+
+	Tombstone       string    `bun:"tombstone,nullzero" json:"tombstone,omitempty"`
+	DeletedAt       time.Time `bun:"deleted_at,nullzero" json:"-"`
+	DeleteSignature string    `bun:"delete_signature,nullzero" json:"-"`
+
+	User       *User             `bun:"rel:belongs-to,join:user_id=id" json:"-"`
+	Recipients []*PasteRecipient `bun:"rel:has-many,join:id=paste_id" json:"-"`
+}
+
+// PasteRecipient addresses a paste to a registered user. The envelope is the
+// paste content key wrapped by the author to the recipient's X25519 key; it
+// is opaque to the server and only handed to that recipient. ReadAt is set
+// the first time the recipient fetches the envelope. PublicKey is the
+// identity key the author addressed; the recipient is found by UserID, so
+// the paste stays in their inbox when they rotate that key.
+type PasteRecipient struct {
+	PasteID   string `bun:"paste_id,pk" json:"paste_id"`
+	PublicKey string `bun:"public_key,pk" json:"public_key"`
+	UserID    string `bun:"user_id,notnull" json:"-"`
+	Envelope  string `bun:"envelope,type:TEXT,notnull" json:"envelope"`
+
+	ReceivedAt time.Time `bun:"received_at,notnull" json:"received_at"`
+	ReadAt     time.Time `bun:"read_at,nullzero" json:"read_at,omitempty"`
+
+	Paste *Paste `bun:"rel:belongs-to,join:paste_id=id" json:"-"`
+}
+
+// PasteRevision is an earlier version of a paste, kept with the signature it
+// was published under. Revisions share the lifetime of the head paste.
+type PasteRevision struct {
+	PasteID          string    `bun:"paste_id,pk" json:"paste_id"`
+	Revision         int       `bun:"revision,pk" json:"revision"`
+	Ciphertext       string    `bun:"type:MEDIUMTEXT,notnull" json:"ciphertext,omitempty"`
+	ContentRef       string    `bun:"content_ref,nullzero" json:"-"`
+	ContentSize      int64     `bun:"content_size,notnull,default:0" json:"-"`
+	Signature        string    `bun:"signature,notnull" json:"signature"`
+	SignatureVersion int       `bun:"signature_version,notnull,default:1" json:"signature_version"`
+	Nonce            string    `bun:"nonce,nullzero" json:"nonce,omitempty"`
+	PublicKey        string    `bun:"public_key,notnull" json:"public_key"`
+	Algorithm        string    `bun:"algorithm,notnull,default:'ed25519'" json:"algorithm"`
+	Fingerprint      string    `bun:"-" json:"fingerprint,omitempty"`
+	ExpiresAt        time.Time `bun:"expires_at,notnull" json:"expires_at"`
+	ReplacedAt       time.Time `bun:"replaced_at,notnull" json:"replaced_at"`
+}
+
+// Kinds of change recorded in the key transparency log.
+const (
+	LogEntryRegister     = "register"
+	LogEntryRotate       = "rotate"
+	LogEntryDeviceAdd    = "device_add"
+	LogEntryDeviceRevoke = "device_revoke"
+)
+
+// LogEntry is one leaf of the key transparency log, recording a change to the
+// keys a user signs with. Entries are only ever appended. LeafHash is the
+// base64 Merkle leaf hash of the entry, see package transparency.
+type LogEntry struct {
+	LeafIndex int64     `bun:"leaf_index,pk" json:"leaf_index"`
+	Type      string    `bun:"type,notnull" json:"type"`
+	UserID    string    `bun:"user_id,notnull" json:"user_id"`
+	DeviceID  string    `bun:"device_id,nullzero" json:"device_id,omitempty"`
+	Algorithm string    `bun:"algorithm,notnull" json:"algorithm"`
+	PublicKey string    `bun:"public_key,notnull" json:"public_key"`
+	LeafHash  string    `bun:"leaf_hash,notnull" json:"leaf_hash"`
+	CreatedAt time.Time `bun:"created_at,notnull" json:"created_at"`
 }
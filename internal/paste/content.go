@@ -0,0 +1,137 @@
+package paste
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// ErrNoBlobStore is returned for a paste whose content was stored in a blob
+// store while none is configured.
+var ErrNoBlobStore = errors.New("paste content is in a blob store but none is configured")
+
+// storeContent moves the ciphertext of paste into the blob store, as its
+// decoded bytes, and records the blob in ContentRef and ContentSize. Every
+// version gets a key of its own, so a blob is never replaced while a revision
+// or a concurrent reader still refers to it. Without a blob store the
+// ciphertext stays inline. The API carries ciphertexts as base64 strings in
+// JSON, so they are decoded in memory; Config.MaxSize bounds how much.
+func (r *pasteRepository) storeContent(ctx context.Context, paste *models.Paste) error {
+	if r.blobs == nil {
+		return nil
+	}
+	content, err := base64.StdEncoding.DecodeString(paste.Ciphertext)
+	if err != nil {
+		return utils.ErrPasteInvalidCiphertext
+	}
+	key := "pastes/" + paste.ID + "/" + uuid.NewString()
+	if err := r.blobs.Put(ctx, key, bytes.NewReader(content), int64(len(content))); err != nil {
+		slog.Error("Error while storing paste content", "operation", "store", "pasteid", paste.ID, "error", err)
+		return err
+	}
+	paste.ContentRef = key
+	paste.ContentSize = int64(len(content))
+	return nil
+}
+
+// contentRow returns the row to write for paste, which leaves out the
+// ciphertext once it is in the blob store.
+func contentRow(paste *models.Paste) *models.Paste {
+	if paste.ContentRef == "" {
+		return paste
+	}
+	row := *paste
+	row.Ciphertext = ""
+	return &row
+}
+
+// loadContent returns the ciphertext kept in the blob ref, base64 encoded as
+// it was submitted, or inline when ref is empty. The blob is streamed
+// through the encoder, but the encoded ciphertext is held in memory for the
+// JSON response.
+func (r *pasteRepository) loadContent(ctx context.Context, ref, inline string) (string, error) {
+	if ref == "" {
+		return inline, nil
+	}
+	if r.blobs == nil {
+		return "", ErrNoBlobStore
+	}
+	body, err := r.blobs.Get(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	var ciphertext strings.Builder
+	encoder := base64.NewEncoder(base64.StdEncoding, &ciphertext)
+	if _, err := io.Copy(encoder, body); err != nil {
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return ciphertext.String(), nil
+}
+
+// loadPaste fills in the ciphertext of paste from the blob store.
+func (r *pasteRepository) loadPaste(ctx context.Context, paste *models.Paste) error {
+	ciphertext, err := r.loadContent(ctx, paste.ContentRef, paste.Ciphertext)
+	if err != nil {
+		slog.Error("Error while loading paste content", "operation", "load", "pasteid", paste.ID, "error", err)
+		return err
+	}
+	paste.Ciphertext = ciphertext
+	return nil
+}
+
+// contentRefs returns the blobs referenced by pastes ids and their revisions.
+func contentRefs(ctx context.Context, db bun.IDB, ids ...string) ([]string, error) {
+	var refs, revisionRefs []string
+	err := db.NewSelect().
+		Model((*models.Paste)(nil)).
+		Column("content_ref").
+		Where("id IN (?)", bun.In(ids)).
+		Where("content_ref IS NOT NULL").
+		Scan(ctx, &refs)
+	if err != nil {
+		return nil, err
+	}
+	err = db.NewSelect().
+		Model((*models.PasteRevision)(nil)).
+		Column("content_ref").
+		Where("paste_id IN (?)", bun.In(ids)).
+		Where("content_ref IS NOT NULL").
+		Scan(ctx, &revisionRefs)
+	if err != nil {
+		return nil, err
+	}
+	return append(refs, revisionRefs...), nil
+}
+
+// deleteContent removes blobs no row refers to any more. It runs after the
+// transaction that dropped the references committed, so a failure only
+// leaves an orphaned blob behind, which is logged.
+func (r *pasteRepository) deleteContent(ctx context.Context, refs ...string) {
+	for _, ref := range refs {
+		if ref == "" {
+			continue
+		}
+		if r.blobs == nil {
+			slog.Error("Error while deleting paste content", "operation", "delete content", "ref", ref, "error", ErrNoBlobStore)
+			continue
+		}
+		if err := r.blobs.Delete(context.WithoutCancel(ctx), ref); err != nil {
+			slog.Error("Error while deleting paste content", "operation", "delete content", "ref", ref, "error", err)
+		}
+	}
+}
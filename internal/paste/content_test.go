@@ -0,0 +1,157 @@
+package paste
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"Drop-Key/internal/blob"
+	"Drop-Key/internal/db/dbtest"
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countBlobs returns how many blobs are stored under dir.
+func countBlobs(t *testing.T, dir string) int {
+	t.Helper()
+	n := 0
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			n++
+		}
+		return err
+	})
+	assert.NoError(t, err)
+	return n
+}
+
+// unreadableStore is a blob store whose blobs cannot be read.
+type unreadableStore struct {
+	blob.BlobStore
+}
+
+func (s unreadableStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return nil, errors.New("blob store unavailable")
+}
+
+func TestBlobContent(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) {
+		db, inline, cleanup := setupTestDB(t)
+		defer cleanup()
+		dir := t.TempDir()
+		blobs, err := blob.NewFileStore(dir)
+		if !assert.NoError(t, err, "should create blob store") {
+			return
+		}
+		repo := NewPasteRepository(db, blobs)
+		ctx := context.Background()
+
+		t.Run("create", func(t *testing.T) {
+			paste := contractPaste("stored")
+			assert.NoError(t, repo.Create(ctx, paste))
+
+			var row models.Paste
+			err := db.NewSelect().Model(&row).Where("id = ?", paste.ID).Scan(ctx)
+			assert.NoError(t, err)
+			assert.Empty(t, row.Ciphertext, "should not store the ciphertext in the database")
+			assert.NotEmpty(t, row.ContentRef, "should reference the blob")
+			assert.Equal(t, int64(len("ciphertext")), row.ContentSize, "should record the decoded size")
+			assert.Equal(t, 1, countBlobs(t, dir))
+
+			fetched, err := repo.GetByID(ctx, paste.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, encoded("ciphertext"), fetched.Ciphertext, "should read the content from the blob store")
+
+			assert.NoError(t, repo.Delete(ctx, paste.ID, "sig"))
+			assert.Equal(t, 0, countBlobs(t, dir), "should delete the blob with the paste")
+		})
+
+		t.Run("invalid content", func(t *testing.T) {
+			paste := contractPaste("invalid")
+			paste.Ciphertext = "not base64!"
+			assert.ErrorIs(t, repo.Create(ctx, paste), utils.ErrPasteInvalidCiphertext)
+		})
+
+		t.Run("failed create", func(t *testing.T) {
+			first := contractPaste("nonce_first")
+			first.Nonce = "nonce"
+			assert.NoError(t, repo.Create(ctx, first))
+			second := contractPaste("nonce_second")
+			second.Nonce = "nonce"
+			assert.ErrorIs(t, repo.Create(ctx, second), utils.ErrPasteNonceReused)
+			assert.Equal(t, 1, countBlobs(t, dir), "should remove the blob of a paste that was not created")
+			assert.NoError(t, repo.Delete(ctx, first.ID, "sig"))
+		})
+
+		t.Run("revisions", func(t *testing.T) {
+			paste := contractPaste("revised")
+			assert.NoError(t, repo.Create(ctx, paste))
+			for _, content := range []string{"second", "third"} {
+				next := contractPaste(paste.ID)
+				next.Ciphertext = encoded(content)
+				assert.NoError(t, repo.Update(ctx, next, 1))
+			}
+			assert.Equal(t, 2, countBlobs(t, dir), "should delete the blob of the pruned revision")
+			revision, err := repo.GetRevision(ctx, paste.ID, 2)
+			assert.NoError(t, err)
+			assert.Equal(t, encoded("second"), revision.Ciphertext, "revision should keep its blob")
+
+			next := contractPaste(paste.ID)
+			next.Ciphertext = encoded("fourth")
+			assert.NoError(t, repo.Update(ctx, next, 0))
+			assert.Equal(t, 1, countBlobs(t, dir), "should delete the blobs of versions no longer kept")
+		})
+
+		t.Run("burn after read", func(t *testing.T) {
+			paste := contractPaste("burn")
+			paste.BurnAfterRead = true
+			assert.NoError(t, repo.Create(ctx, paste))
+			read, err := repo.GetByID(ctx, paste.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, encoded("ciphertext"), read.Ciphertext, "the last read should see the content")
+			assert.Equal(t, 1, countBlobs(t, dir), "should delete the blob once read")
+		})
+
+		t.Run("failed read", func(t *testing.T) {
+			paste := contractPaste("unreadable")
+			paste.BurnAfterRead = true
+			assert.NoError(t, repo.Create(ctx, paste))
+			before := countBlobs(t, dir)
+
+			_, err := NewPasteRepository(db, unreadableStore{blobs}).GetByID(ctx, paste.ID)
+			assert.Error(t, err, "should fail when the content cannot be read")
+			assert.Equal(t, before, countBlobs(t, dir), "should keep the blob of a read that failed")
+
+			read, err := repo.GetByID(ctx, paste.ID)
+			if assert.NoError(t, err, "a failed read should not use up the view") {
+				assert.Equal(t, encoded("ciphertext"), read.Ciphertext)
+				assert.Equal(t, 1, read.Views)
+			}
+			assert.Equal(t, before-1, countBlobs(t, dir), "should delete the blob once read")
+		})
+
+		t.Run("switching stores", func(t *testing.T) {
+			legacy := contractPaste("legacy")
+			assert.NoError(t, inline.Create(ctx, legacy), "should store content inline without a blob store")
+			fetched, err := repo.Peek(ctx, legacy.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, encoded("ciphertext"), fetched.Ciphertext, "should read content stored inline")
+
+			_, err = inline.Peek(ctx, "revised")
+			assert.ErrorIs(t, err, ErrNoBlobStore, "should not lose track of blobs without a blob store")
+		})
+
+		t.Run("purge", func(t *testing.T) {
+			n, err := repo.PurgeExpired(ctx, time.Now().UTC().Add(2*time.Hour), time.Now().UTC(), 100)
+			assert.NoError(t, err)
+			assert.Positive(t, n)
+			assert.Equal(t, 0, countBlobs(t, dir), "should delete the blobs of purged pastes")
+		})
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	custom_middleware "Drop-Key/internal/middleware"
@@ -12,13 +13,19 @@ import (
 	"Drop-Key/internal/utils"
 
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 )
 
 type PasterHandlerInterface interface {
 	CreatePaste(c echo.Context) error
 	GetPaste(c echo.Context) error
 	UpdatePaste(c echo.Context) error
+	DeletePaste(c echo.Context) error
 	GetByPublicKey(c echo.Context) error
+	ListRevisions(c echo.Context) error
+	GetRevision(c echo.Context) error
+	GetEnvelope(c echo.Context) error
+	Inbox(c echo.Context) error
 }
 
 type pasteHandler struct {
@@ -31,11 +38,39 @@ func NewPasteHandler(pasteService PasteService) *pasteHandler {
 	}
 }
 
+// BodyLimit rejects paste requests larger than config allows with 413
+// before they are read into memory.
+func BodyLimit(config Config) echo.MiddlewareFunc {
+	limit := config.MaxBodySize()
+	return middleware.BodyLimitWithConfig(middleware.BodyLimitConfig{
+		Skipper: func(echo.Context) bool { return limit == 0 },
+		Limit:   strconv.FormatInt(limit, 10),
+	})
+}
+
 type PasteRequest struct {
-	Ciphertext string `json:"ciphertext"`
-	Signature  string `json:"signature"`
-	PublicKey  string `json:"public_key"`
-	Expires_in int    `json:"expires_in"`
+	Ciphertext    string `json:"ciphertext"`
+	Signature     string `json:"signature"`
+	PublicKey     string `json:"public_key"`
+	Algorithm     string `json:"algorithm"`
+	Expires_in    int    `json:"expires_in"`
+	BurnAfterRead bool   `json:"burn_after_read"`
+	MaxViews      int    `json:"max_views"`
+
+	// Set by clients signing the v2 payload, see SigningPayload. Expires_in
+	// is ignored for those requests.
+	SignatureVersion int       `json:"signature_version"`
+	Nonce            string    `json:"nonce"`
+	ExpiresAt        time.Time `json:"expires_at"`
+
+	Recipients []RecipientRequest `json:"recipients"`
+}
+
+// RecipientRequest addresses a paste to a registered user by public key,
+// with the content key wrapped to that user's X25519 key.
+type RecipientRequest struct {
+	PublicKey string `json:"public_key"`
+	Envelope  string `json:"envelope"`
 }
 
 type PasteResponse struct {
@@ -46,6 +81,10 @@ type PasteResponse struct {
 	Expires_in time.Time `json:"expires_in"`
 }
 
+type DeleteRequest struct {
+	Signature string `json:"signature"`
+}
+
 type Url struct {
 	URL string `json:"url"`
 }
@@ -69,9 +108,22 @@ func (h *pasteHandler) CreatePaste(c echo.Context) error {
 	}
 
 	paste := &models.Paste{
-		Signature:  pasteReq.Signature,
-		Ciphertext: pasteReq.Ciphertext,
-		PublicKey:  pasteReq.PublicKey,
+		Signature:     pasteReq.Signature,
+		Ciphertext:    pasteReq.Ciphertext,
+		PublicKey:     pasteReq.PublicKey,
+		Algorithm:     pasteReq.Algorithm,
+		BurnAfterRead: pasteReq.BurnAfterRead,
+		MaxViews:      pasteReq.MaxViews,
+
+		SignatureVersion: pasteReq.SignatureVersion,
+		Nonce:            pasteReq.Nonce,
+		ExpiresAt:        pasteReq.ExpiresAt,
+	}
+	for _, recipient := range pasteReq.Recipients {
+		paste.Recipients = append(paste.Recipients, &models.PasteRecipient{
+			PublicKey: recipient.PublicKey,
+			Envelope:  recipient.Envelope,
+		})
 	}
 
 	ctx := c.Request().Context()
@@ -84,22 +136,44 @@ func (h *pasteHandler) CreatePaste(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid expiresin, paste expired already")
 	case errors.Is(err, utils.ErrPasteExpiryTooLong):
 		return echo.NewHTTPError(http.StatusBadRequest, "Expiry date too long")
+	case errors.Is(err, utils.ErrPasteInvalidMaxViews):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid max_views")
 	case errors.Is(err, utils.ErrPasteEmptyCiphertext):
 		return echo.NewHTTPError(http.StatusBadRequest, "Empty ciphertext")
 	case errors.Is(err, utils.ErrPasteInvalidCiphertext):
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid ciphertext")
+	case errors.Is(err, utils.ErrPasteTooLarge):
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Paste too large")
 	case errors.Is(err, utils.ErrEmptySignature):
 		return echo.NewHTTPError(http.StatusBadRequest, "Empty signature")
-	case errors.Is(err, utils.ErrInvalidSignature):
+	case errors.Is(err, utils.ErrInvalidSignature), errors.Is(err, utils.ErrPasteEmptySignature), errors.Is(err, utils.ErrPasteInvalidSignature):
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid signature")
 	case errors.Is(err, utils.ErrEmptyPublicKey):
 		return echo.NewHTTPError(http.StatusBadRequest, "Empty public key")
-	case errors.Is(err, utils.ErrInvalidPublicKey):
+	case errors.Is(err, utils.ErrInvalidPublicKey), errors.Is(err, utils.ErrPasteInvalidPublicKey):
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid public key")
+	case errors.Is(err, utils.ErrUnsupportedAlgorithm):
+		return echo.NewHTTPError(http.StatusBadRequest, "Unsupported algorithm")
 	case errors.Is(err, utils.ErrPasteUserNotFound):
 		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized access")
 	case errors.Is(err, utils.ErrPasteInvalidSignatureVerification):
 		return echo.NewHTTPError(http.StatusBadRequest, "Signature verification failed")
+	case errors.Is(err, utils.ErrPasteUnsupportedSignatureVersion):
+		return echo.NewHTTPError(http.StatusBadRequest, "Unsupported signature version")
+	case errors.Is(err, utils.ErrPasteLegacySignatureDisabled):
+		return echo.NewHTTPError(http.StatusBadRequest, "Legacy signatures are disabled, sign the v2 payload")
+	case errors.Is(err, utils.ErrPasteInvalidNonce):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid nonce")
+	case errors.Is(err, utils.ErrPasteNonceReused):
+		return echo.NewHTTPError(http.StatusConflict, "Nonce already used")
+	case errors.Is(err, utils.ErrPasteTooManyRecipients):
+		return echo.NewHTTPError(http.StatusBadRequest, "Too many recipients")
+	case errors.Is(err, utils.ErrPasteInvalidRecipient):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid recipient public key or envelope")
+	case errors.Is(err, utils.ErrPasteDuplicateRecipient):
+		return echo.NewHTTPError(http.StatusBadRequest, "Duplicate recipient")
+	case errors.Is(err, utils.ErrPasteRecipientNotFound):
+		return echo.NewHTTPError(http.StatusBadRequest, "Recipient is not a registered user")
 
 	default:
 		slog.Error("error while creating paste", "error", err)
@@ -138,6 +212,12 @@ func (h *pasteHandler) GetPaste(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotFound, "Paste not found")
 	case errors.Is(err, utils.ErrPasteExpiredAlready):
 		return echo.NewHTTPError(http.StatusGone, "Paste already expired")
+	case errors.Is(err, utils.ErrPasteDeleted):
+		return echo.NewHTTPError(http.StatusGone, "Paste deleted by owner")
+	case errors.Is(err, utils.ErrPasteBurned):
+		return echo.NewHTTPError(http.StatusGone, "Paste already read and burned")
+	case errors.Is(err, utils.ErrPasteViewsExhausted):
+		return echo.NewHTTPError(http.StatusGone, "Paste reached its maximum number of views")
 	case err != nil:
 		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error")
 	}
@@ -169,6 +249,10 @@ func (h *pasteHandler) UpdatePaste(c echo.Context) error {
 		Signature:  pasteReq.Signature,
 		Ciphertext: pasteReq.Ciphertext,
 		PublicKey:  pasteReq.PublicKey,
+		Algorithm:  pasteReq.Algorithm,
+
+		SignatureVersion: pasteReq.SignatureVersion,
+		ExpiresAt:        pasteReq.ExpiresAt,
 	}
 
 	ctx := c.Request().Context()
@@ -181,12 +265,57 @@ func (h *pasteHandler) UpdatePaste(c echo.Context) error {
 	case errors.Is(err, utils.ErrPasteInvalidExpiryTime):
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid expires_in")
 
-	case errors.Is(err, utils.ErrUnauthorizedAccess):
+	case errors.Is(err, utils.ErrUnauthorizedAccess), errors.Is(err, utils.ErrPasteUserNotFound):
 		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized access")
 
+	case errors.Is(err, utils.ErrPasteForbidden):
+		return echo.NewHTTPError(http.StatusForbidden, "Paste is owned by another user")
+
+	case errors.Is(err, utils.ErrPasteRevisionConflict):
+		return echo.NewHTTPError(http.StatusConflict, "Paste was updated concurrently, sign the new revision and retry")
+
+	case errors.Is(err, utils.ErrPasteDeleted):
+		return echo.NewHTTPError(http.StatusGone, "Paste deleted by owner")
+
+	case errors.Is(err, utils.ErrPasteBurned):
+		return echo.NewHTTPError(http.StatusGone, "Paste already read and burned")
+
+	case errors.Is(err, utils.ErrPasteViewsExhausted):
+		return echo.NewHTTPError(http.StatusGone, "Paste reached its maximum number of views")
+
+	case errors.Is(err, utils.ErrPasteInvalidID):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid paste ID")
+
 	case errors.Is(err, utils.ErrPasteInvalidCiphertext):
 		return echo.NewHTTPError(http.StatusBadRequest, "Bad request, invalid cipher text")
 
+	case errors.Is(err, utils.ErrPasteTooLarge):
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Paste too large")
+
+	case errors.Is(err, utils.ErrPasteEmptyCiphertext):
+		return echo.NewHTTPError(http.StatusBadRequest, "Empty ciphertext")
+
+	case errors.Is(err, utils.ErrPasteEmptySignature), errors.Is(err, utils.ErrPasteInvalidSignature):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid signature")
+
+	case errors.Is(err, utils.ErrPasteInvalidPublicKey):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid public key")
+
+	case errors.Is(err, utils.ErrUnsupportedAlgorithm):
+		return echo.NewHTTPError(http.StatusBadRequest, "Unsupported algorithm")
+
+	case errors.Is(err, utils.ErrPasteInvalidSignatureVerification):
+		return echo.NewHTTPError(http.StatusBadRequest, "Signature verification failed")
+
+	case errors.Is(err, utils.ErrPasteUnsupportedSignatureVersion):
+		return echo.NewHTTPError(http.StatusBadRequest, "Unsupported signature version")
+
+	case errors.Is(err, utils.ErrPasteLegacySignatureDisabled):
+		return echo.NewHTTPError(http.StatusBadRequest, "Legacy signatures are disabled, sign the v2 payload")
+
+	case errors.Is(err, utils.ErrPasteExpiredAlready), errors.Is(err, utils.ErrPasteExpiryTooLong):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid expires_in")
+
 	case err != nil:
 		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error, failed to update paste")
 	}
@@ -194,6 +323,174 @@ func (h *pasteHandler) UpdatePaste(c echo.Context) error {
 	return c.String(http.StatusOK, "paste updated")
 }
 
+func (h *pasteHandler) DeletePaste(c echo.Context) error {
+	id := c.Param("id")
+	if id == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "missing paste ID")
+	}
+
+	deleteReq := &DeleteRequest{}
+	if err := c.Bind(deleteReq); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid input")
+	}
+
+	userInfo, ok := c.Get("userInfo").(custom_middleware.UserInfo)
+	if !ok {
+		return echo.NewHTTPError(http.StatusInternalServerError, "User info not found in context")
+	}
+
+	ctx := c.Request().Context()
+	err := h.service.Delete(ctx, id, userInfo.Publickey, deleteReq.Signature)
+
+	switch {
+	case errors.Is(err, utils.ErrPasteInvalidID):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid paste ID")
+
+	case errors.Is(err, utils.ErrPasteEmptySignature), errors.Is(err, utils.ErrPasteInvalidSignature):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid signature")
+
+	case errors.Is(err, utils.ErrPasteInvalidPublicKey):
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized access")
+
+	case errors.Is(err, utils.ErrPasteNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "Paste not found")
+
+	case errors.Is(err, utils.ErrPasteDeleted):
+		return echo.NewHTTPError(http.StatusGone, "Paste deleted by owner")
+
+	case errors.Is(err, utils.ErrPasteBurned):
+		return echo.NewHTTPError(http.StatusGone, "Paste already read and burned")
+
+	case errors.Is(err, utils.ErrPasteViewsExhausted):
+		return echo.NewHTTPError(http.StatusGone, "Paste reached its maximum number of views")
+
+	case errors.Is(err, utils.ErrPasteForbidden):
+		return echo.NewHTTPError(http.StatusForbidden, "Paste is owned by another user")
+
+	case errors.Is(err, utils.ErrPasteInvalidSignatureVerification):
+		return echo.NewHTTPError(http.StatusBadRequest, "Signature verification failed")
+
+	case err != nil:
+		slog.Error("Error while deleting paste", "pasteid", id, "error", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error, failed to delete paste")
+	}
+
+	return c.String(http.StatusOK, "paste deleted")
+}
+
+func (h *pasteHandler) ListRevisions(c echo.Context) error {
+	id := c.Param("id")
+	if id == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing paste ID")
+	}
+
+	revisions, err := h.service.ListRevisions(c.Request().Context(), id)
+	if err != nil {
+		return lookupError(err)
+	}
+	return c.JSONPretty(http.StatusOK, revisions, " ")
+}
+
+func (h *pasteHandler) GetRevision(c echo.Context) error {
+	id := c.Param("id")
+	if id == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing paste ID")
+	}
+	n, err := strconv.Atoi(c.Param("n"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid revision")
+	}
+
+	revision, err := h.service.GetRevision(c.Request().Context(), id, n)
+	if err != nil {
+		return lookupError(err)
+	}
+	return c.JSONPretty(http.StatusOK, revision, " ")
+}
+
+// GetEnvelope returns the caller's content key envelope for a paste
+// addressed to them.
+func (h *pasteHandler) GetEnvelope(c echo.Context) error {
+	id := c.Param("id")
+	if id == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing paste ID")
+	}
+
+	userInfo, ok := c.Get("userInfo").(custom_middleware.UserInfo)
+	if !ok {
+		return echo.NewHTTPError(http.StatusInternalServerError, "User info not found in context")
+	}
+
+	envelope, err := h.service.GetEnvelope(c.Request().Context(), id, userInfo.UserID)
+	if errors.Is(err, utils.ErrPasteNotRecipient) {
+		return echo.NewHTTPError(http.StatusForbidden, "Paste is not addressed to you")
+	}
+	if errors.Is(err, utils.ErrUnauthorizedAccess) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized access")
+	}
+	if err != nil {
+		return lookupError(err)
+	}
+	return c.JSON(http.StatusOK, envelope)
+}
+
+// Inbox lists the pastes addressed to the caller. Query parameters: limit
+// (default 20, at most 100), offset, and unread=true to hide read pastes.
+func (h *pasteHandler) Inbox(c echo.Context) error {
+	userInfo, ok := c.Get("userInfo").(custom_middleware.UserInfo)
+	if !ok {
+		return echo.NewHTTPError(http.StatusInternalServerError, "User info not found in context")
+	}
+
+	var limit, offset int
+	var unreadOnly bool
+	err := echo.QueryParamsBinder(c).
+		Int("limit", &limit).
+		Int("offset", &offset).
+		Bool("unread", &unreadOnly).
+		BindError()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid pagination parameters")
+	}
+
+	inbox, err := h.service.Inbox(c.Request().Context(), userInfo.UserID, unreadOnly, limit, offset)
+	switch {
+	case errors.Is(err, utils.ErrInvalidPagination):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid pagination parameters")
+	case errors.Is(err, utils.ErrUnauthorizedAccess):
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized access")
+	case err != nil:
+		slog.Error("Error while listing inbox", "error", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error")
+	}
+	return c.JSON(http.StatusOK, inbox)
+}
+
+// lookupError maps errors of the endpoints hanging off an existing paste,
+// which fail the same way GetPaste does when the paste is unavailable.
+func lookupError(err error) error {
+	switch {
+	case errors.Is(err, utils.ErrPasteInvalidID):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid paste ID")
+	case errors.Is(err, utils.ErrPasteInvalidRevision):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid revision")
+	case errors.Is(err, utils.ErrPasteNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "Paste not found")
+	case errors.Is(err, utils.ErrPasteRevisionNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "Revision not found")
+	case errors.Is(err, utils.ErrPasteExpiredAlready):
+		return echo.NewHTTPError(http.StatusGone, "Paste already expired")
+	case errors.Is(err, utils.ErrPasteDeleted):
+		return echo.NewHTTPError(http.StatusGone, "Paste deleted by owner")
+	case errors.Is(err, utils.ErrPasteBurned):
+		return echo.NewHTTPError(http.StatusGone, "Paste already read and burned")
+	case errors.Is(err, utils.ErrPasteViewsExhausted):
+		return echo.NewHTTPError(http.StatusGone, "Paste reached its maximum number of views")
+	}
+	slog.Error("Error while looking up paste", "error", err)
+	return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error")
+}
+
 func (h *pasteHandler) GetByPublicKey(c echo.Context) error {
 	pubB64 := c.QueryParam("public_key")
 
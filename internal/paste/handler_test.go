@@ -0,0 +1,891 @@
+package paste
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	custom_middleware "Drop-Key/internal/middleware"
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/transparency"
+	"Drop-Key/internal/user"
+	"Drop-Key/internal/verifier"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type testIdentity struct {
+	user       *models.User
+	privateKey ed25519.PrivateKey
+	ecdsaKey   *ecdsa.PrivateKey
+}
+
+func (i *testIdentity) sign(message []byte) string {
+	if i.ecdsaKey != nil {
+		digest := sha256.Sum256(message)
+		sig, _ := ecdsa.SignASN1(rand.Reader, i.ecdsaKey, digest[:])
+		return base64.StdEncoding.EncodeToString(sig)
+	}
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(i.privateKey, message))
+}
+
+// createRequest builds a create request signed over the v2 payload.
+func (i *testIdentity) createRequest(message string) *PasteRequest {
+	req := &PasteRequest{
+		Ciphertext:       base64.StdEncoding.EncodeToString([]byte(message)),
+		PublicKey:        i.user.PublicKey,
+		Algorithm:        i.user.Algorithm,
+		SignatureVersion: SignatureVersionV2,
+		Nonce:            uuid.NewString(),
+		ExpiresAt:        time.Now().UTC().Add(time.Hour).Truncate(time.Second),
+	}
+	i.signCreate(req)
+	return req
+}
+
+// signCreate signs req over the v2 create payload, which has to be done
+// again after changing the fields it covers.
+func (i *testIdentity) signCreate(req *PasteRequest) {
+	ciphertext, _ := base64.StdEncoding.DecodeString(req.Ciphertext)
+	paste := &models.Paste{
+		ExpiresAt:     req.ExpiresAt,
+		BurnAfterRead: req.BurnAfterRead,
+		MaxViews:      req.MaxViews,
+	}
+	for _, recipient := range req.Recipients {
+		paste.Recipients = append(paste.Recipients, &models.PasteRecipient{PublicKey: recipient.PublicKey})
+	}
+	req.Signature = i.sign(SigningPayload(ActionCreate, req.Nonce, paste, ciphertext, nil))
+}
+
+// updateRequest builds an update request by signer for paste id, signed over
+// the v2 payload on top of the current version of the paste.
+func (env *handlerTestEnv) updateRequest(signer *testIdentity, id, message string) *PasteRequest {
+	paste := &models.Paste{
+		ExpiresAt: time.Now().UTC().Add(10 * time.Minute).Truncate(time.Second),
+		Revision:  2,
+	}
+	var previous []byte
+	if head, ok := env.pastes.pastes[id]; ok {
+		paste.Revision = head.Revision + 1
+		previous, _ = base64.StdEncoding.DecodeString(head.Ciphertext)
+	}
+	return &PasteRequest{
+		Ciphertext:       base64.StdEncoding.EncodeToString([]byte(message)),
+		Signature:        signer.sign(SigningPayload(ActionUpdate, id, paste, []byte(message), previous)),
+		PublicKey:        signer.user.PublicKey,
+		Algorithm:        signer.user.Algorithm,
+		SignatureVersion: SignatureVersionV2,
+		ExpiresAt:        paste.ExpiresAt,
+	}
+}
+
+func newTestIdentity(t *testing.T, users user.UserRepository) *testIdentity {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err, "should generate key")
+	user := &models.User{ID: uuid.NewString(), PublicKey: base64.StdEncoding.EncodeToString(pub)}
+	assert.NoError(t, users.Create(context.Background(), user))
+	return &testIdentity{user: user, privateKey: priv}
+}
+
+func newP256TestIdentity(t *testing.T, users user.UserRepository) *testIdentity {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "should generate key")
+	pub, err := key.PublicKey.ECDH()
+	assert.NoError(t, err)
+	user := &models.User{
+		ID:        uuid.NewString(),
+		PublicKey: base64.StdEncoding.EncodeToString(pub.Bytes()),
+		Algorithm: verifier.AlgorithmECDSAP256,
+	}
+	assert.NoError(t, users.Create(context.Background(), user))
+	return &testIdentity{user: user, ecdsaKey: key}
+}
+
+type handlerTestEnv struct {
+	echo   *echo.Echo
+	pastes *inMemoryPasteRepository
+	users  user.UserRepository
+}
+
+func setupHandlerTest(t *testing.T) *handlerTestEnv {
+	t.Helper()
+	return setupHandlerTestWithConfig(t, Config{})
+}
+
+func setupHandlerTestWithConfig(t *testing.T, config Config) *handlerTestEnv {
+	t.Helper()
+	pastes := NewInMemoryPasteRepository()
+	users := user.NewInMemoryUserRepository(transparency.NewInMemoryLogRepository())
+	handler := NewPasteHandler(NewPasteService(pastes, users, config))
+
+	e := echo.New()
+	// Stand-in for JwtAuth: trust the caller identity given in test headers.
+	authenticated := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("userInfo", custom_middleware.UserInfo{
+				UserID:    c.Request().Header.Get("X-Test-User"),
+				Publickey: c.Request().Header.Get("X-Test-Key"),
+			})
+			return next(c)
+		}
+	}
+	e.GET("/api/pastes/:id", handler.GetPaste)
+	e.GET("/api/pastes/:id/revisions", handler.ListRevisions)
+	e.GET("/api/pastes/:id/revisions/:n", handler.GetRevision)
+	e.GET("/api/pastes", handler.GetByPublicKey)
+	e.POST("/api/pastes", handler.CreatePaste, authenticated, BodyLimit(config))
+	e.PUT("/api/pastes/:id", handler.UpdatePaste, authenticated, BodyLimit(config))
+	e.DELETE("/api/pastes/:id", handler.DeletePaste, authenticated)
+	e.GET("/api/pastes/:id/envelope", handler.GetEnvelope, authenticated)
+	e.GET("/api/inbox", handler.Inbox, authenticated)
+
+	return &handlerTestEnv{echo: e, pastes: pastes, users: users}
+}
+
+func (env *handlerTestEnv) do(t *testing.T, method, path string, caller *testIdentity, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var payload string
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		assert.NoError(t, err, "should encode request body")
+		payload = string(encoded)
+	}
+	req := httptest.NewRequest(method, path, strings.NewReader(payload))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if caller != nil {
+		req.Header.Set("X-Test-User", caller.user.ID)
+		req.Header.Set("X-Test-Key", caller.user.PublicKey)
+	}
+	rec := httptest.NewRecorder()
+	env.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func (env *handlerTestEnv) createPaste(t *testing.T, owner *testIdentity, message string) string {
+	t.Helper()
+	rec := env.do(t, http.MethodPost, "/api/pastes", owner, owner.createRequest(message))
+	assert.Equal(t, http.StatusCreated, rec.Code, "should create paste: %s", rec.Body.String())
+
+	var created map[string]string
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created), "should decode create response")
+	return created["id"]
+}
+
+func TestUpdatePasteHandler(t *testing.T) {
+	env := setupHandlerTest(t)
+	owner := newTestIdentity(t, env.users)
+	attacker := newTestIdentity(t, env.users)
+	id := env.createPaste(t, owner, "original secret")
+
+	t.Run("owner can update", func(t *testing.T) {
+		rec := env.do(t, http.MethodPut, "/api/pastes/"+id, owner, env.updateRequest(owner, id, "updated secret"))
+		assert.Equal(t, http.StatusOK, rec.Code, "owner should update paste")
+		assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("updated secret")), env.pastes.pastes[id].Ciphertext, "ciphertext should be replaced")
+	})
+
+	t.Run("other user is forbidden", func(t *testing.T) {
+		rec := env.do(t, http.MethodPut, "/api/pastes/"+id, attacker, env.updateRequest(attacker, id, "hijacked"))
+		assert.Equal(t, http.StatusForbidden, rec.Code, "non owner should be forbidden")
+		assert.Equal(t, owner.user.PublicKey, env.pastes.pastes[id].PublicKey, "owner should not change")
+		assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("updated secret")), env.pastes.pastes[id].Ciphertext, "ciphertext should not change")
+	})
+
+	t.Run("token key must match body key", func(t *testing.T) {
+		rec := env.do(t, http.MethodPut, "/api/pastes/"+id, attacker, env.updateRequest(owner, id, "spoofed"))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, "mismatched key should be unauthorized")
+	})
+
+	t.Run("missing paste", func(t *testing.T) {
+		missing := uuid.NewString()
+		rec := env.do(t, http.MethodPut, "/api/pastes/"+missing, owner, env.updateRequest(owner, missing, "nothing here"))
+		assert.Equal(t, http.StatusNotFound, rec.Code, "unknown paste should be not found")
+	})
+
+	t.Run("invalid paste id", func(t *testing.T) {
+		rec := env.do(t, http.MethodPut, "/api/pastes/not-a-uuid", owner, env.updateRequest(owner, "not-a-uuid", "nothing here"))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "invalid id should be a bad request")
+	})
+
+	t.Run("bad signature", func(t *testing.T) {
+		req := env.updateRequest(owner, id, "updated secret")
+		req.Signature = owner.sign([]byte("something else"))
+		rec := env.do(t, http.MethodPut, "/api/pastes/"+id, owner, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "bad signature should be rejected")
+	})
+}
+
+func TestDeletePasteHandler(t *testing.T) {
+	env := setupHandlerTest(t)
+	owner := newTestIdentity(t, env.users)
+	attacker := newTestIdentity(t, env.users)
+	id := env.createPaste(t, owner, "short lived secret")
+
+	t.Run("other user is forbidden", func(t *testing.T) {
+		rec := env.do(t, http.MethodDelete, "/api/pastes/"+id, attacker, &DeleteRequest{Signature: attacker.sign(DeleteStatement(id))})
+		assert.Equal(t, http.StatusForbidden, rec.Code, "non owner should be forbidden")
+	})
+
+	t.Run("signature over another paste", func(t *testing.T) {
+		rec := env.do(t, http.MethodDelete, "/api/pastes/"+id, owner, &DeleteRequest{Signature: owner.sign(DeleteStatement(uuid.NewString()))})
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "signature must cover this paste")
+	})
+
+	t.Run("missing signature", func(t *testing.T) {
+		rec := env.do(t, http.MethodDelete, "/api/pastes/"+id, owner, &DeleteRequest{})
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "signature is required")
+	})
+
+	t.Run("owner deletes", func(t *testing.T) {
+		rec := env.do(t, http.MethodDelete, "/api/pastes/"+id, owner, &DeleteRequest{Signature: owner.sign(DeleteStatement(id))})
+		assert.Equal(t, http.StatusOK, rec.Code, "owner should delete paste")
+		assert.Empty(t, env.pastes.pastes[id].Ciphertext, "ciphertext should be wiped")
+		assert.NotEmpty(t, env.pastes.pastes[id].DeleteSignature, "tombstone should keep the delete signature")
+	})
+
+	t.Run("get after delete", func(t *testing.T) {
+		rec := env.do(t, http.MethodGet, "/api/pastes/"+id, nil, nil)
+		assert.Equal(t, http.StatusGone, rec.Code, "deleted paste should be gone")
+		assert.Contains(t, rec.Body.String(), "deleted by owner", "should explain why the paste is gone")
+	})
+
+	t.Run("delete twice", func(t *testing.T) {
+		rec := env.do(t, http.MethodDelete, "/api/pastes/"+id, owner, &DeleteRequest{Signature: owner.sign(DeleteStatement(id))})
+		assert.Equal(t, http.StatusGone, rec.Code, "deleted paste should be gone")
+	})
+
+	t.Run("missing paste", func(t *testing.T) {
+		missing := uuid.NewString()
+		rec := env.do(t, http.MethodDelete, "/api/pastes/"+missing, owner, &DeleteRequest{Signature: owner.sign(DeleteStatement(missing))})
+		assert.Equal(t, http.StatusNotFound, rec.Code, "unknown paste should be not found")
+	})
+}
+
+func TestGetPasteHandlerExpired(t *testing.T) {
+	env := setupHandlerTest(t)
+	owner := newTestIdentity(t, env.users)
+	id := env.createPaste(t, owner, "expiring secret")
+	env.pastes.pastes[id].ExpiresAt = time.Now().UTC().Add(-time.Minute)
+
+	rec := env.do(t, http.MethodGet, "/api/pastes/"+id, nil, nil)
+	assert.Equal(t, http.StatusGone, rec.Code, "expired paste should be gone")
+	assert.Contains(t, rec.Body.String(), "expired", "should explain why the paste is gone")
+}
+
+func TestBurnAfterReadHandler(t *testing.T) {
+	env := setupHandlerTest(t)
+	owner := newTestIdentity(t, env.users)
+
+	message := "one time password"
+	req := owner.createRequest(message)
+	req.BurnAfterRead = true
+	owner.signCreate(req)
+	rec := env.do(t, http.MethodPost, "/api/pastes", owner, req)
+	assert.Equal(t, http.StatusCreated, rec.Code, "should create paste")
+	var created map[string]string
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created), "should decode create response")
+	id := created["id"]
+
+	rec = env.do(t, http.MethodGet, "/api/pastes/"+id, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code, "first read should succeed")
+	var fetched models.Paste
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &fetched), "should decode paste")
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte(message)), fetched.Ciphertext, "first read should return the ciphertext")
+
+	rec = env.do(t, http.MethodGet, "/api/pastes/"+id, nil, nil)
+	assert.Equal(t, http.StatusGone, rec.Code, "second read should be gone")
+	assert.Contains(t, rec.Body.String(), "burned", "should explain why the paste is gone")
+	assert.Empty(t, env.pastes.pastes[id].Ciphertext, "ciphertext should be wiped")
+}
+
+func TestMaxViewsHandler(t *testing.T) {
+	env := setupHandlerTest(t)
+	owner := newTestIdentity(t, env.users)
+
+	message := "shared with two people"
+	req := owner.createRequest(message)
+	req.MaxViews = 2
+	owner.signCreate(req)
+	rec := env.do(t, http.MethodPost, "/api/pastes", owner, req)
+	assert.Equal(t, http.StatusCreated, rec.Code, "should create paste")
+	var created map[string]string
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created), "should decode create response")
+	id := created["id"]
+
+	listViews := func() int {
+		rec := env.do(t, http.MethodGet, "/api/pastes?public_key="+url.QueryEscape(owner.user.PublicKey), nil, nil)
+		assert.Equal(t, http.StatusOK, rec.Code, "owner listing should succeed")
+		var pastes []models.Paste
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pastes), "should decode listing")
+		if assert.Len(t, pastes, 1, "listing should include the paste") {
+			return pastes[0].Views
+		}
+		return -1
+	}
+	assert.Equal(t, 0, listViews(), "paste should not be opened yet")
+
+	for i := 1; i <= 2; i++ {
+		rec = env.do(t, http.MethodGet, "/api/pastes/"+id, nil, nil)
+		assert.Equal(t, http.StatusOK, rec.Code, "read %d should succeed", i)
+		assert.Equal(t, i, listViews(), "listing should show the view count")
+	}
+
+	rec = env.do(t, http.MethodGet, "/api/pastes/"+id, nil, nil)
+	assert.Equal(t, http.StatusGone, rec.Code, "read beyond max_views should be gone")
+	assert.Contains(t, rec.Body.String(), "maximum number of views", "should explain why the paste is gone")
+
+	req = owner.createRequest(message)
+	req.MaxViews = -1
+	rec = env.do(t, http.MethodPost, "/api/pastes", owner, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "negative max_views should be rejected")
+}
+
+func TestSignedPasteHandler(t *testing.T) {
+	legacyRequest := func(owner *testIdentity, message string) *PasteRequest {
+		return &PasteRequest{
+			Ciphertext: base64.StdEncoding.EncodeToString([]byte(message)),
+			Signature:  owner.sign([]byte(message)),
+			PublicKey:  owner.user.PublicKey,
+			Expires_in: 3600,
+		}
+	}
+
+	t.Run("legacy signature rejected by default", func(t *testing.T) {
+		env := setupHandlerTest(t)
+		owner := newTestIdentity(t, env.users)
+		rec := env.do(t, http.MethodPost, "/api/pastes", owner, legacyRequest(owner, "old client"))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "legacy signature should be rejected")
+		assert.Contains(t, rec.Body.String(), "Legacy signatures are disabled")
+	})
+
+	t.Run("legacy signature accepted when allowed", func(t *testing.T) {
+		env := setupHandlerTestWithConfig(t, Config{AllowLegacySignatures: true})
+		owner := newTestIdentity(t, env.users)
+		rec := env.do(t, http.MethodPost, "/api/pastes", owner, legacyRequest(owner, "old client"))
+		assert.Equal(t, http.StatusCreated, rec.Code, "legacy signature should be accepted: %s", rec.Body.String())
+		for _, paste := range env.pastes.pastes {
+			assert.Equal(t, SignatureVersionLegacy, paste.SignatureVersion, "paste should be stored as legacy")
+		}
+	})
+
+	env := setupHandlerTest(t)
+	owner := newTestIdentity(t, env.users)
+
+	t.Run("signed expiry is stored", func(t *testing.T) {
+		req := owner.createRequest("signed")
+		rec := env.do(t, http.MethodPost, "/api/pastes", owner, req)
+		assert.Equal(t, http.StatusCreated, rec.Code, "should create paste: %s", rec.Body.String())
+		var created map[string]string
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created), "should decode create response")
+		stored := env.pastes.pastes[created["id"]]
+		assert.True(t, req.ExpiresAt.Equal(stored.ExpiresAt), "expiry should be the signed one")
+		assert.Equal(t, SignatureVersionV2, stored.SignatureVersion)
+		assert.Equal(t, req.Nonce, stored.Nonce)
+	})
+
+	t.Run("replayed create", func(t *testing.T) {
+		req := owner.createRequest("posted once")
+		rec := env.do(t, http.MethodPost, "/api/pastes", owner, req)
+		assert.Equal(t, http.StatusCreated, rec.Code, "should create paste")
+		rec = env.do(t, http.MethodPost, "/api/pastes", owner, req)
+		assert.Equal(t, http.StatusConflict, rec.Code, "replayed create should be rejected")
+	})
+
+	t.Run("missing nonce", func(t *testing.T) {
+		req := owner.createRequest("no nonce")
+		req.Nonce = ""
+		rec := env.do(t, http.MethodPost, "/api/pastes", owner, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "nonce should be required")
+	})
+
+	t.Run("changed expiry", func(t *testing.T) {
+		req := owner.createRequest("expiry bound")
+		req.ExpiresAt = req.ExpiresAt.Add(time.Hour)
+		rec := env.do(t, http.MethodPost, "/api/pastes", owner, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expiry should be covered by the signature")
+	})
+
+	t.Run("changed read limits", func(t *testing.T) {
+		req := owner.createRequest("read limits bound")
+		req.MaxViews = 100
+		rec := env.do(t, http.MethodPost, "/api/pastes", owner, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "max views should be covered by the signature")
+
+		req = owner.createRequest("read limits bound")
+		req.BurnAfterRead = true
+		rec = env.do(t, http.MethodPost, "/api/pastes", owner, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "burn after read should be covered by the signature")
+	})
+
+	t.Run("changed recipients", func(t *testing.T) {
+		reader := newTestIdentity(t, env.users)
+		req := owner.createRequest("recipients bound")
+		req.Recipients = []RecipientRequest{{PublicKey: reader.user.PublicKey, Envelope: base64.StdEncoding.EncodeToString([]byte("key"))}}
+		rec := env.do(t, http.MethodPost, "/api/pastes", owner, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "recipients should be covered by the signature")
+	})
+
+	t.Run("replayed update", func(t *testing.T) {
+		id := env.createPaste(t, owner, "original")
+		req := env.updateRequest(owner, id, "first edit")
+		rec := env.do(t, http.MethodPut, "/api/pastes/"+id, owner, req)
+		assert.Equal(t, http.StatusOK, rec.Code, "should update paste: %s", rec.Body.String())
+		rec = env.do(t, http.MethodPut, "/api/pastes/"+id, owner, env.updateRequest(owner, id, "second edit"))
+		assert.Equal(t, http.StatusOK, rec.Code, "should update paste: %s", rec.Body.String())
+
+		rec = env.do(t, http.MethodPut, "/api/pastes/"+id, owner, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "update should be bound to the version it replaced")
+		assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("second edit")), env.pastes.pastes[id].Ciphertext, "ciphertext should not roll back")
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		req := owner.createRequest("from the future")
+		req.SignatureVersion = 3
+		rec := env.do(t, http.MethodPost, "/api/pastes", owner, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "unknown version should be rejected")
+	})
+
+	t.Run("update swapped into another paste", func(t *testing.T) {
+		first := env.createPaste(t, owner, "first")
+		second := env.createPaste(t, owner, "second")
+		rec := env.do(t, http.MethodPut, "/api/pastes/"+second, owner, env.updateRequest(owner, first, "meant for first"))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "update signature should be bound to the paste id")
+		assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("second")), env.pastes.pastes[second].Ciphertext, "ciphertext should not change")
+	})
+}
+
+func TestRevisionsHandler(t *testing.T) {
+	env := setupHandlerTestWithConfig(t, Config{RevisionRetention: 2})
+	owner := newTestIdentity(t, env.users)
+	id := env.createPaste(t, owner, "version 1")
+
+	for _, message := range []string{"version 2", "version 3", "version 4"} {
+		rec := env.do(t, http.MethodPut, "/api/pastes/"+id, owner, env.updateRequest(owner, id, message))
+		assert.Equal(t, http.StatusOK, rec.Code, "owner should update paste: %s", rec.Body.String())
+	}
+	assert.Equal(t, 4, env.pastes.pastes[id].Revision, "head should be at revision 4")
+
+	t.Run("list", func(t *testing.T) {
+		rec := env.do(t, http.MethodGet, "/api/pastes/"+id+"/revisions", nil, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var revisions []models.PasteRevision
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &revisions), "should decode revisions")
+		if assert.Len(t, revisions, 2, "only the configured number of revisions should be kept") {
+			assert.Equal(t, 2, revisions[0].Revision)
+			assert.Equal(t, 3, revisions[1].Revision)
+			assert.Empty(t, revisions[0].Ciphertext, "listing should not include ciphertext")
+		}
+	})
+
+	t.Run("get", func(t *testing.T) {
+		rec := env.do(t, http.MethodGet, "/api/pastes/"+id+"/revisions/3", nil, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var revision models.PasteRevision
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &revision), "should decode revision")
+		assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("version 3")), revision.Ciphertext)
+
+		ciphertext, _ := base64.StdEncoding.DecodeString(revision.Ciphertext)
+		signature, _ := base64.StdEncoding.DecodeString(revision.Signature)
+		// Revision 3 was signed on top of revision 2.
+		signed := &models.Paste{ExpiresAt: revision.ExpiresAt, Revision: revision.Revision}
+		payload := SigningPayload(ActionUpdate, id, signed, ciphertext, []byte("version 2"))
+		assert.True(t, ed25519.Verify(owner.privateKey.Public().(ed25519.PublicKey), payload, signature), "revision should keep its signature")
+	})
+
+	t.Run("pruned revision", func(t *testing.T) {
+		rec := env.do(t, http.MethodGet, "/api/pastes/"+id+"/revisions/1", nil, nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code, "pruned revision should be not found")
+	})
+
+	t.Run("head is not a revision", func(t *testing.T) {
+		rec := env.do(t, http.MethodGet, "/api/pastes/"+id+"/revisions/4", nil, nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code, "head should be read through the paste endpoint")
+	})
+
+	t.Run("invalid revision", func(t *testing.T) {
+		rec := env.do(t, http.MethodGet, "/api/pastes/"+id+"/revisions/zero", nil, nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		rec = env.do(t, http.MethodGet, "/api/pastes/"+id+"/revisions/0", nil, nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("view limited pastes keep no history", func(t *testing.T) {
+		req := owner.createRequest("limited")
+		req.MaxViews = 5
+		owner.signCreate(req)
+		rec := env.do(t, http.MethodPost, "/api/pastes", owner, req)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		var created map[string]string
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created), "should decode create response")
+		limited := created["id"]
+
+		rec = env.do(t, http.MethodPut, "/api/pastes/"+limited, owner, env.updateRequest(owner, limited, "limited v2"))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		rec = env.do(t, http.MethodGet, "/api/pastes/"+limited+"/revisions/1", nil, nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code, "old content of a view limited paste should not be readable")
+	})
+
+	t.Run("history follows the head", func(t *testing.T) {
+		env.pastes.pastes[id].ExpiresAt = time.Now().UTC().Add(-time.Minute)
+		rec := env.do(t, http.MethodGet, "/api/pastes/"+id+"/revisions/3", nil, nil)
+		assert.Equal(t, http.StatusGone, rec.Code, "revisions should expire with the head")
+		rec = env.do(t, http.MethodGet, "/api/pastes/"+id+"/revisions", nil, nil)
+		assert.Equal(t, http.StatusGone, rec.Code, "revisions should expire with the head")
+	})
+}
+
+func TestPasteSizeHandler(t *testing.T) {
+	env := setupHandlerTestWithConfig(t, Config{MaxSize: 16})
+	owner := newTestIdentity(t, env.users)
+
+	id := env.createPaste(t, owner, strings.Repeat("a", 16))
+	rec := env.do(t, http.MethodPost, "/api/pastes", owner, owner.createRequest(strings.Repeat("a", 17)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, "should refuse a paste over the size limit")
+	rec = env.do(t, http.MethodPut, "/api/pastes/"+id, owner, env.updateRequest(owner, id, strings.Repeat("a", 17)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, "should refuse an update over the size limit")
+
+	rec = env.do(t, http.MethodPost, "/api/pastes", owner, owner.createRequest(strings.Repeat("a", 1<<20)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, "should refuse a request body over the limit")
+}
+
+func TestRecipientsHandler(t *testing.T) {
+	env := setupHandlerTest(t)
+	author := newTestIdentity(t, env.users)
+	teammates := []*testIdentity{newTestIdentity(t, env.users), newTestIdentity(t, env.users), newTestIdentity(t, env.users)}
+	outsider := newTestIdentity(t, env.users)
+
+	envelopeFor := func(recipient *testIdentity) string {
+		return base64.StdEncoding.EncodeToString([]byte("wrapped key for " + recipient.user.ID))
+	}
+
+	req := author.createRequest("team secret")
+	for _, teammate := range teammates {
+		req.Recipients = append(req.Recipients, RecipientRequest{PublicKey: teammate.user.PublicKey, Envelope: envelopeFor(teammate)})
+	}
+	author.signCreate(req)
+	rec := env.do(t, http.MethodPost, "/api/pastes", author, req)
+	assert.Equal(t, http.StatusCreated, rec.Code, "should create paste: %s", rec.Body.String())
+	var created map[string]string
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created), "should decode create response")
+	id := created["id"]
+
+	t.Run("each recipient gets their own envelope", func(t *testing.T) {
+		for _, teammate := range teammates {
+			rec := env.do(t, http.MethodGet, "/api/pastes/"+id+"/envelope", teammate, nil)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			var envelope models.PasteRecipient
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &envelope), "should decode envelope")
+			assert.Equal(t, envelopeFor(teammate), envelope.Envelope)
+			assert.Equal(t, teammate.user.PublicKey, envelope.PublicKey)
+		}
+	})
+
+	t.Run("non recipient is forbidden", func(t *testing.T) {
+		rec := env.do(t, http.MethodGet, "/api/pastes/"+id+"/envelope", outsider, nil)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("paste does not list recipients", func(t *testing.T) {
+		rec := env.do(t, http.MethodGet, "/api/pastes/"+id, nil, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotContains(t, rec.Body.String(), teammates[0].user.PublicKey, "recipients should not be public")
+	})
+
+	t.Run("unregistered recipient", func(t *testing.T) {
+		pub, _, _ := ed25519.GenerateKey(nil)
+		req := author.createRequest("to a stranger")
+		req.Recipients = []RecipientRequest{{PublicKey: base64.StdEncoding.EncodeToString(pub), Envelope: envelopeFor(outsider)}}
+		rec := env.do(t, http.MethodPost, "/api/pastes", author, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "not a registered user")
+	})
+
+	t.Run("duplicate recipient", func(t *testing.T) {
+		req := author.createRequest("twice")
+		recipient := RecipientRequest{PublicKey: outsider.user.PublicKey, Envelope: envelopeFor(outsider)}
+		req.Recipients = []RecipientRequest{recipient, recipient}
+		rec := env.do(t, http.MethodPost, "/api/pastes", author, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("invalid envelope", func(t *testing.T) {
+		req := author.createRequest("bad envelope")
+		req.Recipients = []RecipientRequest{{PublicKey: outsider.user.PublicKey, Envelope: "not base64!"}}
+		rec := env.do(t, http.MethodPost, "/api/pastes", author, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("deleted paste", func(t *testing.T) {
+		rec := env.do(t, http.MethodDelete, "/api/pastes/"+id, author, &DeleteRequest{Signature: author.sign(DeleteStatement(id))})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		rec = env.do(t, http.MethodGet, "/api/pastes/"+id+"/envelope", teammates[0], nil)
+		assert.Equal(t, http.StatusGone, rec.Code, "envelope should go with the paste")
+	})
+}
+
+func TestInboxHandler(t *testing.T) {
+	env := setupHandlerTest(t)
+	author := newTestIdentity(t, env.users)
+	reader := newTestIdentity(t, env.users)
+	bystander := newTestIdentity(t, env.users)
+
+	send := func(message string, to ...*testIdentity) string {
+		req := author.createRequest(message)
+		for _, recipient := range to {
+			req.Recipients = append(req.Recipients, RecipientRequest{PublicKey: recipient.user.PublicKey, Envelope: base64.StdEncoding.EncodeToString([]byte("key"))})
+		}
+		author.signCreate(req)
+		rec := env.do(t, http.MethodPost, "/api/pastes", author, req)
+		assert.Equal(t, http.StatusCreated, rec.Code, "should create paste: %s", rec.Body.String())
+		var created map[string]string
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created), "should decode create response")
+		return created["id"]
+	}
+	inbox := func(caller *testIdentity, query string) (int, *Inbox) {
+		rec := env.do(t, http.MethodGet, "/api/inbox"+query, caller, nil)
+		if rec.Code != http.StatusOK {
+			return rec.Code, nil
+		}
+		var page Inbox
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page), "should decode inbox")
+		return rec.Code, &page
+	}
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ids = append(ids, send("for reader", reader))
+	}
+	send("for someone else", bystander)
+	expired := send("expired", reader)
+	env.pastes.pastes[expired].ExpiresAt = time.Now().UTC().Add(-time.Minute)
+	deleted := send("deleted", reader)
+	rec := env.do(t, http.MethodDelete, "/api/pastes/"+deleted, author, &DeleteRequest{Signature: author.sign(DeleteStatement(deleted))})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	t.Run("lists only live pastes addressed to me", func(t *testing.T) {
+		code, page := inbox(reader, "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 3, page.Total)
+		assert.Equal(t, 3, page.Unread)
+		assert.Equal(t, DefaultInboxLimit, page.Limit)
+		if assert.Len(t, page.Items, 3) {
+			assert.ElementsMatch(t, ids, []string{page.Items[0].PasteID, page.Items[1].PasteID, page.Items[2].PasteID})
+			assert.Equal(t, author.user.PublicKey, page.Items[0].AuthorPublicKey)
+			assert.False(t, page.Items[0].Read)
+			assert.Nil(t, page.Items[0].ReadAt)
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		_, first := inbox(reader, "?limit=2")
+		_, second := inbox(reader, "?limit=2&offset=2")
+		if assert.Len(t, first.Items, 2) && assert.Len(t, second.Items, 1) {
+			assert.NotContains(t, []string{first.Items[0].PasteID, first.Items[1].PasteID}, second.Items[0].PasteID, "pages should not overlap")
+		}
+		assert.Equal(t, 3, second.Total)
+	})
+
+	t.Run("fetching the envelope marks it read", func(t *testing.T) {
+		rec := env.do(t, http.MethodGet, "/api/pastes/"+ids[0]+"/envelope", reader, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		_, page := inbox(reader, "")
+		assert.Equal(t, 2, page.Unread)
+		for _, item := range page.Items {
+			if item.PasteID == ids[0] {
+				assert.True(t, item.Read)
+				assert.NotNil(t, item.ReadAt)
+			}
+		}
+
+		_, unread := inbox(reader, "?unread=true")
+		assert.Len(t, unread.Items, 2, "unread filter should hide read pastes")
+	})
+
+	t.Run("device sessions share the inbox", func(t *testing.T) {
+		pub, _, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err, "should generate key")
+		device := &models.DeviceKey{ID: uuid.NewString(), UserID: reader.user.ID, Name: "phone", PublicKey: base64.StdEncoding.EncodeToString(pub)}
+		assert.NoError(t, env.users.CreateDevice(context.Background(), device))
+		phone := &testIdentity{user: &models.User{ID: reader.user.ID, PublicKey: device.PublicKey}}
+
+		code, page := inbox(phone, "")
+		if assert.Equal(t, http.StatusOK, code) {
+			assert.Equal(t, 3, page.Total, "a device session should see the user's inbox")
+			assert.Equal(t, 2, page.Unread)
+		}
+		rec := env.do(t, http.MethodGet, "/api/pastes/"+ids[1]+"/envelope", phone, nil)
+		assert.Equal(t, http.StatusOK, rec.Code, "a device session should open the user's envelopes")
+		_, page = inbox(reader, "")
+		assert.Equal(t, 1, page.Unread, "reading on a device should mark the paste read for the user")
+	})
+
+	t.Run("rotating the key keeps the inbox", func(t *testing.T) {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err, "should generate key")
+		err = env.users.RotateKey(context.Background(), reader.user, &models.UserKey{
+			UserID:    reader.user.ID,
+			Sequence:  2,
+			PublicKey: base64.StdEncoding.EncodeToString(pub),
+		})
+		assert.NoError(t, err, "should rotate key")
+		reader.user.PublicKey = base64.StdEncoding.EncodeToString(pub)
+		reader.privateKey = priv
+
+		code, page := inbox(reader, "")
+		if assert.Equal(t, http.StatusOK, code) {
+			assert.Equal(t, 3, page.Total, "pastes sent to the retired key should stay in the inbox")
+			assert.Equal(t, 1, page.Unread)
+		}
+		rec := env.do(t, http.MethodGet, "/api/pastes/"+ids[2]+"/envelope", reader, nil)
+		assert.Equal(t, http.StatusOK, rec.Code, "envelopes sent to the retired key should still open")
+
+		latest := send("after rotation", reader)
+		_, page = inbox(reader, "?unread=true")
+		if assert.Len(t, page.Items, 1) {
+			assert.Equal(t, latest, page.Items[0].PasteID, "pastes sent to the new key should arrive in the same inbox")
+		}
+	})
+
+	t.Run("invalid pagination", func(t *testing.T) {
+		for _, query := range []string{"?limit=0&offset=-1", "?limit=101", "?limit=abc"} {
+			code, _ := inbox(reader, query)
+			assert.Equal(t, http.StatusBadRequest, code, query)
+		}
+	})
+}
+
+func TestKeyRotationKeepsPastes(t *testing.T) {
+	env := setupHandlerTest(t)
+	owner := newTestIdentity(t, env.users)
+	first := env.createPaste(t, owner, "signed with the first key")
+	second := env.createPaste(t, owner, "also signed with the first key")
+
+	retired := &testIdentity{
+		user:       &models.User{ID: owner.user.ID, PublicKey: owner.user.PublicKey},
+		privateKey: owner.privateKey,
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err, "should generate key")
+	err = env.users.RotateKey(context.Background(), owner.user, &models.UserKey{
+		UserID:    owner.user.ID,
+		Sequence:  2,
+		PublicKey: base64.StdEncoding.EncodeToString(pub),
+	})
+	assert.NoError(t, err, "should rotate key")
+	owner.user.PublicKey = base64.StdEncoding.EncodeToString(pub)
+	owner.privateKey = priv
+
+	t.Run("pastes stay listed under the new key", func(t *testing.T) {
+		rec := env.do(t, http.MethodGet, "/api/pastes?public_key="+url.QueryEscape(owner.user.PublicKey), nil, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var pastes []*models.Paste
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pastes))
+		assert.Len(t, pastes, 2, "pastes signed with the retired key should still be listed")
+	})
+
+	t.Run("the new key can update and delete them", func(t *testing.T) {
+		rec := env.do(t, http.MethodPut, "/api/pastes/"+first, owner, env.updateRequest(owner, first, "re-signed"))
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, owner.user.PublicKey, env.pastes.pastes[first].PublicKey, "should be signed by the new key now")
+
+		rec = env.do(t, http.MethodDelete, "/api/pastes/"+second, owner, &DeleteRequest{Signature: owner.sign(DeleteStatement(second))})
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	})
+
+	t.Run("the retired key cannot", func(t *testing.T) {
+		rec := env.do(t, http.MethodPost, "/api/pastes", retired, retired.createRequest("late"))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		rec = env.do(t, http.MethodPut, "/api/pastes/"+first, retired, env.updateRequest(retired, first, "hijack"))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+
+func TestDevicePasteHandler(t *testing.T) {
+	env := setupHandlerTest(t)
+	owner := newTestIdentity(t, env.users)
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err, "should generate key")
+	device := &models.DeviceKey{ID: uuid.NewString(), UserID: owner.user.ID, Name: "phone", PublicKey: base64.StdEncoding.EncodeToString(pub)}
+	assert.NoError(t, env.users.CreateDevice(context.Background(), device))
+	phone := &testIdentity{user: &models.User{ID: owner.user.ID, PublicKey: device.PublicKey}, privateKey: priv}
+
+	id := env.createPaste(t, phone, "from my phone")
+	stored := env.pastes.pastes[id]
+	assert.Equal(t, owner.user.ID, stored.UserID, "device pastes should belong to the user")
+	assert.Equal(t, device.ID, stored.DeviceID, "paste should record the signing device")
+
+	rec := env.do(t, http.MethodGet, "/api/pastes?public_key="+url.QueryEscape(owner.user.PublicKey), nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), id, "device pastes should be listed under the primary key")
+	rec = env.do(t, http.MethodGet, "/api/pastes?public_key="+url.QueryEscape(device.PublicKey), nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code, "should list the user's pastes by a device key")
+	assert.Contains(t, rec.Body.String(), id)
+
+	rec = env.do(t, http.MethodPut, "/api/pastes/"+id, owner, env.updateRequest(owner, id, "edited on my laptop"))
+	assert.Equal(t, http.StatusOK, rec.Code, "other keys of the user should update device pastes")
+	assert.Empty(t, stored.DeviceID, "primary key pastes should name no device")
+
+	assert.NoError(t, env.users.RevokeDevice(context.Background(), owner.user.ID, device.ID, time.Now().UTC()))
+	rec = env.do(t, http.MethodPost, "/api/pastes", phone, phone.createRequest("after revocation"))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "revoked devices should not create pastes")
+	rec = env.do(t, http.MethodDelete, "/api/pastes/"+id, phone, &DeleteRequest{Signature: phone.sign(DeleteStatement(id))})
+	assert.Equal(t, http.StatusForbidden, rec.Code, "revoked devices should not delete pastes")
+}
+
+func TestP256PasteHandler(t *testing.T) {
+	env := setupHandlerTest(t)
+	owner := newP256TestIdentity(t, env.users)
+
+	id := env.createPaste(t, owner, "signed with P-256")
+	assert.Equal(t, verifier.AlgorithmECDSAP256, env.pastes.pastes[id].Algorithm, "algorithm should be stored")
+
+	rec := env.do(t, http.MethodGet, "/api/pastes/"+id, nil, nil)
+	var fetched models.Paste
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &fetched))
+	assert.Equal(t, user.Fingerprint(verifier.AlgorithmECDSAP256, owner.user.PublicKey), fetched.Fingerprint, "paste should carry the signing key fingerprint")
+
+	rec = env.do(t, http.MethodPut, "/api/pastes/"+id, owner, env.updateRequest(owner, id, "updated"))
+	assert.Equal(t, http.StatusOK, rec.Code, "P-256 owner should update: %s", rec.Body.String())
+
+	t.Run("algorithm must match the key", func(t *testing.T) {
+		req := owner.createRequest("mislabelled")
+		req.Algorithm = verifier.AlgorithmEd25519
+		rec := env.do(t, http.MethodPost, "/api/pastes", owner, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		ed := newTestIdentity(t, env.users)
+		req = ed.createRequest("mislabelled")
+		req.Algorithm = verifier.AlgorithmECDSAP256
+		rec = env.do(t, http.MethodPost, "/api/pastes", ed, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "an Ed25519 key is not a P-256 key")
+	})
+
+	t.Run("unknown algorithm", func(t *testing.T) {
+		req := owner.createRequest("unknown")
+		req.Algorithm = "rsa"
+		rec := env.do(t, http.MethodPost, "/api/pastes", owner, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Unsupported algorithm")
+	})
+
+	rec = env.do(t, http.MethodDelete, "/api/pastes/"+id, owner, &DeleteRequest{Signature: owner.sign(DeleteStatement(id))})
+	assert.Equal(t, http.StatusOK, rec.Code, "P-256 owner should delete: %s", rec.Body.String())
+}
@@ -0,0 +1,411 @@
+package paste
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+	"Drop-Key/internal/verifier"
+)
+
+// inMemoryPasteRepository keeps pastes in memory, for tests and for
+// deployments that store nothing on disk. Pastes are lost on restart.
+type inMemoryPasteRepository struct {
+	mu         sync.Mutex
+	pastes     map[string]*models.Paste
+	revisions  map[string][]*models.PasteRevision
+	recipients map[string][]*models.PasteRecipient
+}
+
+func NewInMemoryPasteRepository() *inMemoryPasteRepository {
+	return &inMemoryPasteRepository{
+		pastes:     make(map[string]*models.Paste),
+		revisions:  make(map[string][]*models.PasteRevision),
+		recipients: make(map[string][]*models.PasteRecipient),
+	}
+}
+
+func (r *inMemoryPasteRepository) Create(ctx context.Context, paste *models.Paste) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.pastes[paste.ID]; ok {
+		return utils.WrapError(utils.ErrDatabase, "paste "+paste.ID+" already exists")
+	}
+	if paste.Nonce != "" {
+		for _, existing := range r.pastes {
+			if existing.Nonce == paste.Nonce {
+				return utils.ErrPasteNonceReused
+			}
+		}
+	}
+	seen := make(map[string]bool, len(paste.Recipients))
+	for _, recipient := range paste.Recipients {
+		if seen[recipient.PublicKey] {
+			return utils.ErrPasteDuplicateRecipient
+		}
+		seen[recipient.PublicKey] = true
+	}
+
+	var recipients []*models.PasteRecipient
+	for _, recipient := range paste.Recipients {
+		recipient.PasteID = paste.ID
+		if recipient.ReceivedAt.IsZero() {
+			recipient.ReceivedAt = time.Now().UTC().Truncate(time.Second)
+		}
+		stored := *recipient
+		stored.Paste = nil
+		recipients = append(recipients, &stored)
+	}
+	// Fill in the column defaults the database would.
+	stored := storedPaste(paste)
+	if stored.Algorithm == "" {
+		stored.Algorithm = verifier.AlgorithmEd25519
+	}
+	if stored.SignatureVersion == 0 {
+		stored.SignatureVersion = 1
+	}
+	if stored.Revision == 0 {
+		stored.Revision = 1
+	}
+	r.pastes[paste.ID] = stored
+	if len(recipients) > 0 {
+		r.recipients[paste.ID] = recipients
+	}
+	return nil
+}
+
+// storedPaste copies the columns of paste, leaving out its relations.
+func storedPaste(paste *models.Paste) *models.Paste {
+	stored := *paste
+	stored.User, stored.Recipients = nil, nil
+	return &stored
+}
+
+// GetByID returns a paste to a reader and counts the view, wiping the
+// content when the read uses up the paste's last allowed view.
+func (r *inMemoryPasteRepository) GetByID(ctx context.Context, id string) (*models.Paste, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.pastes[id]
+	if !ok {
+		return nil, utils.ErrPasteNotFound
+	}
+	if err := checkAvailable(stored); err != nil {
+		return nil, err
+	}
+	if stored.MaxViews > 0 && stored.Views >= stored.MaxViews {
+		return nil, exhaustedError(stored)
+	}
+	stored.Views++
+	paste := storedPaste(stored)
+
+	limit := stored.MaxViews
+	if stored.BurnAfterRead {
+		limit = 1
+	}
+	if limit > 0 && stored.Views >= limit {
+		r.wipe(stored, exhaustedTombstone(stored))
+	}
+	return paste, nil
+}
+
+func (r *inMemoryPasteRepository) wipe(paste *models.Paste, tombstone string) {
+	paste.Ciphertext = ""
+	paste.Signature = ""
+	paste.Tombstone = tombstone
+	paste.DeletedAt = time.Now().UTC().Truncate(time.Second)
+	delete(r.revisions, paste.ID)
+}
+
+// Peek loads a paste without counting as a read.
+func (r *inMemoryPasteRepository) Peek(ctx context.Context, id string) (*models.Paste, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.pastes[id]
+	if !ok {
+		return nil, utils.ErrPasteNotFound
+	}
+	if err := checkAvailable(stored); err != nil {
+		return nil, err
+	}
+	return storedPaste(stored), nil
+}
+
+// Update replaces the paste content and bumps its revision, keeping the
+// newest keepRevisions replaced versions. A set paste.Revision has to follow
+// the current head.
+func (r *inMemoryPasteRepository) Update(ctx context.Context, paste *models.Paste, keepRevisions int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	head, ok := r.pastes[paste.ID]
+	if !ok {
+		return utils.ErrPasteNotFound
+	}
+	if err := checkAvailable(head); err != nil {
+		return err
+	}
+	if paste.Revision != 0 && paste.Revision != head.Revision+1 {
+		return utils.ErrPasteRevisionConflict
+	}
+
+	revisions := r.revisions[head.ID]
+	if keepRevisions > 0 && !isViewLimited(head) {
+		revisions = append(revisions, &models.PasteRevision{
+			PasteID:          head.ID,
+			Revision:         head.Revision,
+			Ciphertext:       head.Ciphertext,
+			Signature:        head.Signature,
+			SignatureVersion: head.SignatureVersion,
+			Nonce:            head.Nonce,
+			PublicKey:        head.PublicKey,
+			Algorithm:        head.Algorithm,
+			ExpiresAt:        head.ExpiresAt,
+			ReplacedAt:       time.Now().UTC().Truncate(time.Second),
+		})
+	}
+	kept := revisions[:0]
+	for _, revision := range revisions {
+		if revision.Revision > head.Revision-keepRevisions {
+			kept = append(kept, revision)
+		}
+	}
+	if len(kept) > 0 {
+		r.revisions[head.ID] = kept
+	} else {
+		delete(r.revisions, head.ID)
+	}
+
+	paste.Revision = head.Revision + 1
+	head.Ciphertext = paste.Ciphertext
+	head.Signature = paste.Signature
+	head.SignatureVersion = paste.SignatureVersion
+	head.PublicKey = paste.PublicKey
+	head.Algorithm = paste.Algorithm
+	head.UserID = paste.UserID
+	head.DeviceID = paste.DeviceID
+	head.ExpiresAt = paste.ExpiresAt
+	head.Revision = paste.Revision
+	return nil
+}
+
+// GetByOwner lists a user's unexpired pastes, including pastes stored before
+// they carried an owner ID, which are matched by publicKey, without their
+// ciphertext.
+func (r *inMemoryPasteRepository) GetByOwner(ctx context.Context, userID, publicKey string) ([]*models.Paste, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	var pastes []*models.Paste
+	for _, paste := range r.pastes {
+		owned := paste.UserID == userID || (paste.UserID == "" && paste.PublicKey == publicKey)
+		if !owned || !paste.ExpiresAt.After(now) || paste.Tombstone == models.TombstoneDeleted {
+			continue
+		}
+		listed := storedPaste(paste)
+		listed.Ciphertext = ""
+		pastes = append(pastes, listed)
+	}
+	return pastes, nil
+}
+
+// Delete wipes the paste content and its revision history but keeps a
+// tombstone signed by the owner.
+func (r *inMemoryPasteRepository) Delete(ctx context.Context, id, deleteSignature string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	paste, ok := r.pastes[id]
+	if !ok || paste.Tombstone != "" {
+		return utils.ErrPasteNotFound
+	}
+	r.wipe(paste, models.TombstoneDeleted)
+	paste.DeleteSignature = deleteSignature
+	return nil
+}
+
+// PurgeExpired permanently removes up to limit pastes that expired before
+// expiredBefore or were tombstoned before tombstonedBefore. Tombstones of
+// pastes created with a nonce are kept until the paste expires.
+func (r *inMemoryPasteRepository) PurgeExpired(ctx context.Context, expiredBefore, tombstonedBefore time.Time, limit int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for id, paste := range r.pastes {
+		if n >= limit {
+			break
+		}
+		expired := !paste.ExpiresAt.After(expiredBefore)
+		tombstoned := paste.Tombstone != "" && paste.Nonce == "" && !paste.DeletedAt.After(tombstonedBefore)
+		if !expired && !tombstoned {
+			continue
+		}
+		delete(r.pastes, id)
+		delete(r.revisions, id)
+		delete(r.recipients, id)
+		n++
+	}
+	return n, nil
+}
+
+// ListRevisions returns the stored history of a paste, oldest first, without
+// ciphertext.
+func (r *inMemoryPasteRepository) ListRevisions(ctx context.Context, id string) ([]*models.PasteRevision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	revisions := []*models.PasteRevision{}
+	for _, revision := range r.revisions[id] {
+		listed := *revision
+		listed.Ciphertext = ""
+		revisions = append(revisions, &listed)
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision < revisions[j].Revision
+	})
+	return revisions, nil
+}
+
+func (r *inMemoryPasteRepository) GetRevision(ctx context.Context, id string, revision int) (*models.PasteRevision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, stored := range r.revisions[id] {
+		if stored.Revision == revision {
+			found := *stored
+			return &found, nil
+		}
+	}
+	return nil, utils.ErrPasteRevisionNotFound
+}
+
+// GetEnvelope returns the envelope addressed to the user userID on paste id
+// and marks the paste as read by that recipient.
+func (r *inMemoryPasteRepository) GetEnvelope(ctx context.Context, id, userID string) (*models.PasteRecipient, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, recipient := range r.recipients[id] {
+		if recipient.UserID != userID {
+			continue
+		}
+		if recipient.ReadAt.IsZero() {
+			recipient.ReadAt = time.Now().UTC().Truncate(time.Second)
+		}
+		found := *recipient
+		return &found, nil
+	}
+	return nil, utils.ErrPasteNotRecipient
+}
+
+// inbox returns the recipient entries of the user userID whose paste has not
+// expired and was not deleted by its author, newest first.
+func (r *inMemoryPasteRepository) inbox(userID string) []*models.PasteRecipient {
+	now := time.Now().UTC()
+	var inbox []*models.PasteRecipient
+	for id, recipients := range r.recipients {
+		paste, ok := r.pastes[id]
+		if !ok || !paste.ExpiresAt.After(now) || paste.Tombstone == models.TombstoneDeleted {
+			continue
+		}
+		for _, recipient := range recipients {
+			if recipient.UserID == userID {
+				inbox = append(inbox, recipient)
+			}
+		}
+	}
+	sort.Slice(inbox, func(i, j int) bool {
+		a, b := inbox[i], inbox[j]
+		if !a.ReceivedAt.Equal(b.ReceivedAt) {
+			return a.ReceivedAt.After(b.ReceivedAt)
+		}
+		return a.PasteID < b.PasteID
+	})
+	return inbox
+}
+
+// Inbox lists the pastes addressed to the user userID, newest first, with the
+// paste metadata (but not its ciphertext) attached.
+func (r *inMemoryPasteRepository) Inbox(ctx context.Context, userID string, unreadOnly bool, limit, offset int) ([]*models.PasteRecipient, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	recipients := []*models.PasteRecipient{}
+	skipped := 0
+	for _, recipient := range r.inbox(userID) {
+		if len(recipients) >= limit {
+			break
+		}
+		if unreadOnly && !recipient.ReadAt.IsZero() {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		listed := *recipient
+		listed.Envelope = ""
+		listed.Paste = storedPaste(r.pastes[recipient.PasteID])
+		listed.Paste.Ciphertext = ""
+		listed.Paste.DeleteSignature = ""
+		recipients = append(recipients, &listed)
+	}
+	return recipients, nil
+}
+
+func (r *inMemoryPasteRepository) CountInbox(ctx context.Context, userID string) (total, unread int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, recipient := range r.inbox(userID) {
+		total++
+		if recipient.ReadAt.IsZero() {
+			unread++
+		}
+	}
+	return total, unread, nil
+}
@@ -0,0 +1,220 @@
+package paste
+
+import (
+	"context"
+	"encoding/base64"
+	"sync"
+	"testing"
+	"time"
+
+	"Drop-Key/internal/blob"
+	"Drop-Key/internal/db/dbtest"
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// eachRepository runs test against the in-memory repository and against the
+// database repository on every test backend, with content kept inline and in
+// a blob store, which must all behave the same.
+func eachRepository(t *testing.T, test func(t *testing.T, repo PasteRepository)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewInMemoryPasteRepository())
+	})
+	dbtest.Run(t, func(t *testing.T) {
+		_, repo, cleanup := setupTestDB(t)
+		defer cleanup()
+		test(t, repo)
+	})
+	t.Run("blobs", func(t *testing.T) {
+		dbtest.Run(t, func(t *testing.T) {
+			db, _, cleanup := setupTestDB(t)
+			defer cleanup()
+			blobs, err := blob.NewFileStore(t.TempDir())
+			assert.NoError(t, err, "should create blob store")
+			test(t, NewPasteRepository(db, blobs))
+		})
+	})
+}
+
+// encoded returns content base64 encoded, as pastes are submitted.
+func encoded(content string) string {
+	return base64.StdEncoding.EncodeToString([]byte(content))
+}
+
+func contractPaste(id string) *models.Paste {
+	return &models.Paste{
+		ID:         id,
+		Ciphertext: encoded("ciphertext"),
+		Signature:  "signature",
+		PublicKey:  "public_key",
+		UserID:     "owner",
+		ExpiresAt:  time.Now().UTC().Add(time.Hour).Truncate(time.Second),
+	}
+}
+
+func TestRepositoryContract(t *testing.T) {
+	eachRepository(t, func(t *testing.T, repo PasteRepository) {
+		ctx := context.Background()
+
+		t.Run("missing paste", func(t *testing.T) {
+			_, err := repo.GetByID(ctx, "missing")
+			assert.Error(t, err, "should not find a missing paste")
+			assert.NotErrorIs(t, err, ErrPasteExpired, "a missing paste has not expired")
+			_, err = repo.Peek(ctx, "missing")
+			assert.Error(t, err, "should not peek a missing paste")
+			assert.ErrorIs(t, repo.Delete(ctx, "missing", "sig"), utils.ErrPasteNotFound)
+		})
+
+		t.Run("expired paste", func(t *testing.T) {
+			paste := contractPaste("expired")
+			paste.ExpiresAt = time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+			assert.NoError(t, repo.Create(ctx, paste))
+			_, err := repo.GetByID(ctx, paste.ID)
+			assert.ErrorIs(t, err, ErrPasteExpired)
+			_, err = repo.Peek(ctx, paste.ID)
+			assert.ErrorIs(t, err, ErrPasteExpired)
+		})
+
+		t.Run("burn after read", func(t *testing.T) {
+			paste := contractPaste("burn")
+			paste.BurnAfterRead = true
+			assert.NoError(t, repo.Create(ctx, paste))
+			read, err := repo.GetByID(ctx, paste.ID)
+			assert.NoError(t, err, "first read should succeed")
+			assert.Equal(t, encoded("ciphertext"), read.Ciphertext, "first read should see the content")
+			_, err = repo.GetByID(ctx, paste.ID)
+			assert.ErrorIs(t, err, utils.ErrPasteBurned)
+		})
+
+		t.Run("owner listing", func(t *testing.T) {
+			paste := contractPaste("listed")
+			paste.BurnAfterRead = true
+			assert.NoError(t, repo.Create(ctx, paste))
+			for i := 0; i < 2; i++ {
+				owned, err := repo.GetByOwner(ctx, paste.UserID, paste.PublicKey)
+				assert.NoError(t, err)
+				var listed *models.Paste
+				for _, owned := range owned {
+					if owned.ID == paste.ID {
+						listed = owned
+					}
+				}
+				if assert.NotNil(t, listed, "should list the paste") {
+					assert.Empty(t, listed.Ciphertext, "listing should leave out the ciphertext")
+					assert.Equal(t, 0, listed.Views, "listing should not count as a view")
+				}
+			}
+			read, err := repo.GetByID(ctx, paste.ID)
+			assert.NoError(t, err, "should still be readable after listing")
+			assert.Equal(t, encoded("ciphertext"), read.Ciphertext)
+			_, err = repo.GetByID(ctx, paste.ID)
+			assert.ErrorIs(t, err, utils.ErrPasteBurned, "should be readable exactly once")
+		})
+
+		t.Run("concurrent reads", func(t *testing.T) {
+			paste := contractPaste("limited")
+			paste.MaxViews = 3
+			assert.NoError(t, repo.Create(ctx, paste))
+
+			var wg sync.WaitGroup
+			results := make(chan *models.Paste, 10)
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if fetched, err := repo.GetByID(ctx, paste.ID); err == nil {
+						results <- fetched
+					}
+				}()
+			}
+			wg.Wait()
+			close(results)
+			assert.Len(t, results, 3, "exactly max_views readers should receive the paste")
+		})
+
+		t.Run("nonce reused", func(t *testing.T) {
+			first := contractPaste("nonce_first")
+			first.Nonce = "nonce"
+			assert.NoError(t, repo.Create(ctx, first))
+			assert.NoError(t, repo.Delete(ctx, first.ID, "sig"))
+			second := contractPaste("nonce_second")
+			second.Nonce = "nonce"
+			assert.ErrorIs(t, repo.Create(ctx, second), utils.ErrPasteNonceReused, "tombstones should keep their nonce")
+		})
+
+		t.Run("delete", func(t *testing.T) {
+			paste := contractPaste("deleted")
+			assert.NoError(t, repo.Create(ctx, paste))
+			assert.NoError(t, repo.Delete(ctx, paste.ID, "sig"))
+			assert.ErrorIs(t, repo.Delete(ctx, paste.ID, "sig"), utils.ErrPasteNotFound, "should not delete twice")
+			_, err := repo.GetByID(ctx, paste.ID)
+			assert.ErrorIs(t, err, utils.ErrPasteDeleted)
+		})
+
+		t.Run("revisions", func(t *testing.T) {
+			paste := contractPaste("revised")
+			assert.NoError(t, repo.Create(ctx, paste))
+			for _, content := range []string{"second", "third", "fourth"} {
+				next := contractPaste(paste.ID)
+				next.Ciphertext = encoded(content)
+				assert.NoError(t, repo.Update(ctx, next, 2))
+			}
+			head, err := repo.Peek(ctx, paste.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, 4, head.Revision)
+			assert.Equal(t, encoded("fourth"), head.Ciphertext)
+
+			revisions, err := repo.ListRevisions(ctx, paste.ID)
+			assert.NoError(t, err)
+			if assert.Len(t, revisions, 2, "should keep the newest two revisions") {
+				assert.Equal(t, 2, revisions[0].Revision)
+				assert.Equal(t, 3, revisions[1].Revision)
+				assert.Empty(t, revisions[0].Ciphertext, "listing should leave out ciphertext")
+			}
+			revision, err := repo.GetRevision(ctx, paste.ID, 3)
+			assert.NoError(t, err)
+			assert.Equal(t, encoded("third"), revision.Ciphertext)
+			_, err = repo.GetRevision(ctx, paste.ID, 1)
+			assert.ErrorIs(t, err, utils.ErrPasteRevisionNotFound)
+		})
+
+		t.Run("recipients", func(t *testing.T) {
+			paste := contractPaste("addressed")
+			paste.Recipients = []*models.PasteRecipient{{PublicKey: "reader-key", UserID: "reader", Envelope: "envelope"}}
+			assert.NoError(t, repo.Create(ctx, paste))
+
+			total, unread, err := repo.CountInbox(ctx, "reader")
+			assert.NoError(t, err)
+			assert.Equal(t, 1, total)
+			assert.Equal(t, 1, unread)
+
+			_, err = repo.GetEnvelope(ctx, paste.ID, "stranger")
+			assert.ErrorIs(t, err, utils.ErrPasteNotRecipient)
+			envelope, err := repo.GetEnvelope(ctx, paste.ID, "reader")
+			assert.NoError(t, err)
+			assert.Equal(t, "envelope", envelope.Envelope)
+			assert.False(t, envelope.ReadAt.IsZero(), "should mark the envelope read")
+
+			inbox, err := repo.Inbox(ctx, "reader", false, 10, 0)
+			assert.NoError(t, err)
+			if assert.Len(t, inbox, 1) && assert.NotNil(t, inbox[0].Paste) {
+				assert.Empty(t, inbox[0].Envelope, "inbox should leave out the envelope")
+				assert.Empty(t, inbox[0].Paste.Ciphertext, "inbox should leave out the ciphertext")
+			}
+			inbox, err = repo.Inbox(ctx, "reader", true, 10, 0)
+			assert.NoError(t, err)
+			assert.Empty(t, inbox, "read pastes should not be listed as unread")
+		})
+
+		t.Run("purge", func(t *testing.T) {
+			n, err := repo.PurgeExpired(ctx, time.Now().UTC(), time.Now().UTC().Add(-time.Hour), 100)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, n, "should purge only the expired paste")
+			_, err = repo.Peek(ctx, "expired")
+			assert.Error(t, err)
+			assert.NotErrorIs(t, err, ErrPasteExpired, "purged pastes should be gone")
+		})
+	})
+}
@@ -0,0 +1,123 @@
+package paste
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultReaperInterval     = time.Minute
+	DefaultReaperBatchSize    = 500
+	DefaultTombstoneRetention = 24 * time.Hour
+)
+
+type ReaperConfig struct {
+	Interval           time.Duration
+	BatchSize          int
+	TombstoneRetention time.Duration
+}
+
+// LoadReaperConfig reads PASTE_REAPER_INTERVAL, PASTE_REAPER_BATCH_SIZE and
+// PASTE_TOMBSTONE_RETENTION, falling back to the defaults for anything unset
+// or invalid.
+func LoadReaperConfig() ReaperConfig {
+	config := ReaperConfig{
+		Interval:           DefaultReaperInterval,
+		BatchSize:          DefaultReaperBatchSize,
+		TombstoneRetention: DefaultTombstoneRetention,
+	}
+	if value := os.Getenv("PASTE_REAPER_INTERVAL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			config.Interval = d
+		} else {
+			slog.Error("Invalid PASTE_REAPER_INTERVAL, using default", "value", value, "default", config.Interval)
+		}
+	}
+	if value := os.Getenv("PASTE_REAPER_BATCH_SIZE"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			config.BatchSize = n
+		} else {
+			slog.Error("Invalid PASTE_REAPER_BATCH_SIZE, using default", "value", value, "default", config.BatchSize)
+		}
+	}
+	if value := os.Getenv("PASTE_TOMBSTONE_RETENTION"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+			config.TombstoneRetention = d
+		} else {
+			slog.Error("Invalid PASTE_TOMBSTONE_RETENTION, using default", "value", value, "default", config.TombstoneRetention)
+		}
+	}
+	return config
+}
+
+// Reaper periodically deletes expired pastes, and tombstones older than the
+// retention period, so that expired ciphertext does not linger on disk.
+type Reaper struct {
+	repo   PasteRepository
+	config ReaperConfig
+	purged atomic.Int64
+	now    func() time.Time
+}
+
+func NewReaper(repo PasteRepository, config ReaperConfig) *Reaper {
+	return &Reaper{
+		repo:   repo,
+		config: config,
+		now:    time.Now,
+	}
+}
+
+// Run sweeps once immediately and then on every interval until ctx is
+// cancelled.
+func (r *Reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Sweep(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("Error while purging expired pastes", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			slog.Info("Paste reaper stopped", "purged_total", r.Purged())
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep deletes batches of purgeable pastes until a batch comes back short,
+// and returns the number of rows removed.
+func (r *Reaper) Sweep(ctx context.Context) (int, error) {
+	now := r.now().UTC()
+	tombstonedBefore := now.Add(-r.config.TombstoneRetention)
+
+	total := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		n, err := r.repo.PurgeExpired(ctx, now, tombstonedBefore, r.config.BatchSize)
+		total += n
+		r.purged.Add(int64(n))
+		if err != nil {
+			return total, err
+		}
+		if n < r.config.BatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		slog.Info("Purged expired pastes", "purged", total, "purged_total", r.Purged())
+	}
+	return total, nil
+}
+
+// Purged returns the number of pastes removed since the reaper was created.
+func (r *Reaper) Purged() int64 {
+	return r.purged.Load()
+}
@@ -0,0 +1,90 @@
+package paste
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"Drop-Key/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReaperSweep(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryPasteRepository()
+	now := time.Now().UTC()
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		repo.Create(ctx, &models.Paste{ID: id, ExpiresAt: now.Add(-time.Minute)})
+	}
+	repo.Create(ctx, &models.Paste{ID: "live", ExpiresAt: now.Add(time.Hour)})
+	repo.Create(ctx, &models.Paste{ID: "recent-tombstone", ExpiresAt: now.Add(time.Hour), Tombstone: models.TombstoneDeleted, DeletedAt: now})
+	repo.Create(ctx, &models.Paste{ID: "old-tombstone", ExpiresAt: now.Add(time.Hour), Tombstone: models.TombstoneBurned, DeletedAt: now.Add(-2 * time.Hour)})
+
+	reaper := NewReaper(repo, ReaperConfig{Interval: time.Minute, BatchSize: 2, TombstoneRetention: time.Hour})
+	n, err := reaper.Sweep(ctx)
+	assert.NoError(t, err, "should sweep without error")
+	assert.Equal(t, 6, n, "should purge every expired paste across batches")
+	assert.Equal(t, int64(6), reaper.Purged(), "should count purged rows")
+	assert.Len(t, repo.pastes, 2, "live paste and recent tombstone should remain")
+	assert.Contains(t, repo.pastes, "live")
+	assert.Contains(t, repo.pastes, "recent-tombstone")
+
+	n, err = reaper.Sweep(ctx)
+	assert.NoError(t, err, "should sweep without error")
+	assert.Equal(t, 0, n, "nothing left to purge")
+	assert.Equal(t, int64(6), reaper.Purged(), "total should not change")
+}
+
+func TestReaperRunStops(t *testing.T) {
+	repo := NewInMemoryPasteRepository()
+	repo.Create(context.Background(), &models.Paste{ID: "expired", ExpiresAt: time.Now().UTC().Add(-time.Minute)})
+
+	reaper := NewReaper(repo, ReaperConfig{Interval: 10 * time.Millisecond, BatchSize: 10})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		reaper.Run(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return reaper.Purged() == 1 }, time.Second, 5*time.Millisecond, "should sweep on start")
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reaper did not stop after cancellation")
+	}
+}
+
+func TestLoadReaperConfig(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		config := LoadReaperConfig()
+		assert.Equal(t, DefaultReaperInterval, config.Interval)
+		assert.Equal(t, DefaultReaperBatchSize, config.BatchSize)
+		assert.Equal(t, DefaultTombstoneRetention, config.TombstoneRetention)
+	})
+
+	t.Run("from environment", func(t *testing.T) {
+		os.Setenv("PASTE_REAPER_INTERVAL", "30s")
+		os.Setenv("PASTE_REAPER_BATCH_SIZE", "50")
+		os.Setenv("PASTE_TOMBSTONE_RETENTION", "1h")
+		defer os.Unsetenv("PASTE_REAPER_INTERVAL")
+		defer os.Unsetenv("PASTE_REAPER_BATCH_SIZE")
+		defer os.Unsetenv("PASTE_TOMBSTONE_RETENTION")
+
+		config := LoadReaperConfig()
+		assert.Equal(t, 30*time.Second, config.Interval)
+		assert.Equal(t, 50, config.BatchSize)
+		assert.Equal(t, time.Hour, config.TombstoneRetention)
+	})
+
+	t.Run("invalid values", func(t *testing.T) {
+		os.Setenv("PASTE_REAPER_BATCH_SIZE", "-1")
+		defer os.Unsetenv("PASTE_REAPER_BATCH_SIZE")
+
+		config := LoadReaperConfig()
+		assert.Equal(t, DefaultReaperBatchSize, config.BatchSize, "should fall back to default")
+	})
+}
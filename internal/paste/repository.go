@@ -2,11 +2,14 @@ package paste
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"log/slog"
 	"time"
 
+	"Drop-Key/internal/blob"
 	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
 
 	"github.com/uptrace/bun"
 )
@@ -14,65 +17,568 @@ import (
 type PasteRepository interface {
 	Create(ctx context.Context, paste *models.Paste) error
 	GetByID(ctx context.Context, id string) (*models.Paste, error)
-	Update(ctx context.Context, paste *models.Paste) error
-	GetByPublicKey(ctx context.Context, publicKey string) ([]*models.Paste, error)
+	Peek(ctx context.Context, id string) (*models.Paste, error)
+	Update(ctx context.Context, paste *models.Paste, keepRevisions int) error
+	GetByOwner(ctx context.Context, userID, publicKey string) ([]*models.Paste, error)
+	Delete(ctx context.Context, id, deleteSignature string) error
+	PurgeExpired(ctx context.Context, expiredBefore, tombstonedBefore time.Time, limit int) (int, error)
+	ListRevisions(ctx context.Context, id string) ([]*models.PasteRevision, error)
+	GetRevision(ctx context.Context, id string, revision int) (*models.PasteRevision, error)
+	GetEnvelope(ctx context.Context, id, userID string) (*models.PasteRecipient, error)
+	Inbox(ctx context.Context, userID string, unreadOnly bool, limit, offset int) ([]*models.PasteRecipient, error)
+	CountInbox(ctx context.Context, userID string) (total, unread int, err error)
 }
 
 type pasteRepository struct {
-	db *bun.DB
+	db    *bun.DB
+	blobs blob.BlobStore
 }
 
 var ErrPasteExpired = errors.New("paste has expired")
 
-func NewPasteRepository(db *bun.DB) *pasteRepository {
+// NewPasteRepository returns a repository storing pastes in db. Their
+// content goes into blobs, or inline into the ciphertext column when blobs is
+// nil; content stored either way stays readable after switching.
+func NewPasteRepository(db *bun.DB, blobs blob.BlobStore) *pasteRepository {
 	return &pasteRepository{
-		db: db,
+		db:    db,
+		blobs: blobs,
 	}
 }
 
+// Create inserts a paste together with its recipient envelopes. The nonce
+// column is unique, so a v2 create that reuses a nonce fails; that is
+// reported as ErrPasteNonceReused whatever error the driver returned. The
+// content is written to the blob store first and removed again if the insert
+// fails.
 func (r *pasteRepository) Create(ctx context.Context, paste *models.Paste) error {
-	_, err := r.db.NewInsert().Model(paste).Exec(ctx)
+	if err := r.storeContent(ctx, paste); err != nil {
+		return err
+	}
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewInsert().Model(contentRow(paste)).Exec(ctx); err != nil {
+			return err
+		}
+		if len(paste.Recipients) == 0 {
+			return nil
+		}
+		for _, recipient := range paste.Recipients {
+			recipient.PasteID = paste.ID
+			if recipient.ReceivedAt.IsZero() {
+				recipient.ReceivedAt = time.Now().UTC().Truncate(time.Second)
+			}
+		}
+		_, err := tx.NewInsert().Model(&paste.Recipients).Exec(ctx)
+		return err
+	})
 	if err != nil {
+		r.deleteContent(ctx, paste.ContentRef)
+		if paste.Nonce != "" {
+			exists, existsErr := r.db.NewSelect().Model((*models.Paste)(nil)).Where("nonce = ?", paste.Nonce).Exists(ctx)
+			if existsErr == nil && exists {
+				return utils.ErrPasteNonceReused
+			}
+		}
 		slog.Error("Error while inserting paste", "operation", "Create", "pasteid", paste.ID, "error", err)
 		return err
 	}
 	return nil
 }
 
+// GetByID returns a paste to a reader and counts the view. When the read
+// uses up the paste's last allowed view (max_views, or the single view of a
+// burn-after-read paste) the content is wiped in the same transaction. The
+// view counter only moves while the row is not tombstoned, so concurrent
+// readers cannot both receive a last view. The content is read from the
+// blob store before the transaction commits, and the blobs of a wiped paste
+// are only deleted after it did.
 func (r *pasteRepository) GetByID(ctx context.Context, id string) (*models.Paste, error) {
 	var paste models.Paste
-	err := r.db.NewSelect().Model(&paste).Where("id = ?", id).Scan(ctx)
+	var wiped []string
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().Model(&paste).Where("id = ?", id).Scan(ctx)
+		if err != nil {
+			return err
+		}
+		if err := checkAvailable(&paste); err != nil {
+			return err
+		}
+
+		res, err := tx.NewUpdate().
+			Model((*models.Paste)(nil)).
+			Set("views = views + 1").
+			Where("id = ?", id).
+			Where("tombstone IS NULL").
+			Where("(max_views = 0 OR views < max_views)").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return exhaustedError(&paste)
+		}
+		paste.Views++
+		// The content is loaded before the view is committed, so a reader
+		// who does not get it has not used up a view, or the last one.
+		if err := r.loadPaste(ctx, &paste); err != nil {
+			return err
+		}
+
+		limit := paste.MaxViews
+		if paste.BurnAfterRead {
+			limit = 1
+		}
+		if limit == 0 || paste.Views < limit {
+			return nil
+		}
+		wiped, err = wipe(ctx, tx, id, exhaustedTombstone(&paste))
+		return err
+	})
 	if err != nil {
 		slog.Error("Error while getting paste", "operation", "get", "pasteid", id, "error", err)
 		return nil, err
 	}
-	if paste.ExpiresAt.Before(time.Now().UTC().Truncate(time.Second)) {
-		slog.Error("Paste has expired", "pasteid", id)
-		return nil, ErrPasteExpired
+	r.deleteContent(ctx, wiped...)
+	return &paste, nil
+}
+
+func exhaustedTombstone(paste *models.Paste) string {
+	if paste.BurnAfterRead {
+		return models.TombstoneBurned
+	}
+	return models.TombstoneExhausted
+}
+
+func exhaustedError(paste *models.Paste) error {
+	if paste.BurnAfterRead {
+		return utils.ErrPasteBurned
+	}
+	return utils.ErrPasteViewsExhausted
+}
+
+// Peek loads a paste without counting as a read, for ownership checks on
+// update and delete.
+func (r *pasteRepository) Peek(ctx context.Context, id string) (*models.Paste, error) {
+	var paste models.Paste
+	err := r.db.NewSelect().Model(&paste).Where("id = ?", id).Scan(ctx)
+	if err != nil {
+		slog.Error("Error while getting paste", "operation", "peek", "pasteid", id, "error", err)
+		return nil, err
+	}
+	if err := checkAvailable(&paste); err != nil {
+		return nil, err
+	}
+	if err := r.loadPaste(ctx, &paste); err != nil {
+		return nil, err
 	}
 	return &paste, nil
 }
 
-func (r *pasteRepository) Update(ctx context.Context, paste *models.Paste) error {
-	_, err := r.db.NewUpdate().Model(paste).Where("id = ?", paste.ID).Column("ciphertext", "signature", "public_key", "expires_at").Exec(ctx)
+func checkAvailable(paste *models.Paste) error {
+	switch paste.Tombstone {
+	case models.TombstoneDeleted:
+		return utils.ErrPasteDeleted
+	case models.TombstoneBurned:
+		return utils.ErrPasteBurned
+	case models.TombstoneExhausted:
+		return utils.ErrPasteViewsExhausted
+	}
+	if paste.ExpiresAt.Before(time.Now().UTC().Truncate(time.Second)) {
+		slog.Error("Paste has expired", "pasteid", paste.ID)
+		return ErrPasteExpired
+	}
+	return nil
+}
+
+// wipe removes the content of paste id and its revisions, and returns the
+// blobs that held it for the caller to delete once the transaction committed.
+func wipe(ctx context.Context, tx bun.Tx, id, tombstone string) ([]string, error) {
+	refs, err := contentRefs(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.NewUpdate().
+		Model((*models.Paste)(nil)).
+		Set("ciphertext = ?", "").
+		Set("content_ref = NULL").
+		Set("content_size = 0").
+		Set("signature = ?", "").
+		Set("tombstone = ?", tombstone).
+		Set("deleted_at = ?", time.Now().UTC().Truncate(time.Second)).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return refs, deleteRevisions(ctx, tx, id)
+}
+
+// GetEnvelope returns the envelope addressed to the user userID on paste id
+// and marks the paste as read by that recipient.
+func (r *pasteRepository) GetEnvelope(ctx context.Context, id, userID string) (*models.PasteRecipient, error) {
+	var recipient models.PasteRecipient
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewUpdate().
+			Model((*models.PasteRecipient)(nil)).
+			Set("read_at = ?", time.Now().UTC().Truncate(time.Second)).
+			Where("paste_id = ?", id).
+			Where("user_id = ?", userID).
+			Where("read_at IS NULL").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return tx.NewSelect().
+			Model(&recipient).
+			Where("paste_id = ?", id).
+			Where("user_id = ?", userID).
+			Scan(ctx)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, utils.ErrPasteNotRecipient
+	}
+	if err != nil {
+		slog.Error("Error while getting paste envelope", "operation", "envelope", "pasteid", id, "error", err)
+		return nil, err
+	}
+	return &recipient, nil
+}
+
+// inboxQuery selects the recipient rows of the user userID whose paste has
+// not expired and was not deleted by its author, joined with the paste.
+func (r *pasteRepository) inboxQuery(userID string) *bun.SelectQuery {
+	return r.db.NewSelect().
+		Model((*models.PasteRecipient)(nil)).
+		Join("JOIN pastes AS p ON p.id = paste_recipient.paste_id").
+		Where("paste_recipient.user_id = ?", userID).
+		Where("p.expires_at > ?", time.Now().UTC()).
+		Where("(p.tombstone IS NULL OR p.tombstone <> ?)", models.TombstoneDeleted)
+}
+
+// Inbox lists the pastes addressed to the user userID, newest first, with
+// the paste metadata (but not its ciphertext) loaded.
+func (r *pasteRepository) Inbox(ctx context.Context, userID string, unreadOnly bool, limit, offset int) ([]*models.PasteRecipient, error) {
+	recipients := []*models.PasteRecipient{}
+	q := r.inboxQuery(userID).
+		ColumnExpr("paste_recipient.paste_id, paste_recipient.public_key, paste_recipient.user_id, paste_recipient.received_at, paste_recipient.read_at")
+	if unreadOnly {
+		q = q.Where("paste_recipient.read_at IS NULL")
+	}
+	err := q.
+		OrderExpr("paste_recipient.received_at DESC, paste_recipient.paste_id ASC").
+		Limit(limit).
+		Offset(offset).
+		Scan(ctx, &recipients)
 	if err != nil {
+		slog.Error("Error while listing inbox", "operation", "inbox", "error", err)
+		return nil, err
+	}
+	if len(recipients) == 0 {
+		return recipients, nil
+	}
+
+	ids := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		ids = append(ids, recipient.PasteID)
+	}
+	var pastes []*models.Paste
+	err = r.db.NewSelect().
+		Model(&pastes).
+		ExcludeColumn("ciphertext", "delete_signature").
+		Where("id IN (?)", bun.In(ids)).
+		Scan(ctx)
+	if err != nil {
+		slog.Error("Error while loading inbox pastes", "operation", "inbox", "error", err)
+		return nil, err
+	}
+	byID := make(map[string]*models.Paste, len(pastes))
+	for _, paste := range pastes {
+		byID[paste.ID] = paste
+	}
+	for _, recipient := range recipients {
+		recipient.Paste = byID[recipient.PasteID]
+	}
+	return recipients, nil
+}
+
+func (r *pasteRepository) CountInbox(ctx context.Context, userID string) (total, unread int, err error) {
+	total, err = r.inboxQuery(userID).Count(ctx)
+	if err != nil {
+		slog.Error("Error while counting inbox", "operation", "inbox", "error", err)
+		return 0, 0, err
+	}
+	unread, err = r.inboxQuery(userID).Where("paste_recipient.read_at IS NULL").Count(ctx)
+	if err != nil {
+		slog.Error("Error while counting inbox", "operation", "inbox", "error", err)
+		return 0, 0, err
+	}
+	return total, unread, nil
+}
+
+func deleteRevisions(ctx context.Context, db bun.IDB, ids ...string) error {
+	_, err := db.NewDelete().
+		Model((*models.PasteRevision)(nil)).
+		Where("paste_id IN (?)", bun.In(ids)).
+		Exec(ctx)
+	return err
+}
+
+// isViewLimited reports whether reads of paste are counted. Such pastes keep
+// no revision history, which would otherwise let readers get around the
+// limit.
+func isViewLimited(paste *models.Paste) bool {
+	return paste.BurnAfterRead || paste.MaxViews > 0
+}
+
+// Update replaces the paste content and bumps its revision. The replaced
+// version is appended to the revision history, of which only the newest
+// keepRevisions entries are kept; keepRevisions of 0 keeps no history. A
+// revision keeps referring to the blob of the version it replaced, and blobs
+// of versions no longer kept are deleted after the update. When
+// paste.Revision is set, the update is only applied if it is the revision
+// following the current head.
+func (r *pasteRepository) Update(ctx context.Context, paste *models.Paste, keepRevisions int) error {
+	if err := r.storeContent(ctx, paste); err != nil {
+		return err
+	}
+	var replaced []string
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var head models.Paste
+		err := tx.NewSelect().Model(&head).Where("id = ?", paste.ID).Scan(ctx)
+		if err != nil {
+			return err
+		}
+		if err := checkAvailable(&head); err != nil {
+			return err
+		}
+		if paste.Revision != 0 && paste.Revision != head.Revision+1 {
+			return utils.ErrPasteRevisionConflict
+		}
+
+		if keepRevisions > 0 && !isViewLimited(&head) {
+			revision := &models.PasteRevision{
+				PasteID:          head.ID,
+				Revision:         head.Revision,
+				Ciphertext:       head.Ciphertext,
+				ContentRef:       head.ContentRef,
+				ContentSize:      head.ContentSize,
+				Signature:        head.Signature,
+				SignatureVersion: head.SignatureVersion,
+				Nonce:            head.Nonce,
+				PublicKey:        head.PublicKey,
+				Algorithm:        head.Algorithm,
+				ExpiresAt:        head.ExpiresAt,
+				ReplacedAt:       time.Now().UTC().Truncate(time.Second),
+			}
+			if _, err := tx.NewInsert().Model(revision).Exec(ctx); err != nil {
+				return err
+			}
+		} else {
+			replaced = append(replaced, head.ContentRef)
+		}
+		var pruned []string
+		err = tx.NewSelect().
+			Model((*models.PasteRevision)(nil)).
+			Column("content_ref").
+			Where("paste_id = ?", head.ID).
+			Where("revision <= ?", head.Revision-keepRevisions).
+			Where("content_ref IS NOT NULL").
+			Scan(ctx, &pruned)
+		if err != nil {
+			return err
+		}
+		replaced = append(replaced, pruned...)
+		_, err = tx.NewDelete().
+			Model((*models.PasteRevision)(nil)).
+			Where("paste_id = ?", head.ID).
+			Where("revision <= ?", head.Revision-keepRevisions).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		paste.Revision = head.Revision + 1
+		res, err := tx.NewUpdate().
+			Model(contentRow(paste)).
+			Column("ciphertext", "content_ref", "content_size", "signature", "signature_version", "public_key", "algorithm", "user_id", "device_id", "expires_at", "revision").
+			Where("id = ?", paste.ID).
+			Where("revision = ?", head.Revision).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return utils.ErrPasteNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		r.deleteContent(ctx, paste.ContentRef)
 		slog.Error("Error while updating paste", "operation", "update", "pasteid", paste.ID, "error", err)
 		return err
 	}
+	r.deleteContent(ctx, replaced...)
 	return nil
 }
 
-func (r *pasteRepository) GetByPublicKey(ctx context.Context, publicKey string) ([]*models.Paste, error) {
+// ListRevisions returns the stored history of a paste, oldest first, without
+// ciphertext.
+func (r *pasteRepository) ListRevisions(ctx context.Context, id string) ([]*models.PasteRevision, error) {
+	revisions := []*models.PasteRevision{}
+	err := r.db.NewSelect().
+		Model(&revisions).
+		ExcludeColumn("ciphertext").
+		Where("paste_id = ?", id).
+		Order("revision ASC").
+		Scan(ctx)
+	if err != nil {
+		slog.Error("Error while listing paste revisions", "operation", "revisions", "pasteid", id, "error", err)
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (r *pasteRepository) GetRevision(ctx context.Context, id string, revision int) (*models.PasteRevision, error) {
+	var pasteRevision models.PasteRevision
+	err := r.db.NewSelect().
+		Model(&pasteRevision).
+		Where("paste_id = ?", id).
+		Where("revision = ?", revision).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, utils.ErrPasteRevisionNotFound
+	}
+	if err != nil {
+		slog.Error("Error while getting paste revision", "operation", "revision", "pasteid", id, "revision", revision, "error", err)
+		return nil, err
+	}
+	pasteRevision.Ciphertext, err = r.loadContent(ctx, pasteRevision.ContentRef, pasteRevision.Ciphertext)
+	if err != nil {
+		slog.Error("Error while loading paste revision content", "operation", "revision", "pasteid", id, "revision", revision, "error", err)
+		return nil, err
+	}
+	return &pasteRevision, nil
+}
+
+// GetByOwner lists a user's unexpired pastes, including pastes stored before
+// they carried an owner ID, which are matched by publicKey. Pastes consumed
+// by their readers stay listed, wiped, so the owner can see that they were
+// opened. The listing is public, so it leaves out the ciphertext, which is
+// only handed out by GetByID where views are counted.
+func (r *pasteRepository) GetByOwner(ctx context.Context, userID, publicKey string) ([]*models.Paste, error) {
 	var pastes []*models.Paste
 
 	err := r.db.NewSelect().
 		Model(&pastes).
-		Where("public_key = ?", publicKey).
+		ExcludeColumn("ciphertext").
+		WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("user_id = ?", userID).WhereOr("(user_id IS NULL AND public_key = ?)", publicKey)
+		}).
 		Where("expires_at > ?", time.Now().UTC()).
+		Where("(tombstone IS NULL OR tombstone <> ?)", models.TombstoneDeleted).
 		Scan(ctx)
 	if err != nil {
-		slog.Error("Error while getting pastes by public key", "public_key", publicKey, "error", err)
+		slog.Error("Error while getting pastes by owner", "userID", userID, "error", err)
 		return nil, err
 	}
 	return pastes, nil
 }
+
+// Delete wipes the paste content and its revision history but keeps a
+// tombstone row, signed by the owner, so that later reads can tell a deletion
+// apart from an expiry. The blobs of the paste are deleted afterwards.
+func (r *pasteRepository) Delete(ctx context.Context, id, deleteSignature string) error {
+	paste := &models.Paste{
+		ID:              id,
+		Tombstone:       models.TombstoneDeleted,
+		DeletedAt:       time.Now().UTC().Truncate(time.Second),
+		DeleteSignature: deleteSignature,
+	}
+	var refs []string
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var err error
+		refs, err = contentRefs(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		res, err := tx.NewUpdate().
+			Model(paste).
+			Column("ciphertext", "content_ref", "content_size", "signature", "tombstone", "deleted_at", "delete_signature").
+			Where("id = ?", id).
+			Where("tombstone IS NULL").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return utils.ErrPasteNotFound
+		}
+		return deleteRevisions(ctx, tx, id)
+	})
+	if err != nil && !errors.Is(err, utils.ErrPasteNotFound) {
+		slog.Error("Error while deleting paste", "operation", "delete", "pasteid", id, "error", err)
+	}
+	if err == nil {
+		r.deleteContent(ctx, refs...)
+	}
+	return err
+}
+
+// PurgeExpired permanently removes up to limit pastes that expired before
+// expiredBefore or were tombstoned before tombstonedBefore, together with
+// their revisions, recipients and blobs, and returns how many pastes were
+// deleted.
+// Tombstones of pastes created with a nonce are kept until the paste
+// expires, so the signed create cannot be replayed.
+func (r *pasteRepository) PurgeExpired(ctx context.Context, expiredBefore, tombstonedBefore time.Time, limit int) (int, error) {
+	var ids []string
+	err := r.db.NewSelect().
+		Model((*models.Paste)(nil)).
+		Column("id").
+		WhereOr("expires_at <= ?", expiredBefore).
+		WhereOr("(tombstone IS NOT NULL AND nonce IS NULL AND deleted_at <= ?)", tombstonedBefore).
+		Limit(limit).
+		Scan(ctx, &ids)
+	if err != nil {
+		slog.Error("Error while selecting expired pastes", "operation", "purge", "error", err)
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	var n int64
+	var refs []string
+	err = r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var err error
+		refs, err = contentRefs(ctx, tx, ids...)
+		if err != nil {
+			return err
+		}
+		if err := deleteRevisions(ctx, tx, ids...); err != nil {
+			return err
+		}
+		_, err = tx.NewDelete().
+			Model((*models.PasteRecipient)(nil)).
+			Where("paste_id IN (?)", bun.In(ids)).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		res, err := tx.NewDelete().
+			Model((*models.Paste)(nil)).
+			Where("id IN (?)", bun.In(ids)).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		slog.Error("Error while deleting expired pastes", "operation", "purge", "error", err)
+		return 0, err
+	}
+	r.deleteContent(ctx, refs...)
+	return int(n), nil
+}
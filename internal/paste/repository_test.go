@@ -2,12 +2,14 @@ package paste
 
 import (
 	"context"
-	"os"
+	"sync"
 	"testing"
 	"time"
 
 	"Drop-Key/internal/db"
+	"Drop-Key/internal/db/dbtest"
 	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/uptrace/bun"
@@ -16,178 +18,565 @@ import (
 func setupTestDB(t *testing.T) (*bun.DB, *pasteRepository, func()) {
 	t.Helper()
 	ctx := context.Background()
-	os.Setenv("DSN", "testuser:testpass@tcp(localhost:3306)/testdb?parseTime=true")
 	db, err := db.InitDB(ctx)
 	assert.NoError(t, err, "should initialize database")
-	repo := NewPasteRepository(db)
+	repo := NewPasteRepository(db, nil)
 	cleanup := func() {
-		_, err := db.NewDropTable().Model(&models.Paste{}).IfExists().Exec(ctx)
-		assert.NoError(t, err, "should drop Paste table")
+		dbtest.Reset(t, db)
 		db.Close()
 	}
 	return db, repo, cleanup
 }
 
 func TestNewPasteRespository(t *testing.T) {
-	db, repo, cleanup := setupTestDB(t)
-	defer cleanup()
+	dbtest.Run(t, func(t *testing.T) {
+		db, repo, cleanup := setupTestDB(t)
+		defer cleanup()
 
-	assert.NotNil(t, repo, "should return a not-nil repository.")
-	assert.Equal(t, db, repo.db, "should set correct database instance.")
+		assert.NotNil(t, repo, "should return a not-nil repository.")
+		assert.Equal(t, db, repo.db, "should set correct database instance.")
+	})
 }
 
 func TestCreatePaste(t *testing.T) {
-	db, repo, cleanup := setupTestDB(t)
-	defer cleanup()
-
-	paste := &models.Paste{
-		ID:         "test-paste",
-		Ciphertext: "encrypted-data",
-		Signature:  "signed-data",
-		PublicKey:  "test-public-key",
-		ExpiresAt:  time.Now().UTC().Add(time.Hour).Truncate(time.Second),
-	}
+	dbtest.Run(t, func(t *testing.T) {
+		db, repo, cleanup := setupTestDB(t)
+		defer cleanup()
 
-	ctx := context.Background()
+		paste := &models.Paste{
+			ID:         "test-paste",
+			Ciphertext: "encrypted-data",
+			Signature:  "signed-data",
+			PublicKey:  "test-public-key",
+			ExpiresAt:  time.Now().UTC().Add(time.Hour).Truncate(time.Second),
+		}
+
+		ctx := context.Background()
 
-	err := repo.Create(ctx, paste)
-	assert.NoError(t, err, "should create paste without error.")
+		err := repo.Create(ctx, paste)
+		assert.NoError(t, err, "should create paste without error.")
 
-	var fetched models.Paste
-	err = db.NewSelect().Model(&fetched).Where("id = ?", paste.ID).Scan(ctx)
-	assert.NoError(t, err, "should retrieve paste")
-	assert.Equal(t, paste.Ciphertext, fetched.Ciphertext, "ciphertext should match")
-	assert.Equal(t, paste.Signature, fetched.Signature, "signature should match")
-	assert.Equal(t, paste.PublicKey, fetched.PublicKey, "public_key should match")
-	assert.WithinDuration(t, paste.ExpiresAt, fetched.ExpiresAt, time.Second, "expires_at should match")
+		var fetched models.Paste
+		err = db.NewSelect().Model(&fetched).Where("id = ?", paste.ID).Scan(ctx)
+		assert.NoError(t, err, "should retrieve paste")
+		assert.Equal(t, paste.Ciphertext, fetched.Ciphertext, "ciphertext should match")
+		assert.Equal(t, paste.Signature, fetched.Signature, "signature should match")
+		assert.Equal(t, paste.PublicKey, fetched.PublicKey, "public_key should match")
+		assert.WithinDuration(t, paste.ExpiresAt, fetched.ExpiresAt, time.Second, "expires_at should match")
+	})
 }
 
 func TestGetByIDPaste(t *testing.T) {
-	ctx := context.Background()
-	_, repo, cleanup := setupTestDB(t)
-	defer cleanup()
-
-	paste := &models.Paste{
-		ID:         "test-paste",
-		Ciphertext: "encrypted-data",
-		Signature:  "signed-data",
-		PublicKey:  "test-public-key",
-		ExpiresAt:  time.Now().UTC().Add(time.Hour).Truncate(time.Second),
-	}
-	id_string := paste.ID
-
-	err := repo.Create(ctx, paste)
-	assert.NoError(t, err, "should create paste without error.")
-
-	var fetched *models.Paste
-	fetched, err = repo.GetByID(ctx, id_string)
-	assert.Equal(t, paste.ID, fetched.ID, "ID should be equal")
-	assert.Equal(t, paste.Ciphertext, fetched.Ciphertext, "ciphertext should be equal")
-	assert.Equal(t, paste.Signature, fetched.Signature, "signature should be equal")
-	assert.Equal(t, paste.PublicKey, fetched.PublicKey, "public_key should match")
-	assert.WithinDuration(t, paste.ExpiresAt, fetched.ExpiresAt, time.Second, "expires_at should match")
-
-	expiredPaste := &models.Paste{
-		ID:         "expired-paste",
-		Ciphertext: "expired-data",
-		Signature:  "expired-signature",
-		PublicKey:  "test-public-key",
-		ExpiresAt:  time.Now().UTC().Add(-time.Hour).Truncate(time.Second),
-	}
-	err = repo.Create(ctx, expiredPaste)
-	assert.NoError(t, err, "should insert expired paste")
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		_, repo, cleanup := setupTestDB(t)
+		defer cleanup()
 
-	fetched, err = repo.GetByID(ctx, expiredPaste.ID)
-	assert.ErrorIs(t, err, ErrPasteExpired, "should return ErrPasteExpired for expired paste")
-	assert.Nil(t, fetched, "should return nil for expired paste")
+		paste := &models.Paste{
+			ID:         "test-paste",
+			Ciphertext: "encrypted-data",
+			Signature:  "signed-data",
+			PublicKey:  "test-public-key",
+			ExpiresAt:  time.Now().UTC().Add(time.Hour).Truncate(time.Second),
+		}
+		id_string := paste.ID
 
-	fetched, err = repo.GetByID(ctx, "non-existent")
-	assert.Error(t, err, "should return error for non-existent paste")
-	assert.NotErrorIs(t, err, ErrPasteExpired, "error should not be ErrPasteExpired for non-existent paste")
-	assert.Nil(t, fetched, "should return nil for non-existent paste")
+		err := repo.Create(ctx, paste)
+		assert.NoError(t, err, "should create paste without error.")
+
+		var fetched *models.Paste
+		fetched, err = repo.GetByID(ctx, id_string)
+		assert.Equal(t, paste.ID, fetched.ID, "ID should be equal")
+		assert.Equal(t, paste.Ciphertext, fetched.Ciphertext, "ciphertext should be equal")
+		assert.Equal(t, paste.Signature, fetched.Signature, "signature should be equal")
+		assert.Equal(t, paste.PublicKey, fetched.PublicKey, "public_key should match")
+		assert.WithinDuration(t, paste.ExpiresAt, fetched.ExpiresAt, time.Second, "expires_at should match")
+
+		expiredPaste := &models.Paste{
+			ID:         "expired-paste",
+			Ciphertext: "expired-data",
+			Signature:  "expired-signature",
+			PublicKey:  "test-public-key",
+			ExpiresAt:  time.Now().UTC().Add(-time.Hour).Truncate(time.Second),
+		}
+		err = repo.Create(ctx, expiredPaste)
+		assert.NoError(t, err, "should insert expired paste")
+
+		fetched, err = repo.GetByID(ctx, expiredPaste.ID)
+		assert.ErrorIs(t, err, ErrPasteExpired, "should return ErrPasteExpired for expired paste")
+		assert.Nil(t, fetched, "should return nil for expired paste")
+
+		fetched, err = repo.GetByID(ctx, "non-existent")
+		assert.Error(t, err, "should return error for non-existent paste")
+		assert.NotErrorIs(t, err, ErrPasteExpired, "error should not be ErrPasteExpired for non-existent paste")
+		assert.Nil(t, fetched, "should return nil for non-existent paste")
+	})
 }
 
 func TestUpdatePaste(t *testing.T) {
-	ctx := context.Background()
-	_, repo, cleanup := setupTestDB(t)
-	defer cleanup()
-
-	paste := &models.Paste{
-		ID:         "test-paste",
-		Ciphertext: "encrypted-data",
-		Signature:  "signed-data",
-		PublicKey:  "test-public-key",
-		ExpiresAt:  time.Now().UTC().Add(time.Hour).Truncate(time.Second),
-	}
-	id_string := paste.ID
-
-	err := repo.Create(ctx, paste)
-	assert.NoError(t, err, "should create paste without error.")
-
-	var fetched *models.Paste
-	fetched, err = repo.GetByID(ctx, id_string)
-	assert.Equal(t, paste.ID, fetched.ID, "ID should be equal")
-	assert.Equal(t, paste.Ciphertext, fetched.Ciphertext, "ciphertext should be equal")
-	assert.Equal(t, paste.Signature, fetched.Signature, "signature should be equal")
-	assert.Equal(t, paste.PublicKey, fetched.PublicKey, "public_key should match")
-	assert.WithinDuration(t, paste.ExpiresAt, fetched.ExpiresAt, time.Second, "expires_at should match")
-
-	paste.Ciphertext = "updated-encrypted-data"
-	paste.Signature = "updated-signed-data"
-
-	err = repo.Update(ctx, paste)
-
-	var fetchedAgain *models.Paste
-	fetchedAgain, err = repo.GetByID(ctx, id_string)
-	assert.Equal(t, paste.ID, fetchedAgain.ID, "ID should be equal")
-	assert.Equal(t, paste.Ciphertext, fetchedAgain.Ciphertext, "ciphertext should be equal")
-	assert.Equal(t, paste.Signature, fetchedAgain.Signature, "signature should be equal")
-	assert.Equal(t, paste.PublicKey, fetchedAgain.PublicKey, "public_key should match")
-	assert.WithinDuration(t, paste.ExpiresAt, fetchedAgain.ExpiresAt, time.Second, "expires_at should match")
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		_, repo, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		paste := &models.Paste{
+			ID:         "test-paste",
+			Ciphertext: "encrypted-data",
+			Signature:  "signed-data",
+			PublicKey:  "test-public-key",
+			ExpiresAt:  time.Now().UTC().Add(time.Hour).Truncate(time.Second),
+		}
+		id_string := paste.ID
+
+		err := repo.Create(ctx, paste)
+		assert.NoError(t, err, "should create paste without error.")
+
+		var fetched *models.Paste
+		fetched, err = repo.GetByID(ctx, id_string)
+		assert.Equal(t, paste.ID, fetched.ID, "ID should be equal")
+		assert.Equal(t, paste.Ciphertext, fetched.Ciphertext, "ciphertext should be equal")
+		assert.Equal(t, paste.Signature, fetched.Signature, "signature should be equal")
+		assert.Equal(t, paste.PublicKey, fetched.PublicKey, "public_key should match")
+		assert.WithinDuration(t, paste.ExpiresAt, fetched.ExpiresAt, time.Second, "expires_at should match")
+
+		paste.Ciphertext = "updated-encrypted-data"
+		paste.Signature = "updated-signed-data"
+		paste.Revision = 2
+
+		err = repo.Update(ctx, paste, 0)
+		assert.NoError(t, err, "should update paste without error.")
+		err = repo.Update(ctx, paste, 0)
+		assert.ErrorIs(t, err, utils.ErrPasteRevisionConflict, "should not apply an update to a newer revision than it was made for")
+
+		var fetchedAgain *models.Paste
+		fetchedAgain, err = repo.GetByID(ctx, id_string)
+		assert.Equal(t, paste.ID, fetchedAgain.ID, "ID should be equal")
+		assert.Equal(t, paste.Ciphertext, fetchedAgain.Ciphertext, "ciphertext should be equal")
+		assert.Equal(t, paste.Signature, fetchedAgain.Signature, "signature should be equal")
+		assert.Equal(t, paste.PublicKey, fetchedAgain.PublicKey, "public_key should match")
+		assert.WithinDuration(t, paste.ExpiresAt, fetchedAgain.ExpiresAt, time.Second, "expires_at should match")
+	})
 }
 
-func TestGetByPublicKey(t *testing.T) {
-	_, repo, cleanup := setupTestDB(t)
-	defer cleanup()
+func TestGetByOwner(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) {
+		_, repo, cleanup := setupTestDB(t)
+		defer cleanup()
 
-	ctx := context.Background()
-	paste1 := &models.Paste{
-		ID:         "test-paste-1",
-		Ciphertext: "encrypted-data-1",
-		Signature:  "signed-data-1",
-		PublicKey:  "test-public-key",
-		ExpiresAt:  time.Now().UTC().Add(time.Hour).Truncate(time.Second),
-	}
-	paste2 := &models.Paste{
-		ID:         "test-paste-2",
-		Ciphertext: "encrypted-data-2",
-		Signature:  "signed-data-2",
-		PublicKey:  "test-public-key",
-		ExpiresAt:  time.Now().UTC().Add(time.Hour).Truncate(time.Second),
-	}
+		ctx := context.Background()
+		paste1 := &models.Paste{
+			ID:         "test-paste-1",
+			Ciphertext: "encrypted-data-1",
+			Signature:  "signed-data-1",
+			PublicKey:  "test-public-key",
+			ExpiresAt:  time.Now().UTC().Add(time.Hour).Truncate(time.Second),
+		}
+		paste2 := &models.Paste{
+			ID:         "test-paste-2",
+			Ciphertext: "encrypted-data-2",
+			Signature:  "signed-data-2",
+			PublicKey:  "test-public-key",
+			ExpiresAt:  time.Now().UTC().Add(time.Hour).Truncate(time.Second),
+		}
 
-	err := repo.Create(ctx, paste1)
-	assert.NoError(t, err, "should create first paste without error")
-	err = repo.Create(ctx, paste2)
-	assert.NoError(t, err, "should create second paste without error")
-
-	pastes, err := repo.GetByPublicKey(ctx, "test-public-key")
-	assert.NoError(t, err, "should retrieve pastes without error")
-	assert.Len(t, pastes, 2, "should return two pastes")
-	assert.Equal(t, paste1.ID, pastes[0].ID, "first paste ID should match")
-	assert.Equal(t, paste2.ID, pastes[1].ID, "second paste ID should match")
-
-	expiredPaste := &models.Paste{
-		ID:         "expired-paste",
-		Ciphertext: "expired-data",
-		Signature:  "expired-signature",
-		PublicKey:  "test-public-key",
-		ExpiresAt:  time.Now().UTC().Add(-time.Hour).Truncate(time.Second),
-	}
-	err = repo.Create(ctx, expiredPaste)
-	assert.NoError(t, err, "should create expired paste")
+		err := repo.Create(ctx, paste1)
+		assert.NoError(t, err, "should create first paste without error")
+		err = repo.Create(ctx, paste2)
+		assert.NoError(t, err, "should create second paste without error")
+
+		pastes, err := repo.GetByOwner(ctx, "test-user-id", "test-public-key")
+		assert.NoError(t, err, "should retrieve pastes without error")
+		assert.Len(t, pastes, 2, "should return two pastes")
+		assert.Equal(t, paste1.ID, pastes[0].ID, "first paste ID should match")
+		assert.Equal(t, paste2.ID, pastes[1].ID, "second paste ID should match")
+
+		expiredPaste := &models.Paste{
+			ID:         "expired-paste",
+			Ciphertext: "expired-data",
+			Signature:  "expired-signature",
+			PublicKey:  "test-public-key",
+			ExpiresAt:  time.Now().UTC().Add(-time.Hour).Truncate(time.Second),
+		}
+		err = repo.Create(ctx, expiredPaste)
+		assert.NoError(t, err, "should create expired paste")
+
+		pastes, err = repo.GetByOwner(ctx, "test-user-id", "test-public-key")
+		assert.NoError(t, err, "should retrieve pastes without error")
+		assert.Len(t, pastes, 2, "should return only non-expired pastes")
+
+		rotated := &models.Paste{
+			ID:         "rotated-paste",
+			Ciphertext: "encrypted-data-3",
+			Signature:  "signed-data-3",
+			PublicKey:  "retired-public-key",
+			UserID:     "test-user-id",
+			ExpiresAt:  time.Now().UTC().Add(time.Hour).Truncate(time.Second),
+		}
+		other := &models.Paste{
+			ID:         "other-user-paste",
+			Ciphertext: "encrypted-data-4",
+			Signature:  "signed-data-4",
+			PublicKey:  "test-public-key",
+			UserID:     "other-user-id",
+			ExpiresAt:  time.Now().UTC().Add(time.Hour).Truncate(time.Second),
+		}
+		assert.NoError(t, repo.Create(ctx, rotated), "should create paste signed with a retired key")
+		assert.NoError(t, repo.Create(ctx, other), "should create paste of another user")
+
+		pastes, err = repo.GetByOwner(ctx, "test-user-id", "test-public-key")
+		assert.NoError(t, err, "should retrieve pastes without error")
+		ids := make([]string, 0, len(pastes))
+		for _, paste := range pastes {
+			ids = append(ids, paste.ID)
+		}
+		assert.ElementsMatch(t, []string{paste1.ID, paste2.ID, rotated.ID}, ids, "should match by owner ID, and by key only for pastes without one")
+	})
+}
+
+func TestDeletePaste(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		db, repo, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		paste := &models.Paste{
+			ID:         "test-paste",
+			Ciphertext: "encrypted-data",
+			Signature:  "signed-data",
+			PublicKey:  "test-public-key",
+			ExpiresAt:  time.Now().UTC().Add(time.Hour).Truncate(time.Second),
+		}
+		err := repo.Create(ctx, paste)
+		assert.NoError(t, err, "should create paste without error.")
+
+		err = repo.Delete(ctx, paste.ID, "delete-signature")
+		assert.NoError(t, err, "should delete paste without error.")
+
+		var tombstone models.Paste
+		err = db.NewSelect().Model(&tombstone).Where("id = ?", paste.ID).Scan(ctx)
+		assert.NoError(t, err, "tombstone row should remain")
+		assert.Empty(t, tombstone.Ciphertext, "ciphertext should be wiped")
+		assert.Equal(t, models.TombstoneDeleted, tombstone.Tombstone, "tombstone reason should be recorded")
+		assert.Equal(t, "delete-signature", tombstone.DeleteSignature, "delete signature should be kept")
+		assert.False(t, tombstone.DeletedAt.IsZero(), "deletion time should be recorded")
+
+		fetched, err := repo.GetByID(ctx, paste.ID)
+		assert.ErrorIs(t, err, utils.ErrPasteDeleted, "should return ErrPasteDeleted for deleted paste")
+		assert.Nil(t, fetched, "should return nil for deleted paste")
+
+		pastes, err := repo.GetByOwner(ctx, "test-user-id", paste.PublicKey)
+		assert.NoError(t, err, "should list pastes without error")
+		assert.Len(t, pastes, 0, "deleted paste should not be listed")
+
+		err = repo.Delete(ctx, paste.ID, "delete-signature")
+		assert.ErrorIs(t, err, utils.ErrPasteNotFound, "should not delete twice")
+	})
+}
+
+func TestGetByIDBurnAfterRead(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		db, repo, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		paste := &models.Paste{
+			ID:            "burn-paste",
+			Ciphertext:    "encrypted-data",
+			Signature:     "signed-data",
+			PublicKey:     "test-public-key",
+			ExpiresAt:     time.Now().UTC().Add(time.Hour).Truncate(time.Second),
+			BurnAfterRead: true,
+		}
+		err := repo.Create(ctx, paste)
+		assert.NoError(t, err, "should create paste without error.")
+
+		peeked, err := repo.Peek(ctx, paste.ID)
+		assert.NoError(t, err, "peek should not burn the paste")
+		assert.Equal(t, paste.Ciphertext, peeked.Ciphertext, "ciphertext should match")
+
+		const readers = 8
+		var wg sync.WaitGroup
+		results := make(chan *models.Paste, readers)
+		for i := 0; i < readers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				fetched, err := repo.GetByID(ctx, paste.ID)
+				if err == nil {
+					results <- fetched
+				}
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		assert.Len(t, results, 1, "exactly one reader should receive the ciphertext")
+		for fetched := range results {
+			assert.Equal(t, paste.Ciphertext, fetched.Ciphertext, "ciphertext should match")
+		}
+
+		fetched, err := repo.GetByID(ctx, paste.ID)
+		assert.ErrorIs(t, err, utils.ErrPasteBurned, "should return ErrPasteBurned after the first read")
+		assert.Nil(t, fetched, "should return nil for burned paste")
+
+		var burned models.Paste
+		err = db.NewSelect().Model(&burned).Where("id = ?", paste.ID).Scan(ctx)
+		assert.NoError(t, err, "tombstone row should remain")
+		assert.Empty(t, burned.Ciphertext, "ciphertext should be wiped")
+		assert.Equal(t, models.TombstoneBurned, burned.Tombstone, "tombstone reason should be recorded")
+	})
+}
+
+func TestGetByIDMaxViews(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		_, repo, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		paste := &models.Paste{
+			ID:         "limited-paste",
+			Ciphertext: "encrypted-data",
+			Signature:  "signed-data",
+			PublicKey:  "test-public-key",
+			ExpiresAt:  time.Now().UTC().Add(time.Hour).Truncate(time.Second),
+			MaxViews:   3,
+		}
+		err := repo.Create(ctx, paste)
+		assert.NoError(t, err, "should create paste without error.")
+
+		for i := 1; i <= 3; i++ {
+			fetched, err := repo.GetByID(ctx, paste.ID)
+			assert.NoError(t, err, "view %d should succeed", i)
+			if assert.NotNil(t, fetched) {
+				assert.Equal(t, paste.Ciphertext, fetched.Ciphertext, "ciphertext should match")
+				assert.Equal(t, i, fetched.Views, "view count should be incremented")
+			}
+		}
+
+		fetched, err := repo.GetByID(ctx, paste.ID)
+		assert.ErrorIs(t, err, utils.ErrPasteViewsExhausted, "should return ErrPasteViewsExhausted")
+		assert.Nil(t, fetched, "should return nil for exhausted paste")
+
+		pastes, err := repo.GetByOwner(ctx, "test-user-id", paste.PublicKey)
+		assert.NoError(t, err, "should list pastes without error")
+		if assert.Len(t, pastes, 1, "exhausted paste should stay listed for its owner") {
+			assert.Equal(t, 3, pastes[0].Views, "listing should show the view count")
+			assert.Equal(t, models.TombstoneExhausted, pastes[0].Tombstone, "listing should show the paste is exhausted")
+			assert.Empty(t, pastes[0].Ciphertext, "exhausted paste should be wiped")
+		}
+	})
+}
+
+func TestPurgeExpired(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		db, repo, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		now := time.Now().UTC().Truncate(time.Second)
+		live := &models.Paste{ID: "live", Ciphertext: "c", Signature: "s", PublicKey: "pk", ExpiresAt: now.Add(time.Hour)}
+		expired := []*models.Paste{
+			{ID: "expired-1", Ciphertext: "c", Signature: "s", PublicKey: "pk", ExpiresAt: now.Add(-time.Hour)},
+			{ID: "expired-2", Ciphertext: "c", Signature: "s", PublicKey: "pk", ExpiresAt: now.Add(-time.Minute)},
+		}
+		deleted := &models.Paste{ID: "deleted", Ciphertext: "c", Signature: "s", PublicKey: "pk", ExpiresAt: now.Add(time.Hour)}
+		for _, p := range append(expired, live, deleted) {
+			assert.NoError(t, repo.Create(ctx, p), "should create paste without error.")
+		}
+		assert.NoError(t, repo.Delete(ctx, deleted.ID, "delete-signature"), "should delete paste without error.")
+
+		n, err := repo.PurgeExpired(ctx, now, now.Add(-time.Hour), 1)
+		assert.NoError(t, err, "should purge without error")
+		assert.Equal(t, 1, n, "should respect the batch limit")
+
+		n, err = repo.PurgeExpired(ctx, now, now.Add(-time.Hour), 10)
+		assert.NoError(t, err, "should purge without error")
+		assert.Equal(t, 1, n, "should purge the remaining expired paste only")
+
+		count, err := db.NewSelect().Model((*models.Paste)(nil)).Count(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count, "live paste and recent tombstone should remain")
+
+		n, err = repo.PurgeExpired(ctx, now, now.Add(time.Hour), 10)
+		assert.NoError(t, err, "should purge without error")
+		assert.Equal(t, 1, n, "tombstone past its retention should be purged")
+
+		_, err = repo.Peek(ctx, live.ID)
+		assert.NoError(t, err, "live paste should not be purged")
+	})
+}
+
+func TestCreatePasteNonceReused(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		_, repo, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		expiresAt := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+		first := &models.Paste{ID: "first", Ciphertext: "c", Signature: "s", PublicKey: "pk", ExpiresAt: expiresAt, SignatureVersion: 2, Nonce: "nonce"}
+		assert.NoError(t, repo.Create(ctx, first), "should create paste without error.")
+
+		replay := &models.Paste{ID: "replay", Ciphertext: "c", Signature: "s", PublicKey: "pk", ExpiresAt: expiresAt, SignatureVersion: 2, Nonce: "nonce"}
+		assert.ErrorIs(t, repo.Create(ctx, replay), utils.ErrPasteNonceReused, "should reject a reused nonce")
+
+		legacy := []*models.Paste{
+			{ID: "legacy-1", Ciphertext: "c", Signature: "s", PublicKey: "pk", ExpiresAt: expiresAt},
+			{ID: "legacy-2", Ciphertext: "c", Signature: "s", PublicKey: "pk", ExpiresAt: expiresAt},
+		}
+		for _, p := range legacy {
+			assert.NoError(t, repo.Create(ctx, p), "legacy pastes without nonce should not conflict")
+		}
+
+		assert.NoError(t, repo.Delete(ctx, first.ID, "delete-signature"), "should delete paste without error.")
+		n, err := repo.PurgeExpired(ctx, time.Now().UTC(), time.Now().UTC().Add(time.Hour), 10)
+		assert.NoError(t, err, "should purge without error")
+		assert.Equal(t, 0, n, "tombstone holding a nonce should be kept until it expires")
+		assert.ErrorIs(t, repo.Create(ctx, replay), utils.ErrPasteNonceReused, "nonce should stay reserved after delete")
+	})
+}
+
+func TestPasteRevisions(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		db, repo, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		paste := &models.Paste{
+			ID:         "revised-paste",
+			Ciphertext: "version-1",
+			Signature:  "signature-1",
+			PublicKey:  "test-public-key",
+			ExpiresAt:  time.Now().UTC().Add(time.Hour).Truncate(time.Second),
+		}
+		assert.NoError(t, repo.Create(ctx, paste), "should create paste without error.")
+
+		for _, version := range []string{"2", "3", "4"} {
+			update := &models.Paste{
+				ID:         paste.ID,
+				Ciphertext: "version-" + version,
+				Signature:  "signature-" + version,
+				PublicKey:  paste.PublicKey,
+				ExpiresAt:  paste.ExpiresAt,
+			}
+			assert.NoError(t, repo.Update(ctx, update, 2), "should update paste without error.")
+		}
+
+		head, err := repo.Peek(ctx, paste.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, head.Revision, "each update should bump the revision")
+		assert.Equal(t, "version-4", head.Ciphertext)
+
+		revisions, err := repo.ListRevisions(ctx, paste.ID)
+		assert.NoError(t, err, "should list revisions without error")
+		if assert.Len(t, revisions, 2, "should keep only the newest revisions") {
+			assert.Equal(t, 2, revisions[0].Revision)
+			assert.Equal(t, 3, revisions[1].Revision)
+			assert.Empty(t, revisions[0].Ciphertext, "listing should not load ciphertext")
+		}
+
+		revision, err := repo.GetRevision(ctx, paste.ID, 3)
+		assert.NoError(t, err, "should get revision without error")
+		assert.Equal(t, "version-3", revision.Ciphertext)
+		assert.Equal(t, "signature-3", revision.Signature, "revision should keep its signature")
+
+		_, err = repo.GetRevision(ctx, paste.ID, 1)
+		assert.ErrorIs(t, err, utils.ErrPasteRevisionNotFound, "pruned revision should be gone")
+
+		assert.NoError(t, repo.Delete(ctx, paste.ID, "delete-signature"), "should delete paste without error.")
+		count, err := db.NewSelect().Model((*models.PasteRevision)(nil)).Where("paste_id = ?", paste.ID).Count(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, count, "delete should remove the history")
+	})
+}
+
+func TestPasteRecipients(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		db, repo, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		paste := &models.Paste{
+			ID:         "addressed-paste",
+			Ciphertext: "encrypted-data",
+			Signature:  "signed-data",
+			PublicKey:  "author-key",
+			ExpiresAt:  time.Now().UTC().Add(time.Hour).Truncate(time.Second),
+			Recipients: []*models.PasteRecipient{
+				{PublicKey: "alice-key", UserID: "alice", Envelope: "alice-envelope"},
+				{PublicKey: "bob-key", UserID: "bob", Envelope: "bob-envelope"},
+			},
+		}
+		assert.NoError(t, repo.Create(ctx, paste), "should create paste with recipients")
+
+		envelope, err := repo.GetEnvelope(ctx, paste.ID, "bob")
+		assert.NoError(t, err, "should get envelope")
+		assert.Equal(t, "bob-envelope", envelope.Envelope, "should return the recipient's own envelope")
+
+		_, err = repo.GetEnvelope(ctx, paste.ID, "mallory")
+		assert.ErrorIs(t, err, utils.ErrPasteNotRecipient, "should not return envelopes to others")
+
+		n, err := repo.PurgeExpired(ctx, paste.ExpiresAt.Add(time.Second), time.Now().UTC(), 10)
+		assert.NoError(t, err, "should purge without error")
+		assert.Equal(t, 1, n)
+		count, err := db.NewSelect().Model((*models.PasteRecipient)(nil)).Count(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, count, "recipients should be purged with the paste")
+	})
+}
+
+func TestInbox(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		_, repo, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		for i, id := range []string{"inbox-1", "inbox-2", "inbox-3", "inbox-expired"} {
+			expiresAt := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+			if id == "inbox-expired" {
+				expiresAt = time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+			}
+			paste := &models.Paste{
+				ID:         id,
+				Ciphertext: "encrypted-data",
+				Signature:  "signed-data",
+				PublicKey:  "author-key",
+				ExpiresAt:  expiresAt,
+				Recipients: []*models.PasteRecipient{
+					{PublicKey: "alice-key", UserID: "alice", Envelope: "alice-envelope", ReceivedAt: time.Now().UTC().Add(time.Duration(i) * time.Minute).Truncate(time.Second)},
+				},
+			}
+			assert.NoError(t, repo.Create(ctx, paste), "should create paste %s", id)
+		}
+
+		recipients, err := repo.Inbox(ctx, "alice", false, 2, 0)
+		assert.NoError(t, err, "should list inbox")
+		if assert.Len(t, recipients, 2, "should honour limit") {
+			assert.Equal(t, "inbox-3", recipients[0].PasteID, "newest paste should come first")
+			assert.Equal(t, "inbox-2", recipients[1].PasteID)
+			assert.Equal(t, "author-key", recipients[0].Paste.PublicKey, "should attach the paste")
+			assert.Empty(t, recipients[0].Paste.Ciphertext, "should not load ciphertext")
+		}
+
+		recipients, err = repo.Inbox(ctx, "alice", false, 2, 2)
+		assert.NoError(t, err)
+		if assert.Len(t, recipients, 1, "expired pastes should be excluded") {
+			assert.Equal(t, "inbox-1", recipients[0].PasteID)
+		}
+
+		_, err = repo.GetEnvelope(ctx, "inbox-2", "alice")
+		assert.NoError(t, err, "should fetch envelope")
+
+		total, unread, err := repo.CountInbox(ctx, "alice")
+		assert.NoError(t, err)
+		assert.Equal(t, 3, total)
+		assert.Equal(t, 2, unread, "envelope fetch should mark the paste read")
+
+		recipients, err = repo.Inbox(ctx, "alice", true, 10, 0)
+		assert.NoError(t, err)
+		assert.Len(t, recipients, 2, "unread filter should hide read pastes")
 
-	pastes, err = repo.GetByPublicKey(ctx, "test-public-key")
-	assert.NoError(t, err, "should retrieve pastes without error")
-	assert.Len(t, pastes, 2, "should return only non-expired pastes")
+		recipients, err = repo.Inbox(ctx, "bob", false, 10, 0)
+		assert.NoError(t, err)
+		assert.Empty(t, recipients, "should not list pastes addressed to others")
+	})
 }
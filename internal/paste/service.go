@@ -2,15 +2,20 @@ package paste
 
 import (
 	"context"
-	"crypto/ed25519"
+	"database/sql"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log/slog"
+	"os"
+	"strconv"
 	"time"
 
+	"Drop-Key/internal/blob"
 	"Drop-Key/internal/models"
 	"Drop-Key/internal/user"
 	"Drop-Key/internal/utils"
+	"Drop-Key/internal/verifier"
 
 	"github.com/google/uuid"
 )
@@ -20,22 +25,131 @@ type PasteService interface {
 	GetByID(ctx context.Context, id string) (*models.Paste, error)
 	Update(ctx context.Context, paste *models.Paste, expires_in int) error
 	GetByPublicKey(ctx context.Context, publicKey string) ([]*models.Paste, error)
+	Delete(ctx context.Context, id, publicKey, signature string) error
+	ListRevisions(ctx context.Context, id string) ([]*models.PasteRevision, error)
+	GetRevision(ctx context.Context, id string, revision int) (*models.PasteRevision, error)
+	GetEnvelope(ctx context.Context, id, userID string) (*models.PasteRecipient, error)
+	Inbox(ctx context.Context, userID string, unreadOnly bool, limit, offset int) (*Inbox, error)
+}
+
+// DeleteStatement is the message an owner signs to delete a paste. It is
+// bound to the paste ID so a signature cannot be replayed on another paste.
+func DeleteStatement(id string) []byte {
+	return []byte("DropKey paste delete v1:" + id)
+}
+
+const (
+	DefaultRevisionRetention = 10
+	// DefaultMaxSize keeps the base64 ciphertext of a paste within the 16
+	// MiB a MySQL MEDIUMTEXT column holds.
+	DefaultMaxSize = 8 << 20
+	// DefaultBlobMaxSize is the default with a blob store, which no column
+	// limits. Requests still hold their paste in memory, which bounds it.
+	DefaultBlobMaxSize = 64 << 20
+
+	// maxRequestOverhead is how much larger than its base64 ciphertext a
+	// paste request may be, for the signature, recipients and other fields.
+	maxRequestOverhead = 64 << 10
+
+	maxRecipients    = 32
+	maxEnvelopeBytes = 1024
+
+	DefaultInboxLimit = 20
+	maxInboxLimit     = 100
+)
+
+type Config struct {
+	// AllowLegacySignatures accepts pastes signed over the ciphertext only,
+	// for clients that do not sign the v2 payload yet.
+	AllowLegacySignatures bool
+	// RevisionRetention is how many earlier versions of a paste are kept
+	// when it is updated. Zero keeps no history.
+	RevisionRetention int
+	// MaxSize is the largest ciphertext a paste may have, in decoded bytes.
+	// Zero does not limit it. Ciphertexts are held in memory while they are
+	// verified, stored and served, so this bounds the memory every request
+	// takes, whichever blob store keeps them.
+	MaxSize int64
+}
+
+// MaxBodySize returns the largest paste request body to read, or zero when
+// MaxSize does not limit it.
+func (c Config) MaxBodySize() int64 {
+	if c.MaxSize <= 0 {
+		return 0
+	}
+	return int64(base64.StdEncoding.EncodedLen(int(c.MaxSize))) + maxRequestOverhead
+}
+
+// LoadConfig reads PASTE_ALLOW_LEGACY_SIGNATURES, PASTE_REVISION_RETENTION
+// and PASTE_MAX_SIZE from the environment. Legacy signatures are rejected
+// unless the first is set to a true value. PASTE_MAX_SIZE defaults to
+// DefaultBlobMaxSize when BLOB_STORE keeps ciphertexts outside the database.
+func LoadConfig() Config {
+	config := Config{
+		RevisionRetention: DefaultRevisionRetention,
+		MaxSize:           DefaultMaxSize,
+	}
+	if store := os.Getenv("BLOB_STORE"); store != "" && store != blob.StoreDatabase {
+		config.MaxSize = DefaultBlobMaxSize
+	}
+	if value := os.Getenv("PASTE_ALLOW_LEGACY_SIGNATURES"); value != "" {
+		allow, err := strconv.ParseBool(value)
+		if err != nil {
+			slog.Error("Invalid PASTE_ALLOW_LEGACY_SIGNATURES, legacy signatures stay disabled", "value", value)
+		}
+		config.AllowLegacySignatures = allow
+	}
+	if value := os.Getenv("PASTE_REVISION_RETENTION"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+			config.RevisionRetention = n
+		} else {
+			slog.Error("Invalid PASTE_REVISION_RETENTION, using default", "value", value, "default", config.RevisionRetention)
+		}
+	}
+	if value := os.Getenv("PASTE_MAX_SIZE"); value != "" {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+			config.MaxSize = n
+		} else {
+			slog.Error("Invalid PASTE_MAX_SIZE, using default", "value", value, "default", config.MaxSize)
+		}
+	}
+	return config
+}
+
+// checkSize rejects ciphertext larger than MaxSize.
+func (p *pasteService) checkSize(ciphertext []byte) error {
+	if p.config.MaxSize > 0 && int64(len(ciphertext)) > p.config.MaxSize {
+		return utils.ErrPasteTooLarge
+	}
+	return nil
 }
 
 type pasteService struct {
 	repo     PasteRepository
 	userRepo user.UserRepository
+	config   Config
 }
 
-func NewPasteService(repo PasteRepository, userRepo user.UserRepository) *pasteService {
+func NewPasteService(repo PasteRepository, userRepo user.UserRepository, config Config) *pasteService {
 	return &pasteService{
 		repo:     repo,
 		userRepo: userRepo,
+		config:   config,
 	}
 }
 
+// expiry returns the absolute expiry of paste. v2 pastes carry the signed
+// expires_at, legacy pastes expire expiresIn seconds from now.
+func expiry(paste *models.Paste, expiresIn int) time.Time {
+	if paste.SignatureVersion == SignatureVersionV2 {
+		return paste.ExpiresAt.UTC().Truncate(time.Second)
+	}
+	return time.Now().UTC().Add(time.Second * time.Duration(expiresIn)).Truncate(time.Second)
+}
+
 func (p *pasteService) Create(ctx context.Context, paste *models.Paste, expires_in int) (string, error) {
-	expires_at := time.Now().UTC().Add(time.Second * time.Duration(expires_in)).Truncate(time.Second)
+	expires_at := expiry(paste, expires_in)
 	if time.Now().UTC().Compare(expires_at) != -1 {
 		return "", utils.ErrPasteExpiredAlready
 	}
@@ -46,6 +160,10 @@ func (p *pasteService) Create(ctx context.Context, paste *models.Paste, expires_
 		return "", err
 	}
 
+	if paste.MaxViews < 0 {
+		return "", utils.ErrPasteInvalidMaxViews
+	}
+
 	if paste.Ciphertext == "" {
 		return "", utils.ErrPasteEmptyCiphertext
 	}
@@ -53,38 +171,51 @@ func (p *pasteService) Create(ctx context.Context, paste *models.Paste, expires_
 	if err != nil {
 		return "", utils.ErrPasteInvalidCiphertext
 	}
+	if err := p.checkSize(ciphertext); err != nil {
+		return "", err
+	}
 
 	if paste.Signature == "" {
 		return "", utils.ErrPasteEmptySignature
 	}
 
-	signature, err := base64.StdEncoding.DecodeString(paste.Signature)
-	if err != nil || len(signature) != ed25519.SignatureSize {
+	paste.Algorithm = verifier.Normalize(paste.Algorithm)
+	if _, err := verifier.Default.Lookup(paste.Algorithm); err != nil {
+		return "", err
+	}
+	signature, err := verifier.Default.DecodeSignature(paste.Algorithm, paste.Signature)
+	if err != nil {
 		return "", utils.ErrPasteInvalidSignature
 	}
 
-	fmt.Printf("Decoded message: %q\n", ciphertext)
-	fmt.Printf("Signature: %x\n", signature)
 	if paste.PublicKey == "" {
 		return "", utils.ErrPasteInvalidPublicKey
 	}
-	publicKey, err := base64.StdEncoding.DecodeString(paste.PublicKey)
-	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+	publicKey, err := verifier.Default.DecodePublicKey(paste.Algorithm, paste.PublicKey)
+	if err != nil {
 		return "", utils.ErrPasteInvalidPublicKey
 	}
 
-	fmt.Printf("PublicKey: %x\n", publicKey)
-	_, err = p.userRepo.GetByPublicKey(ctx, paste.PublicKey)
+	owner, device, err := user.ResolveSigningKey(ctx, p.userRepo, paste.PublicKey)
 	if err != nil {
 		return "", utils.ErrPasteUserNotFound
 	}
+	if user.SigningAlgorithm(owner, device) != paste.Algorithm {
+		return "", utils.ErrPasteInvalidPublicKey
+	}
+	paste.UserID = owner.ID
+	paste.DeviceID = deviceID(device)
+
+	if err := p.validateRecipients(ctx, paste.Recipients); err != nil {
+		return "", err
+	}
 
-	if !ed25519.Verify(publicKey, ciphertext, signature) {
-		return "", utils.ErrPasteInvalidSignatureVerification
+	paste.ExpiresAt = expires_at
+	if err := p.verifyPasteSignature(ActionCreate, paste, publicKey, ciphertext, nil, signature); err != nil {
+		return "", err
 	}
 
 	paste.ID = uuid.NewString()
-	paste.ExpiresAt = expires_at
 
 	err = p.repo.Create(ctx, paste)
 	if err != nil {
@@ -93,6 +224,44 @@ func (p *pasteService) Create(ctx context.Context, paste *models.Paste, expires_
 	return paste.ID, nil
 }
 
+// validateRecipients checks that every recipient is a registered user,
+// listed once, with a well formed envelope, and records which user each
+// one is.
+func (p *pasteService) validateRecipients(ctx context.Context, recipients []*models.PasteRecipient) error {
+	if len(recipients) > maxRecipients {
+		return utils.ErrPasteTooManyRecipients
+	}
+	seen := make(map[string]bool, len(recipients))
+	for _, recipient := range recipients {
+		if !verifier.Default.IsPublicKey(recipient.PublicKey) {
+			return utils.ErrPasteInvalidRecipient
+		}
+		envelope, err := base64.StdEncoding.DecodeString(recipient.Envelope)
+		if err != nil || len(envelope) == 0 || len(envelope) > maxEnvelopeBytes {
+			return utils.ErrPasteInvalidRecipient
+		}
+		if seen[recipient.PublicKey] {
+			return utils.ErrPasteDuplicateRecipient
+		}
+		seen[recipient.PublicKey] = true
+
+		addressee, err := p.userRepo.GetByPublicKey(ctx, recipient.PublicKey)
+		if err != nil {
+			return utils.ErrPasteRecipientNotFound
+		}
+		recipient.UserID = addressee.ID
+	}
+	return nil
+}
+
+// isGone reports whether err says the paste content was removed on purpose
+// before it expired.
+func isGone(err error) bool {
+	return errors.Is(err, utils.ErrPasteDeleted) ||
+		errors.Is(err, utils.ErrPasteBurned) ||
+		errors.Is(err, utils.ErrPasteViewsExhausted)
+}
+
 func (p *pasteService) GetByID(ctx context.Context, id string) (*models.Paste, error) {
 	if id == "" {
 		return nil, utils.ErrPasteInvalidID
@@ -102,35 +271,56 @@ func (p *pasteService) GetByID(ctx context.Context, id string) (*models.Paste, e
 	}
 	paste, err := p.repo.GetByID(ctx, id)
 	if err != nil {
-		if err == utils.ErrPasteExpiredAlready {
+		switch {
+		case errors.Is(err, ErrPasteExpired), errors.Is(err, utils.ErrPasteExpiredAlready):
+			return nil, utils.ErrPasteExpiredAlready
+		case isGone(err):
 			return nil, err
+		case isNotFound(err):
+			return nil, utils.ErrPasteNotFound
 		}
-		return nil, utils.ErrPasteNotFound
+		return nil, lookupFailed(id, err)
 	}
+	paste.Fingerprint = user.Fingerprint(paste.Algorithm, paste.PublicKey)
 	return paste, nil
 }
 
+// isNotFound reports whether err is the repositories' answer for a paste
+// that does not exist, or no longer does because it expired.
+func isNotFound(err error) bool {
+	return errors.Is(err, sql.ErrNoRows) ||
+		errors.Is(err, utils.ErrPasteNotFound) ||
+		errors.Is(err, ErrPasteExpired)
+}
+
+// lookupFailed wraps an error other than a missing paste that loading paste
+// id failed with.
+func lookupFailed(id string, err error) error {
+	return fmt.Errorf("Error while loading paste %s, error: %w", id, err)
+}
+
 func (p *pasteService) GetByPublicKey(ctx context.Context, publicKey string) ([]*models.Paste, error) {
 	if publicKey == "" {
 		return nil, utils.ErrEmptyPublicKey
 	}
-	base64publicKey, err := base64.StdEncoding.DecodeString(publicKey)
-	if err != nil || len(base64publicKey) != ed25519.PublicKeySize {
+	if !verifier.Default.IsPublicKey(publicKey) {
 		return nil, utils.ErrInvalidPublicKey
 	}
 
-	userWithPublicKey, err := p.userRepo.GetByPublicKey(ctx, publicKey)
+	// Any active key of a user, their devices' included, lists all of the
+	// user's pastes.
+	owner, _, err := user.ResolveSigningKey(ctx, p.userRepo, publicKey)
 	if err != nil {
 		return nil, utils.ErrUserNotFoundForPublicKey
 	}
-	if publicKey != userWithPublicKey.PublicKey {
-		return nil, utils.ErrUnauthorizedAccess
-	}
 
-	pastes, err := p.repo.GetByPublicKey(ctx, publicKey)
+	pastes, err := p.repo.GetByOwner(ctx, owner.ID, owner.PublicKey)
 	if err != nil {
 		return nil, utils.ErrPasteNotFound
 	}
+	for _, paste := range pastes {
+		paste.Fingerprint = user.Fingerprint(paste.Algorithm, paste.PublicKey)
+	}
 	return pastes, nil
 }
 
@@ -148,28 +338,52 @@ func (p *pasteService) Update(ctx context.Context, paste *models.Paste, expiresI
 	if err != nil {
 		return utils.ErrPasteInvalidCiphertext
 	}
+	if err := p.checkSize(ciphertext); err != nil {
+		return err
+	}
 	if paste.Signature == "" {
 		return utils.ErrPasteEmptySignature
 	}
-	signature, err := base64.StdEncoding.DecodeString(paste.Signature)
-	if err != nil || len(signature) != ed25519.SignatureSize {
+	paste.Algorithm = verifier.Normalize(paste.Algorithm)
+	if _, err := verifier.Default.Lookup(paste.Algorithm); err != nil {
+		return err
+	}
+	signature, err := verifier.Default.DecodeSignature(paste.Algorithm, paste.Signature)
+	if err != nil {
 		return utils.ErrPasteInvalidSignature
 	}
 	if paste.PublicKey == "" {
 		return utils.ErrPasteInvalidPublicKey
 	}
-	publicKey, err := base64.StdEncoding.DecodeString(paste.PublicKey)
-	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+	publicKey, err := verifier.Default.DecodePublicKey(paste.Algorithm, paste.PublicKey)
+	if err != nil {
 		return utils.ErrPasteInvalidPublicKey
 	}
-	if !ed25519.Verify(publicKey, ciphertext, signature) {
-		return utils.ErrPasteInvalidSignatureVerification
-	}
 
-	if expiresIn < 0 {
+	if expiresIn < 0 && paste.SignatureVersion != SignatureVersionV2 {
 		return utils.ErrPasteInvalidExpiryTime
 	}
-	expiresAt := time.Now().UTC().Add(time.Second * time.Duration(expiresIn)).Truncate(time.Second)
+	expiresAt := expiry(paste, expiresIn)
+	paste.ExpiresAt = expiresAt
+	existing, err := p.repo.Peek(ctx, paste.ID)
+	switch {
+	case isGone(err):
+		return err
+	case isNotFound(err):
+		return utils.ErrPasteNotFound
+	case err != nil:
+		return lookupFailed(paste.ID, err)
+	}
+	previous, err := base64.StdEncoding.DecodeString(existing.Ciphertext)
+	if err != nil {
+		return lookupFailed(paste.ID, err)
+	}
+	// The update is only stored on top of the version it was checked
+	// against, which v2 signatures also cover.
+	paste.Revision = existing.Revision + 1
+	if err := p.verifyPasteSignature(ActionUpdate, paste, publicKey, ciphertext, previous, signature); err != nil {
+		return err
+	}
 
 	if time.Now().UTC().After(expiresAt) {
 		return utils.ErrPasteExpiredAlready
@@ -178,7 +392,222 @@ func (p *pasteService) Update(ctx context.Context, paste *models.Paste, expiresI
 		return utils.ErrPasteExpiryTooLong
 	}
 
-	paste.ExpiresAt = expiresAt
+	owner, device, err := user.ResolveSigningKey(ctx, p.userRepo, paste.PublicKey)
+	if err != nil {
+		return utils.ErrPasteUserNotFound
+	}
+	if user.SigningAlgorithm(owner, device) != paste.Algorithm {
+		return utils.ErrPasteInvalidPublicKey
+	}
+	if !ownedBy(existing, owner) {
+		return utils.ErrPasteForbidden
+	}
+	paste.UserID = owner.ID
+	paste.DeviceID = deviceID(device)
+
 	slog.Info("Updating paste expiration", "id", paste.ID, "new_expires_at", paste.ExpiresAt)
-	return p.repo.Update(ctx, paste)
+	return p.repo.Update(ctx, paste, p.config.RevisionRetention)
+}
+
+// ownedBy reports whether paste belongs to user. Pastes are owned by user ID
+// so that they survive identity key rotation; pastes stored before they
+// carried an owner ID fall back to the key they were signed with.
+func ownedBy(paste *models.Paste, user *models.User) bool {
+	if paste.UserID != "" {
+		return paste.UserID == user.ID
+	}
+	return paste.PublicKey == user.PublicKey
+}
+
+func deviceID(device *models.DeviceKey) string {
+	if device == nil {
+		return ""
+	}
+	return device.ID
+}
+
+// head loads the current version of a paste for the revision endpoints, so
+// that the history becomes unavailable together with the paste itself.
+func (p *pasteService) head(ctx context.Context, id string) (*models.Paste, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, utils.ErrPasteInvalidID
+	}
+	head, err := p.repo.Peek(ctx, id)
+	switch {
+	case errors.Is(err, ErrPasteExpired):
+		return nil, utils.ErrPasteExpiredAlready
+	case isGone(err):
+		return nil, err
+	case isNotFound(err):
+		return nil, utils.ErrPasteNotFound
+	case err != nil:
+		return nil, lookupFailed(id, err)
+	}
+	return head, nil
+}
+
+func (p *pasteService) ListRevisions(ctx context.Context, id string) ([]*models.PasteRevision, error) {
+	if _, err := p.head(ctx, id); err != nil {
+		return nil, err
+	}
+	revisions, err := p.repo.ListRevisions(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, revision := range revisions {
+		revision.Fingerprint = user.Fingerprint(revision.Algorithm, revision.PublicKey)
+	}
+	return revisions, nil
+}
+
+// GetRevision returns an earlier version of a paste. The current version is
+// served by GetByID, which counts views, so it is not available here.
+func (p *pasteService) GetRevision(ctx context.Context, id string, revision int) (*models.PasteRevision, error) {
+	if revision < 1 {
+		return nil, utils.ErrPasteInvalidRevision
+	}
+	head, err := p.head(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if revision >= head.Revision {
+		return nil, utils.ErrPasteRevisionNotFound
+	}
+	rev, err := p.repo.GetRevision(ctx, id, revision)
+	if err != nil {
+		return nil, err
+	}
+	rev.Fingerprint = user.Fingerprint(rev.Algorithm, rev.PublicKey)
+	return rev, nil
+}
+
+func (p *pasteService) Delete(ctx context.Context, id, publicKey, signature string) error {
+	if id == "" {
+		return utils.ErrPasteInvalidID
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return utils.ErrPasteInvalidID
+	}
+	if signature == "" {
+		return utils.ErrPasteEmptySignature
+	}
+	if _, err := base64.StdEncoding.DecodeString(signature); err != nil {
+		return utils.ErrPasteInvalidSignature
+	}
+	if !verifier.Default.IsPublicKey(publicKey) {
+		return utils.ErrPasteInvalidPublicKey
+	}
+
+	existing, err := p.repo.Peek(ctx, id)
+	switch {
+	case isGone(err):
+		return err
+	case isNotFound(err):
+		return utils.ErrPasteNotFound
+	case err != nil:
+		return lookupFailed(id, err)
+	}
+	owner, device, err := user.ResolveSigningKey(ctx, p.userRepo, publicKey)
+	if err != nil || !ownedBy(existing, owner) {
+		return utils.ErrPasteForbidden
+	}
+
+	// Deletes do not name an algorithm; it is that of the signing key.
+	if err := verifier.Default.Verify(user.SigningAlgorithm(owner, device), publicKey, DeleteStatement(id), signature); err != nil {
+		return utils.ErrPasteInvalidSignatureVerification
+	}
+
+	slog.Info("Deleting paste", "id", id)
+	return p.repo.Delete(ctx, id, signature)
+}
+
+// GetEnvelope returns the content key envelope addressed to the user
+// userID. Recipients only ever see their own envelope. Recipients are
+// users, so sessions opened with any of the user's keys, a device's or one
+// rotated in after the paste was sent included, share one inbox.
+func (p *pasteService) GetEnvelope(ctx context.Context, id, userID string) (*models.PasteRecipient, error) {
+	if userID == "" {
+		return nil, utils.ErrUnauthorizedAccess
+	}
+	if _, err := p.head(ctx, id); err != nil {
+		return nil, err
+	}
+	return p.repo.GetEnvelope(ctx, id, userID)
+}
+
+// Inbox is one page of the pastes addressed to a user.
+type Inbox struct {
+	Items  []*InboxItem `json:"items"`
+	Total  int          `json:"total"`
+	Unread int          `json:"unread"`
+	Limit  int          `json:"limit"`
+	Offset int          `json:"offset"`
+}
+
+type InboxItem struct {
+	PasteID           string     `json:"paste_id"`
+	AuthorPublicKey   string     `json:"author_public_key"`
+	AuthorFingerprint string     `json:"author_fingerprint"`
+	ExpiresAt         time.Time  `json:"expires_at"`
+	BurnAfterRead     bool       `json:"burn_after_read"`
+	MaxViews          int        `json:"max_views,omitempty"`
+	Views             int        `json:"views"`
+	Tombstone         string     `json:"tombstone,omitempty"`
+	ReceivedAt        time.Time  `json:"received_at"`
+	Read              bool       `json:"read"`
+	ReadAt            *time.Time `json:"read_at"`
+}
+
+// Inbox lists the unexpired pastes addressed to the user userID, newest
+// first. A paste counts as read once the recipient has fetched its envelope.
+func (p *pasteService) Inbox(ctx context.Context, userID string, unreadOnly bool, limit, offset int) (*Inbox, error) {
+	if limit == 0 {
+		limit = DefaultInboxLimit
+	}
+	if limit < 1 || limit > maxInboxLimit || offset < 0 {
+		return nil, utils.ErrInvalidPagination
+	}
+	if userID == "" {
+		return nil, utils.ErrUnauthorizedAccess
+	}
+
+	recipients, err := p.repo.Inbox(ctx, userID, unreadOnly, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	total, unread, err := p.repo.CountInbox(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	inbox := &Inbox{
+		Items:  make([]*InboxItem, 0, len(recipients)),
+		Total:  total,
+		Unread: unread,
+		Limit:  limit,
+		Offset: offset,
+	}
+	for _, recipient := range recipients {
+		if recipient.Paste == nil {
+			continue
+		}
+		item := &InboxItem{
+			PasteID:           recipient.PasteID,
+			AuthorPublicKey:   recipient.Paste.PublicKey,
+			AuthorFingerprint: user.Fingerprint(recipient.Paste.Algorithm, recipient.Paste.PublicKey),
+			ExpiresAt:         recipient.Paste.ExpiresAt,
+			BurnAfterRead:     recipient.Paste.BurnAfterRead,
+			MaxViews:          recipient.Paste.MaxViews,
+			Views:             recipient.Paste.Views,
+			Tombstone:         recipient.Paste.Tombstone,
+			ReceivedAt:        recipient.ReceivedAt,
+		}
+		if !recipient.ReadAt.IsZero() {
+			readAt := recipient.ReadAt
+			item.Read = true
+			item.ReadAt = &readAt
+		}
+		inbox.Items = append(inbox.Items, item)
+	}
+	return inbox, nil
 }
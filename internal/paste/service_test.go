@@ -4,15 +4,17 @@ import (
 	"context"
 	"crypto/ed25519"
 	"encoding/base64"
-	"os"
+	"errors"
 	"testing"
 	"time"
 
-	"Drop-Key/internal/db"
+	"Drop-Key/internal/blob"
 	"Drop-Key/internal/models"
+	"Drop-Key/internal/transparency"
 	"Drop-Key/internal/user"
 	"Drop-Key/internal/utils"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -22,38 +24,23 @@ var (
 	cipher_text string = "VGhpcyBpcyBhIHNhbXBsZSBzZWNyZXQgbWVzc2FnZQ=="
 )
 
-func setupTestService(t *testing.T) (*pasteService, func()) {
+func setupTestService(t *testing.T) *pasteService {
 	t.Helper()
-	ctx := context.Background()
-	os.Setenv("DSN", "testuser:testpass@tcp(localhost:3306)/testdb?parseTime=true")
-	db, err := db.InitDB(ctx)
-	assert.NoError(t, err, "should initialize database")
-	pasteRepo := NewPasteRepository(db)
-
-	userRepo := user.NewUserRepository(db)
-	cleanup := func() {
-		_, err := db.NewDropTable().Model(&models.Paste{}).IfExists().Exec(ctx)
-		assert.NoError(t, err, "should drop Paste table")
-		_, err = db.NewDropTable().Model(&models.User{}).IfExists().Exec(ctx)
-		assert.NoError(t, err, "should drop User table")
-		db.Close()
-	}
+	pasteRepo := NewInMemoryPasteRepository()
+	userRepo := user.NewInMemoryUserRepository(transparency.NewInMemoryLogRepository())
 
-	paste_service := NewPasteService(pasteRepo, userRepo)
-
-	return paste_service, cleanup
+	// The fixtures below are signed over the ciphertext only.
+	return NewPasteService(pasteRepo, userRepo, Config{AllowLegacySignatures: true})
 }
 
 func TestNewPasteService(t *testing.T) {
-	service, cleanup := setupTestService(t)
-	defer cleanup()
+	service := setupTestService(t)
 
 	assert.NotNil(t, service, "should not return a nil service.")
 }
 
 func TestCreate(t *testing.T) {
-	service, cleanup := setupTestService(t)
-	defer cleanup()
+	service := setupTestService(t)
 
 	ctx := context.Background()
 	err := service.userRepo.Create(ctx, &models.User{PublicKey: publicKey})
@@ -106,8 +93,7 @@ func TestCreate(t *testing.T) {
 }
 
 func TestGetByID(t *testing.T) {
-	service, cleanup := setupTestService(t)
-	defer cleanup()
+	service := setupTestService(t)
 
 	ctx := context.Background()
 	err := service.userRepo.Create(ctx, &models.User{PublicKey: publicKey})
@@ -156,8 +142,7 @@ func TestGetByID(t *testing.T) {
 }
 
 func TestGetbyPublicKey(t *testing.T) {
-	service, cleanup := setupTestService(t)
-	defer cleanup()
+	service := setupTestService(t)
 	ctx := context.Background()
 
 	publicKey := "kwf9K6p0OPrF5wP9l1t8ZIEd52/RCLy6XhN8ZMKGT0A="
@@ -211,8 +196,7 @@ func TestGetbyPublicKey(t *testing.T) {
 }
 
 func TestUpdate(t *testing.T) {
-	service, cleanup := setupTestService(t)
-	defer cleanup()
+	service := setupTestService(t)
 
 	msg := []byte("This is a sample secret message")
 	pub, priv, _ := ed25519.GenerateKey(nil)
@@ -275,6 +259,35 @@ func TestUpdate(t *testing.T) {
 		assert.ErrorIs(t, err, utils.ErrPasteEmptyCiphertext, "should return ErrPasteEmptyCiphertext")
 	})
 
+	t.Run("paste owned by another user", func(t *testing.T) {
+		otherPub, otherPriv, _ := ed25519.GenerateKey(nil)
+		otherKey := base64.StdEncoding.EncodeToString(otherPub)
+		err := service.userRepo.Create(ctx, &models.User{ID: "other-user", PublicKey: otherKey})
+		assert.NoError(t, err, "should create user without error.")
+
+		msg := []byte("hijacked message")
+		hijack := &models.Paste{
+			ID:         id,
+			Ciphertext: base64.StdEncoding.EncodeToString(msg),
+			PublicKey:  otherKey,
+			Signature:  base64.StdEncoding.EncodeToString(ed25519.Sign(otherPriv, msg)),
+		}
+		err = service.Update(ctx, hijack, 200)
+		assert.ErrorIs(t, err, utils.ErrPasteForbidden, "should return ErrPasteForbidden")
+	})
+
+	t.Run("non-existent paste", func(t *testing.T) {
+		msg := []byte("nothing here")
+		missing := &models.Paste{
+			ID:         "123e4567-e89b-12d3-a456-426614174000",
+			Ciphertext: base64.StdEncoding.EncodeToString(msg),
+			PublicKey:  publicKey,
+			Signature:  base64.StdEncoding.EncodeToString(ed25519.Sign(priv, msg)),
+		}
+		err := service.Update(ctx, missing, 200)
+		assert.ErrorIs(t, err, utils.ErrPasteNotFound, "should return ErrPasteNotFound")
+	})
+
 	t.Run("invalid expires in", func(t *testing.T) {
 		new_msg := []byte("This is a new sample secret message")
 		new_sig := ed25519.Sign(priv, new_msg)
@@ -285,3 +298,106 @@ func TestUpdate(t *testing.T) {
 		assert.ErrorIs(t, utils.ErrPasteInvalidExpiryTime, err, "should return ErrPasteInvalidExpiryTime")
 	})
 }
+
+func TestDelete(t *testing.T) {
+	service := setupTestService(t)
+
+	msg := []byte("This is a sample secret message")
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	ownerKey := base64.StdEncoding.EncodeToString(pub)
+
+	ctx := context.Background()
+	err := service.userRepo.Create(ctx, &models.User{ID: "owner", PublicKey: ownerKey})
+	assert.NoError(t, err, "should create user without error.")
+
+	paste := &models.Paste{
+		Ciphertext: base64.StdEncoding.EncodeToString(msg),
+		PublicKey:  ownerKey,
+		Signature:  base64.StdEncoding.EncodeToString(ed25519.Sign(priv, msg)),
+	}
+	id, err := service.Create(ctx, paste, 3600)
+	assert.NoError(t, err, "should create a paste.")
+
+	t.Run("other user", func(t *testing.T) {
+		otherPub, otherPriv, _ := ed25519.GenerateKey(nil)
+		sig := base64.StdEncoding.EncodeToString(ed25519.Sign(otherPriv, DeleteStatement(id)))
+		err := service.Delete(ctx, id, base64.StdEncoding.EncodeToString(otherPub), sig)
+		assert.ErrorIs(t, err, utils.ErrPasteForbidden, "should return ErrPasteForbidden")
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte("something else")))
+		err := service.Delete(ctx, id, ownerKey, sig)
+		assert.ErrorIs(t, err, utils.ErrPasteInvalidSignatureVerification, "should return ErrPasteInvalidSignatureVerification")
+	})
+
+	t.Run("valid delete", func(t *testing.T) {
+		sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, DeleteStatement(id)))
+		err := service.Delete(ctx, id, ownerKey, sig)
+		assert.NoError(t, err, "should delete paste without error")
+
+		_, err = service.GetByID(ctx, id)
+		assert.ErrorIs(t, err, utils.ErrPasteDeleted, "should return ErrPasteDeleted")
+	})
+}
+
+// unavailableRepository is a paste repository whose database is down.
+type unavailableRepository struct {
+	PasteRepository
+}
+
+var errUnavailable = errors.New("connection refused")
+
+func (r unavailableRepository) Peek(ctx context.Context, id string) (*models.Paste, error) {
+	return nil, errUnavailable
+}
+
+func TestLookupErrors(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	ownerKey := base64.StdEncoding.EncodeToString(pub)
+	assert.NoError(t, service.userRepo.Create(ctx, &models.User{PublicKey: ownerKey}))
+
+	id := uuid.NewString()
+	update := func() error {
+		msg := []byte("update")
+		return service.Update(ctx, &models.Paste{
+			ID:         id,
+			Ciphertext: base64.StdEncoding.EncodeToString(msg),
+			Signature:  base64.StdEncoding.EncodeToString(ed25519.Sign(priv, msg)),
+			PublicKey:  ownerKey,
+		}, 3600)
+	}
+	remove := func() error {
+		return service.Delete(ctx, id, ownerKey, base64.StdEncoding.EncodeToString(ed25519.Sign(priv, DeleteStatement(id))))
+	}
+
+	t.Run("missing paste", func(t *testing.T) {
+		assert.ErrorIs(t, update(), utils.ErrPasteNotFound, "update should report a missing paste")
+		assert.ErrorIs(t, remove(), utils.ErrPasteNotFound, "delete should report a missing paste")
+	})
+
+	t.Run("repository failure", func(t *testing.T) {
+		service.repo = unavailableRepository{service.repo}
+		err := update()
+		assert.ErrorIs(t, err, errUnavailable, "update should pass on the failure")
+		assert.NotErrorIs(t, err, utils.ErrPasteNotFound)
+		err = remove()
+		assert.ErrorIs(t, err, errUnavailable, "delete should pass on the failure")
+		assert.NotErrorIs(t, err, utils.ErrPasteNotFound)
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("PASTE_MAX_SIZE", "")
+	t.Setenv("BLOB_STORE", "")
+	assert.Equal(t, int64(DefaultMaxSize), LoadConfig().MaxSize, "inline ciphertexts should fit a MEDIUMTEXT column")
+	t.Setenv("BLOB_STORE", blob.StoreDatabase)
+	assert.Equal(t, int64(DefaultMaxSize), LoadConfig().MaxSize)
+
+	t.Setenv("BLOB_STORE", blob.StoreFilesystem)
+	assert.Equal(t, int64(DefaultBlobMaxSize), LoadConfig().MaxSize, "blob stores should not be limited by the database column")
+	t.Setenv("PASTE_MAX_SIZE", "1024")
+	assert.Equal(t, int64(1024), LoadConfig().MaxSize, "PASTE_MAX_SIZE should override the default")
+}
@@ -0,0 +1,136 @@
+package paste
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+	"Drop-Key/internal/verifier"
+)
+
+// Signature versions a paste can be signed with. Legacy signatures cover the
+// ciphertext only; v2 signatures cover the canonical payload built by
+// SigningPayload.
+const (
+	SignatureVersionLegacy = 1
+	SignatureVersionV2     = 2
+)
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+
+	signingPayloadDomain = "DropKey paste signature"
+	maxNonceLength       = 128
+)
+
+// SigningPayload returns the canonical bytes a client signs for a v2 paste.
+// Creates are bound to a client chosen nonce, since the paste ID is not known
+// yet, and updates to the paste ID. The ciphertext is bound by its SHA-256
+// digest and the expiry as an absolute unix timestamp, so neither can be
+// changed without a new signature. Creates also cover the read limits and
+// the recipients, listed by public key in sorted order, and updates the
+// revision they create and the digest of the ciphertext they replace, so an
+// update only applies on top of the version it was signed for:
+//
+//	DropKey paste signature
+//	version:2
+//	action:create
+//	nonce:<nonce>
+//	ciphertext-sha256:<hex>
+//	expires-at:<unix seconds>
+//	burn-after-read:<true|false>
+//	max-views:<n>
+//	recipients:<public key>,<public key>,...
+//
+//	DropKey paste signature
+//	version:2
+//	action:update
+//	id:<paste id>
+//	ciphertext-sha256:<hex>
+//	expires-at:<unix seconds>
+//	revision:<n>
+//	prev-sha256:<hex>
+func SigningPayload(action, subject string, paste *models.Paste, ciphertext, previous []byte) []byte {
+	subjectKey := "id"
+	if action == ActionCreate {
+		subjectKey = "nonce"
+	}
+
+	var b strings.Builder
+	b.WriteString(signingPayloadDomain)
+	b.WriteString("\nversion:")
+	b.WriteString(strconv.Itoa(SignatureVersionV2))
+	b.WriteString("\naction:")
+	b.WriteString(action)
+	b.WriteString("\n" + subjectKey + ":")
+	b.WriteString(subject)
+	b.WriteString("\nciphertext-sha256:")
+	b.WriteString(hexDigest(ciphertext))
+	b.WriteString("\nexpires-at:")
+	b.WriteString(strconv.FormatInt(paste.ExpiresAt.Unix(), 10))
+	if action == ActionCreate {
+		recipients := make([]string, 0, len(paste.Recipients))
+		for _, recipient := range paste.Recipients {
+			recipients = append(recipients, recipient.PublicKey)
+		}
+		sort.Strings(recipients)
+		b.WriteString("\nburn-after-read:")
+		b.WriteString(strconv.FormatBool(paste.BurnAfterRead))
+		b.WriteString("\nmax-views:")
+		b.WriteString(strconv.Itoa(paste.MaxViews))
+		b.WriteString("\nrecipients:")
+		b.WriteString(strings.Join(recipients, ","))
+	} else {
+		b.WriteString("\nrevision:")
+		b.WriteString(strconv.Itoa(paste.Revision))
+		b.WriteString("\nprev-sha256:")
+		b.WriteString(hexDigest(previous))
+	}
+	return []byte(b.String())
+}
+
+func hexDigest(data []byte) string {
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
+
+// verifyPasteSignature checks the paste signature according to its version.
+// A zero version is treated as legacy, which is what clients that predate
+// versioned signatures send. previous is the ciphertext an update replaces.
+func (p *pasteService) verifyPasteSignature(action string, paste *models.Paste, publicKey, ciphertext, previous, signature []byte) error {
+	if paste.SignatureVersion == 0 {
+		paste.SignatureVersion = SignatureVersionLegacy
+	}
+
+	var message []byte
+	switch paste.SignatureVersion {
+	case SignatureVersionLegacy:
+		if !p.config.AllowLegacySignatures {
+			return utils.ErrPasteLegacySignatureDisabled
+		}
+		paste.Nonce = ""
+		message = ciphertext
+	case SignatureVersionV2:
+		subject := paste.ID
+		if action == ActionCreate {
+			if paste.Nonce == "" || len(paste.Nonce) > maxNonceLength {
+				return utils.ErrPasteInvalidNonce
+			}
+			subject = paste.Nonce
+		}
+		message = SigningPayload(action, subject, paste, ciphertext, previous)
+	default:
+		return utils.ErrPasteUnsupportedSignatureVersion
+	}
+
+	v, err := verifier.Default.Lookup(paste.Algorithm)
+	if err != nil || !v.Verify(publicKey, message, signature) {
+		return utils.ErrPasteInvalidSignatureVerification
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package paste
+
+import (
+	"testing"
+	"time"
+
+	"Drop-Key/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigningPayload(t *testing.T) {
+	paste := &models.Paste{
+		ExpiresAt:     time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		BurnAfterRead: true,
+		MaxViews:      3,
+		Recipients: []*models.PasteRecipient{
+			{PublicKey: "bob_key"},
+			{PublicKey: "alice_key"},
+		},
+	}
+
+	payload := SigningPayload(ActionCreate, "client-nonce", paste, []byte("hello"), nil)
+	assert.Equal(t, "DropKey paste signature\n"+
+		"version:2\n"+
+		"action:create\n"+
+		"nonce:client-nonce\n"+
+		"ciphertext-sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824\n"+
+		"expires-at:1735787045\n"+
+		"burn-after-read:true\n"+
+		"max-views:3\n"+
+		"recipients:alice_key,bob_key", string(payload), "payload should match the documented format")
+
+	paste.Recipients = paste.Recipients[:1]
+	assert.NotEqual(t, payload, SigningPayload(ActionCreate, "client-nonce", paste, []byte("hello"), nil), "recipients should be covered")
+
+	update := &models.Paste{ExpiresAt: paste.ExpiresAt, Revision: 2}
+	payload = SigningPayload(ActionUpdate, "paste-id", update, []byte("hello"), []byte("world"))
+	assert.Equal(t, "DropKey paste signature\n"+
+		"version:2\n"+
+		"action:update\n"+
+		"id:paste-id\n"+
+		"ciphertext-sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824\n"+
+		"expires-at:1735787045\n"+
+		"revision:2\n"+
+		"prev-sha256:486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7", string(payload), "updates should be bound to the paste id and the version they replace")
+}
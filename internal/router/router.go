@@ -3,13 +3,15 @@ package router
 import (
 	"Drop-Key/internal/middleware"
 	"Drop-Key/internal/paste"
+	"Drop-Key/internal/signing"
+	"Drop-Key/internal/transparency"
 	"Drop-Key/internal/user"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
 
-func Router(pasteHandler paste.PasterHandlerInterface, userHandler user.UserHandler) *echo.Echo {
+func Router(pasteHandler paste.PasterHandlerInterface, userHandler user.UserHandler, webAuthnHandler user.WebAuthnHandler, keysHandler signing.KeysHandler, logHandler transparency.LogHandler, jwtAuth, pasteBodyLimit echo.MiddlewareFunc) *echo.Echo {
 	e := echo.New()
 
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
@@ -23,18 +25,44 @@ func Router(pasteHandler paste.PasterHandlerInterface, userHandler user.UserHand
 
 	e.Pre(middleware.RemoveTrailingSlash())
 	e.Use(custom_middleware.Logger)
+	e.GET("/.well-known/jwks.json", keysHandler.JWKS)
+
+	logGroup := e.Group("/api/log", custom_middleware.Logger)
+	logGroup.GET("/tree-head", logHandler.TreeHead)
+	logGroup.GET("/entries", logHandler.Entries)
+	logGroup.GET("/proof/inclusion", logHandler.InclusionProof)
+	logGroup.GET("/proof/consistency", logHandler.ConsistencyProof)
+
 	publicPasteGroup := e.Group("/api/pastes", custom_middleware.Logger)
 	publicPasteGroup.GET("/:id", pasteHandler.GetPaste)
+	publicPasteGroup.GET("/:id/revisions", pasteHandler.ListRevisions)
+	publicPasteGroup.GET("/:id/revisions/:n", pasteHandler.GetRevision)
 	publicPasteGroup.GET("", pasteHandler.GetByPublicKey)
 
-	protectedPasteGroup := e.Group("/api/pastes", custom_middleware.Logger, custom_middleware.JwtAuth)
-	protectedPasteGroup.POST("", pasteHandler.CreatePaste)
-	protectedPasteGroup.PUT("/:id", pasteHandler.UpdatePaste)
+	protectedPasteGroup := e.Group("/api/pastes", custom_middleware.Logger, jwtAuth)
+	protectedPasteGroup.POST("", pasteHandler.CreatePaste, pasteBodyLimit)
+	protectedPasteGroup.PUT("/:id", pasteHandler.UpdatePaste, pasteBodyLimit)
+	protectedPasteGroup.DELETE("/:id", pasteHandler.DeletePaste)
+	protectedPasteGroup.GET("/:id/envelope", pasteHandler.GetEnvelope)
+
+	e.GET("/api/inbox", pasteHandler.Inbox, custom_middleware.Logger, jwtAuth)
 
 	userGroup := e.Group("/api/users", custom_middleware.Logger)
 	userGroup.POST("", userHandler.RegisterHandler)
+	userGroup.POST("/challenge", userHandler.ChallengeHandler)
 	userGroup.POST("/auth", userHandler.AuthenticateHandler)
+	userGroup.POST("/token/refresh", userHandler.RefreshHandler)
+	userGroup.POST("/logout", userHandler.LogoutHandler, jwtAuth)
+	userGroup.POST("/keys", userHandler.AddEncryptionKeyHandler, jwtAuth)
+	userGroup.POST("/webauthn/register/begin", webAuthnHandler.BeginRegistrationHandler, jwtAuth)
+	userGroup.POST("/webauthn/register/finish", webAuthnHandler.FinishRegistrationHandler, jwtAuth)
+	userGroup.POST("/webauthn/login/begin", webAuthnHandler.BeginLoginHandler)
+	userGroup.POST("/webauthn/login/finish", webAuthnHandler.FinishLoginHandler)
 	userGroup.GET("/:id", userHandler.GetByIDHandler)
+	userGroup.GET("/:id/safety-number", userHandler.SafetyNumberHandler)
+	userGroup.POST("/:id/rotate", userHandler.RotateKeyHandler)
+	userGroup.POST("/:id/devices", userHandler.AddDeviceHandler)
+	userGroup.DELETE("/:id/devices/:device", userHandler.RevokeDeviceHandler)
 	userGroup.GET("", userHandler.GetByPublicKeyHandler)
 	return e
 }
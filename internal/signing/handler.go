@@ -0,0 +1,26 @@
+package signing
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type KeysHandler interface {
+	JWKS(c echo.Context) error
+}
+
+type keysHandler struct {
+	keys *KeySet
+}
+
+func NewKeysHandler(keys *KeySet) *keysHandler {
+	return &keysHandler{
+		keys: keys,
+	}
+}
+
+func (h *keysHandler) JWKS(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, h.keys.JWKS())
+}
@@ -0,0 +1,223 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"Drop-Key/internal/utils"
+)
+
+// Key is an Ed25519 key used to sign session tokens, identified by the RFC
+// 7638 thumbprint of its public JWK.
+type Key struct {
+	ID         string
+	PrivateKey ed25519.PrivateKey
+}
+
+func (k *Key) PublicKey() ed25519.PublicKey {
+	return k.PrivateKey.Public().(ed25519.PublicKey)
+}
+
+func NewKey(privateKey ed25519.PrivateKey) *Key {
+	return &Key{
+		ID:         Thumbprint(privateKey.Public().(ed25519.PublicKey)),
+		PrivateKey: privateKey,
+	}
+}
+
+// Thumbprint computes the RFC 7638 JWK thumbprint of an Ed25519 public key,
+// base64url encoded, which is used as the key's kid.
+func Thumbprint(publicKey ed25519.PublicKey) string {
+	canonical := fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`, base64.RawURLEncoding.EncodeToString(publicKey))
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// KeySet holds every key that tokens may be verified with. The current key
+// signs new tokens; the others stay published until tokens signed with them
+// have expired, which is what makes rotation seamless.
+type KeySet struct {
+	mu      sync.RWMutex
+	current *Key
+	keys    map[string]*Key
+	order   []string
+}
+
+func NewKeySet(keys ...*Key) *KeySet {
+	ks := &KeySet{keys: make(map[string]*Key)}
+	for _, key := range keys {
+		_ = ks.Add(key)
+	}
+	return ks
+}
+
+// LoadKeySet reads JWT_SIGNING_KEYS, a comma separated list of base64 encoded
+// Ed25519 seeds or private keys. The first key signs new tokens, the rest are
+// kept for verification only. Without configuration an ephemeral key is
+// generated, so tokens do not survive a restart.
+func LoadKeySet() (*KeySet, error) {
+	raw := os.Getenv("JWT_SIGNING_KEYS")
+	if strings.TrimSpace(raw) == "" {
+		slog.Warn("JWT_SIGNING_KEYS not set, generating an ephemeral signing key")
+		_, privateKey, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			return nil, err
+		}
+		return NewKeySet(NewKey(privateKey)), nil
+	}
+
+	ks := NewKeySet()
+	for _, encoded := range strings.Split(raw, ",") {
+		privateKey, err := ParsePrivateKey(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, err
+		}
+		if err := ks.Add(NewKey(privateKey)); err != nil {
+			return nil, err
+		}
+	}
+	return ks, nil
+}
+
+// ParsePrivateKey decodes a base64 Ed25519 seed or private key. A private key
+// must end with the public key of its seed, since tokens would otherwise be
+// signed with a key that does not match the one published in the JWKS.
+func ParsePrivateKey(encoded string) (ed25519.PrivateKey, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, utils.ErrInvalidSigningKey
+	}
+	switch len(decoded) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(decoded), nil
+	case ed25519.PrivateKeySize:
+		privateKey := ed25519.NewKeyFromSeed(decoded[:ed25519.SeedSize])
+		if !bytes.Equal(privateKey[ed25519.SeedSize:], decoded[ed25519.SeedSize:]) {
+			return nil, utils.ErrInvalidSigningKey
+		}
+		return privateKey, nil
+	default:
+		return nil, utils.ErrInvalidSigningKey
+	}
+}
+
+// Add appends a key to the set. The first key added becomes the current one.
+func (ks *KeySet) Add(key *Key) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if _, ok := ks.keys[key.ID]; ok {
+		return utils.ErrDuplicateSigningKey
+	}
+	ks.keys[key.ID] = key
+	ks.order = append(ks.order, key.ID)
+	if ks.current == nil {
+		ks.current = key
+	}
+	return nil
+}
+
+// Rotate makes key the current signing key while keeping the previous keys
+// available for verification.
+func (ks *KeySet) Rotate(key *Key) error {
+	if err := ks.Add(key); err != nil && !errors.Is(err, utils.ErrDuplicateSigningKey) {
+		return err
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.current = ks.keys[key.ID]
+	return nil
+}
+
+// Retire removes a key, after which tokens signed with it are rejected. The
+// current signing key cannot be retired.
+func (ks *KeySet) Retire(kid string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if _, ok := ks.keys[kid]; !ok {
+		return utils.ErrUnknownSigningKeyID
+	}
+	if ks.current != nil && ks.current.ID == kid {
+		return fmt.Errorf("cannot retire current signing key %s", kid)
+	}
+	delete(ks.keys, kid)
+	for i, id := range ks.order {
+		if id == kid {
+			ks.order = append(ks.order[:i], ks.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (ks *KeySet) Current() (*Key, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	if ks.current == nil {
+		return nil, utils.ErrNoSigningKey
+	}
+	return ks.current, nil
+}
+
+func (ks *KeySet) PublicKey(kid string) (ed25519.PublicKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, utils.ErrUnknownSigningKeyID
+	}
+	return key.PublicKey(), nil
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every key in the set, current key first.
+func (ks *KeySet) JWKS() JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	jwks := JWKS{Keys: make([]JWK, 0, len(ks.keys))}
+	if ks.current != nil {
+		jwks.Keys = append(jwks.Keys, toJWK(ks.current))
+	}
+	for _, kid := range ks.order {
+		if ks.current != nil && kid == ks.current.ID {
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, toJWK(ks.keys[kid]))
+	}
+	return jwks
+}
+
+func toJWK(key *Key) JWK {
+	return JWK{
+		Kty: "OKP",
+		Crv: "Ed25519",
+		X:   base64.RawURLEncoding.EncodeToString(key.PublicKey()),
+		Kid: key.ID,
+		Use: "sig",
+		Alg: "EdDSA",
+	}
+}
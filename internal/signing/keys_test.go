@@ -0,0 +1,103 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"os"
+	"testing"
+
+	"Drop-Key/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func generateKey(t *testing.T) *Key {
+	t.Helper()
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err, "should generate key")
+	return NewKey(privateKey)
+}
+
+func TestThumbprint(t *testing.T) {
+	// RFC 8037 appendix A.3
+	x, err := base64.RawURLEncoding.DecodeString("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
+	assert.NoError(t, err)
+	assert.Equal(t, "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k", Thumbprint(ed25519.PublicKey(x)), "should match the RFC thumbprint")
+}
+
+func TestKeySetRotation(t *testing.T) {
+	first := generateKey(t)
+	second := generateKey(t)
+	ks := NewKeySet(first)
+
+	current, err := ks.Current()
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, current.ID, "first key should sign")
+
+	assert.NoError(t, ks.Rotate(second), "should rotate to the new key")
+	current, err = ks.Current()
+	assert.NoError(t, err)
+	assert.Equal(t, second.ID, current.ID, "rotated key should sign")
+
+	pub, err := ks.PublicKey(first.ID)
+	assert.NoError(t, err, "previous key should still verify")
+	assert.Equal(t, first.PublicKey(), pub)
+
+	jwks := ks.JWKS()
+	if assert.Len(t, jwks.Keys, 2, "both keys should be published") {
+		assert.Equal(t, second.ID, jwks.Keys[0].Kid, "current key should be listed first")
+		assert.Equal(t, "OKP", jwks.Keys[0].Kty)
+		assert.Equal(t, "Ed25519", jwks.Keys[0].Crv)
+		assert.Equal(t, "EdDSA", jwks.Keys[0].Alg)
+		assert.Equal(t, base64.RawURLEncoding.EncodeToString(second.PublicKey()), jwks.Keys[0].X)
+	}
+
+	assert.Error(t, ks.Retire(second.ID), "should not retire the current key")
+	assert.NoError(t, ks.Retire(first.ID), "should retire the previous key")
+	_, err = ks.PublicKey(first.ID)
+	assert.ErrorIs(t, err, utils.ErrUnknownSigningKeyID, "retired key should no longer verify")
+	assert.Len(t, ks.JWKS().Keys, 1, "retired key should not be published")
+}
+
+func TestLoadKeySet(t *testing.T) {
+	first := generateKey(t)
+	second := generateKey(t)
+
+	t.Run("from environment", func(t *testing.T) {
+		os.Setenv("JWT_SIGNING_KEYS", base64.StdEncoding.EncodeToString(first.PrivateKey.Seed())+", "+base64.StdEncoding.EncodeToString(second.PrivateKey))
+		defer os.Unsetenv("JWT_SIGNING_KEYS")
+
+		ks, err := LoadKeySet()
+		assert.NoError(t, err, "should load keys")
+		current, err := ks.Current()
+		assert.NoError(t, err)
+		assert.Equal(t, first.ID, current.ID, "first configured key should sign")
+		_, err = ks.PublicKey(second.ID)
+		assert.NoError(t, err, "second configured key should verify")
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		os.Setenv("JWT_SIGNING_KEYS", "not-a-key")
+		defer os.Unsetenv("JWT_SIGNING_KEYS")
+
+		_, err := LoadKeySet()
+		assert.ErrorIs(t, err, utils.ErrInvalidSigningKey, "should reject invalid keys")
+	})
+
+	t.Run("mismatched public key", func(t *testing.T) {
+		mismatched := append(first.PrivateKey.Seed(), second.PublicKey()...)
+		os.Setenv("JWT_SIGNING_KEYS", base64.StdEncoding.EncodeToString(mismatched))
+		defer os.Unsetenv("JWT_SIGNING_KEYS")
+
+		_, err := LoadKeySet()
+		assert.ErrorIs(t, err, utils.ErrInvalidSigningKey, "should reject a private key whose public half belongs to another key")
+	})
+
+	t.Run("ephemeral", func(t *testing.T) {
+		os.Unsetenv("JWT_SIGNING_KEYS")
+		ks, err := LoadKeySet()
+		assert.NoError(t, err, "should generate an ephemeral key")
+		_, err = ks.Current()
+		assert.NoError(t, err)
+	})
+}
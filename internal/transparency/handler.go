@@ -0,0 +1,104 @@
+package transparency
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"Drop-Key/internal/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+type LogHandler interface {
+	TreeHead(c echo.Context) error
+	Entries(c echo.Context) error
+	InclusionProof(c echo.Context) error
+	ConsistencyProof(c echo.Context) error
+}
+
+type logHandler struct {
+	service LogService
+}
+
+func NewLogHandler(service LogService) *logHandler {
+	return &logHandler{
+		service: service,
+	}
+}
+
+func (h *logHandler) TreeHead(c echo.Context) error {
+	head, err := h.service.TreeHead(c.Request().Context())
+	if err != nil {
+		return logError(err)
+	}
+	return c.JSON(http.StatusOK, head)
+}
+
+// Entries lists log entries from start, at most limit (default 100, at most
+// 1000) of them.
+func (h *logHandler) Entries(c echo.Context) error {
+	var start int64
+	var limit int
+	err := echo.QueryParamsBinder(c).
+		Int64("start", &start).
+		Int("limit", &limit).
+		BindError()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid start or limit")
+	}
+
+	entries, err := h.service.Entries(c.Request().Context(), start, limit)
+	if err != nil {
+		return logError(err)
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+// InclusionProof proves the leaf_index query parameter against the tree of
+// tree_size entries, by default the current tree.
+func (h *logHandler) InclusionProof(c echo.Context) error {
+	var index, treeSize int64
+	err := echo.QueryParamsBinder(c).
+		MustInt64("leaf_index", &index).
+		Int64("tree_size", &treeSize).
+		BindError()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid leaf_index or tree_size")
+	}
+
+	proof, err := h.service.InclusionProof(c.Request().Context(), index, treeSize)
+	if err != nil {
+		return logError(err)
+	}
+	return c.JSON(http.StatusOK, proof)
+}
+
+// ConsistencyProof proves that the tree of first entries is a prefix of the
+// tree of second entries, by default the current tree.
+func (h *logHandler) ConsistencyProof(c echo.Context) error {
+	var first, second int64
+	err := echo.QueryParamsBinder(c).
+		MustInt64("first", &first).
+		Int64("second", &second).
+		BindError()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid first or second")
+	}
+
+	proof, err := h.service.ConsistencyProof(c.Request().Context(), first, second)
+	if err != nil {
+		return logError(err)
+	}
+	return c.JSON(http.StatusOK, proof)
+}
+
+func logError(err error) error {
+	switch {
+	case errors.Is(err, utils.ErrInvalidLogRange):
+		return echo.NewHTTPError(http.StatusBadRequest, "Index or tree size out of range")
+	default:
+		slog.Error("Error while reading transparency log", "error", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error")
+	}
+}
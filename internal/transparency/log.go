@@ -0,0 +1,283 @@
+// Package transparency keeps an append-only Merkle log of every change to
+// the keys users sign with, so that clients can check that the key a lookup
+// returned is the one everybody else sees. The log follows RFC 6962: tree
+// heads are signed with the session token keys, published in the JWKS, and
+// come with inclusion and consistency proofs.
+package transparency
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/signing"
+	"Drop-Key/internal/utils"
+)
+
+const (
+	leafDomain     = "DropKey log entry v1"
+	treeHeadDomain = "DropKey tree head v1"
+
+	DefaultEntriesLimit = 100
+	maxEntriesLimit     = 1000
+
+	// MaxTreeHeadAge is how long a signed head is served before it is signed
+	// again, so that its timestamp shows monitors the log is still live
+	// while nothing is logged.
+	MaxTreeHeadAge = 5 * time.Minute
+)
+
+// LeafData returns the bytes of the leaf that records entry:
+//
+//	DropKey log entry v1
+//	type:<type>
+//	user:<user id>
+//	device:<device id>       (device entries only)
+//	algorithm:<algorithm>
+//	key:<base64 public key>
+//	time:<unix seconds>
+//
+// joined by "\n" without a trailing newline.
+func LeafData(entry *models.LogEntry) []byte {
+	var b strings.Builder
+	b.WriteString(leafDomain)
+	b.WriteString("\ntype:" + entry.Type)
+	b.WriteString("\nuser:" + entry.UserID)
+	if entry.DeviceID != "" {
+		b.WriteString("\ndevice:" + entry.DeviceID)
+	}
+	b.WriteString("\nalgorithm:" + entry.Algorithm)
+	b.WriteString("\nkey:" + entry.PublicKey)
+	b.WriteString("\ntime:" + strconv.FormatInt(entry.CreatedAt.Unix(), 10))
+	return []byte(b.String())
+}
+
+// SignedTreeHead commits to the log at TreeSize entries. Signature is the
+// Ed25519 signature of TreeHeadStatement by the JWKS key KeyID.
+type SignedTreeHead struct {
+	TreeSize  int64  `json:"tree_size"`
+	Timestamp int64  `json:"timestamp"`
+	RootHash  string `json:"root_hash"`
+	KeyID     string `json:"key_id"`
+	Signature string `json:"signature"`
+}
+
+// TreeHeadStatement is the message a tree head signature covers, with the
+// timestamp in unix milliseconds:
+//
+//	DropKey tree head v1
+//	<tree size>
+//	<timestamp>
+//	<base64 root hash>
+func TreeHeadStatement(treeSize, timestamp int64, rootHash []byte) []byte {
+	return []byte(treeHeadDomain + "\n" + strconv.FormatInt(treeSize, 10) + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + base64.StdEncoding.EncodeToString(rootHash))
+}
+
+// VerifyTreeHead checks the signature of head by publicKey and returns its
+// decoded root hash.
+func VerifyTreeHead(head *SignedTreeHead, publicKey ed25519.PublicKey) ([]byte, error) {
+	root, err := base64.StdEncoding.DecodeString(head.RootHash)
+	if err != nil {
+		return nil, utils.ErrInvalidTreeHead
+	}
+	sig, err := base64.StdEncoding.DecodeString(head.Signature)
+	if err != nil || !ed25519.Verify(publicKey, TreeHeadStatement(head.TreeSize, head.Timestamp, root), sig) {
+		return nil, utils.ErrInvalidTreeHead
+	}
+	return root, nil
+}
+
+type InclusionProof struct {
+	LeafIndex int64    `json:"leaf_index"`
+	TreeSize  int64    `json:"tree_size"`
+	AuditPath []string `json:"audit_path"`
+}
+
+type ConsistencyProof struct {
+	First  int64    `json:"first"`
+	Second int64    `json:"second"`
+	Proof  []string `json:"proof"`
+}
+
+// KeyInclusion proves that the key a user lookup returned was logged: Entry
+// is included in the tree committed to by TreeHead.
+type KeyInclusion struct {
+	Entry *models.LogEntry `json:"entry"`
+	InclusionProof
+	TreeHead *SignedTreeHead `json:"tree_head"`
+}
+
+type LogService interface {
+	TreeHead(ctx context.Context) (*SignedTreeHead, error)
+	Entries(ctx context.Context, start int64, limit int) ([]*models.LogEntry, error)
+	InclusionProof(ctx context.Context, index, treeSize int64) (*InclusionProof, error)
+	ConsistencyProof(ctx context.Context, first, second int64) (*ConsistencyProof, error)
+	ProveKey(ctx context.Context, userID, publicKey string) (*KeyInclusion, error)
+}
+
+type logService struct {
+	repo LogRepository
+	keys *signing.KeySet
+
+	// mu guards the tree, kept in step with the log, and its signed head,
+	// which is only signed again when the log grows, the signing key
+	// rotates or it is older than MaxTreeHeadAge.
+	mu   sync.Mutex
+	tree tree
+	head *SignedTreeHead
+	now  func() time.Time
+}
+
+func NewLogService(repo LogRepository, keys *signing.KeySet) *logService {
+	return &logService{
+		repo: repo,
+		keys: keys,
+		now:  time.Now,
+	}
+}
+
+// update appends the entries logged since the last call to the tree and
+// returns a signed head of the current tree.
+func (l *logService) update(ctx context.Context) (*SignedTreeHead, error) {
+	l.mu.Lock()
+	size := l.tree.size()
+	l.mu.Unlock()
+
+	leaves, err := l.repo.LeafHashes(ctx, int64(size))
+	if err != nil {
+		return nil, err
+	}
+	key, err := l.keys.Current()
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Another request may have added some of the leaves in the meantime.
+	if added := l.tree.size() - size; added < len(leaves) {
+		for _, leaf := range leaves[added:] {
+			l.tree.append(leaf)
+		}
+	}
+	if l.head == nil || l.head.TreeSize != int64(l.tree.size()) || l.head.KeyID != key.ID ||
+		l.now().Sub(time.UnixMilli(l.head.Timestamp)) >= MaxTreeHeadAge {
+		l.head = l.sign(key)
+	}
+	head := *l.head
+	return &head, nil
+}
+
+// sign signs a head of the whole tree with key. The caller holds l.mu.
+func (l *logService) sign(key *signing.Key) *SignedTreeHead {
+	root := l.tree.hash(0, l.tree.size())
+	head := &SignedTreeHead{
+		TreeSize:  int64(l.tree.size()),
+		Timestamp: l.now().UnixMilli(),
+		RootHash:  base64.StdEncoding.EncodeToString(root),
+		KeyID:     key.ID,
+	}
+	head.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key.PrivateKey, TreeHeadStatement(head.TreeSize, head.Timestamp, root)))
+	return head
+}
+
+func (l *logService) TreeHead(ctx context.Context) (*SignedTreeHead, error) {
+	return l.update(ctx)
+}
+
+func (l *logService) Entries(ctx context.Context, start int64, limit int) ([]*models.LogEntry, error) {
+	if limit == 0 {
+		limit = DefaultEntriesLimit
+	}
+	if start < 0 || limit < 1 || limit > maxEntriesLimit {
+		return nil, utils.ErrInvalidLogRange
+	}
+	return l.repo.Entries(ctx, start, limit)
+}
+
+// InclusionProof proves leaf index in the tree of treeSize entries, or in
+// the current tree if treeSize is 0.
+func (l *logService) InclusionProof(ctx context.Context, index, treeSize int64) (*InclusionProof, error) {
+	head, err := l.update(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if treeSize == 0 {
+		treeSize = head.TreeSize
+	}
+	if index < 0 || index >= treeSize || treeSize > head.TreeSize {
+		return nil, utils.ErrInvalidLogRange
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return &InclusionProof{
+		LeafIndex: index,
+		TreeSize:  treeSize,
+		AuditPath: encodeHashes(l.tree.inclusionPath(0, int(treeSize), int(index))),
+	}, nil
+}
+
+// ConsistencyProof proves that the tree of first entries is a prefix of the
+// tree of second entries, or of the current tree if second is 0.
+func (l *logService) ConsistencyProof(ctx context.Context, first, second int64) (*ConsistencyProof, error) {
+	head, err := l.update(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if second == 0 {
+		second = head.TreeSize
+	}
+	if first < 0 || first > second || second > head.TreeSize {
+		return nil, utils.ErrInvalidLogRange
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return &ConsistencyProof{
+		First:  first,
+		Second: second,
+		Proof:  encodeHashes(l.tree.consistencyPath(int(second), int(first))),
+	}, nil
+}
+
+// ProveKey proves that publicKey was logged as the identity key of userID,
+// against a signed head of the current tree.
+func (l *logService) ProveKey(ctx context.Context, userID, publicKey string) (*KeyInclusion, error) {
+	entry, err := l.repo.LatestKeyEntry(ctx, userID, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	head, err := l.update(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if entry.LeafIndex >= head.TreeSize {
+		return nil, utils.ErrInvalidLogRange
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return &KeyInclusion{
+		Entry: entry,
+		InclusionProof: InclusionProof{
+			LeafIndex: entry.LeafIndex,
+			TreeSize:  head.TreeSize,
+			AuditPath: encodeHashes(l.tree.inclusionPath(0, int(head.TreeSize), int(entry.LeafIndex))),
+		},
+		TreeHead: head,
+	}, nil
+}
+
+func encodeHashes(hashes [][]byte) []string {
+	encoded := make([]string, len(hashes))
+	for i, hash := range hashes {
+		encoded[i] = base64.StdEncoding.EncodeToString(hash)
+	}
+	return encoded
+}
@@ -0,0 +1,264 @@
+package transparency
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/signing"
+	"Drop-Key/internal/utils"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type stubLogRepository struct {
+	entries []*models.LogEntry
+	// start is where the last read of leaf hashes started.
+	start int64
+}
+
+func (r *stubLogRepository) append(entry *models.LogEntry) {
+	entry.LeafIndex = int64(len(r.entries))
+	entry.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	entry.LeafHash = base64.StdEncoding.EncodeToString(LeafHash(LeafData(entry)))
+	r.entries = append(r.entries, entry)
+}
+
+func (r *stubLogRepository) LeafHashes(ctx context.Context, start int64) ([][]byte, error) {
+	r.start = start
+	leaves := [][]byte{}
+	for _, entry := range r.entries[min(start, int64(len(r.entries))):] {
+		leaf, _ := base64.StdEncoding.DecodeString(entry.LeafHash)
+		leaves = append(leaves, leaf)
+	}
+	return leaves, nil
+}
+
+func (r *stubLogRepository) Entries(ctx context.Context, start int64, limit int) ([]*models.LogEntry, error) {
+	if start >= int64(len(r.entries)) {
+		return []*models.LogEntry{}, nil
+	}
+	end := min(start+int64(limit), int64(len(r.entries)))
+	return r.entries[start:end], nil
+}
+
+func (r *stubLogRepository) LatestKeyEntry(ctx context.Context, userID, publicKey string) (*models.LogEntry, error) {
+	for i := len(r.entries) - 1; i >= 0; i-- {
+		entry := r.entries[i]
+		if entry.UserID == userID && entry.PublicKey == publicKey && entry.DeviceID == "" {
+			return entry, nil
+		}
+	}
+	return nil, utils.ErrLogEntryNotFound
+}
+
+func setupLogService(t *testing.T) (*logService, *stubLogRepository, *signing.KeySet) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err, "should generate signing key")
+	keys := signing.NewKeySet(signing.NewKey(priv))
+	repo := &stubLogRepository{}
+	return NewLogService(repo, keys), repo, keys
+}
+
+func verifiedRoot(t *testing.T, keys *signing.KeySet, head *SignedTreeHead) []byte {
+	t.Helper()
+	pub, err := keys.PublicKey(head.KeyID)
+	assert.NoError(t, err, "tree head should name a published key")
+	root, err := VerifyTreeHead(head, pub)
+	assert.NoError(t, err, "tree head signature should verify")
+	return root
+}
+
+func decodeHashes(t *testing.T, encoded []string) [][]byte {
+	t.Helper()
+	hashes := make([][]byte, len(encoded))
+	for i, hash := range encoded {
+		var err error
+		hashes[i], err = base64.StdEncoding.DecodeString(hash)
+		assert.NoError(t, err, "hash should be base64")
+	}
+	return hashes
+}
+
+func TestLogService(t *testing.T) {
+	ctx := context.Background()
+	service, repo, keys := setupLogService(t)
+
+	t.Run("empty log", func(t *testing.T) {
+		head, err := service.TreeHead(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), head.TreeSize)
+		assert.Equal(t, RootHash(nil), verifiedRoot(t, keys, head))
+
+		_, err = service.ProveKey(ctx, "user", "key")
+		assert.ErrorIs(t, err, utils.ErrLogEntryNotFound)
+	})
+
+	for i := 0; i < 5; i++ {
+		repo.append(&models.LogEntry{Type: models.LogEntryRegister, UserID: "user-" + strconv.Itoa(i), Algorithm: "ed25519", PublicKey: "key-" + strconv.Itoa(i)})
+	}
+	oldHead, err := service.TreeHead(ctx)
+	assert.NoError(t, err)
+	oldRoot := verifiedRoot(t, keys, oldHead)
+
+	repo.append(&models.LogEntry{Type: models.LogEntryDeviceAdd, UserID: "user-1", DeviceID: "device", Algorithm: "ed25519", PublicKey: "device-key"})
+	repo.append(&models.LogEntry{Type: models.LogEntryRotate, UserID: "user-1", Algorithm: "ed25519", PublicKey: "key-1b"})
+
+	t.Run("tampered tree head", func(t *testing.T) {
+		head, err := service.TreeHead(ctx)
+		assert.NoError(t, err)
+		pub, _ := keys.PublicKey(head.KeyID)
+		head.TreeSize--
+		_, err = VerifyTreeHead(head, pub)
+		assert.ErrorIs(t, err, utils.ErrInvalidTreeHead)
+	})
+
+	t.Run("prove key", func(t *testing.T) {
+		proof, err := service.ProveKey(ctx, "user-1", "key-1b")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(6), proof.LeafIndex, "should prove the rotation")
+		assert.Equal(t, int64(7), proof.TreeHead.TreeSize)
+		root := verifiedRoot(t, keys, proof.TreeHead)
+		leaf := LeafHash(LeafData(proof.Entry))
+		assert.NoError(t, VerifyInclusion(leaf, proof.LeafIndex, proof.TreeSize, decodeHashes(t, proof.AuditPath), root), "inclusion proof should verify against the tree head")
+
+		_, err = service.ProveKey(ctx, "user-1", "device-key")
+		assert.ErrorIs(t, err, utils.ErrLogEntryNotFound, "device keys are not identity keys")
+	})
+
+	t.Run("inclusion proof in an older tree", func(t *testing.T) {
+		proof, err := service.InclusionProof(ctx, 2, oldHead.TreeSize)
+		assert.NoError(t, err)
+		leaf, _ := base64.StdEncoding.DecodeString(repo.entries[2].LeafHash)
+		assert.NoError(t, VerifyInclusion(leaf, 2, proof.TreeSize, decodeHashes(t, proof.AuditPath), oldRoot))
+	})
+
+	t.Run("consistency proof", func(t *testing.T) {
+		head, err := service.TreeHead(ctx)
+		assert.NoError(t, err)
+		root := verifiedRoot(t, keys, head)
+
+		proof, err := service.ConsistencyProof(ctx, oldHead.TreeSize, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, head.TreeSize, proof.Second, "should default to the current tree")
+		assert.NoError(t, VerifyConsistency(proof.First, proof.Second, oldRoot, root, decodeHashes(t, proof.Proof)), "old tree head should be a prefix of the new one")
+	})
+
+	t.Run("out of range", func(t *testing.T) {
+		_, err := service.InclusionProof(ctx, 7, 0)
+		assert.ErrorIs(t, err, utils.ErrInvalidLogRange)
+		_, err = service.InclusionProof(ctx, 0, 8)
+		assert.ErrorIs(t, err, utils.ErrInvalidLogRange)
+		_, err = service.ConsistencyProof(ctx, 5, 4)
+		assert.ErrorIs(t, err, utils.ErrInvalidLogRange)
+		_, err = service.Entries(ctx, -1, 0)
+		assert.ErrorIs(t, err, utils.ErrInvalidLogRange)
+		_, err = service.Entries(ctx, 0, maxEntriesLimit+1)
+		assert.ErrorIs(t, err, utils.ErrInvalidLogRange)
+	})
+}
+
+func TestTreeHeadCache(t *testing.T) {
+	ctx := context.Background()
+	service, repo, keys := setupLogService(t)
+	for i := 0; i < 3; i++ {
+		repo.append(&models.LogEntry{Type: models.LogEntryRegister, UserID: "user-" + strconv.Itoa(i), Algorithm: "ed25519", PublicKey: "key-" + strconv.Itoa(i)})
+	}
+
+	head, err := service.TreeHead(ctx)
+	assert.NoError(t, err)
+	again, err := service.TreeHead(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, head, again, "should not sign a new head while the log has not grown")
+	assert.Equal(t, int64(3), repo.start, "should only read leaves logged since the last head")
+
+	again.TreeSize = 0
+	cached, err := service.TreeHead(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, head, cached, "callers should not change the cached head")
+
+	repo.append(&models.LogEntry{Type: models.LogEntryRotate, UserID: "user-1", Algorithm: "ed25519", PublicKey: "key-1b"})
+	proof, err := service.ProveKey(ctx, "user-1", "key-1b")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), proof.TreeHead.TreeSize, "should sign a new head once the log grows")
+	root := verifiedRoot(t, keys, proof.TreeHead)
+	assert.NoError(t, VerifyInclusion(LeafHash(LeafData(proof.Entry)), proof.LeafIndex, proof.TreeSize, decodeHashes(t, proof.AuditPath), root))
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	next := signing.NewKey(priv)
+	assert.NoError(t, keys.Rotate(next))
+	rotated, err := service.TreeHead(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, next.ID, rotated.KeyID, "should sign the head again with a rotated key")
+	assert.Equal(t, root, verifiedRoot(t, keys, rotated))
+
+	signedAt := time.UnixMilli(rotated.Timestamp)
+	service.now = func() time.Time { return signedAt.Add(MaxTreeHeadAge - time.Second) }
+	again, err = service.TreeHead(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, rotated, again, "should keep serving a recent head")
+	service.now = func() time.Time { return signedAt.Add(MaxTreeHeadAge) }
+	refreshed, err := service.TreeHead(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, signedAt.Add(MaxTreeHeadAge).UnixMilli(), refreshed.Timestamp, "should sign an idle log again once its head is too old")
+	assert.Equal(t, rotated.TreeSize, refreshed.TreeSize)
+	assert.Equal(t, root, verifiedRoot(t, keys, refreshed))
+}
+
+func TestLogHandler(t *testing.T) {
+	service, repo, keys := setupLogService(t)
+	for i := 0; i < 3; i++ {
+		repo.append(&models.LogEntry{Type: models.LogEntryRegister, UserID: "user-" + strconv.Itoa(i), Algorithm: "ed25519", PublicKey: "key-" + strconv.Itoa(i)})
+	}
+
+	e := echo.New()
+	handler := NewLogHandler(service)
+	e.GET("/tree-head", handler.TreeHead)
+	e.GET("/entries", handler.Entries)
+	e.GET("/proof/inclusion", handler.InclusionProof)
+	e.GET("/proof/consistency", handler.ConsistencyProof)
+
+	get := func(target string, v any) int {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code == http.StatusOK && v != nil {
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), v), "response should decode")
+		}
+		return rec.Code
+	}
+
+	var head SignedTreeHead
+	assert.Equal(t, http.StatusOK, get("/tree-head", &head))
+	root := verifiedRoot(t, keys, &head)
+
+	var entries []*models.LogEntry
+	assert.Equal(t, http.StatusOK, get("/entries?start=1&limit=5", &entries))
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, int64(1), entries[0].LeafIndex)
+	}
+
+	var inclusion InclusionProof
+	assert.Equal(t, http.StatusOK, get("/proof/inclusion?leaf_index=1", &inclusion))
+	leaf := LeafHash(LeafData(entries[0]))
+	assert.NoError(t, VerifyInclusion(leaf, inclusion.LeafIndex, inclusion.TreeSize, decodeHashes(t, inclusion.AuditPath), root), "entry from the listing should verify")
+
+	var consistency ConsistencyProof
+	assert.Equal(t, http.StatusOK, get("/proof/consistency?first=1&second=3", &consistency))
+	assert.NoError(t, VerifyConsistency(1, 3, LeafHash(LeafData(repo.entries[0])), root, decodeHashes(t, consistency.Proof)))
+
+	assert.Equal(t, http.StatusBadRequest, get("/proof/inclusion", nil), "leaf_index is required")
+	assert.Equal(t, http.StatusBadRequest, get("/proof/inclusion?leaf_index=3", nil))
+	assert.Equal(t, http.StatusBadRequest, get("/proof/consistency?first=4", nil))
+	assert.Equal(t, http.StatusBadRequest, get("/entries?limit=abc", nil))
+}
@@ -0,0 +1,94 @@
+package transparency
+
+import (
+	"context"
+	"encoding/base64"
+	"sync"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+)
+
+// LogAppender adds entries to a log that is not kept in the database, for
+// repositories that cannot join a database transaction.
+type LogAppender interface {
+	Append(ctx context.Context, entry *models.LogEntry) error
+}
+
+// inMemoryLogRepository keeps the log in memory, for deployments that store
+// nothing on disk. The log starts empty on every start.
+type inMemoryLogRepository struct {
+	mu      sync.RWMutex
+	entries []*models.LogEntry
+	leaves  [][]byte
+}
+
+func NewInMemoryLogRepository() *inMemoryLogRepository {
+	return &inMemoryLogRepository{}
+}
+
+func (r *inMemoryLogRepository) Append(ctx context.Context, entry *models.LogEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seal(entry, int64(len(r.entries)))
+	leaf, err := base64.StdEncoding.DecodeString(entry.LeafHash)
+	if err != nil {
+		return err
+	}
+	stored := *entry
+	r.entries = append(r.entries, &stored)
+	r.leaves = append(r.leaves, leaf)
+	return nil
+}
+
+func (r *inMemoryLogRepository) LeafHashes(ctx context.Context, start int64) ([][]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if start >= int64(len(r.leaves)) {
+		return [][]byte{}, nil
+	}
+	return append([][]byte{}, r.leaves[start:]...), nil
+}
+
+func (r *inMemoryLogRepository) Entries(ctx context.Context, start int64, limit int) ([]*models.LogEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := []*models.LogEntry{}
+	for i := start; i < int64(len(r.entries)) && len(entries) < limit; i++ {
+		entry := *r.entries[i]
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
+
+func (r *inMemoryLogRepository) LatestKeyEntry(ctx context.Context, userID, publicKey string) (*models.LogEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := len(r.entries) - 1; i >= 0; i-- {
+		entry := r.entries[i]
+		if entry.UserID != userID || entry.PublicKey != publicKey {
+			continue
+		}
+		if entry.Type == models.LogEntryRegister || entry.Type == models.LogEntryRotate {
+			found := *entry
+			return &found, nil
+		}
+	}
+	return nil, utils.ErrLogEntryNotFound
+}
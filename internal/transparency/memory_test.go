@@ -0,0 +1,52 @@
+package transparency
+
+import (
+	"context"
+	"testing"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInMemoryLogRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryLogRepository()
+	for _, entry := range []*models.LogEntry{
+		{Type: models.LogEntryRegister, UserID: "log_user_id", Algorithm: "ed25519", PublicKey: "first_key"},
+		{Type: models.LogEntryDeviceAdd, UserID: "log_user_id", DeviceID: "device_id", Algorithm: "ed25519", PublicKey: "device_key"},
+		{Type: models.LogEntryRotate, UserID: "log_user_id", Algorithm: "ed25519", PublicKey: "second_key"},
+	} {
+		assert.NoError(t, repo.Append(ctx, entry), "should append entry")
+	}
+
+	entries, err := repo.Entries(ctx, 0, 10)
+	assert.NoError(t, err, "should list entries")
+	leaves, err := repo.LeafHashes(ctx, 0)
+	assert.NoError(t, err, "should list leaf hashes")
+	if assert.Len(t, entries, 3) && assert.Len(t, leaves, 3) {
+		for i, entry := range entries {
+			assert.Equal(t, int64(i), entry.LeafIndex, "indexes should be sequential")
+			assert.Equal(t, LeafHash(LeafData(entry)), leaves[i], "stored entry should hash to its leaf")
+		}
+	}
+	tail, err := repo.LeafHashes(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, leaves[2:], tail, "should list the leaves from start on")
+
+	page, err := repo.Entries(ctx, 1, 1)
+	assert.NoError(t, err, "should page entries")
+	if assert.Len(t, page, 1) {
+		assert.Equal(t, "device_key", page[0].PublicKey)
+	}
+	page[0].PublicKey = "changed"
+	entries, _ = repo.Entries(ctx, 1, 1)
+	assert.Equal(t, "device_key", entries[0].PublicKey, "returned entries should be copies")
+
+	latest, err := repo.LatestKeyEntry(ctx, "log_user_id", "second_key")
+	assert.NoError(t, err, "should find the rotation")
+	assert.Equal(t, int64(2), latest.LeafIndex)
+	_, err = repo.LatestKeyEntry(ctx, "log_user_id", "device_key")
+	assert.ErrorIs(t, err, utils.ErrLogEntryNotFound, "device keys are not identity keys")
+}
@@ -0,0 +1,235 @@
+package transparency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/bits"
+
+	"Drop-Key/internal/utils"
+)
+
+// The log is a Merkle tree as defined in RFC 6962 section 2.1: leaves and
+// interior nodes are hashed with distinct prefixes so that a leaf can never
+// be passed off as a node.
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// LeafHash returns the hash of a leaf holding data.
+func LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// split returns the largest power of two smaller than n, for n > 1.
+func split(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// tree keeps the hashes of a Merkle tree as it grows, so that roots and
+// proofs for any of its sizes are computed from O(log n) cached hashes
+// instead of rehashing every leaf. Trees are append-only, like the log.
+type tree struct {
+	// levels[h] holds the hashes of the complete subtrees of 2^h leaves in
+	// order, levels[0] being the leaves themselves.
+	levels [][][]byte
+}
+
+func newTree(leaves [][]byte) *tree {
+	t := &tree{}
+	for _, leaf := range leaves {
+		t.append(leaf)
+	}
+	return t
+}
+
+func (t *tree) size() int {
+	if len(t.levels) == 0 {
+		return 0
+	}
+	return len(t.levels[0])
+}
+
+// append adds leaf to the tree and hashes the subtrees it completes.
+func (t *tree) append(leaf []byte) {
+	if len(t.levels) == 0 {
+		t.levels = [][][]byte{nil}
+	}
+	t.levels[0] = append(t.levels[0], leaf)
+	for h := 0; len(t.levels[h])%2 == 0; h++ {
+		if h+1 == len(t.levels) {
+			t.levels = append(t.levels, nil)
+		}
+		n := len(t.levels[h])
+		t.levels[h+1] = append(t.levels[h+1], nodeHash(t.levels[h][n-2], t.levels[h][n-1]))
+	}
+}
+
+// hash returns the hash of the n leaves from leaf start on. Subtrees of a
+// power of two leaves always start at a multiple of their size, so they are
+// found in the cache.
+func (t *tree) hash(start, n int) []byte {
+	switch {
+	case n == 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case n&(n-1) == 0:
+		h := bits.TrailingZeros(uint(n))
+		return t.levels[h][start>>h]
+	}
+	k := split(n)
+	return nodeHash(t.hash(start, k), t.hash(start+k, n-k))
+}
+
+// inclusionPath returns the audit path of leaf index in the subtree of n
+// leaves from leaf start on.
+func (t *tree) inclusionPath(start, n, index int) [][]byte {
+	if n <= 1 {
+		return [][]byte{}
+	}
+	k := split(n)
+	if index < k {
+		return append(t.inclusionPath(start, k, index), t.hash(start+k, n-k))
+	}
+	return append(t.inclusionPath(start+k, n-k, index-k), t.hash(start, k))
+}
+
+func (t *tree) subProof(start, n, m int, complete bool) [][]byte {
+	if m == n {
+		if complete {
+			return [][]byte{}
+		}
+		return [][]byte{t.hash(start, n)}
+	}
+	k := split(n)
+	if m <= k {
+		return append(t.subProof(start, k, m, complete), t.hash(start+k, n-k))
+	}
+	return append(t.subProof(start+k, n-k, m-k, false), t.hash(start, k))
+}
+
+// consistencyPath returns the proof that the tree of the first leaves is a
+// prefix of the tree of the first n leaves.
+func (t *tree) consistencyPath(n, first int) [][]byte {
+	if first == 0 || first == n {
+		return [][]byte{}
+	}
+	return t.subProof(0, n, first, true)
+}
+
+// RootHash returns the Merkle tree hash of the given leaf hashes.
+func RootHash(leaves [][]byte) []byte {
+	return newTree(leaves).hash(0, len(leaves))
+}
+
+// InclusionPath returns the audit path of leaf index in the tree made of
+// leaves, ordered from the leaf up.
+func InclusionPath(leaves [][]byte, index int) [][]byte {
+	return newTree(leaves).inclusionPath(0, len(leaves), index)
+}
+
+// ConsistencyPath returns the proof that the tree of the first leaves is a
+// prefix of the tree made of all leaves.
+func ConsistencyPath(leaves [][]byte, first int) [][]byte {
+	return newTree(leaves).consistencyPath(len(leaves), first)
+}
+
+// VerifyInclusion checks that leafHash is leaf index of the tree of size
+// with the given root, following RFC 9162 section 2.1.3.2.
+func VerifyInclusion(leafHash []byte, index, size int64, proof [][]byte, root []byte) error {
+	if index < 0 || index >= size {
+		return utils.ErrInvalidLogProof
+	}
+	fn, sn := index, size-1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return utils.ErrInvalidLogProof
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || !bytes.Equal(r, root) {
+		return utils.ErrInvalidLogProof
+	}
+	return nil
+}
+
+// VerifyConsistency checks that the tree of size first with firstRoot is a
+// prefix of the tree of size second with secondRoot, following RFC 9162
+// section 2.1.4.2. Every tree is consistent with the empty tree.
+func VerifyConsistency(first, second int64, firstRoot, secondRoot []byte, proof [][]byte) error {
+	switch {
+	case first < 0 || first > second:
+		return utils.ErrInvalidLogProof
+	case first == second:
+		if len(proof) != 0 || !bytes.Equal(firstRoot, secondRoot) {
+			return utils.ErrInvalidLogProof
+		}
+		return nil
+	case first == 0:
+		if len(proof) != 0 {
+			return utils.ErrInvalidLogProof
+		}
+		return nil
+	case len(proof) == 0:
+		return utils.ErrInvalidLogProof
+	}
+
+	// When the first tree is complete its root is the start of the path
+	// rather than part of the proof.
+	if first&(first-1) == 0 {
+		proof = append([][]byte{firstRoot}, proof...)
+	}
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return utils.ErrInvalidLogProof
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || !bytes.Equal(fr, firstRoot) || !bytes.Equal(sr, secondRoot) {
+		return utils.ErrInvalidLogProof
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package transparency
+
+import (
+	"encoding/hex"
+	"strconv"
+	"testing"
+
+	"Drop-Key/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = LeafHash([]byte("leaf " + strconv.Itoa(i)))
+	}
+	return leaves
+}
+
+func TestRootHash(t *testing.T) {
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", hex.EncodeToString(RootHash(nil)), "empty tree should hash to SHA-256 of nothing")
+
+	leaves := testLeaves(3)
+	want := nodeHash(nodeHash(leaves[0], leaves[1]), leaves[2])
+	assert.Equal(t, want, RootHash(leaves), "should split at the largest power of two")
+}
+
+func TestInclusionProof(t *testing.T) {
+	for n := 1; n <= 33; n++ {
+		leaves := testLeaves(n)
+		root := RootHash(leaves)
+		for i := 0; i < n; i++ {
+			path := InclusionPath(leaves, i)
+			assert.NoError(t, VerifyInclusion(leaves[i], int64(i), int64(n), path, root), "leaf %d of %d should verify", i, n)
+
+			if n > 1 {
+				other := (i + 1) % n
+				assert.ErrorIs(t, VerifyInclusion(leaves[other], int64(i), int64(n), path, root), utils.ErrInvalidLogProof, "another leaf should not verify")
+				tampered := append([][]byte{}, path...)
+				tampered[0] = leaves[i]
+				assert.ErrorIs(t, VerifyInclusion(leaves[i], int64(i), int64(n), tampered, root), utils.ErrInvalidLogProof, "a tampered path should not verify")
+			}
+		}
+	}
+	assert.ErrorIs(t, VerifyInclusion(nil, 0, 0, nil, RootHash(nil)), utils.ErrInvalidLogProof, "nothing is in the empty tree")
+}
+
+func TestConsistencyProof(t *testing.T) {
+	for n := 1; n <= 33; n++ {
+		leaves := testLeaves(n)
+		root := RootHash(leaves)
+		for m := 0; m <= n; m++ {
+			oldRoot := RootHash(leaves[:m])
+			proof := ConsistencyPath(leaves, m)
+			assert.NoError(t, VerifyConsistency(int64(m), int64(n), oldRoot, root, proof), "tree of %d should be a prefix of %d", m, n)
+
+			if m > 0 && m < n {
+				forked := append(testLeaves(m-1), LeafHash([]byte("forked")))
+				assert.ErrorIs(t, VerifyConsistency(int64(m), int64(n), RootHash(forked), root, proof), utils.ErrInvalidLogProof, "a rewritten history should not verify")
+				assert.ErrorIs(t, VerifyConsistency(int64(m), int64(n), oldRoot, root, proof[:len(proof)-1]), utils.ErrInvalidLogProof, "a truncated proof should not verify")
+			}
+		}
+	}
+	assert.ErrorIs(t, VerifyConsistency(2, 1, nil, nil, nil), utils.ErrInvalidLogProof, "trees do not shrink")
+}
+
+func TestTreeSizes(t *testing.T) {
+	leaves := testLeaves(33)
+	tree := newTree(leaves)
+	for n := 1; n <= len(leaves); n++ {
+		root := tree.hash(0, n)
+		assert.Equal(t, RootHash(leaves[:n]), root, "grown tree should keep the root of %d leaves", n)
+		for i := 0; i < n; i++ {
+			assert.NoError(t, VerifyInclusion(leaves[i], int64(i), int64(n), tree.inclusionPath(0, n, i), root), "leaf %d of %d should verify", i, n)
+		}
+		for m := 0; m <= n; m++ {
+			assert.NoError(t, VerifyConsistency(int64(m), int64(n), tree.hash(0, m), root, tree.consistencyPath(n, m)), "tree of %d should be a prefix of %d", m, n)
+		}
+	}
+}
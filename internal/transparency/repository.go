@@ -0,0 +1,128 @@
+package transparency
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"time"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+)
+
+type LogRepository interface {
+	// LeafHashes returns the decoded leaf hashes of the entries from leaf
+	// index start on, in order.
+	LeafHashes(ctx context.Context, start int64) ([][]byte, error)
+	Entries(ctx context.Context, start int64, limit int) ([]*models.LogEntry, error)
+	// LatestKeyEntry returns the newest register or rotate entry that made
+	// publicKey the identity key of userID.
+	LatestKeyEntry(ctx context.Context, userID, publicKey string) (*models.LogEntry, error)
+}
+
+type logRepository struct {
+	db *bun.DB
+}
+
+func NewLogRepository(db *bun.DB) *logRepository {
+	return &logRepository{
+		db: db,
+	}
+}
+
+// Append adds entry to the end of the log. It is meant to run in the
+// transaction that makes the change entry records, so the log cannot miss a
+// committed key. Appends are serialised while the next index is taken: MySQL
+// locks the last entry and the gap after it, Postgres, whose row locks leave
+// an empty log open, locks the table against other writers, and SQLite
+// serialises writers on its own.
+func Append(ctx context.Context, db bun.IDB, entry *models.LogEntry) error {
+	var last models.LogEntry
+	q := db.NewSelect().Model(&last).Column("leaf_index").OrderExpr("leaf_index DESC").Limit(1)
+	switch db.Dialect().Name() {
+	case dialect.MySQL:
+		q = q.For("UPDATE")
+	case dialect.PG:
+		if _, err := db.NewRaw("LOCK TABLE ? IN SHARE ROW EXCLUSIVE MODE", bun.Ident("log_entries")).Exec(ctx); err != nil {
+			return err
+		}
+	}
+	err := q.Scan(ctx)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		seal(entry, 0)
+	case err != nil:
+		return err
+	default:
+		seal(entry, last.LeafIndex+1)
+	}
+
+	if _, err := db.NewInsert().Model(entry).Exec(ctx); err != nil {
+		slog.Error("Error while appending log entry", "operation", "append", "userID", entry.UserID, "error", err)
+		return err
+	}
+	return nil
+}
+
+// seal makes entry leaf index of the log and computes its leaf hash.
+func seal(entry *models.LogEntry, index int64) {
+	entry.LeafIndex = index
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	// Stored timestamps have whole seconds, and the leaf has to hash the
+	// same after a round trip through the database.
+	entry.CreatedAt = entry.CreatedAt.UTC().Truncate(time.Second)
+	entry.LeafHash = base64.StdEncoding.EncodeToString(LeafHash(LeafData(entry)))
+}
+
+func (r *logRepository) LeafHashes(ctx context.Context, start int64) ([][]byte, error) {
+	var hashes []string
+	err := r.db.NewSelect().Model((*models.LogEntry)(nil)).Column("leaf_hash").Where("leaf_index >= ?", start).Order("leaf_index").Scan(ctx, &hashes)
+	if err != nil {
+		slog.Error("Error while reading log", "operation", "leaves", "error", err)
+		return nil, err
+	}
+	leaves := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		if leaves[i], err = base64.StdEncoding.DecodeString(hash); err != nil {
+			return nil, err
+		}
+	}
+	return leaves, nil
+}
+
+func (r *logRepository) Entries(ctx context.Context, start int64, limit int) ([]*models.LogEntry, error) {
+	var entries []*models.LogEntry
+	err := r.db.NewSelect().Model(&entries).Where("leaf_index >= ?", start).Order("leaf_index").Limit(limit).Scan(ctx)
+	if err != nil {
+		slog.Error("Error while listing log entries", "operation", "list", "start", start, "error", err)
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (r *logRepository) LatestKeyEntry(ctx context.Context, userID, publicKey string) (*models.LogEntry, error) {
+	var entry models.LogEntry
+	err := r.db.NewSelect().
+		Model(&entry).
+		Where("user_id = ?", userID).
+		Where("public_key = ?", publicKey).
+		Where("type IN (?)", bun.In([]string{models.LogEntryRegister, models.LogEntryRotate})).
+		OrderExpr("leaf_index DESC").
+		Limit(1).
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrLogEntryNotFound
+		}
+		slog.Error("Error while getting log entry", "operation", "get", "userID", userID, "error", err)
+		return nil, err
+	}
+	return &entry, nil
+}
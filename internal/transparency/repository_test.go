@@ -0,0 +1,54 @@
+package transparency
+
+import (
+	"context"
+	"testing"
+
+	"Drop-Key/internal/db"
+	"Drop-Key/internal/db/dbtest"
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogRepository(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		db, err := db.InitDB(ctx)
+		assert.NoError(t, err, "should initialise database")
+		assert.NotNil(t, db, "should return a not-nil database")
+		defer db.Close()
+
+		repo := NewLogRepository(db)
+		for _, entry := range []*models.LogEntry{
+			{Type: models.LogEntryRegister, UserID: "log_user_id", Algorithm: "ed25519", PublicKey: "first_key"},
+			{Type: models.LogEntryDeviceAdd, UserID: "log_user_id", DeviceID: "device_id", Algorithm: "ed25519", PublicKey: "device_key"},
+			{Type: models.LogEntryRotate, UserID: "log_user_id", Algorithm: "ed25519", PublicKey: "second_key"},
+		} {
+			assert.NoError(t, Append(ctx, db, entry), "should append entry")
+		}
+
+		entries, err := repo.Entries(ctx, 0, 10)
+		assert.NoError(t, err, "should list entries")
+		leaves, err := repo.LeafHashes(ctx, 0)
+		assert.NoError(t, err, "should list leaf hashes")
+		if assert.Len(t, entries, 3) && assert.Len(t, leaves, 3) {
+			for i, entry := range entries {
+				assert.Equal(t, int64(i), entry.LeafIndex, "indexes should be sequential")
+				assert.Equal(t, LeafHash(LeafData(entry)), leaves[i], "stored entry should hash to its leaf")
+			}
+		}
+		tail, err := repo.LeafHashes(ctx, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, leaves[2:], tail, "should list the leaves from start on")
+
+		latest, err := repo.LatestKeyEntry(ctx, "log_user_id", "second_key")
+		assert.NoError(t, err, "should find the rotation")
+		assert.Equal(t, int64(2), latest.LeafIndex)
+		_, err = repo.LatestKeyEntry(ctx, "log_user_id", "device_key")
+		assert.ErrorIs(t, err, utils.ErrLogEntryNotFound, "device keys are not identity keys")
+
+		dbtest.Reset(t, db)
+	})
+}
@@ -0,0 +1,40 @@
+package user
+
+import (
+	"encoding/base64"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/verifier"
+)
+
+// normalizeUserKey checks that publicKey is a key of algorithm and returns it
+// in the base64 form keys are stored in. Ed25519 keys may also be given in
+// OpenSSH format.
+func normalizeUserKey(algorithm, publicKey string) (string, error) {
+	if verifier.Normalize(algorithm) == verifier.AlgorithmEd25519 {
+		return normalizePublicKey(publicKey)
+	}
+	pub, err := verifier.Default.DecodePublicKey(algorithm, publicKey)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub), nil
+}
+
+// keyAlgorithm returns the algorithm of publicKey, which must be an active
+// key of user. Device keys are always Ed25519.
+func keyAlgorithm(user *models.User, publicKey string) string {
+	if publicKey == user.PublicKey {
+		return verifier.Normalize(user.Algorithm)
+	}
+	return verifier.AlgorithmEd25519
+}
+
+// SigningAlgorithm returns the algorithm of the key ResolveSigningKey
+// resolved to user and device.
+func SigningAlgorithm(user *models.User, device *models.DeviceKey) string {
+	if device != nil {
+		return verifier.AlgorithmEd25519
+	}
+	return verifier.Normalize(user.Algorithm)
+}
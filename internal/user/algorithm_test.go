@@ -0,0 +1,78 @@
+package user
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+	"Drop-Key/internal/verifier"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newP256Key(t *testing.T) (string, *ecdsa.PrivateKey) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "should generate P-256 key")
+	pub, err := priv.PublicKey.ECDH()
+	assert.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(pub.Bytes()), priv
+}
+
+func p256Sign(t *testing.T, priv *ecdsa.PrivateKey, message []byte) string {
+	t.Helper()
+	digest := sha256.Sum256(message)
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+	assert.NoError(t, err, "should sign")
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func TestP256Keys(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestUserRepository(t)
+	service := NewUserService(repo, NewInMemoryChallengeStore())
+
+	key, priv := newP256Key(t)
+	_, err := service.Create(ctx, &models.User{PublicKey: key})
+	assert.ErrorIs(t, err, utils.ErrInvalidPublicKey, "a P-256 key is not an Ed25519 key")
+	_, err = service.Create(ctx, &models.User{PublicKey: key, Algorithm: "rsa"})
+	assert.ErrorIs(t, err, utils.ErrUnsupportedAlgorithm)
+
+	userID, err := service.Create(ctx, &models.User{PublicKey: key, Algorithm: verifier.AlgorithmECDSAP256})
+	assert.NoError(t, err, "should register a P-256 key")
+	assert.Equal(t, verifier.AlgorithmECDSAP256, repo.users[userID].Algorithm)
+
+	found, err := service.GetByPublicKey(ctx, key)
+	assert.NoError(t, err, "should look up by P-256 key")
+	assert.Equal(t, userID, found.ID)
+	assert.Empty(t, found.X25519PublicKey, "only Ed25519 identities have a derived X25519 key")
+
+	authenticate := func(priv *ecdsa.PrivateKey) error {
+		challenge, _, err := service.IssueChallenge(ctx, userID)
+		assert.NoError(t, err, "should issue challenge")
+		decoded, _ := base64.StdEncoding.DecodeString(challenge)
+		_, err = service.AuthenticateKey(ctx, userID, p256Sign(t, priv, decoded), challenge)
+		return err
+	}
+	assert.NoError(t, authenticate(priv), "should authenticate with the P-256 key")
+	_, other := newP256Key(t)
+	assert.ErrorIs(t, authenticate(other), utils.ErrInvalidSignature, "other keys should be rejected")
+
+	t.Run("rotation keeps the algorithm", func(t *testing.T) {
+		edKey, _ := newIdentityKey(t)
+		_, err := service.RotateKey(ctx, userID, edKey, p256Sign(t, priv, RotationStatement(userID, 2, key, edKey)))
+		assert.ErrorIs(t, err, utils.ErrInvalidPublicKey, "should not rotate to another algorithm")
+
+		newKey, newPriv := newP256Key(t)
+		user, err := service.RotateKey(ctx, userID, newKey, p256Sign(t, priv, RotationStatement(userID, 2, key, newKey)))
+		assert.NoError(t, err, "should rotate to a P-256 key")
+		assert.NoError(t, VerifyKeyHistory(userID, verifier.AlgorithmECDSAP256, user.KeyHistory), "history should verify")
+		assert.NoError(t, authenticate(newPriv), "rotated key should authenticate")
+	})
+}
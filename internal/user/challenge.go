@@ -0,0 +1,138 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"slices"
+	"sync"
+	"time"
+
+	"Drop-Key/internal/utils"
+)
+
+const (
+	challengeSize       = 32
+	DefaultChallengeTTL = 2 * time.Minute
+	// MaxChallengesPerUser is how many challenges a user can have
+	// outstanding at once. Issuing another one evicts the oldest, so
+	// requesting challenges in a loop cannot grow the store without bound.
+	MaxChallengesPerUser = 10
+
+	challengePurgeInterval = time.Minute
+)
+
+// ChallengeStore keeps the authentication challenges issued by the server so
+// that every challenge can be redeemed at most once, by the user it was
+// issued to, before it expires.
+type ChallengeStore interface {
+	Save(ctx context.Context, userID, challenge string, expiresAt time.Time) error
+	Consume(ctx context.Context, userID, challenge string) error
+}
+
+type challengeEntry struct {
+	challenge string
+	expiresAt time.Time
+	consumed  bool
+}
+
+type inMemoryChallengeStore struct {
+	mu sync.Mutex
+	// challenges holds the challenges of every user, oldest first.
+	challenges map[string][]*challengeEntry
+	limit      int
+	purgedAt   time.Time
+	now        func() time.Time
+}
+
+func NewInMemoryChallengeStore() *inMemoryChallengeStore {
+	return &inMemoryChallengeStore{
+		challenges: make(map[string][]*challengeEntry),
+		limit:      MaxChallengesPerUser,
+		now:        time.Now,
+	}
+}
+
+// Save issues challenge to the user, evicting their oldest challenge when
+// they already have the maximum outstanding. Expired challenges of other
+// users are purged at most once per challengePurgeInterval, so that saving
+// does not scan the whole store every time.
+func (s *inMemoryChallengeStore) Save(ctx context.Context, userID, challenge string, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.purgedAt) >= challengePurgeInterval {
+		s.purgeExpired(now)
+		s.purgedAt = now
+	}
+
+	entries := unexpired(s.challenges[userID], now)
+	entries = append(entries, &challengeEntry{challenge: challenge, expiresAt: expiresAt})
+	if len(entries) > s.limit {
+		entries = slices.Delete(entries, 0, len(entries)-s.limit)
+	}
+	s.challenges[userID] = entries
+	return nil
+}
+
+// Consume marks the challenge as used. Consumed entries are kept until they
+// expire so that a replay is reported as such rather than as unknown.
+func (s *inMemoryChallengeStore) Consume(ctx context.Context, userID, challenge string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := s.challenges[userID]
+	i := slices.IndexFunc(entries, func(entry *challengeEntry) bool {
+		return entry.challenge == challenge
+	})
+	if i < 0 {
+		return utils.ErrChallengeNotIssued
+	}
+	entry := entries[i]
+	switch {
+	case entry.consumed:
+		return utils.ErrChallengeConsumed
+	case !s.now().Before(entry.expiresAt):
+		s.put(userID, slices.Delete(entries, i, i+1))
+		return utils.ErrChallengeExpired
+	}
+	entry.consumed = true
+	return nil
+}
+
+func (s *inMemoryChallengeStore) purgeExpired(now time.Time) {
+	for userID, entries := range s.challenges {
+		s.put(userID, unexpired(entries, now))
+	}
+}
+
+// put stores the remaining challenges of the user, dropping the user once
+// none are left.
+func (s *inMemoryChallengeStore) put(userID string, entries []*challengeEntry) {
+	if len(entries) == 0 {
+		delete(s.challenges, userID)
+		return
+	}
+	s.challenges[userID] = entries
+}
+
+func unexpired(entries []*challengeEntry, now time.Time) []*challengeEntry {
+	return slices.DeleteFunc(entries, func(entry *challengeEntry) bool {
+		return !now.Before(entry.expiresAt)
+	})
+}
+
+func newChallenge() (string, error) {
+	buf := make([]byte, challengeSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,80 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"Drop-Key/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInMemoryChallengeStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("consume once", func(t *testing.T) {
+		store := NewInMemoryChallengeStore()
+		err := store.Save(ctx, "user", "challenge", time.Now().Add(time.Minute))
+		assert.NoError(t, err, "should save challenge")
+
+		assert.NoError(t, store.Consume(ctx, "user", "challenge"), "should consume issued challenge")
+		assert.ErrorIs(t, store.Consume(ctx, "user", "challenge"), utils.ErrChallengeConsumed, "should reject second use")
+	})
+
+	t.Run("not issued", func(t *testing.T) {
+		store := NewInMemoryChallengeStore()
+		assert.ErrorIs(t, store.Consume(ctx, "user", "challenge"), utils.ErrChallengeNotIssued, "should reject unknown challenge")
+	})
+
+	t.Run("bound to user", func(t *testing.T) {
+		store := NewInMemoryChallengeStore()
+		err := store.Save(ctx, "user", "challenge", time.Now().Add(time.Minute))
+		assert.NoError(t, err, "should save challenge")
+
+		assert.ErrorIs(t, store.Consume(ctx, "other-user", "challenge"), utils.ErrChallengeNotIssued, "should reject challenge of another user")
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		store := NewInMemoryChallengeStore()
+		now := time.Now()
+		store.now = func() time.Time { return now }
+		err := store.Save(ctx, "user", "challenge", now.Add(time.Minute))
+		assert.NoError(t, err, "should save challenge")
+
+		store.now = func() time.Time { return now.Add(2 * time.Minute) }
+		assert.ErrorIs(t, store.Consume(ctx, "user", "challenge"), utils.ErrChallengeExpired, "should reject expired challenge")
+	})
+
+	t.Run("purges expired entries", func(t *testing.T) {
+		store := NewInMemoryChallengeStore()
+		now := time.Now()
+		store.now = func() time.Time { return now }
+		assert.NoError(t, store.Save(ctx, "user", "old", now.Add(time.Second)))
+
+		store.now = func() time.Time { return now.Add(challengePurgeInterval / 2) }
+		assert.NoError(t, store.Save(ctx, "other-user", "new", now.Add(time.Hour)))
+		assert.Len(t, store.challenges, 2, "should not scan the store on every save")
+
+		store.now = func() time.Time { return now.Add(challengePurgeInterval) }
+		assert.NoError(t, store.Save(ctx, "other-user", "newer", now.Add(time.Hour)))
+		assert.Len(t, store.challenges, 1, "should drop expired challenges of every user periodically")
+		assert.Len(t, store.challenges["other-user"], 2)
+	})
+
+	t.Run("evicts the oldest challenge", func(t *testing.T) {
+		store := NewInMemoryChallengeStore()
+		expiresAt := time.Now().Add(time.Minute)
+		for i := 0; i <= MaxChallengesPerUser; i++ {
+			assert.NoError(t, store.Save(ctx, "user", fmt.Sprintf("challenge-%d", i), expiresAt))
+		}
+		assert.NoError(t, store.Save(ctx, "other-user", "challenge", expiresAt))
+
+		assert.Len(t, store.challenges["user"], MaxChallengesPerUser, "should cap the challenges of a user")
+		assert.ErrorIs(t, store.Consume(ctx, "user", "challenge-0"), utils.ErrChallengeNotIssued, "should evict the oldest challenge")
+		assert.NoError(t, store.Consume(ctx, "user", "challenge-1"), "should keep newer challenges")
+		assert.NoError(t, store.Consume(ctx, "user", fmt.Sprintf("challenge-%d", MaxChallengesPerUser)), "should keep the newest challenge")
+		assert.NoError(t, store.Consume(ctx, "other-user", "challenge"), "should not evict challenges of other users")
+	})
+}
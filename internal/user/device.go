@@ -0,0 +1,82 @@
+package user
+
+import (
+	"context"
+	"errors"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+	"Drop-Key/internal/verifier"
+)
+
+const (
+	DeviceActionAdd    = "add"
+	DeviceActionRevoke = "revoke"
+
+	maxDevices        = 10
+	maxDeviceNameSize = 64
+)
+
+// DeviceStatement is the message an active key of a user signs to add or
+// revoke the device key devicePublicKey. Device keys can never be reused, so
+// neither statement can be replayed once it took effect.
+func DeviceStatement(action, userID, devicePublicKey string) []byte {
+	return []byte("DropKey device v1:" + action + ":" + userID + ":" + devicePublicKey)
+}
+
+// activeKey reports whether publicKey may act for user: either the primary
+// identity key or the key of a device that has not been revoked.
+func activeKey(user *models.User, publicKey string) bool {
+	if publicKey == user.PublicKey {
+		return true
+	}
+	for _, device := range user.Devices {
+		if device.PublicKey == publicKey && device.RevokedAt.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+func activeDevices(user *models.User) int {
+	n := 0
+	for _, device := range user.Devices {
+		if device.RevokedAt.IsZero() {
+			n++
+		}
+	}
+	return n
+}
+
+// ResolveSigningKey finds the user that publicKey signs for. The device is
+// nil when publicKey is the user's primary identity key; keys of revoked
+// devices resolve to ErrDeviceRevoked.
+func ResolveSigningKey(ctx context.Context, repo UserRepository, publicKey string) (*models.User, *models.DeviceKey, error) {
+	user, err := repo.GetByPublicKey(ctx, publicKey)
+	if err == nil {
+		return user, nil, nil
+	}
+	if !errors.Is(err, utils.ErrUserNotFound) {
+		return nil, nil, err
+	}
+
+	device, err := repo.GetDeviceByKey(ctx, publicKey)
+	if errors.Is(err, utils.ErrDeviceNotFound) {
+		return nil, nil, utils.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !device.RevokedAt.IsZero() {
+		return nil, nil, utils.ErrDeviceRevoked
+	}
+	user, err = repo.GetByID(ctx, device.UserID)
+	if err != nil {
+		return nil, nil, utils.ErrUserNotFound
+	}
+	return user, device, nil
+}
+
+func verifySignature(algorithm, publicKey string, message []byte, signature string) error {
+	return verifier.Default.Verify(algorithm, publicKey, message, signature)
+}
@@ -0,0 +1,119 @@
+package user
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDevices(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestUserRepository(t)
+	service := NewUserService(repo, NewInMemoryChallengeStore())
+
+	primaryKey, primaryPriv := newIdentityKey(t)
+	laptopKey, laptopPriv := newIdentityKey(t)
+	phoneKey, phonePriv := newIdentityKey(t)
+	userID, err := service.Create(ctx, &models.User{PublicKey: primaryKey})
+	assert.NoError(t, err, "should create user")
+
+	authorize := func(priv ed25519.PrivateKey, action, devicePublicKey string) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, DeviceStatement(action, userID, devicePublicKey)))
+	}
+	authenticate := func(priv ed25519.PrivateKey) (string, error) {
+		challenge, _, err := service.IssueChallenge(ctx, userID)
+		assert.NoError(t, err, "should issue challenge")
+		decoded, _ := base64.StdEncoding.DecodeString(challenge)
+		return service.AuthenticateKey(ctx, userID, base64.StdEncoding.EncodeToString(ed25519.Sign(priv, decoded)), challenge)
+	}
+
+	var laptop, phone *models.DeviceKey
+
+	t.Run("primary key adds a device", func(t *testing.T) {
+		laptop, err = service.AddDevice(ctx, userID, "laptop", laptopKey, primaryKey, authorize(primaryPriv, DeviceActionAdd, laptopKey))
+		assert.NoError(t, err, "should add device")
+		assert.Equal(t, primaryKey, laptop.AuthorizedBy)
+
+		signedBy, err := authenticate(laptopPriv)
+		assert.NoError(t, err, "device key should authenticate")
+		assert.Equal(t, laptopKey, signedBy, "session should be bound to the device key")
+
+		signedBy, err = authenticate(primaryPriv)
+		assert.NoError(t, err, "primary key should still authenticate")
+		assert.Equal(t, primaryKey, signedBy)
+	})
+
+	t.Run("a device adds another device", func(t *testing.T) {
+		phone, err = service.AddDevice(ctx, userID, "phone", phoneKey, laptopKey, authorize(laptopPriv, DeviceActionAdd, phoneKey))
+		assert.NoError(t, err, "active device should authorize new devices")
+
+		owner, device, err := ResolveSigningKey(ctx, repo, phoneKey)
+		assert.NoError(t, err)
+		assert.Equal(t, userID, owner.ID, "device key should resolve to its user")
+		assert.Equal(t, phone.ID, device.ID)
+	})
+
+	t.Run("rejected additions", func(t *testing.T) {
+		otherKey, otherPriv := newIdentityKey(t)
+		_, err := service.AddDevice(ctx, userID, "tablet", otherKey, otherKey, authorize(otherPriv, DeviceActionAdd, otherKey))
+		assert.ErrorIs(t, err, utils.ErrInvalidSignature, "a key cannot authorize itself")
+
+		_, err = service.AddDevice(ctx, userID, "tablet", otherKey, primaryKey, authorize(primaryPriv, DeviceActionRevoke, otherKey))
+		assert.ErrorIs(t, err, utils.ErrInvalidSignature, "revocation statement should not add a device")
+
+		_, err = service.AddDevice(ctx, userID, "", otherKey, primaryKey, authorize(primaryPriv, DeviceActionAdd, otherKey))
+		assert.ErrorIs(t, err, utils.ErrInvalidDeviceName)
+
+		_, err = service.AddDevice(ctx, userID, "again", phoneKey, primaryKey, authorize(primaryPriv, DeviceActionAdd, phoneKey))
+		assert.ErrorIs(t, err, utils.ErrDuplicatePublicKey, "keys cannot be added twice")
+
+		_, err = service.Create(ctx, &models.User{PublicKey: phoneKey})
+		assert.ErrorIs(t, err, utils.ErrDuplicatePublicKey, "device keys cannot register as users")
+	})
+
+	t.Run("a device revokes itself", func(t *testing.T) {
+		err := service.RevokeDevice(ctx, userID, phone.ID, phoneKey, authorize(phonePriv, DeviceActionRevoke, phoneKey))
+		assert.NoError(t, err, "should revoke device")
+
+		_, err = authenticate(phonePriv)
+		assert.ErrorIs(t, err, utils.ErrInvalidSignature, "revoked device should not authenticate")
+
+		_, _, err = ResolveSigningKey(ctx, repo, phoneKey)
+		assert.ErrorIs(t, err, utils.ErrDeviceRevoked)
+
+		err = service.RevokeDevice(ctx, userID, phone.ID, phoneKey, authorize(phonePriv, DeviceActionRevoke, phoneKey))
+		assert.ErrorIs(t, err, utils.ErrDeviceRevoked, "revocation should not be replayed")
+
+		otherKey, _ := newIdentityKey(t)
+		_, err = service.AddDevice(ctx, userID, "tablet", otherKey, phoneKey, authorize(phonePriv, DeviceActionAdd, otherKey))
+		assert.ErrorIs(t, err, utils.ErrInvalidSignature, "revoked device should not authorize new devices")
+	})
+
+	t.Run("revoking needs an active key", func(t *testing.T) {
+		err := service.RevokeDevice(ctx, userID, laptop.ID, laptopKey, authorize(primaryPriv, DeviceActionRevoke, laptopKey))
+		assert.ErrorIs(t, err, utils.ErrInvalidSignature, "signature must match the authorizing key")
+
+		err = service.RevokeDevice(ctx, userID, "unknown-device", primaryKey, authorize(primaryPriv, DeviceActionRevoke, laptopKey))
+		assert.ErrorIs(t, err, utils.ErrDeviceNotFound)
+	})
+
+	t.Run("device limit", func(t *testing.T) {
+		user, err := service.GetByID(ctx, userID)
+		assert.NoError(t, err)
+		for i := activeDevices(user); i < maxDevices; i++ {
+			key, _ := newIdentityKey(t)
+			_, err := service.AddDevice(ctx, userID, fmt.Sprintf("device %d", i), key, primaryKey, authorize(primaryPriv, DeviceActionAdd, key))
+			assert.NoError(t, err)
+		}
+		key, _ := newIdentityKey(t)
+		_, err = service.AddDevice(ctx, userID, "one too many", key, primaryKey, authorize(primaryPriv, DeviceActionAdd, key))
+		assert.ErrorIs(t, err, utils.ErrTooManyDevices)
+	})
+}
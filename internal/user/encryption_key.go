@@ -0,0 +1,48 @@
+package user
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+	"Drop-Key/internal/verifier"
+)
+
+const (
+	x25519KeySize     = 32
+	maxEncryptionKeys = 8
+)
+
+// EncryptionKeyStatement is the message a user signs with their identity key
+// to publish an X25519 encryption key. It names the user so a binding cannot
+// be copied to another account.
+func EncryptionKeyStatement(userID, publicKey string) []byte {
+	return []byte("DropKey encryption key v1:" + userID + ":" + publicKey)
+}
+
+// VerifyEncryptionKey checks that key was signed by the identity key it names
+// in SignedBy, a key of algorithm. Clients should also check that SignedBy is
+// the identity key they expect before wrapping anything to the key.
+func VerifyEncryptionKey(key *models.EncryptionKey, algorithm string) error {
+	if _, err := verifier.Default.DecodePublicKey(algorithm, key.SignedBy); err != nil {
+		return err
+	}
+	if _, err := decodeX25519Key(key.PublicKey); err != nil {
+		return err
+	}
+	return verifier.Default.Verify(algorithm, key.SignedBy, EncryptionKeyStatement(key.UserID, key.PublicKey), key.Signature)
+}
+
+// decodeX25519Key rejects keys that are not 32 bytes and the all-zero key,
+// which would make every shared secret zero.
+func decodeX25519Key(publicKey string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != x25519KeySize {
+		return nil, utils.ErrInvalidEncryptionKey
+	}
+	if subtle.ConstantTimeCompare(key, make([]byte, x25519KeySize)) == 1 {
+		return nil, utils.ErrInvalidEncryptionKey
+	}
+	return key, nil
+}
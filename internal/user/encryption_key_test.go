@@ -0,0 +1,96 @@
+package user
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+	"Drop-Key/internal/verifier"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func newX25519Key(t *testing.T) string {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	assert.NoError(t, err, "should generate x25519 key")
+	return base64.StdEncoding.EncodeToString(key.PublicKey().Bytes())
+}
+
+func TestAddEncryptionKey(t *testing.T) {
+	ctx := context.Background()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err, "should generate ed25519 key")
+	otherPub, otherPriv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err, "should generate ed25519 key")
+	user := &models.User{ID: uuid.NewString(), PublicKey: base64.StdEncoding.EncodeToString(pub)}
+	other := &models.User{ID: uuid.NewString(), PublicKey: base64.StdEncoding.EncodeToString(otherPub)}
+	repo := newTestUserRepository(t, user, other)
+	service := NewUserService(repo, NewInMemoryChallengeStore())
+
+	signWith := func(key ed25519.PrivateKey, userID, publicKey string) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(key, EncryptionKeyStatement(userID, publicKey)))
+	}
+	sign := func(userID, publicKey string) string {
+		return signWith(priv, userID, publicKey)
+	}
+
+	t.Run("valid binding", func(t *testing.T) {
+		publicKey := newX25519Key(t)
+		key, err := service.AddEncryptionKey(ctx, user.ID, publicKey, sign(user.ID, publicKey))
+		assert.NoError(t, err, "should add encryption key")
+		assert.Equal(t, publicKey, key.PublicKey)
+		assert.Equal(t, user.PublicKey, key.SignedBy, "should record the signing identity key")
+		assert.NoError(t, VerifyEncryptionKey(key, verifier.AlgorithmEd25519), "clients should be able to verify the binding")
+
+		fetched, err := service.GetByID(ctx, user.ID)
+		assert.NoError(t, err)
+		assert.Len(t, fetched.EncryptionKeys, 1, "should be returned with the user")
+	})
+
+	t.Run("signature for another user", func(t *testing.T) {
+		publicKey := newX25519Key(t)
+		_, err := service.AddEncryptionKey(ctx, other.ID, publicKey, signWith(otherPriv, user.ID, publicKey))
+		assert.ErrorIs(t, err, utils.ErrInvalidSignature, "binding should not move between accounts")
+	})
+
+	t.Run("signature over another key", func(t *testing.T) {
+		_, err := service.AddEncryptionKey(ctx, user.ID, newX25519Key(t), sign(user.ID, newX25519Key(t)))
+		assert.ErrorIs(t, err, utils.ErrInvalidSignature)
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		zero := base64.StdEncoding.EncodeToString(make([]byte, 32))
+		for _, publicKey := range []string{"", "not base64", base64.StdEncoding.EncodeToString([]byte("short")), zero} {
+			_, err := service.AddEncryptionKey(ctx, user.ID, publicKey, sign(user.ID, publicKey))
+			assert.ErrorIs(t, err, utils.ErrInvalidEncryptionKey, publicKey)
+		}
+	})
+
+	t.Run("duplicate key", func(t *testing.T) {
+		fetched, err := service.GetByID(ctx, user.ID)
+		assert.NoError(t, err)
+		publicKey := fetched.EncryptionKeys[0].PublicKey
+		_, err = service.AddEncryptionKey(ctx, user.ID, publicKey, sign(user.ID, publicKey))
+		assert.ErrorIs(t, err, utils.ErrDuplicateEncryptionKey)
+	})
+
+	t.Run("too many keys", func(t *testing.T) {
+		fetched, err := service.GetByID(ctx, user.ID)
+		assert.NoError(t, err)
+		for i := len(fetched.EncryptionKeys); i < maxEncryptionKeys; i++ {
+			publicKey := newX25519Key(t)
+			_, err := service.AddEncryptionKey(ctx, user.ID, publicKey, sign(user.ID, publicKey))
+			assert.NoError(t, err)
+		}
+		publicKey := newX25519Key(t)
+		_, err = service.AddEncryptionKey(ctx, user.ID, publicKey, sign(user.ID, publicKey))
+		assert.ErrorIs(t, err, utils.ErrTooManyEncryptionKeys)
+	})
+}
@@ -0,0 +1,115 @@
+package user
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"strconv"
+	"strings"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+	"Drop-Key/internal/verifier"
+)
+
+const (
+	fingerprintDomain  = "DropKey fingerprint v1"
+	safetyNumberDomain = "DropKey safety number v1"
+
+	// A fingerprint is six groups of five digits, read from the first 30
+	// bytes of a SHA-256 digest.
+	fingerprintGroups      = 6
+	fingerprintGroupDigits = 5
+	fingerprintDigits      = fingerprintGroups * fingerprintGroupDigits
+)
+
+// Fingerprint returns the canonical fingerprint of a base64 public key of
+// algorithm, for people to compare keys by eye or read out loud:
+//
+//	SHA-256("DropKey fingerprint v1" || 0x00 || algorithm || 0x00 || key)
+//
+// rendered as six space separated groups of five digits. Each group is a
+// 5-byte chunk of the digest read as a big-endian integer modulo 100000.
+func Fingerprint(algorithm, publicKey string) string {
+	return digitGroups(digest(fingerprintDomain, verifier.Normalize(algorithm), decodeKey(publicKey)))
+}
+
+// ParseFingerprint accepts a fingerprint with any or no separators between
+// the digits and returns it in canonical form.
+func ParseFingerprint(fingerprint string) (string, error) {
+	digits := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', ':', '.':
+			return -1
+		}
+		return r
+	}, fingerprint)
+	if len(digits) != fingerprintDigits {
+		return "", utils.ErrInvalidFingerprint
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return "", utils.ErrInvalidFingerprint
+		}
+	}
+	groups := make([]string, 0, fingerprintGroups)
+	for i := 0; i < len(digits); i += fingerprintGroupDigits {
+		groups = append(groups, digits[i:i+fingerprintGroupDigits])
+	}
+	return strings.Join(groups, " "), nil
+}
+
+// SafetyNumber returns the number two users compare out of band to confirm
+// they see each other's current identity keys. Each half is derived like a
+// fingerprint but also covers the user ID, so a key cannot be vouched for
+// under another account. The halves are sorted, so both users get the same
+// twelve groups.
+func SafetyNumber(a, b *models.User) string {
+	first := digitGroups(digest(safetyNumberDomain, a.ID, verifier.Normalize(a.Algorithm), decodeKey(a.PublicKey)))
+	second := digitGroups(digest(safetyNumberDomain, b.ID, verifier.Normalize(b.Algorithm), decodeKey(b.PublicKey)))
+	if second < first {
+		first, second = second, first
+	}
+	return first + " " + second
+}
+
+// withFingerprint fills in the fingerprint of user's current key for API
+// responses.
+func withFingerprint(user *models.User) *models.User {
+	user.Fingerprint = Fingerprint(user.Algorithm, user.PublicKey)
+	return user
+}
+
+func decodeKey(publicKey string) []byte {
+	if key, err := base64.StdEncoding.DecodeString(publicKey); err == nil {
+		return key
+	}
+	return []byte(publicKey)
+}
+
+func digest(domain string, fields ...any) []byte {
+	h := sha256.New()
+	h.Write([]byte(domain))
+	for _, field := range fields {
+		h.Write([]byte{0})
+		switch field := field.(type) {
+		case string:
+			h.Write([]byte(field))
+		case []byte:
+			h.Write(field)
+		}
+	}
+	return h.Sum(nil)
+}
+
+func digitGroups(digest []byte) string {
+	groups := make([]string, 0, fingerprintGroups)
+	chunk := make([]byte, 8)
+	for i := 0; i < fingerprintGroups; i++ {
+		copy(chunk[3:], digest[i*5:i*5+5])
+		n := binary.BigEndian.Uint64(chunk) % 100000
+		group := strconv.FormatUint(n, 10)
+		groups = append(groups, strings.Repeat("0", fingerprintGroupDigits-len(group))+group)
+	}
+	return strings.Join(groups, " ")
+}
@@ -0,0 +1,108 @@
+package user
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+	"Drop-Key/internal/verifier"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprint(t *testing.T) {
+	key, _ := newIdentityKey(t)
+	fingerprint := Fingerprint(verifier.AlgorithmEd25519, key)
+	assert.Regexp(t, regexp.MustCompile(`^\d{5}( \d{5}){5}$`), fingerprint, "should be six groups of five digits")
+	assert.Equal(t, fingerprint, Fingerprint("", key), "legacy records are Ed25519")
+	assert.NotEqual(t, fingerprint, Fingerprint(verifier.AlgorithmECDSAP256, key), "the algorithm should be covered")
+
+	other, _ := newIdentityKey(t)
+	assert.NotEqual(t, fingerprint, Fingerprint(verifier.AlgorithmEd25519, other))
+
+	for _, input := range []string{
+		fingerprint,
+		strings.ReplaceAll(fingerprint, " ", ""),
+		strings.ReplaceAll(fingerprint, " ", "-"),
+	} {
+		parsed, err := ParseFingerprint(input)
+		assert.NoError(t, err, "should parse %q", input)
+		assert.Equal(t, fingerprint, parsed)
+	}
+	for _, input := range []string{"", "12345", fingerprint + "1", strings.Replace(fingerprint, fingerprint[:1], "a", 1)} {
+		_, err := ParseFingerprint(input)
+		assert.ErrorIs(t, err, utils.ErrInvalidFingerprint, "should reject %q", input)
+	}
+}
+
+func TestSafetyNumber(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestUserRepository(t)
+	service := NewUserService(repo, NewInMemoryChallengeStore())
+
+	aliceKey, alicePriv := newIdentityKey(t)
+	aliceID, err := service.Create(ctx, &models.User{PublicKey: aliceKey})
+	assert.NoError(t, err)
+	bobKey, _ := newP256Key(t)
+	bobID, err := service.Create(ctx, &models.User{PublicKey: bobKey, Algorithm: verifier.AlgorithmECDSAP256})
+	assert.NoError(t, err)
+
+	e := echo.New()
+	handler := NewUserHandler(service, nil, nil)
+	e.GET("/api/users", handler.GetByPublicKeyHandler)
+	e.GET("/api/users/:id/safety-number", handler.SafetyNumberHandler)
+	get := func(path string, out any) int {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), out))
+		}
+		return rec.Code
+	}
+
+	t.Run("lookup by fingerprint", func(t *testing.T) {
+		var found models.User
+		fingerprint := Fingerprint(verifier.AlgorithmEd25519, aliceKey)
+		assert.Equal(t, http.StatusOK, get("/api/users?fingerprint="+url.QueryEscape(fingerprint), &found))
+		assert.Equal(t, aliceID, found.ID)
+		assert.Equal(t, fingerprint, found.Fingerprint, "responses should carry the fingerprint")
+
+		assert.Equal(t, http.StatusOK, get("/api/users?fingerprint="+strings.ReplaceAll(fingerprint, " ", "-"), &found))
+		assert.Equal(t, http.StatusBadRequest, get("/api/users?fingerprint=123", &found))
+		assert.Equal(t, http.StatusNotFound, get("/api/users?fingerprint="+strings.Repeat("0", 30), &found))
+	})
+
+	var forAlice, forBob SafetyNumberResponse
+	assert.Equal(t, http.StatusOK, get("/api/users/"+aliceID+"/safety-number?with="+bobID, &forAlice))
+	assert.Equal(t, http.StatusOK, get("/api/users/"+bobID+"/safety-number?with="+aliceID, &forBob))
+	assert.Regexp(t, regexp.MustCompile(`^\d{5}( \d{5}){11}$`), forAlice.SafetyNumber, "should be twelve groups of five digits")
+	assert.Equal(t, forAlice.SafetyNumber, forBob.SafetyNumber, "both users should see the same number")
+	assert.Equal(t, Fingerprint(verifier.AlgorithmECDSAP256, bobKey), forAlice.Fingerprints[bobID])
+	assert.Equal(t, http.StatusBadRequest, get("/api/users/"+aliceID+"/safety-number?with="+aliceID, &forAlice))
+
+	t.Run("rotation changes both", func(t *testing.T) {
+		newKey, _ := newIdentityKey(t)
+		_, err := service.RotateKey(ctx, aliceID, newKey, base64.StdEncoding.EncodeToString(ed25519.Sign(alicePriv, RotationStatement(aliceID, 2, aliceKey, newKey))))
+		assert.NoError(t, err, "should rotate key")
+
+		found, err := service.GetByFingerprint(ctx, Fingerprint(verifier.AlgorithmEd25519, newKey))
+		assert.NoError(t, err, "should find the user by their new fingerprint")
+		assert.Equal(t, aliceID, found.ID)
+		_, err = service.GetByFingerprint(ctx, Fingerprint(verifier.AlgorithmEd25519, aliceKey))
+		assert.ErrorIs(t, err, utils.ErrUserNotFound, "the retired fingerprint should not resolve")
+
+		var rotated SafetyNumberResponse
+		assert.Equal(t, http.StatusOK, get("/api/users/"+aliceID+"/safety-number?with="+bobID, &rotated))
+		assert.NotEqual(t, forAlice.SafetyNumber, rotated.SafetyNumber, "a new key should change the safety number")
+	})
+}
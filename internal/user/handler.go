@@ -3,44 +3,135 @@ package user
 import (
 	"encoding/base64"
 	"errors"
-	"fmt"
 	"log/slog"
 	"net/http"
-	"os"
+	"time"
 
-	"Drop-Key/internal/middleware"
+	custom_middleware "Drop-Key/internal/middleware"
 	"Drop-Key/internal/models"
+	"Drop-Key/internal/transparency"
 	"Drop-Key/internal/utils"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 )
 
 type UserHandler interface {
 	RegisterHandler(c echo.Context) error
+	ChallengeHandler(c echo.Context) error
 	AuthenticateHandler(c echo.Context) error
+	RefreshHandler(c echo.Context) error
+	LogoutHandler(c echo.Context) error
 	GetByIDHandler(c echo.Context) error
 	GetByPublicKeyHandler(c echo.Context) error
+	SafetyNumberHandler(c echo.Context) error
+	AddEncryptionKeyHandler(c echo.Context) error
+	RotateKeyHandler(c echo.Context) error
+	AddDeviceHandler(c echo.Context) error
+	RevokeDeviceHandler(c echo.Context) error
 }
 
 type userHandler struct {
 	service UserService
+	tokens  TokenService
+	keyLog  transparency.LogService
 }
 
-func NewUserHandler(service UserService) *userHandler {
+// NewUserHandler creates the user handler. User responses carry a proof from
+// keyLog that their key is in the transparency log; keyLog may be nil.
+func NewUserHandler(service UserService, tokens TokenService, keyLog transparency.LogService) *userHandler {
 	return &userHandler{
 		service: service,
+		tokens:  tokens,
+		keyLog:  keyLog,
 	}
 }
 
+// userResponse is a user together with the proof that their current key
+// was logged, omitted for users registered before the log was kept.
+type userResponse struct {
+	*models.User
+	Transparency *transparency.KeyInclusion `json:"transparency,omitempty"`
+}
+
+// respondWithProof writes user with the proof that their current key was
+// logged. Failing to prove it for any other reason than the key predating
+// the log is an error, since a response without the proof could not be told
+// apart from a key that was never logged.
+func (h *userHandler) respondWithProof(c echo.Context, user *models.User) error {
+	response := &userResponse{User: user}
+	if h.keyLog != nil {
+		proof, err := h.keyLog.ProveKey(c.Request().Context(), user.ID, user.PublicKey)
+		switch {
+		case err == nil:
+			response.Transparency = proof
+		case !errors.Is(err, utils.ErrLogEntryNotFound):
+			slog.Error("Error while proving key", "userID", user.ID, "error", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Cannot prove the key is in the transparency log")
+		}
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
 type pub struct {
 	PublicKey string `json:"public_key"`
+	Algorithm string `json:"algorithm"`
 }
 
 type ID struct {
 	Id string `json:"id"`
 }
 
+type ChallengeResponse struct {
+	Challenge string    `json:"challenge"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type TokenResponse struct {
+	Message          string    `json:"message"`
+	Token            string    `json:"token"`
+	TokenType        string    `json:"token_type"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+func newTokenResponse(message string, pair *TokenPair) *TokenResponse {
+	return &TokenResponse{
+		Message:          message,
+		Token:            pair.AccessToken,
+		TokenType:        "Bearer",
+		ExpiresAt:        pair.AccessExpiresAt,
+		RefreshToken:     pair.RefreshToken,
+		RefreshExpiresAt: pair.RefreshExpiresAt,
+	}
+}
+
+type EncryptionKeyRequest struct {
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+type RotateKeyRequest struct {
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+type DeviceRequest struct {
+	Name         string `json:"name"`
+	PublicKey    string `json:"public_key"`
+	AuthorizedBy string `json:"authorized_by"`
+	Signature    string `json:"signature"`
+}
+
+type RevokeDeviceRequest struct {
+	AuthorizedBy string `json:"authorized_by"`
+	Signature    string `json:"signature"`
+}
+
 type AuthRequest struct {
 	ID        string `json:"id"`
 	Signature string `json:"signature"`
@@ -56,6 +147,7 @@ func (h *userHandler) RegisterHandler(c echo.Context) error {
 
 	user := &models.User{
 		PublicKey: pub.PublicKey,
+		Algorithm: pub.Algorithm,
 	}
 	id, err := h.service.Create(c.Request().Context(), user)
 	switch {
@@ -72,6 +164,9 @@ func (h *userHandler) RegisterHandler(c echo.Context) error {
 	case errors.Is(err, utils.ErrInvalidPublicKey):
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid public key")
 
+	case errors.Is(err, utils.ErrUnsupportedAlgorithm):
+		return echo.NewHTTPError(http.StatusBadRequest, "Unsupported algorithm")
+
 	case errors.Is(err, utils.ErrUserCreationFailed):
 		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error.")
 
@@ -85,44 +180,55 @@ func (h *userHandler) RegisterHandler(c echo.Context) error {
 	}
 }
 
+func (h *userHandler) ChallengeHandler(c echo.Context) error {
+	req := &ID{}
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid JSON payload")
+	}
+
+	challenge, expiresAt, err := h.service.IssueChallenge(c.Request().Context(), req.Id)
+
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusCreated, &ChallengeResponse{
+			Challenge: challenge,
+			ExpiresAt: expiresAt,
+		})
+	case errors.Is(err, utils.ErrEmptyUserID):
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing user ID")
+	case errors.Is(err, utils.ErrInvalidUserID):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	case errors.Is(err, utils.ErrUserNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "User not found")
+
+	default:
+		slog.Error("Error while issuing challenge", "userID", req.Id, "error", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error")
+	}
+}
+
 func (h *userHandler) AuthenticateHandler(c echo.Context) error {
 	req := &AuthRequest{}
 	if err := c.Bind(req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid JSON payload")
 	}
 
-	ok, err := h.service.Authenticate(c.Request().Context(), req.ID, req.Signature, req.Challenge)
+	signedBy, err := h.service.AuthenticateKey(c.Request().Context(), req.ID, req.Signature, req.Challenge)
 
 	switch {
-	case err == nil && ok:
-		jwtSecret := os.Getenv("JWTSECRET")
-		if jwtSecret == "" {
-			slog.Error("No JWTSECRET found", "JWTSECRET", jwtSecret)
-		}
-
+	case err == nil:
 		user, err := h.service.GetByID(c.Request().Context(), req.ID)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusUnauthorized, "User not found")
 		}
 
-		fmt.Println("creating claims	")
-		fmt.Println("pub key", user.PublicKey)
-		fmt.Println("userid", user.ID)
-		claims := &custom_middleware.JwtCustomClaims{
-			UserInfo: custom_middleware.UserInfo{
-				UserID:    user.ID,
-				Publickey: user.PublicKey,
-			},
-		}
-
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-		t, err := token.SignedString([]byte(jwtSecret))
+		pair, err := h.tokens.Issue(c.Request().Context(), sessionUser(user, signedBy))
 		if err != nil {
+			slog.Error("Error while issuing tokens", "userID", user.ID, "error", err)
 			return c.String(http.StatusInternalServerError, "Failed to generate token")
 		}
 
-		return c.JSON(http.StatusOK, map[string]string{"message": "Authentication successful", "token": t})
+		return c.JSON(http.StatusOK, newTokenResponse("Authentication successful", pair))
 
 	case errors.Is(err, utils.ErrEmptyUserID):
 		return echo.NewHTTPError(http.StatusBadRequest, "Missing user ID")
@@ -136,30 +242,95 @@ func (h *userHandler) AuthenticateHandler(c echo.Context) error {
 	case errors.Is(err, utils.ErrInvalidSignature):
 		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid signature")
 
+	case errors.Is(err, utils.ErrChallengeNotIssued):
+		return echo.NewHTTPError(http.StatusUnauthorized, "Challenge was not issued by the server")
+
+	case errors.Is(err, utils.ErrChallengeExpired):
+		return echo.NewHTTPError(http.StatusUnauthorized, "Challenge expired")
+
+	case errors.Is(err, utils.ErrChallengeConsumed):
+		return echo.NewHTTPError(http.StatusUnauthorized, "Challenge already used")
+
 	case errors.Is(err, utils.ErrUserNotFound):
 		return echo.NewHTTPError(http.StatusNotFound, "User not found")
 
 	case errors.Is(err, utils.ErrInvalidPublicKey):
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid public key")
+	}
+
+	return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error")
+}
+
+func (h *userHandler) RefreshHandler(c echo.Context) error {
+	req := &RefreshRequest{}
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid JSON payload")
+	}
+
+	pair, err := h.tokens.Refresh(c.Request().Context(), req.RefreshToken)
+
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, newTokenResponse("Token refreshed", pair))
+	case errors.Is(err, utils.ErrInvalidRefreshToken):
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid refresh token")
+	case errors.Is(err, utils.ErrRefreshTokenExpired):
+		return echo.NewHTTPError(http.StatusUnauthorized, "Refresh token expired")
+	case errors.Is(err, utils.ErrRefreshTokenReused):
+		return echo.NewHTTPError(http.StatusUnauthorized, "Refresh token already used, session revoked")
+	case errors.Is(err, utils.ErrUserNotFound):
+		return echo.NewHTTPError(http.StatusUnauthorized, "User not found")
 
-	case err != nil:
+	default:
+		slog.Error("Error while refreshing token", "error", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error")
 	}
+}
+
+func (h *userHandler) LogoutHandler(c echo.Context) error {
+	req := &RefreshRequest{}
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid JSON payload")
+	}
+
+	claims, ok := c.Get("claims").(*custom_middleware.JwtCustomClaims)
+	if !ok {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Token claims not found in context")
+	}
+
+	err := h.tokens.Revoke(c.Request().Context(), claims, req.RefreshToken)
 
-	return echo.NewHTTPError(http.StatusUnauthorized, "Authentication failed")
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, map[string]string{"message": "Logged out"})
+	case errors.Is(err, utils.ErrInvalidToken):
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
+	case errors.Is(err, utils.ErrInvalidRefreshToken):
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid refresh token")
+
+	default:
+		slog.Error("Error while revoking tokens", "userID", claims.UserID, "error", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error")
+	}
 }
 
+// GetByPublicKeyHandler looks a user up by the public_key or, easier to
+// type, the fingerprint query parameter.
 func (h *userHandler) GetByPublicKeyHandler(c echo.Context) error {
+	if fingerprint := c.QueryParam("fingerprint"); fingerprint != "" {
+		return h.getByFingerprint(c, fingerprint)
+	}
+
 	publicKey := c.QueryParam("public_key")
 	if publicKey == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "Missing public key")
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing public key or fingerprint")
 	}
 
 	user, err := h.service.GetByPublicKey(c.Request().Context(), publicKey)
 
 	switch {
 	case err == nil:
-		return c.JSON(http.StatusOK, user)
+		return h.respondWithProof(c, user)
 
 	case errors.Is(err, utils.ErrEmptyPublicKey):
 		return echo.NewHTTPError(http.StatusBadRequest, "Empty publickey")
@@ -175,6 +346,47 @@ func (h *userHandler) GetByPublicKeyHandler(c echo.Context) error {
 	}
 }
 
+func (h *userHandler) getByFingerprint(c echo.Context, fingerprint string) error {
+	user, err := h.service.GetByFingerprint(c.Request().Context(), fingerprint)
+
+	switch {
+	case err == nil:
+		return h.respondWithProof(c, user)
+
+	case errors.Is(err, utils.ErrInvalidFingerprint):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid fingerprint, expected 30 digits")
+
+	case errors.Is(err, utils.ErrUserNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "User not found")
+
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+// SafetyNumberHandler returns the safety number of the user in the path and
+// the user named by the with query parameter.
+func (h *userHandler) SafetyNumberHandler(c echo.Context) error {
+	other := c.QueryParam("with")
+	if other == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing with parameter")
+	}
+
+	safetyNumber, err := h.service.SafetyNumber(c.Request().Context(), c.Param("id"), other)
+
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, safetyNumber)
+	case errors.Is(err, utils.ErrEmptyUserID), errors.Is(err, utils.ErrInvalidUserID):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid id")
+	case errors.Is(err, utils.ErrUserNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "User not found")
+
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error")
+	}
+}
+
 func (h *userHandler) GetByIDHandler(c echo.Context) error {
 	id := c.Param("id")
 	if id == "" {
@@ -184,7 +396,7 @@ func (h *userHandler) GetByIDHandler(c echo.Context) error {
 
 	switch {
 	case err == nil:
-		return c.JSON(http.StatusOK, user)
+		return h.respondWithProof(c, user)
 	case errors.Is(err, utils.ErrEmptyUserID):
 		return echo.NewHTTPError(http.StatusBadRequest, "Empty id")
 	case errors.Is(err, utils.ErrInvalidUserID):
@@ -196,3 +408,269 @@ func (h *userHandler) GetByIDHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Internal server error")
 	}
 }
+
+func (h *userHandler) AddEncryptionKeyHandler(c echo.Context) error {
+	req := &EncryptionKeyRequest{}
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid JSON payload")
+	}
+
+	userInfo, ok := c.Get("userInfo").(custom_middleware.UserInfo)
+	if !ok {
+		return echo.NewHTTPError(http.StatusInternalServerError, "User info not found in context")
+	}
+
+	key, err := h.service.AddEncryptionKey(c.Request().Context(), userInfo.UserID, req.PublicKey, req.Signature)
+
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusCreated, key)
+	case errors.Is(err, utils.ErrInvalidEncryptionKey):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid encryption key")
+	case errors.Is(err, utils.ErrEmptySignature):
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing signature")
+	case errors.Is(err, utils.ErrInvalidSignature):
+		return echo.NewHTTPError(http.StatusBadRequest, "Signature verification failed")
+	case errors.Is(err, utils.ErrTooManyEncryptionKeys):
+		return echo.NewHTTPError(http.StatusBadRequest, "Too many encryption keys")
+	case errors.Is(err, utils.ErrDuplicateEncryptionKey):
+		return echo.NewHTTPError(http.StatusConflict, "Encryption key already registered")
+	case errors.Is(err, utils.ErrUserNotFound):
+		return echo.NewHTTPError(http.StatusUnauthorized, "User not found")
+
+	default:
+		slog.Error("Error while adding encryption key", "userID", userInfo.UserID, "error", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+func (h *userHandler) RotateKeyHandler(c echo.Context) error {
+	id := c.Param("id")
+	req := &RotateKeyRequest{}
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid JSON payload")
+	}
+
+	user, err := h.service.RotateKey(c.Request().Context(), id, req.PublicKey, req.Signature)
+
+	switch {
+	case err == nil:
+		return h.respondWithProof(c, user)
+	case errors.Is(err, utils.ErrEmptyUserID):
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing user ID")
+	case errors.Is(err, utils.ErrInvalidUserID):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	case errors.Is(err, utils.ErrUserNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "User not found")
+	case errors.Is(err, utils.ErrEmptyPublicKey):
+		return echo.NewHTTPError(http.StatusBadRequest, "Empty public key")
+	case errors.Is(err, utils.ErrInvalidPublicKey):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid public key")
+	case errors.Is(err, utils.ErrEmptySignature):
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing signature")
+	case errors.Is(err, utils.ErrInvalidSignature):
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid signature")
+	case errors.Is(err, utils.ErrDuplicatePublicKey):
+		return echo.NewHTTPError(http.StatusConflict, "Public key already in use")
+	case errors.Is(err, utils.ErrKeyRotationConflict):
+		return echo.NewHTTPError(http.StatusConflict, "Key was rotated concurrently, retry")
+
+	default:
+		slog.Error("Error while rotating key", "userID", id, "error", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+func (h *userHandler) AddDeviceHandler(c echo.Context) error {
+	id := c.Param("id")
+	req := &DeviceRequest{}
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid JSON payload")
+	}
+
+	device, err := h.service.AddDevice(c.Request().Context(), id, req.Name, req.PublicKey, req.AuthorizedBy, req.Signature)
+
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusCreated, device)
+	case errors.Is(err, utils.ErrEmptyUserID):
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing user ID")
+	case errors.Is(err, utils.ErrInvalidUserID):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	case errors.Is(err, utils.ErrUserNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "User not found")
+	case errors.Is(err, utils.ErrInvalidDeviceName):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid device name")
+	case errors.Is(err, utils.ErrEmptyPublicKey):
+		return echo.NewHTTPError(http.StatusBadRequest, "Empty public key")
+	case errors.Is(err, utils.ErrInvalidPublicKey):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid public key")
+	case errors.Is(err, utils.ErrEmptySignature):
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing signature")
+	case errors.Is(err, utils.ErrInvalidSignature):
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid signature")
+	case errors.Is(err, utils.ErrTooManyDevices):
+		return echo.NewHTTPError(http.StatusBadRequest, "Too many devices")
+	case errors.Is(err, utils.ErrDuplicatePublicKey):
+		return echo.NewHTTPError(http.StatusConflict, "Public key already in use")
+
+	default:
+		slog.Error("Error while adding device", "userID", id, "error", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+func (h *userHandler) RevokeDeviceHandler(c echo.Context) error {
+	id := c.Param("id")
+	deviceID := c.Param("device")
+	req := &RevokeDeviceRequest{}
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid JSON payload")
+	}
+
+	err := h.service.RevokeDevice(c.Request().Context(), id, deviceID, req.AuthorizedBy, req.Signature)
+
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, map[string]string{"message": "Device revoked"})
+	case errors.Is(err, utils.ErrEmptyUserID):
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing user ID")
+	case errors.Is(err, utils.ErrInvalidUserID):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	case errors.Is(err, utils.ErrUserNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "User not found")
+	case errors.Is(err, utils.ErrDeviceNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "Device not found")
+	case errors.Is(err, utils.ErrDeviceRevoked):
+		return echo.NewHTTPError(http.StatusGone, "Device already revoked")
+	case errors.Is(err, utils.ErrEmptySignature):
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing signature")
+	case errors.Is(err, utils.ErrInvalidSignature), errors.Is(err, utils.ErrInvalidPublicKey):
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid signature")
+
+	default:
+		slog.Error("Error while revoking device", "userID", id, "deviceID", deviceID, "error", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+type WebAuthnHandler interface {
+	BeginRegistrationHandler(c echo.Context) error
+	FinishRegistrationHandler(c echo.Context) error
+	BeginLoginHandler(c echo.Context) error
+	FinishLoginHandler(c echo.Context) error
+}
+
+type webAuthnHandler struct {
+	service WebAuthnService
+	tokens  TokenService
+}
+
+func NewWebAuthnHandler(service WebAuthnService, tokens TokenService) *webAuthnHandler {
+	return &webAuthnHandler{
+		service: service,
+		tokens:  tokens,
+	}
+}
+
+type PasskeyLoginRequest struct {
+	ID         string               `json:"id"`
+	Credential *PublicKeyCredential `json:"credential"`
+}
+
+func (h *webAuthnHandler) BeginRegistrationHandler(c echo.Context) error {
+	userInfo, ok := c.Get("userInfo").(custom_middleware.UserInfo)
+	if !ok {
+		return echo.NewHTTPError(http.StatusInternalServerError, "User info not found in context")
+	}
+
+	options, err := h.service.BeginRegistration(c.Request().Context(), userInfo.UserID)
+	if err != nil {
+		return passkeyError(err, userInfo.UserID)
+	}
+	return c.JSON(http.StatusOK, options)
+}
+
+func (h *webAuthnHandler) FinishRegistrationHandler(c echo.Context) error {
+	credential := &PublicKeyCredential{}
+	if err := c.Bind(credential); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid JSON payload")
+	}
+
+	userInfo, ok := c.Get("userInfo").(custom_middleware.UserInfo)
+	if !ok {
+		return echo.NewHTTPError(http.StatusInternalServerError, "User info not found in context")
+	}
+
+	passkey, err := h.service.FinishRegistration(c.Request().Context(), userInfo.UserID, credential)
+	if err != nil {
+		return passkeyError(err, userInfo.UserID)
+	}
+	return c.JSON(http.StatusCreated, passkey)
+}
+
+func (h *webAuthnHandler) BeginLoginHandler(c echo.Context) error {
+	req := &ID{}
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid JSON payload")
+	}
+
+	options, err := h.service.BeginLogin(c.Request().Context(), req.Id)
+	if err != nil {
+		return passkeyError(err, req.Id)
+	}
+	return c.JSON(http.StatusOK, options)
+}
+
+func (h *webAuthnHandler) FinishLoginHandler(c echo.Context) error {
+	req := &PasskeyLoginRequest{}
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid JSON payload")
+	}
+
+	user, err := h.service.FinishLogin(c.Request().Context(), req.ID, req.Credential)
+	if err != nil {
+		return passkeyError(err, req.ID)
+	}
+
+	pair, err := h.tokens.Issue(c.Request().Context(), user)
+	if err != nil {
+		slog.Error("Error while issuing tokens", "userID", user.ID, "error", err)
+		return c.String(http.StatusInternalServerError, "Failed to generate token")
+	}
+	return c.JSON(http.StatusOK, newTokenResponse("Authentication successful", pair))
+}
+
+// passkeyError maps the errors of the WebAuthn ceremonies to responses.
+func passkeyError(err error, userID string) error {
+	switch {
+	case errors.Is(err, utils.ErrEmptyUserID):
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing user ID")
+	case errors.Is(err, utils.ErrValidationError):
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing or invalid challenge")
+	case errors.Is(err, utils.ErrInvalidPasskey):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid passkey response")
+	case errors.Is(err, utils.ErrTooManyPasskeys):
+		return echo.NewHTTPError(http.StatusBadRequest, "Too many passkeys")
+	case errors.Is(err, utils.ErrDuplicatePasskey):
+		return echo.NewHTTPError(http.StatusConflict, "Passkey already registered")
+	case errors.Is(err, utils.ErrInvalidSignature):
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid signature")
+	case errors.Is(err, utils.ErrPasskeyCloned):
+		return echo.NewHTTPError(http.StatusUnauthorized, "Passkey signature counter did not increase")
+	case errors.Is(err, utils.ErrChallengeNotIssued):
+		return echo.NewHTTPError(http.StatusUnauthorized, "Challenge was not issued by the server")
+	case errors.Is(err, utils.ErrChallengeExpired):
+		return echo.NewHTTPError(http.StatusUnauthorized, "Challenge expired")
+	case errors.Is(err, utils.ErrChallengeConsumed):
+		return echo.NewHTTPError(http.StatusUnauthorized, "Challenge already used")
+	case errors.Is(err, utils.ErrPasskeyNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "Passkey not found")
+	case errors.Is(err, utils.ErrUserNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "User not found")
+
+	default:
+		slog.Error("Error during passkey ceremony", "userID", userID, "error", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error")
+	}
+}
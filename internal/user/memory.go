@@ -0,0 +1,367 @@
+package user
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/transparency"
+	"Drop-Key/internal/utils"
+	"Drop-Key/internal/verifier"
+)
+
+// inMemoryUserRepository keeps users in memory, for tests and for
+// deployments that store nothing on disk. Users are lost on restart. Key
+// changes are appended to keyLog while the repository is locked, so the log
+// orders them the same way the repository applied them.
+type inMemoryUserRepository struct {
+	mu             sync.RWMutex
+	keyLog         transparency.LogAppender
+	users          map[string]*models.User
+	keyHistory     map[string][]*models.UserKey
+	devices        map[string][]*models.DeviceKey
+	encryptionKeys map[string][]*models.EncryptionKey
+	passkeys       map[string]*models.Passkey
+	// publicKeys holds every identity and device key ever used, which may
+	// not be registered again.
+	publicKeys map[string]bool
+}
+
+func NewInMemoryUserRepository(keyLog transparency.LogAppender) *inMemoryUserRepository {
+	return &inMemoryUserRepository{
+		keyLog:         keyLog,
+		users:          make(map[string]*models.User),
+		keyHistory:     make(map[string][]*models.UserKey),
+		devices:        make(map[string][]*models.DeviceKey),
+		encryptionKeys: make(map[string][]*models.EncryptionKey),
+		passkeys:       make(map[string]*models.Passkey),
+		publicKeys:     make(map[string]bool),
+	}
+}
+
+func (r *inMemoryUserRepository) Create(ctx context.Context, user *models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.publicKeys[user.PublicKey] {
+		return utils.ErrDuplicatePublicKey
+	}
+	if _, ok := r.users[user.ID]; ok {
+		return utils.ErrUserAlreadyExists
+	}
+	first := &models.UserKey{
+		UserID:    user.ID,
+		Sequence:  1,
+		PublicKey: user.PublicKey,
+		CreatedAt: time.Now().UTC(),
+	}
+	err := r.keyLog.Append(ctx, &models.LogEntry{
+		Type:      models.LogEntryRegister,
+		UserID:    user.ID,
+		Algorithm: verifier.Normalize(user.Algorithm),
+		PublicKey: user.PublicKey,
+		CreatedAt: first.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	stored := *user
+	stored.EncryptionKeys, stored.KeyHistory, stored.Devices = nil, nil, nil
+	r.users[user.ID] = &stored
+	r.keyHistory[user.ID] = []*models.UserKey{first}
+	r.publicKeys[user.PublicKey] = true
+	return nil
+}
+
+func (r *inMemoryUserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, utils.ErrUserNotFound
+	}
+	return r.load(user), nil
+}
+
+func (r *inMemoryUserRepository) GetByPublicKey(ctx context.Context, public_key string) (*models.User, error) {
+	return r.find(ctx, func(user *models.User) bool { return user.PublicKey == public_key })
+}
+
+func (r *inMemoryUserRepository) GetByFingerprint(ctx context.Context, fingerprint string) (*models.User, error) {
+	return r.find(ctx, func(user *models.User) bool { return user.Fingerprint != "" && user.Fingerprint == fingerprint })
+}
+
+func (r *inMemoryUserRepository) find(ctx context.Context, match func(user *models.User) bool) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if match(user) {
+			return r.load(user), nil
+		}
+	}
+	return nil, utils.ErrUserNotFound
+}
+
+// load returns a copy of user with its keys and devices attached, ordered
+// like the database repository orders them.
+func (r *inMemoryUserRepository) load(user *models.User) *models.User {
+	loaded := *user
+	for _, key := range r.encryptionKeys[user.ID] {
+		key := *key
+		loaded.EncryptionKeys = append(loaded.EncryptionKeys, &key)
+	}
+	for _, key := range r.keyHistory[user.ID] {
+		key := *key
+		loaded.KeyHistory = append(loaded.KeyHistory, &key)
+	}
+	for _, device := range r.devices[user.ID] {
+		device := *device
+		loaded.Devices = append(loaded.Devices, &device)
+	}
+	sort.SliceStable(loaded.EncryptionKeys, func(i, j int) bool {
+		a, b := loaded.EncryptionKeys[i], loaded.EncryptionKeys[j]
+		return createdBefore(a.CreatedAt, a.ID, b.CreatedAt, b.ID)
+	})
+	sort.SliceStable(loaded.KeyHistory, func(i, j int) bool {
+		return loaded.KeyHistory[i].Sequence < loaded.KeyHistory[j].Sequence
+	})
+	sort.SliceStable(loaded.Devices, func(i, j int) bool {
+		a, b := loaded.Devices[i], loaded.Devices[j]
+		return createdBefore(a.CreatedAt, a.ID, b.CreatedAt, b.ID)
+	})
+	return &loaded
+}
+
+// createdBefore orders rows by creation time, then ID.
+func createdBefore(a time.Time, aID string, b time.Time, bID string) bool {
+	if !a.Equal(b) {
+		return a.Before(b)
+	}
+	return aID < bID
+}
+
+func (r *inMemoryUserRepository) CreateEncryptionKey(ctx context.Context, key *models.EncryptionKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, keys := range r.encryptionKeys {
+		for _, existing := range keys {
+			if existing.PublicKey == key.PublicKey {
+				return utils.ErrDuplicateEncryptionKey
+			}
+		}
+	}
+	stored := *key
+	r.encryptionKeys[key.UserID] = append(r.encryptionKeys[key.UserID], &stored)
+	return nil
+}
+
+// RotateKey makes next the current identity key of user, retiring the key
+// user was loaded with and dropping the encryption keys it endorsed. Users
+// registered before key history was kept get their current key recorded as
+// the predecessor of next.
+func (r *inMemoryUserRepository) RotateKey(ctx context.Context, user *models.User, next *models.UserKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.publicKeys[next.PublicKey] {
+		return utils.ErrDuplicatePublicKey
+	}
+	stored, ok := r.users[user.ID]
+	if !ok || stored.PublicKey != user.PublicKey {
+		return utils.ErrKeyRotationConflict
+	}
+	history := r.keyHistory[user.ID]
+	for _, key := range history {
+		if key.Sequence == next.Sequence {
+			return utils.ErrKeyRotationConflict
+		}
+	}
+	err := r.keyLog.Append(ctx, &models.LogEntry{
+		Type:      models.LogEntryRotate,
+		UserID:    user.ID,
+		Algorithm: verifier.Normalize(user.Algorithm),
+		PublicKey: next.PublicKey,
+		CreatedAt: next.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(user.KeyHistory) == 0 && len(history) == 0 {
+		history = append(history, &models.UserKey{
+			UserID:    user.ID,
+			Sequence:  next.Sequence - 1,
+			PublicKey: user.PublicKey,
+			CreatedAt: next.CreatedAt,
+		})
+	}
+	for _, key := range history {
+		if key.RetiredAt.IsZero() {
+			key.RetiredAt = next.CreatedAt
+		}
+	}
+	key := *next
+	r.keyHistory[user.ID] = append(history, &key)
+
+	var endorsed []*models.EncryptionKey
+	for _, encryptionKey := range r.encryptionKeys[user.ID] {
+		if encryptionKey.SignedBy == next.PublicKey {
+			endorsed = append(endorsed, encryptionKey)
+		}
+	}
+	r.encryptionKeys[user.ID] = endorsed
+
+	stored.PublicKey = next.PublicKey
+	stored.Fingerprint = Fingerprint(user.Algorithm, next.PublicKey)
+	r.publicKeys[next.PublicKey] = true
+	return nil
+}
+
+func (r *inMemoryUserRepository) GetDeviceByKey(ctx context.Context, publicKey string) (*models.DeviceKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, devices := range r.devices {
+		for _, device := range devices {
+			if device.PublicKey == publicKey {
+				found := *device
+				return &found, nil
+			}
+		}
+	}
+	return nil, utils.ErrDeviceNotFound
+}
+
+func (r *inMemoryUserRepository) CreateDevice(ctx context.Context, device *models.DeviceKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.publicKeys[device.PublicKey] {
+		return utils.ErrDuplicatePublicKey
+	}
+	err := r.keyLog.Append(ctx, &models.LogEntry{
+		Type:      models.LogEntryDeviceAdd,
+		UserID:    device.UserID,
+		DeviceID:  device.ID,
+		Algorithm: verifier.AlgorithmEd25519,
+		PublicKey: device.PublicKey,
+		CreatedAt: device.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	stored := *device
+	r.devices[device.UserID] = append(r.devices[device.UserID], &stored)
+	r.publicKeys[device.PublicKey] = true
+	return nil
+}
+
+func (r *inMemoryUserRepository) RevokeDevice(ctx context.Context, userID, deviceID string, revokedAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, device := range r.devices[userID] {
+		if device.ID != deviceID || !device.RevokedAt.IsZero() {
+			continue
+		}
+		err := r.keyLog.Append(ctx, &models.LogEntry{
+			Type:      models.LogEntryDeviceRevoke,
+			UserID:    userID,
+			DeviceID:  deviceID,
+			Algorithm: verifier.AlgorithmEd25519,
+			PublicKey: device.PublicKey,
+			CreatedAt: revokedAt,
+		})
+		if err != nil {
+			return err
+		}
+		device.RevokedAt = revokedAt
+		return nil
+	}
+	return utils.ErrDeviceNotFound
+}
+
+func (r *inMemoryUserRepository) CreatePasskey(ctx context.Context, passkey *models.Passkey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.passkeys[passkey.ID]; ok {
+		return utils.ErrDuplicatePasskey
+	}
+	stored := *passkey
+	r.passkeys[passkey.ID] = &stored
+	return nil
+}
+
+func (r *inMemoryUserRepository) ListPasskeys(ctx context.Context, userID string) ([]*models.Passkey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var passkeys []*models.Passkey
+	for _, passkey := range r.passkeys {
+		if passkey.UserID == userID {
+			found := *passkey
+			passkeys = append(passkeys, &found)
+		}
+	}
+	sort.Slice(passkeys, func(i, j int) bool {
+		a, b := passkeys[i], passkeys[j]
+		return createdBefore(a.CreatedAt, a.ID, b.CreatedAt, b.ID)
+	})
+	return passkeys, nil
+}
+
+// UpdatePasskeyUse records a login with passkey if the stored counter is
+// still the one passkey was loaded with.
+func (r *inMemoryUserRepository) UpdatePasskeyUse(ctx context.Context, passkey *models.Passkey, signCount uint32, usedAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.passkeys[passkey.ID]
+	if !ok || stored.SignCount != passkey.SignCount {
+		return utils.ErrPasskeyCloned
+	}
+	stored.SignCount = signCount
+	stored.LastUsedAt = usedAt
+	return nil
+}
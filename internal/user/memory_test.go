@@ -0,0 +1,137 @@
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"Drop-Key/internal/db"
+	"Drop-Key/internal/db/dbtest"
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/transparency"
+	"Drop-Key/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// eachRepository runs test against the in-memory repository and against the
+// database repository on every test backend, which must behave the same,
+// together with the log each appends key changes to.
+func eachRepository(t *testing.T, test func(t *testing.T, repo UserRepository, keyLog transparency.LogRepository)) {
+	t.Run("memory", func(t *testing.T) {
+		keyLog := transparency.NewInMemoryLogRepository()
+		test(t, NewInMemoryUserRepository(keyLog), keyLog)
+	})
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		db, err := db.InitDB(ctx)
+		assert.NoError(t, err, "should initialise database")
+		defer func() {
+			dbtest.Reset(t, db)
+			db.Close()
+		}()
+		test(t, NewUserRepository(db), transparency.NewLogRepository(db))
+	})
+}
+
+func TestRepositoryContract(t *testing.T) {
+	eachRepository(t, func(t *testing.T, repo UserRepository, keyLog transparency.LogRepository) {
+		ctx := context.Background()
+		user := &models.User{
+			ID:          "contract_user",
+			PublicKey:   "first_key",
+			Algorithm:   "ed25519",
+			Fingerprint: Fingerprint("ed25519", "first_key"),
+		}
+		assert.NoError(t, repo.Create(ctx, user), "should create user")
+		assert.ErrorIs(t, repo.Create(ctx, &models.User{ID: "other", PublicKey: "first_key"}), utils.ErrDuplicatePublicKey)
+
+		t.Run("lookups", func(t *testing.T) {
+			_, err := repo.GetByID(ctx, "missing")
+			assert.Error(t, err, "should not find a missing user")
+			_, err = repo.GetByPublicKey(ctx, "missing")
+			assert.ErrorIs(t, err, utils.ErrUserNotFound)
+			_, err = repo.GetByFingerprint(ctx, "missing")
+			assert.ErrorIs(t, err, utils.ErrUserNotFound)
+
+			fetched, err := repo.GetByFingerprint(ctx, user.Fingerprint)
+			assert.NoError(t, err)
+			assert.Equal(t, user.ID, fetched.ID)
+			assert.Len(t, fetched.KeyHistory, 1, "should load the key history")
+		})
+
+		t.Run("encryption keys", func(t *testing.T) {
+			key := &models.EncryptionKey{ID: "enc", UserID: user.ID, PublicKey: "x25519_key", Signature: "sig", SignedBy: user.PublicKey, CreatedAt: time.Now().UTC().Truncate(time.Second)}
+			assert.NoError(t, repo.CreateEncryptionKey(ctx, key))
+			duplicate := *key
+			duplicate.ID = "enc_duplicate"
+			assert.ErrorIs(t, repo.CreateEncryptionKey(ctx, &duplicate), utils.ErrDuplicateEncryptionKey)
+		})
+
+		t.Run("devices", func(t *testing.T) {
+			device := &models.DeviceKey{ID: "device", UserID: user.ID, Name: "laptop", PublicKey: "device_key", AuthorizedBy: user.PublicKey, Signature: "sig", CreatedAt: time.Now().UTC().Truncate(time.Second)}
+			assert.NoError(t, repo.CreateDevice(ctx, device))
+			assert.ErrorIs(t, repo.CreateDevice(ctx, &models.DeviceKey{ID: "clash", UserID: user.ID, PublicKey: user.PublicKey}), utils.ErrDuplicatePublicKey)
+
+			fetched, err := repo.GetDeviceByKey(ctx, "device_key")
+			assert.NoError(t, err)
+			assert.Equal(t, device.ID, fetched.ID)
+			_, err = repo.GetDeviceByKey(ctx, "missing")
+			assert.ErrorIs(t, err, utils.ErrDeviceNotFound)
+
+			assert.NoError(t, repo.RevokeDevice(ctx, user.ID, device.ID, time.Now().UTC().Truncate(time.Second)))
+			assert.ErrorIs(t, repo.RevokeDevice(ctx, user.ID, device.ID, time.Now().UTC()), utils.ErrDeviceNotFound, "should not revoke twice")
+			assert.ErrorIs(t, repo.CreateDevice(ctx, &models.DeviceKey{ID: "reused", UserID: user.ID, PublicKey: "device_key"}), utils.ErrDuplicatePublicKey, "revoked keys stay taken")
+		})
+
+		t.Run("rotation", func(t *testing.T) {
+			current, err := repo.GetByID(ctx, user.ID)
+			assert.NoError(t, err)
+			next := &models.UserKey{UserID: user.ID, Sequence: 2, PublicKey: "second_key", Signature: "sig", CreatedAt: time.Now().UTC().Truncate(time.Second)}
+			assert.NoError(t, repo.RotateKey(ctx, current, next))
+
+			stale := &models.UserKey{UserID: user.ID, Sequence: 2, PublicKey: "third_key", CreatedAt: time.Now().UTC()}
+			assert.ErrorIs(t, repo.RotateKey(ctx, current, stale), utils.ErrKeyRotationConflict, "should not rotate from a retired key")
+
+			rotated, err := repo.GetByID(ctx, user.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, "second_key", rotated.PublicKey)
+			assert.Equal(t, Fingerprint("ed25519", "second_key"), rotated.Fingerprint)
+			if assert.Len(t, rotated.KeyHistory, 2) {
+				assert.False(t, rotated.KeyHistory[0].RetiredAt.IsZero(), "should retire the first key")
+				assert.True(t, rotated.KeyHistory[1].RetiredAt.IsZero(), "should keep the new key open")
+			}
+			assert.Empty(t, rotated.EncryptionKeys, "should drop encryption keys endorsed by the retired key")
+			_, err = repo.GetByPublicKey(ctx, "first_key")
+			assert.ErrorIs(t, err, utils.ErrUserNotFound)
+
+			endorsed := &models.EncryptionKey{ID: "enc_again", UserID: user.ID, PublicKey: "x25519_key", Signature: "sig", SignedBy: "second_key", CreatedAt: time.Now().UTC().Truncate(time.Second)}
+			assert.NoError(t, repo.CreateEncryptionKey(ctx, endorsed), "the new key should endorse the encryption key again")
+		})
+
+		t.Run("passkeys", func(t *testing.T) {
+			passkey := &models.Passkey{ID: "credential", UserID: user.ID, PublicKey: "cose", Algorithm: -7, SignCount: 1, CreatedAt: time.Now().UTC().Truncate(time.Second)}
+			assert.NoError(t, repo.CreatePasskey(ctx, passkey))
+			assert.ErrorIs(t, repo.CreatePasskey(ctx, passkey), utils.ErrDuplicatePasskey)
+
+			assert.NoError(t, repo.UpdatePasskeyUse(ctx, passkey, 2, time.Now().UTC()))
+			assert.ErrorIs(t, repo.UpdatePasskeyUse(ctx, passkey, 3, time.Now().UTC()), utils.ErrPasskeyCloned, "should refuse a stale counter")
+
+			passkeys, err := repo.ListPasskeys(ctx, user.ID)
+			assert.NoError(t, err)
+			if assert.Len(t, passkeys, 1) {
+				assert.Equal(t, uint32(2), passkeys[0].SignCount)
+			}
+		})
+
+		t.Run("log", func(t *testing.T) {
+			entries, err := keyLog.Entries(ctx, 0, 100)
+			assert.NoError(t, err)
+			var types []string
+			for _, entry := range entries {
+				types = append(types, entry.Type)
+			}
+			assert.Equal(t, []string{models.LogEntryRegister, models.LogEntryDeviceAdd, models.LogEntryDeviceRevoke, models.LogEntryRotate}, types)
+		})
+	})
+}
@@ -0,0 +1,80 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"Drop-Key/internal/db"
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/transparency"
+	"Drop-Key/internal/verifier"
+
+	"github.com/uptrace/bun"
+)
+
+func init() {
+	db.RegisterMigration("0003", "backfill_keys", backfillKeys, func(context.Context, *bun.DB) error {
+		// The backfilled columns and log entries are dropped with the
+		// schema they belong to when 0002 is rolled back.
+		return nil
+	})
+}
+
+// backfillKeys gives users registered before fingerprints and the key
+// transparency log existed a fingerprint and a register entry in the log, as
+// Create does for new users. Users that already have them are left alone, so
+// it can run again after failing halfway.
+func backfillKeys(ctx context.Context, database *bun.DB) error {
+	return database.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var users []models.User
+		err := tx.NewSelect().
+			Model(&users).
+			Column("id", "public_key", "algorithm", "fingerprint").
+			OrderExpr("id ASC").
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+		var logged []string
+		err = tx.NewSelect().
+			Model((*models.LogEntry)(nil)).
+			Column("user_id").
+			Where("type = ?", models.LogEntryRegister).
+			Scan(ctx, &logged)
+		if err != nil {
+			return err
+		}
+		registered := make(map[string]bool, len(logged))
+		for _, id := range logged {
+			registered[id] = true
+		}
+
+		now := time.Now().UTC()
+		for _, user := range users {
+			if user.Fingerprint == "" {
+				_, err := tx.NewUpdate().
+					Model((*models.User)(nil)).
+					Set("fingerprint = ?", Fingerprint(user.Algorithm, user.PublicKey)).
+					Where("id = ?", user.ID).
+					Exec(ctx)
+				if err != nil {
+					return err
+				}
+			}
+			if registered[user.ID] {
+				continue
+			}
+			err := transparency.Append(ctx, tx, &models.LogEntry{
+				Type:      models.LogEntryRegister,
+				UserID:    user.ID,
+				Algorithm: verifier.Normalize(user.Algorithm),
+				PublicKey: user.PublicKey,
+				CreatedAt: now,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
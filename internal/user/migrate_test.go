@@ -0,0 +1,58 @@
+package user
+
+import (
+	"context"
+	"testing"
+
+	"Drop-Key/internal/db"
+	"Drop-Key/internal/db/dbtest"
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/transparency"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackfillKeys(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		database, err := db.Connect(ctx)
+		if !assert.NoError(t, err, "should connect to database") {
+			return
+		}
+		defer database.Close()
+		defer dbtest.Reset(t, database)
+
+		dbtest.CreateBaseline(t, database)
+		for _, id := range []string{"first", "second"} {
+			_, err := database.ExecContext(ctx, "INSERT INTO users (id, public_key) VALUES (?, ?)", id, id+"_key")
+			assert.NoError(t, err, "should insert a user of the baseline release")
+		}
+
+		_, err = db.Migrate(ctx, database)
+		if !assert.NoError(t, err, "should migrate the baseline database") {
+			return
+		}
+
+		repo := NewUserRepository(database)
+		for _, id := range []string{"first", "second"} {
+			user, err := repo.GetByID(ctx, id)
+			if assert.NoError(t, err) {
+				assert.Equal(t, Fingerprint("ed25519", id+"_key"), user.Fingerprint, "should backfill the fingerprint")
+			}
+			entry, err := transparency.NewLogRepository(database).LatestKeyEntry(ctx, id, id+"_key")
+			if assert.NoError(t, err, "should log the existing key") {
+				assert.Equal(t, models.LogEntryRegister, entry.Type)
+			}
+		}
+
+		assert.NoError(t, backfillKeys(ctx, database), "should run again")
+		count, err := database.NewSelect().Model((*models.LogEntry)(nil)).Count(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count, "should not log a user twice")
+
+		assert.NoError(t, repo.Create(ctx, &models.User{ID: "third", PublicKey: "third_key", Fingerprint: Fingerprint("ed25519", "third_key")}), "should register users after the backfill")
+		leaves, err := transparency.NewLogRepository(database).LeafHashes(ctx, 0)
+		assert.NoError(t, err)
+		assert.Len(t, leaves, 3, "should append new users to the backfilled log")
+	})
+}
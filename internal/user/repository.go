@@ -5,9 +5,12 @@ import (
 	"database/sql"
 	"errors"
 	"log/slog"
+	"time"
 
 	"Drop-Key/internal/models"
+	"Drop-Key/internal/transparency"
 	"Drop-Key/internal/utils"
+	"Drop-Key/internal/verifier"
 
 	"github.com/uptrace/bun"
 )
@@ -16,6 +19,15 @@ type UserRepository interface {
 	Create(ctx context.Context, user *models.User) error
 	GetByID(ctx context.Context, id string) (*models.User, error)
 	GetByPublicKey(ctx context.Context, public_key string) (*models.User, error)
+	GetByFingerprint(ctx context.Context, fingerprint string) (*models.User, error)
+	CreateEncryptionKey(ctx context.Context, key *models.EncryptionKey) error
+	RotateKey(ctx context.Context, user *models.User, next *models.UserKey) error
+	GetDeviceByKey(ctx context.Context, publicKey string) (*models.DeviceKey, error)
+	CreateDevice(ctx context.Context, device *models.DeviceKey) error
+	RevokeDevice(ctx context.Context, userID, deviceID string, revokedAt time.Time) error
+	CreatePasskey(ctx context.Context, passkey *models.Passkey) error
+	ListPasskeys(ctx context.Context, userID string) ([]*models.Passkey, error)
+	UpdatePasskeyUse(ctx context.Context, passkey *models.Passkey, signCount uint32, usedAt time.Time) error
 }
 
 type userRepository struct {
@@ -30,7 +42,34 @@ func NewUserRepository(db *bun.DB) *userRepository {
 
 // TODO Fix user already exists error
 func (r *userRepository) Create(ctx context.Context, user *models.User) error {
-	_, err := r.db.NewInsert().Model(user).Exec(ctx)
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		taken, err := publicKeyTaken(ctx, tx, user.PublicKey)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return utils.ErrDuplicatePublicKey
+		}
+		if _, err := tx.NewInsert().Model(user).Exec(ctx); err != nil {
+			return err
+		}
+		first := &models.UserKey{
+			UserID:    user.ID,
+			Sequence:  1,
+			PublicKey: user.PublicKey,
+			CreatedAt: time.Now().UTC(),
+		}
+		if _, err := tx.NewInsert().Model(first).Exec(ctx); err != nil {
+			return err
+		}
+		return transparency.Append(ctx, tx, &models.LogEntry{
+			Type:      models.LogEntryRegister,
+			UserID:    user.ID,
+			Algorithm: verifier.Normalize(user.Algorithm),
+			PublicKey: user.PublicKey,
+			CreatedAt: first.CreatedAt,
+		})
+	})
 	if err != nil {
 		slog.Error("Error while inserting user", "operation", "create", "publickey", user.PublicKey)
 		return err
@@ -40,7 +79,7 @@ func (r *userRepository) Create(ctx context.Context, user *models.User) error {
 
 func (r *userRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
 	var user models.User
-	err := r.db.NewSelect().Model(&user).Where("id = ?", id).Scan(ctx)
+	err := r.db.NewSelect().Model(&user).Relation("EncryptionKeys", orderByCreation).Relation("KeyHistory", orderBySequence).Relation("Devices", orderByCreation).Where("id = ?", id).Scan(ctx)
 	if err != nil {
 		slog.Error("Error while getting user", "operation", "get", "userID", id, "error", err)
 		return nil, err
@@ -50,7 +89,7 @@ func (r *userRepository) GetByID(ctx context.Context, id string) (*models.User,
 
 func (r *userRepository) GetByPublicKey(ctx context.Context, public_key string) (*models.User, error) {
 	var user models.User
-	err := r.db.NewSelect().Model(&user).Where("public_key = ?", public_key).Scan(ctx)
+	err := r.db.NewSelect().Model(&user).Relation("EncryptionKeys", orderByCreation).Relation("KeyHistory", orderBySequence).Relation("Devices", orderByCreation).Where("public_key = ?", public_key).Scan(ctx)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, utils.ErrUserNotFound
@@ -60,3 +99,259 @@ func (r *userRepository) GetByPublicKey(ctx context.Context, public_key string)
 	}
 	return &user, nil
 }
+
+func (r *userRepository) GetByFingerprint(ctx context.Context, fingerprint string) (*models.User, error) {
+	var user models.User
+	err := r.db.NewSelect().Model(&user).Relation("EncryptionKeys", orderByCreation).Relation("KeyHistory", orderBySequence).Relation("Devices", orderByCreation).Where("fingerprint = ?", fingerprint).Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrUserNotFound
+		}
+		slog.Error("Error while getting user", "operation", "get", "fingerprint", fingerprint, "error", err)
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *userRepository) CreateEncryptionKey(ctx context.Context, key *models.EncryptionKey) error {
+	_, err := r.db.NewInsert().Model(key).Exec(ctx)
+	if err != nil {
+		exists, existsErr := r.db.NewSelect().Model((*models.EncryptionKey)(nil)).Where("public_key = ?", key.PublicKey).Exists(ctx)
+		if existsErr == nil && exists {
+			return utils.ErrDuplicateEncryptionKey
+		}
+		slog.Error("Error while inserting encryption key", "operation", "create", "userID", key.UserID, "error", err)
+		return err
+	}
+	return nil
+}
+
+// RotateKey makes next the current identity key of user, retiring the key
+// user was loaded with. Users registered before key history was kept get
+// their current key recorded as the predecessor of next. Encryption keys
+// endorsed by a retired key are dropped: a key is rotated away when it may
+// be compromised, and whoever holds it could have endorsed their own.
+func (r *userRepository) RotateKey(ctx context.Context, user *models.User, next *models.UserKey) error {
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		taken, err := publicKeyTaken(ctx, tx, next.PublicKey)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return utils.ErrDuplicatePublicKey
+		}
+
+		res, err := tx.NewUpdate().
+			Model((*models.User)(nil)).
+			Set("public_key = ?", next.PublicKey).
+			Set("fingerprint = ?", Fingerprint(user.Algorithm, next.PublicKey)).
+			Where("id = ?", user.ID).
+			Where("public_key = ?", user.PublicKey).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return utils.ErrKeyRotationConflict
+		}
+
+		if len(user.KeyHistory) == 0 {
+			previous := &models.UserKey{
+				UserID:    user.ID,
+				Sequence:  next.Sequence - 1,
+				PublicKey: user.PublicKey,
+				CreatedAt: next.CreatedAt,
+			}
+			if _, err := tx.NewInsert().Model(previous).Exec(ctx); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.NewUpdate().
+			Model((*models.UserKey)(nil)).
+			Set("retired_at = ?", next.CreatedAt).
+			Where("user_id = ?", user.ID).
+			Where("retired_at IS NULL").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.NewInsert().Model(next).Exec(ctx); err != nil {
+			return utils.WrapError(utils.ErrKeyRotationConflict, err.Error())
+		}
+
+		_, err = tx.NewDelete().
+			Model((*models.EncryptionKey)(nil)).
+			Where("user_id = ?", user.ID).
+			Where("signed_by <> ?", next.PublicKey).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		// Pastes created before they carried an owner ID are claimed by
+		// the user now, as they can no longer be matched by signing key.
+		_, err = tx.NewUpdate().
+			Model((*models.Paste)(nil)).
+			Set("user_id = ?", user.ID).
+			Where("public_key = ?", user.PublicKey).
+			Where("user_id IS NULL").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return transparency.Append(ctx, tx, &models.LogEntry{
+			Type:      models.LogEntryRotate,
+			UserID:    user.ID,
+			Algorithm: verifier.Normalize(user.Algorithm),
+			PublicKey: next.PublicKey,
+			CreatedAt: next.CreatedAt,
+		})
+	})
+	if err != nil {
+		slog.Error("Error while rotating user key", "operation", "rotate", "userID", user.ID, "error", err)
+		return err
+	}
+	return nil
+}
+
+func (r *userRepository) GetDeviceByKey(ctx context.Context, publicKey string) (*models.DeviceKey, error) {
+	var device models.DeviceKey
+	err := r.db.NewSelect().Model(&device).Where("public_key = ?", publicKey).Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.ErrDeviceNotFound
+		}
+		slog.Error("Error while getting device", "operation", "get", "error", err)
+		return nil, err
+	}
+	return &device, nil
+}
+
+func (r *userRepository) CreateDevice(ctx context.Context, device *models.DeviceKey) error {
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		taken, err := publicKeyTaken(ctx, tx, device.PublicKey)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return utils.ErrDuplicatePublicKey
+		}
+		if _, err := tx.NewInsert().Model(device).Exec(ctx); err != nil {
+			return err
+		}
+		return transparency.Append(ctx, tx, &models.LogEntry{
+			Type:      models.LogEntryDeviceAdd,
+			UserID:    device.UserID,
+			DeviceID:  device.ID,
+			Algorithm: verifier.AlgorithmEd25519,
+			PublicKey: device.PublicKey,
+			CreatedAt: device.CreatedAt,
+		})
+	})
+	if err != nil {
+		slog.Error("Error while inserting device", "operation", "create", "userID", device.UserID, "error", err)
+		return err
+	}
+	return nil
+}
+
+func (r *userRepository) RevokeDevice(ctx context.Context, userID, deviceID string, revokedAt time.Time) error {
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewUpdate().
+			Model((*models.DeviceKey)(nil)).
+			Set("revoked_at = ?", revokedAt).
+			Where("id = ?", deviceID).
+			Where("user_id = ?", userID).
+			Where("revoked_at IS NULL").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return utils.ErrDeviceNotFound
+		}
+
+		var device models.DeviceKey
+		if err := tx.NewSelect().Model(&device).Where("id = ?", deviceID).Scan(ctx); err != nil {
+			return err
+		}
+		return transparency.Append(ctx, tx, &models.LogEntry{
+			Type:      models.LogEntryDeviceRevoke,
+			UserID:    userID,
+			DeviceID:  deviceID,
+			Algorithm: verifier.AlgorithmEd25519,
+			PublicKey: device.PublicKey,
+			CreatedAt: revokedAt,
+		})
+	})
+	if err != nil && !errors.Is(err, utils.ErrDeviceNotFound) {
+		slog.Error("Error while revoking device", "operation", "revoke", "deviceID", deviceID, "error", err)
+	}
+	return err
+}
+
+func (r *userRepository) CreatePasskey(ctx context.Context, passkey *models.Passkey) error {
+	_, err := r.db.NewInsert().Model(passkey).Exec(ctx)
+	if err != nil {
+		exists, existsErr := r.db.NewSelect().Model((*models.Passkey)(nil)).Where("id = ?", passkey.ID).Exists(ctx)
+		if existsErr == nil && exists {
+			return utils.ErrDuplicatePasskey
+		}
+		slog.Error("Error while inserting passkey", "operation", "create", "userID", passkey.UserID, "error", err)
+		return err
+	}
+	return nil
+}
+
+func (r *userRepository) ListPasskeys(ctx context.Context, userID string) ([]*models.Passkey, error) {
+	var passkeys []*models.Passkey
+	err := orderByCreation(r.db.NewSelect().Model(&passkeys).Where("user_id = ?", userID)).Scan(ctx)
+	if err != nil {
+		slog.Error("Error while listing passkeys", "operation", "list", "userID", userID, "error", err)
+		return nil, err
+	}
+	return passkeys, nil
+}
+
+// UpdatePasskeyUse records a login with passkey. The update only applies if
+// the stored counter is still the one passkey was loaded with, so two
+// concurrent logins cannot both accept the same counter value.
+func (r *userRepository) UpdatePasskeyUse(ctx context.Context, passkey *models.Passkey, signCount uint32, usedAt time.Time) error {
+	res, err := r.db.NewUpdate().
+		Model((*models.Passkey)(nil)).
+		Set("sign_count = ?", signCount).
+		Set("last_used_at = ?", usedAt).
+		Where("id = ?", passkey.ID).
+		Where("sign_count = ?", passkey.SignCount).
+		Exec(ctx)
+	if err != nil {
+		slog.Error("Error while updating passkey", "operation", "update", "userID", passkey.UserID, "error", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return utils.ErrPasskeyCloned
+	}
+	return nil
+}
+
+// publicKeyTaken reports whether publicKey is, or ever was, an identity or
+// device key of any user.
+func publicKeyTaken(ctx context.Context, tx bun.Tx, publicKey string) (bool, error) {
+	for _, model := range []any{(*models.User)(nil), (*models.UserKey)(nil), (*models.DeviceKey)(nil)} {
+		exists, err := tx.NewSelect().Model(model).Where("public_key = ?", publicKey).Exists(ctx)
+		if err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+func orderByCreation(q *bun.SelectQuery) *bun.SelectQuery {
+	return q.Order("created_at", "id")
+}
+
+func orderBySequence(q *bun.SelectQuery) *bun.SelectQuery {
+	return q.Order("sequence")
+}
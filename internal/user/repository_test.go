@@ -2,105 +2,338 @@ package user
 
 import (
 	"context"
-	"os"
 	"testing"
+	"time"
 
 	"Drop-Key/internal/db"
+	"Drop-Key/internal/db/dbtest"
 	"Drop-Key/internal/models"
+	"Drop-Key/internal/transparency"
+	"Drop-Key/internal/utils"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestNewUserRespository(t *testing.T) {
-	ctx := context.Background()
-	os.Setenv("DSN", "testuser:testpass@tcp(localhost:3306)/testdb?parseTime=true")
-	db, err := db.InitDB(ctx)
-	assert.NoError(t, err, "should initialise database")
-	assert.NotNil(t, db, "should return a not-nil database")
-	defer db.Close()
-
-	repo := NewUserRepository(db)
-	assert.NotNil(t, repo, "should return a not-nil repository.")
-	assert.Equal(t, db, repo.db, "should set correct database instance.")
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		db, err := db.InitDB(ctx)
+		assert.NoError(t, err, "should initialise database")
+		assert.NotNil(t, db, "should return a not-nil database")
+		defer db.Close()
+
+		repo := NewUserRepository(db)
+		assert.NotNil(t, repo, "should return a not-nil repository.")
+		assert.Equal(t, db, repo.db, "should set correct database instance.")
+	})
 }
 
 func TestCreate(t *testing.T) {
-	ctx := context.Background()
-	os.Setenv("DSN", "testuser:testpass@tcp(localhost:3306)/testdb?parseTime=true")
-	db, err := db.InitDB(ctx)
-	assert.NoError(t, err, "should initialise database")
-	assert.NotNil(t, db, "should return a not-nil database")
-	defer db.Close()
-
-	repo := NewUserRepository(db)
-	user := &models.User{
-		ID:        "user_id",
-		PublicKey: "public_key",
-	}
-
-	err = repo.Create(ctx, user)
-	assert.NoError(t, err, "should create user without error.")
-
-	var fetched models.User
-	err = db.NewSelect().Model(&fetched).Where("id = ?", user.ID).Scan(ctx)
-	assert.NoError(t, err, "should retrieve user")
-	assert.Equal(t, user.ID, fetched.ID, "id should match")
-	assert.Equal(t, user.PublicKey, fetched.PublicKey, "public_key should match.")
-
-	_, err = db.NewDropTable().Model(&models.User{}).IfExists().Exec(ctx)
-	assert.NoError(t, err, "should drop User table")
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		db, err := db.InitDB(ctx)
+		assert.NoError(t, err, "should initialise database")
+		assert.NotNil(t, db, "should return a not-nil database")
+		defer db.Close()
+
+		repo := NewUserRepository(db)
+		user := &models.User{
+			ID:        "user_id",
+			PublicKey: "public_key",
+		}
+
+		err = repo.Create(ctx, user)
+		assert.NoError(t, err, "should create user without error.")
+
+		var fetched models.User
+		err = db.NewSelect().Model(&fetched).Where("id = ?", user.ID).Scan(ctx)
+		assert.NoError(t, err, "should retrieve user")
+		assert.Equal(t, user.ID, fetched.ID, "id should match")
+		assert.Equal(t, user.PublicKey, fetched.PublicKey, "public_key should match.")
+
+		var history []models.UserKey
+		err = db.NewSelect().Model(&history).Where("user_id = ?", user.ID).Scan(ctx)
+		assert.NoError(t, err, "should retrieve key history")
+		if assert.Len(t, history, 1, "should record the first identity key") {
+			assert.Equal(t, user.PublicKey, history[0].PublicKey)
+			assert.Equal(t, 1, history[0].Sequence)
+		}
+
+		dbtest.Reset(t, db)
+	})
 }
 
 func TestGetUserById(t *testing.T) {
-	ctx := context.Background()
-	os.Setenv("DSN", "testuser:testpass@tcp(localhost:3306)/testdb?parseTime=true")
-	db, err := db.InitDB(ctx)
-	assert.NoError(t, err, "should initialise database")
-	assert.NotNil(t, db, "should return a not-nil database")
-	defer db.Close()
-
-	repo := NewUserRepository(db)
-	user := &models.User{
-		ID:        "user_id",
-		PublicKey: "public_key",
-	}
-
-	_, err = db.NewInsert().Model(user).Exec(ctx)
-	assert.NoError(t, err, "should create user without error.")
-
-	var fetched *models.User
-	fetched, err = repo.GetByID(ctx, user.ID)
-	assert.NoError(t, err, "should retirve user")
-	assert.Equal(t, user.ID, fetched.ID, "id should match")
-	assert.Equal(t, user.PublicKey, fetched.PublicKey, "public_key should match.")
-
-	_, err = db.NewDropTable().Model(&models.User{}).IfExists().Exec(ctx)
-	assert.NoError(t, err, "should drop User table")
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		db, err := db.InitDB(ctx)
+		assert.NoError(t, err, "should initialise database")
+		assert.NotNil(t, db, "should return a not-nil database")
+		defer db.Close()
+
+		repo := NewUserRepository(db)
+		user := &models.User{
+			ID:        "user_id",
+			PublicKey: "public_key",
+		}
+
+		_, err = db.NewInsert().Model(user).Exec(ctx)
+		assert.NoError(t, err, "should create user without error.")
+
+		var fetched *models.User
+		fetched, err = repo.GetByID(ctx, user.ID)
+		assert.NoError(t, err, "should retirve user")
+		assert.Equal(t, user.ID, fetched.ID, "id should match")
+		assert.Equal(t, user.PublicKey, fetched.PublicKey, "public_key should match.")
+
+		dbtest.Reset(t, db)
+	})
 }
 
 func TestGetUserByPublicKey(t *testing.T) {
-	ctx := context.Background()
-	os.Setenv("DSN", "testuser:testpass@tcp(localhost:3306)/testdb?parseTime=true")
-	db, err := db.InitDB(ctx)
-	assert.NoError(t, err, "should initialise database")
-	assert.NotNil(t, db, "should return a not-nil database")
-	defer db.Close()
-
-	repo := NewUserRepository(db)
-	user := &models.User{
-		ID:        "user_id",
-		PublicKey: "public_key",
-	}
-
-	_, err = db.NewInsert().Model(user).Exec(ctx)
-	assert.NoError(t, err, "should create user without error.")
-
-	var fetched *models.User
-	fetched, err = repo.GetByPublicKey(ctx, user.PublicKey)
-	assert.NoError(t, err, "should retirve user")
-	assert.Equal(t, user.ID, fetched.ID, "id should match")
-	assert.Equal(t, user.PublicKey, fetched.PublicKey, "public_key should match.")
-
-	_, err = db.NewDropTable().Model(&models.User{}).IfExists().Exec(ctx)
-	assert.NoError(t, err, "should drop User table")
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		db, err := db.InitDB(ctx)
+		assert.NoError(t, err, "should initialise database")
+		assert.NotNil(t, db, "should return a not-nil database")
+		defer db.Close()
+
+		repo := NewUserRepository(db)
+		user := &models.User{
+			ID:        "user_id",
+			PublicKey: "public_key",
+		}
+
+		_, err = db.NewInsert().Model(user).Exec(ctx)
+		assert.NoError(t, err, "should create user without error.")
+
+		var fetched *models.User
+		fetched, err = repo.GetByPublicKey(ctx, user.PublicKey)
+		assert.NoError(t, err, "should retirve user")
+		assert.Equal(t, user.ID, fetched.ID, "id should match")
+		assert.Equal(t, user.PublicKey, fetched.PublicKey, "public_key should match.")
+
+		dbtest.Reset(t, db)
+	})
+}
+
+func TestCreateEncryptionKey(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		db, err := db.InitDB(ctx)
+		assert.NoError(t, err, "should initialise database")
+		assert.NotNil(t, db, "should return a not-nil database")
+		defer db.Close()
+
+		repo := NewUserRepository(db)
+		user := &models.User{
+			ID:        "user_id",
+			PublicKey: "public_key",
+		}
+		_, err = db.NewInsert().Model(user).Exec(ctx)
+		assert.NoError(t, err, "should create user without error.")
+
+		key := &models.EncryptionKey{
+			ID:        "key_id",
+			UserID:    user.ID,
+			PublicKey: "encryption_key",
+			Signature: "signature",
+			SignedBy:  user.PublicKey,
+			CreatedAt: time.Now().UTC().Truncate(time.Second),
+		}
+		err = repo.CreateEncryptionKey(ctx, key)
+		assert.NoError(t, err, "should create encryption key")
+
+		duplicate := *key
+		duplicate.ID = "other_key_id"
+		err = repo.CreateEncryptionKey(ctx, &duplicate)
+		assert.ErrorIs(t, err, utils.ErrDuplicateEncryptionKey, "should reject a key registered twice")
+
+		fetched, err := repo.GetByID(ctx, user.ID)
+		assert.NoError(t, err, "should retrieve user")
+		if assert.Len(t, fetched.EncryptionKeys, 1, "should load encryption keys with the user") {
+			assert.Equal(t, key.PublicKey, fetched.EncryptionKeys[0].PublicKey)
+			assert.Equal(t, key.Signature, fetched.EncryptionKeys[0].Signature)
+		}
+
+		fetched, err = repo.GetByPublicKey(ctx, user.PublicKey)
+		assert.NoError(t, err, "should retrieve user")
+		assert.Len(t, fetched.EncryptionKeys, 1, "should load encryption keys with the user")
+
+		dbtest.Reset(t, db)
+	})
+}
+
+func TestRotateUserKey(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		db, err := db.InitDB(ctx)
+		assert.NoError(t, err, "should initialise database")
+		assert.NotNil(t, db, "should return a not-nil database")
+		defer db.Close()
+
+		repo := NewUserRepository(db)
+		legacy := &models.User{ID: "legacy_user_id", PublicKey: "legacy_key"}
+		_, err = db.NewInsert().Model(legacy).Exec(ctx)
+		assert.NoError(t, err, "should create user without key history")
+		other := &models.User{ID: "other_user_id", PublicKey: "other_key"}
+		assert.NoError(t, repo.Create(ctx, other), "should create user")
+
+		unowned := &models.Paste{
+			ID:         "legacy_paste",
+			Ciphertext: "encrypted-data",
+			Signature:  "signed-data",
+			PublicKey:  legacy.PublicKey,
+			ExpiresAt:  time.Now().UTC().Add(time.Hour).Truncate(time.Second),
+		}
+		_, err = db.NewInsert().Model(unowned).Exec(ctx)
+		assert.NoError(t, err, "should create paste without owner ID")
+
+		next := &models.UserKey{UserID: legacy.ID, Sequence: 2, PublicKey: "rotated_key", Signature: "endorsement", CreatedAt: time.Now().UTC().Truncate(time.Second)}
+		assert.NoError(t, repo.RotateKey(ctx, legacy, next), "should rotate key")
+
+		fetched, err := repo.GetByID(ctx, legacy.ID)
+		assert.NoError(t, err, "should retrieve user")
+		assert.Equal(t, "rotated_key", fetched.PublicKey, "new key should be current")
+		if assert.Len(t, fetched.KeyHistory, 2, "should record the predecessor and the new key") {
+			assert.Equal(t, "legacy_key", fetched.KeyHistory[0].PublicKey)
+			assert.False(t, fetched.KeyHistory[0].RetiredAt.IsZero(), "predecessor should be retired")
+			assert.Equal(t, "endorsement", fetched.KeyHistory[1].Signature)
+			assert.True(t, fetched.KeyHistory[1].RetiredAt.IsZero())
+		}
+
+		_, err = repo.GetByPublicKey(ctx, "legacy_key")
+		assert.ErrorIs(t, err, utils.ErrUserNotFound, "retired key should no longer find the user")
+
+		byFingerprint, err := repo.GetByFingerprint(ctx, Fingerprint(legacy.Algorithm, "rotated_key"))
+		assert.NoError(t, err, "rotation should store the new fingerprint")
+		assert.Equal(t, legacy.ID, byFingerprint.ID)
+
+		logged, err := transparency.NewLogRepository(db).LatestKeyEntry(ctx, legacy.ID, "rotated_key")
+		assert.NoError(t, err, "rotation should be logged")
+		assert.Equal(t, models.LogEntryRotate, logged.Type)
+
+		var claimed models.Paste
+		err = db.NewSelect().Model(&claimed).Where("id = ?", unowned.ID).Scan(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, legacy.ID, claimed.UserID, "pastes of the retired key should keep their owner")
+
+		stale := &models.UserKey{UserID: legacy.ID, Sequence: 3, PublicKey: "stale_key", Signature: "endorsement", CreatedAt: time.Now().UTC()}
+		err = repo.RotateKey(ctx, legacy, stale)
+		assert.ErrorIs(t, err, utils.ErrKeyRotationConflict, "should not rotate from a key that is no longer current")
+
+		taken := &models.UserKey{UserID: legacy.ID, Sequence: 3, PublicKey: "legacy_key", Signature: "endorsement", CreatedAt: time.Now().UTC()}
+		err = repo.RotateKey(ctx, fetched, taken)
+		assert.ErrorIs(t, err, utils.ErrDuplicatePublicKey, "should not reuse a retired key")
+
+		dbtest.Reset(t, db)
+	})
+}
+
+func TestDeviceKeys(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		db, err := db.InitDB(ctx)
+		assert.NoError(t, err, "should initialise database")
+		assert.NotNil(t, db, "should return a not-nil database")
+		defer db.Close()
+
+		repo := NewUserRepository(db)
+		user := &models.User{ID: "device_user_id", PublicKey: "primary_key"}
+		assert.NoError(t, repo.Create(ctx, user), "should create user")
+
+		device := &models.DeviceKey{
+			ID:           "device_id",
+			UserID:       user.ID,
+			Name:         "laptop",
+			PublicKey:    "device_key",
+			AuthorizedBy: user.PublicKey,
+			Signature:    "authorization",
+			CreatedAt:    time.Now().UTC().Truncate(time.Second),
+		}
+		assert.NoError(t, repo.CreateDevice(ctx, device), "should create device")
+
+		duplicate := *device
+		duplicate.ID = "other_device_id"
+		assert.ErrorIs(t, repo.CreateDevice(ctx, &duplicate), utils.ErrDuplicatePublicKey, "should not add a key twice")
+		duplicate.PublicKey = user.PublicKey
+		assert.ErrorIs(t, repo.CreateDevice(ctx, &duplicate), utils.ErrDuplicatePublicKey, "should not add the primary key as a device")
+		assert.ErrorIs(t, repo.Create(ctx, &models.User{ID: "other_user_id", PublicKey: "device_key"}), utils.ErrDuplicatePublicKey, "should not register a device key as a user")
+
+		fetched, err := repo.GetByID(ctx, user.ID)
+		assert.NoError(t, err, "should retrieve user")
+		if assert.Len(t, fetched.Devices, 1, "should load devices with the user") {
+			assert.Equal(t, "laptop", fetched.Devices[0].Name)
+		}
+
+		found, err := repo.GetDeviceByKey(ctx, "device_key")
+		assert.NoError(t, err, "should find device by key")
+		assert.Equal(t, device.ID, found.ID)
+		_, err = repo.GetDeviceByKey(ctx, "unknown_key")
+		assert.ErrorIs(t, err, utils.ErrDeviceNotFound)
+
+		assert.NoError(t, repo.RevokeDevice(ctx, user.ID, device.ID, time.Now().UTC()), "should revoke device")
+		assert.ErrorIs(t, repo.RevokeDevice(ctx, user.ID, device.ID, time.Now().UTC()), utils.ErrDeviceNotFound, "should not revoke twice")
+		found, err = repo.GetDeviceByKey(ctx, "device_key")
+		assert.NoError(t, err, "revoked devices should be kept")
+		assert.False(t, found.RevokedAt.IsZero(), "revocation time should be recorded")
+
+		entries, err := transparency.NewLogRepository(db).Entries(ctx, 0, 1000)
+		assert.NoError(t, err, "should list log entries")
+		var logged []string
+		for _, entry := range entries {
+			if entry.UserID == user.ID {
+				logged = append(logged, entry.Type)
+			}
+		}
+		assert.Equal(t, []string{models.LogEntryRegister, models.LogEntryDeviceAdd, models.LogEntryDeviceRevoke}, logged, "should log every key change in order")
+
+		dbtest.Reset(t, db)
+	})
+}
+
+func TestPasskeys(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) {
+		ctx := context.Background()
+		db, err := db.InitDB(ctx)
+		assert.NoError(t, err, "should initialise database")
+		assert.NotNil(t, db, "should return a not-nil database")
+		defer db.Close()
+
+		repo := NewUserRepository(db)
+		user := &models.User{ID: "passkey_user_id", PublicKey: "passkey_user_key"}
+		assert.NoError(t, repo.Create(ctx, user), "should create user")
+
+		passkey := &models.Passkey{
+			ID:        "credential_id",
+			UserID:    user.ID,
+			PublicKey: "cose_key",
+			Algorithm: -7,
+			SignCount: 1,
+			CreatedAt: time.Now().UTC().Truncate(time.Second),
+		}
+		assert.NoError(t, repo.CreatePasskey(ctx, passkey), "should create passkey")
+		assert.ErrorIs(t, repo.CreatePasskey(ctx, passkey), utils.ErrDuplicatePasskey, "should not register a credential twice")
+
+		passkeys, err := repo.ListPasskeys(ctx, user.ID)
+		assert.NoError(t, err, "should list passkeys")
+		if assert.Len(t, passkeys, 1) {
+			assert.Equal(t, passkey.PublicKey, passkeys[0].PublicKey)
+			assert.Equal(t, -7, passkeys[0].Algorithm)
+		}
+		passkeys, err = repo.ListPasskeys(ctx, "other_user_id")
+		assert.NoError(t, err)
+		assert.Empty(t, passkeys, "should only list the user's passkeys")
+
+		assert.NoError(t, repo.UpdatePasskeyUse(ctx, passkey, 2, time.Now().UTC()), "should record use")
+		assert.ErrorIs(t, repo.UpdatePasskeyUse(ctx, passkey, 3, time.Now().UTC()), utils.ErrPasskeyCloned, "should not update from a stale counter")
+		passkeys, err = repo.ListPasskeys(ctx, user.ID)
+		assert.NoError(t, err)
+		if assert.Len(t, passkeys, 1) {
+			assert.Equal(t, uint32(2), passkeys[0].SignCount)
+			assert.False(t, passkeys[0].LastUsedAt.IsZero(), "last use should be recorded")
+		}
+
+		dbtest.Reset(t, db)
+	})
 }
@@ -0,0 +1,36 @@
+package user
+
+import (
+	"strconv"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+	"Drop-Key/internal/verifier"
+)
+
+// RotationStatement is the message the current identity key signs to endorse
+// its successor. sequence is the position the new key takes in the user's key
+// history, so an endorsement cannot be replayed later in the chain.
+func RotationStatement(userID string, sequence int, oldPublicKey, newPublicKey string) []byte {
+	return []byte("DropKey key rotation v1:" + userID + ":" + strconv.Itoa(sequence) + ":" + oldPublicKey + ":" + newPublicKey)
+}
+
+// VerifyKeyHistory checks that every key in history, ordered by sequence, is
+// endorsed by the key before it. The first key is taken on trust. All keys
+// of a user share the algorithm of their current key.
+func VerifyKeyHistory(userID, algorithm string, history []*models.UserKey) error {
+	for i := 1; i < len(history); i++ {
+		previous, next := history[i-1], history[i]
+		if next.Sequence != previous.Sequence+1 {
+			return utils.ErrInvalidSignature
+		}
+		if err := verifyRotation(userID, algorithm, previous.PublicKey, next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifyRotation(userID, algorithm, oldPublicKey string, next *models.UserKey) error {
+	return verifier.Default.Verify(algorithm, oldPublicKey, RotationStatement(userID, next.Sequence, oldPublicKey, next.PublicKey), next.Signature)
+}
@@ -0,0 +1,112 @@
+package user
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+	"Drop-Key/internal/verifier"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newIdentityKey(t *testing.T) (string, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err, "should generate ed25519 key")
+	return base64.StdEncoding.EncodeToString(pub), priv
+}
+
+func TestRotateKey(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestUserRepository(t)
+	service := NewUserService(repo, NewInMemoryChallengeStore())
+
+	oldKey, oldPriv := newIdentityKey(t)
+	newKey, newPriv := newIdentityKey(t)
+	userID, err := service.Create(ctx, &models.User{PublicKey: oldKey})
+	assert.NoError(t, err, "should create user")
+
+	endorse := func(priv ed25519.PrivateKey, sequence int, from, to string) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, RotationStatement(userID, sequence, from, to)))
+	}
+	authenticate := func(priv ed25519.PrivateKey) error {
+		challenge, _, err := service.IssueChallenge(ctx, userID)
+		assert.NoError(t, err, "should issue challenge")
+		decoded, _ := base64.StdEncoding.DecodeString(challenge)
+		signature := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, decoded))
+		_, err = service.Authenticate(ctx, userID, signature, challenge)
+		return err
+	}
+
+	t.Run("endorsement by another key", func(t *testing.T) {
+		_, err := service.RotateKey(ctx, userID, newKey, endorse(newPriv, 2, oldKey, newKey))
+		assert.ErrorIs(t, err, utils.ErrInvalidSignature)
+	})
+
+	t.Run("endorsement for another position", func(t *testing.T) {
+		_, err := service.RotateKey(ctx, userID, newKey, endorse(oldPriv, 3, oldKey, newKey))
+		assert.ErrorIs(t, err, utils.ErrInvalidSignature)
+	})
+
+	encryptionKey := newX25519Key(t)
+	bind := func(priv ed25519.PrivateKey) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, EncryptionKeyStatement(userID, encryptionKey)))
+	}
+	_, err = service.AddEncryptionKey(ctx, userID, encryptionKey, bind(oldPriv))
+	assert.NoError(t, err, "should add encryption key")
+
+	t.Run("rotate", func(t *testing.T) {
+		user, err := service.RotateKey(ctx, userID, newKey, endorse(oldPriv, 2, oldKey, newKey))
+		assert.NoError(t, err, "should rotate key")
+		assert.Equal(t, userID, user.ID, "should keep the user ID")
+		assert.Equal(t, newKey, user.PublicKey, "should make the new key current")
+		if assert.Len(t, user.KeyHistory, 2) {
+			assert.Equal(t, oldKey, user.KeyHistory[0].PublicKey)
+			assert.False(t, user.KeyHistory[0].RetiredAt.IsZero(), "old key should be retired")
+			assert.True(t, user.KeyHistory[1].RetiredAt.IsZero(), "new key should be current")
+		}
+		assert.NoError(t, VerifyKeyHistory(userID, verifier.AlgorithmEd25519, user.KeyHistory), "history should verify")
+		assert.Empty(t, user.EncryptionKeys, "encryption keys endorsed by the old key should be dropped")
+
+		_, err = service.AddEncryptionKey(ctx, userID, encryptionKey, bind(oldPriv))
+		assert.ErrorIs(t, err, utils.ErrInvalidSignature, "the old key should no longer endorse encryption keys")
+		key, err := service.AddEncryptionKey(ctx, userID, encryptionKey, bind(newPriv))
+		if assert.NoError(t, err, "the new key should endorse the encryption key again") {
+			assert.Equal(t, newKey, key.SignedBy)
+		}
+	})
+
+	t.Run("only the current key authenticates", func(t *testing.T) {
+		assert.ErrorIs(t, authenticate(oldPriv), utils.ErrInvalidSignature, "retired key should be rejected")
+		assert.NoError(t, authenticate(newPriv), "current key should be accepted")
+	})
+
+	t.Run("replayed endorsement", func(t *testing.T) {
+		_, err := service.RotateKey(ctx, userID, newKey, endorse(oldPriv, 2, oldKey, newKey))
+		assert.ErrorIs(t, err, utils.ErrDuplicatePublicKey)
+
+		thirdKey, _ := newIdentityKey(t)
+		_, err = service.RotateKey(ctx, userID, thirdKey, endorse(oldPriv, 3, oldKey, thirdKey))
+		assert.ErrorIs(t, err, utils.ErrInvalidSignature, "retired key should not endorse successors")
+	})
+
+	t.Run("key of another user", func(t *testing.T) {
+		otherKey, _ := newIdentityKey(t)
+		_, err := service.Create(ctx, &models.User{PublicKey: otherKey})
+		assert.NoError(t, err)
+		_, err = service.RotateKey(ctx, userID, otherKey, endorse(newPriv, 3, newKey, otherKey))
+		assert.ErrorIs(t, err, utils.ErrDuplicatePublicKey)
+	})
+
+	t.Run("tampered history", func(t *testing.T) {
+		user, err := service.GetByID(ctx, userID)
+		assert.NoError(t, err)
+		forged := *user.KeyHistory[1]
+		forged.PublicKey, _ = newIdentityKey(t)
+		assert.ErrorIs(t, VerifyKeyHistory(userID, verifier.AlgorithmEd25519, []*models.UserKey{user.KeyHistory[0], &forged}), utils.ErrInvalidSignature)
+	})
+}
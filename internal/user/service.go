@@ -6,27 +6,41 @@ import (
 	"encoding/base64"
 	"errors"
 	"log/slog"
+	"time"
 
 	"Drop-Key/internal/models"
 	"Drop-Key/internal/utils"
+	"Drop-Key/internal/verifier"
 
 	"github.com/google/uuid"
 )
 
 type UserService interface {
 	Create(ctx context.Context, user *models.User) (string, error)
+	IssueChallenge(ctx context.Context, userID string) (string, time.Time, error)
 	Authenticate(ctx context.Context, userID, signature, challenge string) (bool, error)
+	AuthenticateKey(ctx context.Context, userID, signature, challenge string) (string, error)
 	GetByID(ctx context.Context, id string) (*models.User, error)
 	GetByPublicKey(ctx context.Context, publicKey string) (*models.User, error)
+	GetByFingerprint(ctx context.Context, fingerprint string) (*models.User, error)
+	SafetyNumber(ctx context.Context, userID, otherID string) (*SafetyNumberResponse, error)
+	AddEncryptionKey(ctx context.Context, userID, publicKey, signature string) (*models.EncryptionKey, error)
+	RotateKey(ctx context.Context, userID, newPublicKey, signature string) (*models.User, error)
+	AddDevice(ctx context.Context, userID, name, publicKey, authorizedBy, signature string) (*models.DeviceKey, error)
+	RevokeDevice(ctx context.Context, userID, deviceID, authorizedBy, signature string) error
 }
 
 type userService struct {
-	repo UserRepository
+	repo         UserRepository
+	challenges   ChallengeStore
+	challengeTTL time.Duration
 }
 
-func NewUserService(repo UserRepository) *userService {
+func NewUserService(repo UserRepository, challenges ChallengeStore) *userService {
 	return &userService{
-		repo: repo,
+		repo:         repo,
+		challenges:   challenges,
+		challengeTTL: DefaultChallengeTTL,
 	}
 }
 
@@ -35,13 +49,20 @@ func (u *userService) Create(ctx context.Context, user *models.User) (string, er
 		return "", utils.WrapError(utils.ErrEmptyPublicKey, "Cannot create user, error")
 	}
 
-	publicKey, err := base64.StdEncoding.DecodeString(user.PublicKey)
-	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+	// OpenSSH keys are stored like any other Ed25519 key.
+	user.Algorithm = verifier.Normalize(user.Algorithm)
+	if _, err := verifier.Default.Lookup(user.Algorithm); err != nil {
+		return "", utils.WrapError(err, "Cannot create user, error")
+	}
+	publicKey, err := normalizeUserKey(user.Algorithm, user.PublicKey)
+	if err != nil {
 		return "", utils.WrapError(utils.ErrInvalidPublicKey, "Cannot create user, error")
 	}
+	user.PublicKey = publicKey
+	user.Fingerprint = Fingerprint(user.Algorithm, publicKey)
 
-	existingUser, err := u.repo.GetByPublicKey(ctx, base64.StdEncoding.EncodeToString(publicKey))
-	slog.Info("public key in create user", "publicKey", base64.StdEncoding.EncodeToString(publicKey))
+	existingUser, err := u.repo.GetByPublicKey(ctx, publicKey)
+	slog.Info("public key in create user", "publicKey", publicKey)
 	if err == nil && existingUser != nil {
 		slog.Info("after get by public key", "userID", existingUser.ID)
 		return "", utils.WrapError(utils.ErrDuplicatePublicKey, "Cannot create user, error")
@@ -52,6 +73,9 @@ func (u *userService) Create(ctx context.Context, user *models.User) (string, er
 
 	user.ID = uuid.NewString()
 	err = u.repo.Create(ctx, user)
+	if errors.Is(err, utils.ErrDuplicatePublicKey) {
+		return "", utils.WrapError(err, "Cannot create user, error")
+	}
 	if err != nil {
 		return "", utils.WrapError(utils.ErrUserCreationFailed, "Failed to create user")
 	}
@@ -59,46 +83,110 @@ func (u *userService) Create(ctx context.Context, user *models.User) (string, er
 	return user.ID, nil
 }
 
-func (u *userService) Authenticate(ctx context.Context, userID, signature, challenge string) (bool, error) {
+func (u *userService) IssueChallenge(ctx context.Context, userID string) (string, time.Time, error) {
 	if err := ctx.Err(); err != nil {
+		return "", time.Time{}, err
+	}
+	user, err := u.GetByID(ctx, userID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	challenge, err := newChallenge()
+	if err != nil {
+		return "", time.Time{}, utils.WrapError(err, "Cannot generate challenge")
+	}
+	expiresAt := time.Now().Add(u.challengeTTL)
+	if err := u.challenges.Save(ctx, user.ID, challenge, expiresAt); err != nil {
+		return "", time.Time{}, utils.WrapError(err, "Cannot store challenge")
+	}
+	return challenge, expiresAt, nil
+}
+
+func (u *userService) Authenticate(ctx context.Context, userID, signature, challenge string) (bool, error) {
+	if _, err := u.AuthenticateKey(ctx, userID, signature, challenge); err != nil {
 		return false, err
 	}
+	return true, nil
+}
+
+// AuthenticateKey checks the challenge signature against the user's primary
+// key and the keys of their active devices, and returns the key that signed,
+// which the session is then bound to.
+func (u *userService) AuthenticateKey(ctx context.Context, userID, signature, challenge string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	if userID == "" {
-		return false, utils.WrapError(utils.ErrEmptyUserID, "Authentication failed, error ")
+		return "", utils.WrapError(utils.ErrEmptyUserID, "Authentication failed, error ")
 	}
 	if challenge == "" {
-		return false, utils.WrapError(utils.ErrValidationError, "Auth failed, challenge empty, error ")
+		return "", utils.WrapError(utils.ErrValidationError, "Auth failed, challenge empty, error ")
 	}
 
 	if signature == "" {
-		return false, utils.WrapError(utils.ErrEmptySignature, "Auth failed, error ")
+		return "", utils.WrapError(utils.ErrEmptySignature, "Auth failed, error ")
 	}
-	sig, err := base64.StdEncoding.DecodeString(signature)
-	if err != nil || len(sig) != ed25519.SignatureSize {
-		return false, utils.WrapError(utils.ErrInvalidSignature, "Auth failed, error ")
+	sshSig, err := parseSSHSignature(signature)
+	if err != nil {
+		return "", utils.WrapError(err, "Auth failed, invalid SSH signature")
+	}
+	var sig []byte
+	if sshSig == nil {
+		sig, err = base64.StdEncoding.DecodeString(signature)
+		if err != nil {
+			return "", utils.WrapError(utils.ErrInvalidSignature, "Auth failed, error ")
+		}
 	}
 
 	user, err := u.repo.GetByID(ctx, userID)
 	if err != nil {
-		return false, utils.WrapError(utils.ErrUserNotFound, "Auth failed, error ")
+		return "", utils.WrapError(utils.ErrUserNotFound, "Auth failed, error ")
 	}
-	pubKeyBytes, err := base64.StdEncoding.DecodeString(user.PublicKey)
-	if err != nil || len(pubKeyBytes) != ed25519.PublicKeySize {
-		return false, utils.WrapError(utils.ErrInvalidPublicKey, "Auth failed, public key invalid")
+	algorithm := verifier.Normalize(user.Algorithm)
+	pubKeyBytes, err := verifier.Default.DecodePublicKey(algorithm, user.PublicKey)
+	if err != nil {
+		return "", utils.WrapError(utils.ErrInvalidPublicKey, "Auth failed, public key invalid")
 	}
 
 	decodedChallenge, err := base64.StdEncoding.DecodeString(challenge)
 	if err != nil {
-		return false, utils.WrapError(utils.ErrValidationError, "Auth failed, invalid base64 challenge")
+		return "", utils.WrapError(utils.ErrValidationError, "Auth failed, invalid base64 challenge")
 	}
 
-	ok := ed25519.Verify(pubKeyBytes, decodedChallenge, sig)
+	// Either a raw signature of the challenge bytes, or for Ed25519 keys an
+	// SSH signature of them in the DropKey namespace.
+	signs := func(algorithm string, pub []byte) bool {
+		if sshSig != nil {
+			return algorithm == verifier.AlgorithmEd25519 && sshSig.verify(pub, decodedChallenge) == nil
+		}
+		v, err := verifier.Default.Lookup(algorithm)
+		return err == nil && v.ValidateSignature(sig) == nil && v.Verify(pub, decodedChallenge, sig)
+	}
 
-	if !ok {
-		return false, utils.WrapError(utils.ErrInvalidSignature, "Auth failed, error ")
+	signedBy := ""
+	if signs(algorithm, pubKeyBytes) {
+		signedBy = user.PublicKey
+	}
+	for _, device := range user.Devices {
+		if signedBy != "" {
+			break
+		}
+		if pub, err := decodePublicKey(device.PublicKey); err == nil && device.RevokedAt.IsZero() && signs(verifier.AlgorithmEd25519, pub) {
+			signedBy = device.PublicKey
+		}
+	}
+	if signedBy == "" {
+		return "", utils.WrapError(utils.ErrInvalidSignature, "Auth failed, error ")
 	}
 
-	return true, nil
+	// The challenge is only redeemed once the signature checks out, so a
+	// forged attempt cannot burn a challenge issued to the real user.
+	if err := u.challenges.Consume(ctx, user.ID, challenge); err != nil {
+		return "", utils.WrapError(err, "Auth failed, challenge rejected")
+	}
+
+	return signedBy, nil
 }
 
 func (u *userService) GetByPublicKey(ctx context.Context, publicKey string) (*models.User, error) {
@@ -109,8 +197,9 @@ func (u *userService) GetByPublicKey(ctx context.Context, publicKey string) (*mo
 	if publicKey == "" {
 		return nil, utils.WrapError(utils.ErrEmptyPublicKey, "Cannot get user by publicKey, error ")
 	}
-	pub, err := base64.StdEncoding.DecodeString(publicKey)
-	if err != nil || len(pub) != ed25519.PublicKeySize {
+	if normalized, err := normalizePublicKey(publicKey); err == nil {
+		publicKey = normalized
+	} else if !verifier.Default.IsPublicKey(publicKey) {
 		return nil, utils.WrapError(utils.ErrInvalidPublicKey, "Cannot get user by publicKey, error ")
 	}
 
@@ -119,7 +208,52 @@ func (u *userService) GetByPublicKey(ctx context.Context, publicKey string) (*mo
 		return nil, utils.WrapError(utils.ErrUserNotFound, "Cannot get user by public key")
 	}
 
-	return user, nil
+	return withFingerprint(withX25519Key(user)), nil
+}
+
+func (u *userService) GetByFingerprint(ctx context.Context, fingerprint string) (*models.User, error) {
+	fingerprint, err := ParseFingerprint(fingerprint)
+	if err != nil {
+		return nil, utils.WrapError(err, "Cannot get user by fingerprint")
+	}
+
+	user, err := u.repo.GetByFingerprint(ctx, fingerprint)
+	if err != nil {
+		return nil, utils.WrapError(utils.ErrUserNotFound, "Cannot get user by fingerprint")
+	}
+
+	return withFingerprint(withX25519Key(user)), nil
+}
+
+// SafetyNumberResponse is the safety number of two users together with the
+// fingerprint of each user's current key.
+type SafetyNumberResponse struct {
+	SafetyNumber string            `json:"safety_number"`
+	Fingerprints map[string]string `json:"fingerprints"`
+}
+
+// SafetyNumber computes the safety number userID and otherID compare to
+// verify each other's identity keys out of band.
+func (u *userService) SafetyNumber(ctx context.Context, userID, otherID string) (*SafetyNumberResponse, error) {
+	if userID == otherID {
+		return nil, utils.WrapError(utils.ErrInvalidUserID, "Cannot compare a user with themselves")
+	}
+	user, err := u.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	other, err := u.GetByID(ctx, otherID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SafetyNumberResponse{
+		SafetyNumber: SafetyNumber(user, other),
+		Fingerprints: map[string]string{
+			user.ID:  user.Fingerprint,
+			other.ID: other.Fingerprint,
+		},
+	}, nil
 }
 
 func (u *userService) GetByID(ctx context.Context, id string) (*models.User, error) {
@@ -137,5 +271,179 @@ func (u *userService) GetByID(ctx context.Context, id string) (*models.User, err
 		return nil, utils.WrapError(utils.ErrUserNotFound, "cannot get user by id, error")
 	}
 
-	return user, nil
+	return withFingerprint(withX25519Key(user)), nil
+}
+
+// AddEncryptionKey publishes an X25519 encryption key for the user after
+// checking that the user signed EncryptionKeyStatement with their identity key.
+func (u *userService) AddEncryptionKey(ctx context.Context, userID, publicKey, signature string) (*models.EncryptionKey, error) {
+	user, err := u.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := decodeX25519Key(publicKey)
+	if err != nil {
+		return nil, utils.WrapError(err, "Cannot add encryption key")
+	}
+	if signature == "" {
+		return nil, utils.WrapError(utils.ErrEmptySignature, "Cannot add encryption key")
+	}
+
+	encryptionKey := &models.EncryptionKey{
+		ID:        uuid.NewString(),
+		UserID:    user.ID,
+		PublicKey: base64.StdEncoding.EncodeToString(key),
+		Signature: signature,
+		SignedBy:  user.PublicKey,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := VerifyEncryptionKey(encryptionKey, user.Algorithm); err != nil {
+		return nil, utils.WrapError(err, "Cannot add encryption key")
+	}
+	if len(user.EncryptionKeys) >= maxEncryptionKeys {
+		return nil, utils.WrapError(utils.ErrTooManyEncryptionKeys, "Cannot add encryption key")
+	}
+
+	if err := u.repo.CreateEncryptionKey(ctx, encryptionKey); err != nil {
+		return nil, utils.WrapError(err, "Cannot add encryption key")
+	}
+	return encryptionKey, nil
+}
+
+// RotateKey replaces the user's identity key with newPublicKey, endorsed by
+// a signature of the current key over RotationStatement. The user keeps their
+// ID, and with it their pastes; only the new key can authenticate afterwards.
+// Encryption keys endorsed by the old key are dropped and have to be
+// endorsed again by the new one.
+func (u *userService) RotateKey(ctx context.Context, userID, newPublicKey, signature string) (*models.User, error) {
+	user, err := u.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if newPublicKey == "" {
+		return nil, utils.WrapError(utils.ErrEmptyPublicKey, "Cannot rotate key")
+	}
+	// The successor uses the algorithm of the key it replaces.
+	newPublicKey, err = normalizeUserKey(user.Algorithm, newPublicKey)
+	if err != nil {
+		return nil, utils.WrapError(utils.ErrInvalidPublicKey, "Cannot rotate key")
+	}
+	if newPublicKey == user.PublicKey {
+		return nil, utils.WrapError(utils.ErrDuplicatePublicKey, "Cannot rotate key")
+	}
+	if signature == "" {
+		return nil, utils.WrapError(utils.ErrEmptySignature, "Cannot rotate key")
+	}
+
+	sequence := 2
+	if n := len(user.KeyHistory); n > 0 {
+		sequence = user.KeyHistory[n-1].Sequence + 1
+	}
+	next := &models.UserKey{
+		UserID:    user.ID,
+		Sequence:  sequence,
+		PublicKey: newPublicKey,
+		Signature: signature,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := verifyRotation(user.ID, user.Algorithm, user.PublicKey, next); err != nil {
+		return nil, utils.WrapError(err, "Cannot rotate key")
+	}
+
+	if _, err := u.repo.GetByPublicKey(ctx, newPublicKey); err == nil {
+		return nil, utils.WrapError(utils.ErrDuplicatePublicKey, "Cannot rotate key")
+	}
+	if err := u.repo.RotateKey(ctx, user, next); err != nil {
+		return nil, utils.WrapError(err, "Cannot rotate key")
+	}
+
+	slog.Info("Rotated identity key", "userID", user.ID, "sequence", sequence)
+	return u.GetByID(ctx, user.ID)
+}
+
+// AddDevice lets the device key publicKey act for the user. authorizedBy must
+// be an active key of the user and sign DeviceStatement for the new key.
+func (u *userService) AddDevice(ctx context.Context, userID, name, publicKey, authorizedBy, signature string) (*models.DeviceKey, error) {
+	user, err := u.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" || len(name) > maxDeviceNameSize {
+		return nil, utils.WrapError(utils.ErrInvalidDeviceName, "Cannot add device")
+	}
+	if publicKey == "" {
+		return nil, utils.WrapError(utils.ErrEmptyPublicKey, "Cannot add device")
+	}
+	pub, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, utils.WrapError(utils.ErrInvalidPublicKey, "Cannot add device")
+	}
+	publicKey = base64.StdEncoding.EncodeToString(pub)
+	if signature == "" {
+		return nil, utils.WrapError(utils.ErrEmptySignature, "Cannot add device")
+	}
+	if !activeKey(user, authorizedBy) {
+		return nil, utils.WrapError(utils.ErrInvalidSignature, "Cannot add device, authorizing key is not active")
+	}
+	if err := verifySignature(keyAlgorithm(user, authorizedBy), authorizedBy, DeviceStatement(DeviceActionAdd, user.ID, publicKey), signature); err != nil {
+		return nil, utils.WrapError(err, "Cannot add device")
+	}
+	if activeDevices(user) >= maxDevices {
+		return nil, utils.WrapError(utils.ErrTooManyDevices, "Cannot add device")
+	}
+
+	device := &models.DeviceKey{
+		ID:           uuid.NewString(),
+		UserID:       user.ID,
+		Name:         name,
+		PublicKey:    publicKey,
+		AuthorizedBy: authorizedBy,
+		Signature:    signature,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if err := u.repo.CreateDevice(ctx, device); err != nil {
+		return nil, utils.WrapError(err, "Cannot add device")
+	}
+	slog.Info("Added device", "userID", user.ID, "deviceID", device.ID)
+	return device, nil
+}
+
+// RevokeDevice stops the device deviceID from acting for the user. Any active
+// key of the user, including the device's own, can revoke it.
+func (u *userService) RevokeDevice(ctx context.Context, userID, deviceID, authorizedBy, signature string) error {
+	user, err := u.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	var device *models.DeviceKey
+	for _, candidate := range user.Devices {
+		if candidate.ID == deviceID {
+			device = candidate
+		}
+	}
+	if device == nil {
+		return utils.WrapError(utils.ErrDeviceNotFound, "Cannot revoke device")
+	}
+	if !device.RevokedAt.IsZero() {
+		return utils.WrapError(utils.ErrDeviceRevoked, "Cannot revoke device")
+	}
+	if signature == "" {
+		return utils.WrapError(utils.ErrEmptySignature, "Cannot revoke device")
+	}
+	if !activeKey(user, authorizedBy) {
+		return utils.WrapError(utils.ErrInvalidSignature, "Cannot revoke device, authorizing key is not active")
+	}
+	if err := verifySignature(keyAlgorithm(user, authorizedBy), authorizedBy, DeviceStatement(DeviceActionRevoke, user.ID, device.PublicKey), signature); err != nil {
+		return utils.WrapError(err, "Cannot revoke device")
+	}
+
+	if err := u.repo.RevokeDevice(ctx, user.ID, device.ID, time.Now().UTC()); err != nil {
+		return utils.WrapError(err, "Cannot revoke device")
+	}
+	slog.Info("Revoked device", "userID", user.ID, "deviceID", device.ID)
+	return nil
 }
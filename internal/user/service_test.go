@@ -4,48 +4,39 @@ import (
 	"context"
 	"crypto/ed25519"
 	"encoding/base64"
-	"os"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
-	"Drop-Key/internal/db"
 	"Drop-Key/internal/models"
+	"Drop-Key/internal/transparency"
 	"Drop-Key/internal/utils"
 
 	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 )
 
 var publicKey string
 
-func setupTestService(t *testing.T) (*userService, context.Context, func()) {
+func setupTestService(t *testing.T) (*userService, context.Context) {
 	t.Helper()
 
-	ctx := context.Background()
-	os.Setenv("DSN", "testuser:testpass@tcp(localhost:3306)/testdb?parseTime=true")
-	db, err := db.InitDB(ctx)
-	assert.NoError(t, err, "should initialise db without error")
-
-	userRepo := NewUserRepository(db)
-	userService := NewUserService(userRepo)
-	cleanup := func() {
-		_, err = db.NewDropTable().Model(&models.User{}).IfExists().Exec(ctx)
-		assert.NoError(t, err, "should drop User table")
-		db.Close()
-	}
-
-	return userService, ctx, cleanup
+	userRepo := NewInMemoryUserRepository(transparency.NewInMemoryLogRepository())
+	return NewUserService(userRepo, NewInMemoryChallengeStore()), context.Background()
 }
 
 func TestNewUserService(t *testing.T) {
-	userService, _, cleanup := setupTestService(t)
-	defer cleanup()
+	userService, _ := setupTestService(t)
 
 	assert.NotNil(t, userService, "should return a not nil userService")
 }
 
 func TestCreateUser(t *testing.T) {
-	userService, ctx, cleanup := setupTestService(t)
-	defer cleanup()
+	userService, ctx := setupTestService(t)
 
 	t.Run("valid user", func(t *testing.T) {
 		pub, _, err := ed25519.GenerateKey(nil)
@@ -90,8 +81,7 @@ func TestCreateUser(t *testing.T) {
 }
 
 func TestGetByID(t *testing.T) {
-	userService, ctx, cleanup := setupTestService(t)
-	defer cleanup()
+	userService, ctx := setupTestService(t)
 
 	t.Run("valid user id", func(t *testing.T) {
 		pub, _, err := ed25519.GenerateKey(nil)
@@ -124,16 +114,12 @@ func TestGetByID(t *testing.T) {
 }
 
 func TestAuthenticate(t *testing.T) {
-	userService, ctx, cleanup := setupTestService(t)
-	defer cleanup()
+	userService, ctx := setupTestService(t)
 
 	t.Run("valid user id", func(t *testing.T) {
 		pub, priv, err := ed25519.GenerateKey(nil)
 		assert.NoError(t, err, "should generate ed25519 key without error")
 		validPublicKey := base64.StdEncoding.EncodeToString(pub)
-		msg := []byte("a hard challenge")
-		signature := ed25519.Sign(priv, msg)
-		sig := base64.StdEncoding.EncodeToString(signature)
 
 		user := &models.User{
 			PublicKey: validPublicKey,
@@ -141,10 +127,57 @@ func TestAuthenticate(t *testing.T) {
 		id, err := userService.Create(ctx, user)
 		assert.NoError(t, err, "should create user without error")
 
-		msgB64 := base64.StdEncoding.EncodeToString(msg)
-		ok, err := userService.Authenticate(ctx, id, sig, msgB64)
+		challenge, expiresAt, err := userService.IssueChallenge(ctx, id)
+		assert.NoError(t, err, "should issue challenge without error")
+		assert.True(t, expiresAt.After(time.Now()), "challenge should expire in the future")
+
+		msg, err := base64.StdEncoding.DecodeString(challenge)
+		assert.NoError(t, err, "challenge should be base64 encoded")
+		sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, msg))
+
+		ok, err := userService.Authenticate(ctx, id, sig, challenge)
 		assert.NoError(t, err, "should Authenticate without error")
 		assert.Equal(t, true, ok, "authentication should be true")
+
+		ok, err = userService.Authenticate(ctx, id, sig, challenge)
+		assert.ErrorIs(t, err, utils.ErrChallengeConsumed, "should reject a replayed challenge")
+		assert.False(t, ok, "authentication should fail")
+	})
+
+	t.Run("challenge not issued", func(t *testing.T) {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err, "should generate ed25519 key without error")
+
+		id, err := userService.Create(ctx, &models.User{PublicKey: base64.StdEncoding.EncodeToString(pub)})
+		assert.NoError(t, err, "should create user without error")
+
+		msg := []byte("a hard challenge")
+		sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, msg))
+
+		ok, err := userService.Authenticate(ctx, id, sig, base64.StdEncoding.EncodeToString(msg))
+		assert.ErrorIs(t, err, utils.ErrChallengeNotIssued, "should reject a client chosen challenge")
+		assert.False(t, ok, "authentication should fail")
+	})
+
+	t.Run("challenge issued to another user", func(t *testing.T) {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err, "should generate ed25519 key without error")
+		id, err := userService.Create(ctx, &models.User{PublicKey: base64.StdEncoding.EncodeToString(pub)})
+		assert.NoError(t, err, "should create user without error")
+
+		otherPub, _, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err, "should generate ed25519 key without error")
+		otherID, err := userService.Create(ctx, &models.User{PublicKey: base64.StdEncoding.EncodeToString(otherPub)})
+		assert.NoError(t, err, "should create user without error")
+
+		challenge, _, err := userService.IssueChallenge(ctx, otherID)
+		assert.NoError(t, err, "should issue challenge without error")
+		msg, _ := base64.StdEncoding.DecodeString(challenge)
+		sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, msg))
+
+		ok, err := userService.Authenticate(ctx, id, sig, challenge)
+		assert.ErrorIs(t, err, utils.ErrChallengeNotIssued, "should reject a challenge bound to another user")
+		assert.False(t, ok, "authentication should fail")
 	})
 
 	t.Run("invalid user id", func(t *testing.T) {
@@ -176,9 +209,51 @@ func TestAuthenticate(t *testing.T) {
 	})
 }
 
+// failingLog stands in for a transparency log that fails to prove keys.
+type failingLog struct {
+	transparency.LogService
+	err error
+}
+
+func (l failingLog) ProveKey(context.Context, string, string) (*transparency.KeyInclusion, error) {
+	return nil, l.err
+}
+
+func TestUserKeyProof(t *testing.T) {
+	ctx := context.Background()
+	keyLog := transparency.NewInMemoryLogRepository()
+	repo := NewInMemoryUserRepository(keyLog)
+	service := NewUserService(repo, NewInMemoryChallengeStore())
+	publicKey, _ := newIdentityKey(t)
+	id, err := service.Create(ctx, &models.User{PublicKey: publicKey})
+	assert.NoError(t, err, "should create user")
+
+	get := func(log transparency.LogService) (int, *userResponse) {
+		e := echo.New()
+		e.GET("/api/users/:id", NewUserHandler(service, nil, log).GetByIDHandler)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/users/"+id, nil))
+		var response userResponse
+		if rec.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		}
+		return rec.Code, &response
+	}
+
+	code, response := get(transparency.NewLogService(keyLog, testSigningKeys))
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotNil(t, response.Transparency, "should prove the logged key")
+
+	code, response = get(failingLog{err: utils.ErrLogEntryNotFound})
+	assert.Equal(t, http.StatusOK, code, "keys from before the log should be served without a proof")
+	assert.Nil(t, response.Transparency)
+
+	code, _ = get(failingLog{err: errors.New("log unavailable")})
+	assert.Equal(t, http.StatusInternalServerError, code, "should not drop the proof when the log fails")
+}
+
 func TestGetByPublicKey(t *testing.T) {
-	userService, ctx, cleanup := setupTestService(t)
-	defer cleanup()
+	userService, ctx := setupTestService(t)
 
 	t.Run("valid public key", func(t *testing.T) {
 		pub, _, err := ed25519.GenerateKey(nil)
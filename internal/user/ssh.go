@@ -0,0 +1,154 @@
+package user
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/pem"
+	"hash"
+	"strings"
+
+	"Drop-Key/internal/utils"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHSignatureNamespace is the namespace SSH signatures must be made in, as
+// in `ssh-keygen -Y sign -n dropkey`, so that a signature made for DropKey
+// cannot be used for git commits or other SSHSIG consumers, and vice versa.
+const SSHSignatureNamespace = "dropkey"
+
+const (
+	sshSigMagic      = "SSHSIG"
+	sshSigVersion    = 1
+	sshSigPEMType    = "SSH SIGNATURE"
+	sshKeyTypePrefix = ssh.KeyAlgoED25519 + " "
+)
+
+// decodePublicKey accepts an Ed25519 public key either as standard base64 of
+// the 32 raw bytes or in OpenSSH authorized_keys format (`ssh-ed25519 AAAA...
+// comment`), and returns the raw key.
+func decodePublicKey(publicKey string) (ed25519.PublicKey, error) {
+	if strings.HasPrefix(strings.TrimSpace(publicKey), sshKeyTypePrefix) {
+		parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+		if err != nil {
+			return nil, utils.ErrInvalidPublicKey
+		}
+		return sshEd25519Key(parsed)
+	}
+	pub, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, utils.ErrInvalidPublicKey
+	}
+	return pub, nil
+}
+
+// normalizePublicKey returns publicKey in the base64 form keys are stored in.
+func normalizePublicKey(publicKey string) (string, error) {
+	pub, err := decodePublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub), nil
+}
+
+func sshEd25519Key(key ssh.PublicKey) (ed25519.PublicKey, error) {
+	if key.Type() != ssh.KeyAlgoED25519 {
+		return nil, utils.ErrInvalidPublicKey
+	}
+	cryptoKey, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, utils.ErrInvalidPublicKey
+	}
+	pub, ok := cryptoKey.CryptoPublicKey().(ed25519.PublicKey)
+	if !ok {
+		return nil, utils.ErrInvalidPublicKey
+	}
+	return pub, nil
+}
+
+// sshSignature is an SSHSIG blob (PROTOCOL.sshsig) after the magic preamble.
+type sshSignature struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+// parseSSHSignature decodes an SSH signature given either armored, as written
+// by `ssh-keygen -Y sign`, or as standard base64 of the binary blob. It
+// returns nil if signature is not an SSH signature at all.
+func parseSSHSignature(signature string) (*sshSignature, error) {
+	var blob []byte
+	if block, _ := pem.Decode([]byte(strings.TrimSpace(signature))); block != nil {
+		if block.Type != sshSigPEMType {
+			return nil, utils.ErrInvalidSignature
+		}
+		blob = block.Bytes
+	} else {
+		decoded, err := base64.StdEncoding.DecodeString(signature)
+		if err != nil || !bytes.HasPrefix(decoded, []byte(sshSigMagic)) {
+			return nil, nil
+		}
+		blob = decoded
+	}
+
+	if !bytes.HasPrefix(blob, []byte(sshSigMagic)) {
+		return nil, utils.ErrInvalidSignature
+	}
+	var sig sshSignature
+	if err := ssh.Unmarshal(blob[len(sshSigMagic):], &sig); err != nil {
+		return nil, utils.ErrInvalidSignature
+	}
+	if sig.Version != sshSigVersion {
+		return nil, utils.ErrInvalidSignature
+	}
+	return &sig, nil
+}
+
+// verify checks that sig is a signature of message by publicKey in the
+// DropKey namespace.
+func (sig *sshSignature) verify(publicKey ed25519.PublicKey, message []byte) error {
+	if sig.Namespace != SSHSignatureNamespace {
+		return utils.ErrInvalidSignature
+	}
+	signer, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return utils.ErrInvalidSignature
+	}
+	signerKey, err := sshEd25519Key(signer)
+	if err != nil || !signerKey.Equal(publicKey) {
+		return utils.ErrInvalidSignature
+	}
+
+	var h hash.Hash
+	switch sig.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return utils.ErrInvalidSignature
+	}
+	h.Write(message)
+
+	signed := append([]byte(sshSigMagic), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{sig.Namespace, sig.Reserved, sig.HashAlgorithm, h.Sum(nil)})...)
+
+	var wire ssh.Signature
+	if err := ssh.Unmarshal(sig.Signature, &wire); err != nil {
+		return utils.ErrInvalidSignature
+	}
+	if err := signer.Verify(signed, &wire); err != nil {
+		return utils.ErrInvalidSignature
+	}
+	return nil
+}
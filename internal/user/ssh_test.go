@@ -0,0 +1,132 @@
+package user
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshSign produces the armored SSHSIG of message that
+// `ssh-keygen -Y sign -n namespace` would write.
+func sshSign(t *testing.T, signer ssh.Signer, namespace string, message []byte) string {
+	t.Helper()
+	hash := sha512.Sum512(message)
+	signed := append([]byte(sshSigMagic), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{namespace, "", "sha512", hash[:]})...)
+	sig, err := signer.Sign(rand.Reader, signed)
+	assert.NoError(t, err, "should sign")
+
+	blob := append([]byte(sshSigMagic), ssh.Marshal(sshSignature{
+		Version:       sshSigVersion,
+		PublicKey:     signer.PublicKey().Marshal(),
+		Namespace:     namespace,
+		HashAlgorithm: "sha512",
+		Signature:     ssh.Marshal(sig),
+	})...)
+	return string(pem.EncodeToMemory(&pem.Block{Type: sshSigPEMType, Bytes: blob}))
+}
+
+func newSSHKey(t *testing.T) (string, ssh.Signer) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	assert.NoError(t, err)
+	return string(ssh.MarshalAuthorizedKey(signer.PublicKey())), signer
+}
+
+func TestSSHKeys(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestUserRepository(t)
+	service := NewUserService(repo, NewInMemoryChallengeStore())
+
+	authorizedKey, signer := newSSHKey(t)
+	userID, err := service.Create(ctx, &models.User{PublicKey: authorizedKey + " alice@laptop"})
+	assert.NoError(t, err, "should register an OpenSSH key")
+
+	rawKey := base64.StdEncoding.EncodeToString(signer.PublicKey().(ssh.CryptoPublicKey).CryptoPublicKey().(ed25519.PublicKey))
+	assert.Equal(t, rawKey, repo.users[userID].PublicKey, "key should be stored in raw base64 form")
+
+	_, err = service.Create(ctx, &models.User{PublicKey: rawKey})
+	assert.ErrorIs(t, err, utils.ErrDuplicatePublicKey, "both forms should be the same key")
+
+	found, err := service.GetByPublicKey(ctx, authorizedKey)
+	assert.NoError(t, err, "should look up by OpenSSH key")
+	assert.Equal(t, userID, found.ID)
+
+	authenticate := func(sign func(message []byte) string) error {
+		challenge, _, err := service.IssueChallenge(ctx, userID)
+		assert.NoError(t, err, "should issue challenge")
+		decoded, _ := base64.StdEncoding.DecodeString(challenge)
+		_, err = service.AuthenticateKey(ctx, userID, sign(decoded), challenge)
+		return err
+	}
+
+	t.Run("armored signature", func(t *testing.T) {
+		err := authenticate(func(message []byte) string {
+			return sshSign(t, signer, SSHSignatureNamespace, message)
+		})
+		assert.NoError(t, err, "should accept ssh-keygen output")
+	})
+
+	t.Run("base64 signature", func(t *testing.T) {
+		err := authenticate(func(message []byte) string {
+			block, _ := pem.Decode([]byte(sshSign(t, signer, SSHSignatureNamespace, message)))
+			return base64.StdEncoding.EncodeToString(block.Bytes)
+		})
+		assert.NoError(t, err, "should accept the unarmored blob")
+	})
+
+	t.Run("wrong namespace", func(t *testing.T) {
+		err := authenticate(func(message []byte) string {
+			return sshSign(t, signer, "git", message)
+		})
+		assert.ErrorIs(t, err, utils.ErrInvalidSignature, "signatures for other namespaces should be rejected")
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		_, other := newSSHKey(t)
+		err := authenticate(func(message []byte) string {
+			return sshSign(t, other, SSHSignatureNamespace, message)
+		})
+		assert.ErrorIs(t, err, utils.ErrInvalidSignature, "signatures by other keys should be rejected")
+	})
+
+	t.Run("wrong challenge", func(t *testing.T) {
+		err := authenticate(func(message []byte) string {
+			return sshSign(t, signer, SSHSignatureNamespace, []byte("something else"))
+		})
+		assert.ErrorIs(t, err, utils.ErrInvalidSignature, "signatures of other messages should be rejected")
+	})
+
+	t.Run("non-ed25519 keys", func(t *testing.T) {
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NoError(t, err)
+		ecSigner, err := ssh.NewSignerFromKey(ecKey)
+		assert.NoError(t, err)
+
+		_, err = service.Create(ctx, &models.User{PublicKey: string(ssh.MarshalAuthorizedKey(ecSigner.PublicKey()))})
+		assert.ErrorIs(t, err, utils.ErrInvalidPublicKey, "only ssh-ed25519 keys should register")
+
+		err = authenticate(func(message []byte) string {
+			return sshSign(t, ecSigner, SSHSignatureNamespace, message)
+		})
+		assert.ErrorIs(t, err, utils.ErrInvalidSignature, "only ssh-ed25519 signatures should verify")
+	})
+}
@@ -0,0 +1,388 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	custom_middleware "Drop-Key/internal/middleware"
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/signing"
+	"Drop-Key/internal/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	refreshTokenSize = 32
+
+	DefaultAccessTokenTTL  = 15 * time.Minute
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+	DefaultTokenIssuer     = "dropkey"
+	DefaultTokenAudience   = "dropkey"
+)
+
+type TokenConfig struct {
+	Issuer     string
+	Audience   string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// LoadTokenConfig reads token settings from the environment, falling back to
+// the defaults for anything unset or unparsable.
+func LoadTokenConfig() TokenConfig {
+	config := TokenConfig{
+		Issuer:     DefaultTokenIssuer,
+		Audience:   DefaultTokenAudience,
+		AccessTTL:  DefaultAccessTokenTTL,
+		RefreshTTL: DefaultRefreshTokenTTL,
+	}
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		config.Issuer = issuer
+	}
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		config.Audience = audience
+	}
+	config.AccessTTL = durationFromEnv("ACCESS_TOKEN_TTL", config.AccessTTL)
+	config.RefreshTTL = durationFromEnv("REFRESH_TOKEN_TTL", config.RefreshTTL)
+	return config
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		slog.Error("Invalid duration in environment, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return d
+}
+
+type TokenPair struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+type RefreshRecord struct {
+	UserID    string
+	PublicKey string
+	FamilyID  string
+	ExpiresAt time.Time
+}
+
+// TokenStore persists refresh tokens (by hash, never in the clear) and the
+// list of revoked access token IDs.
+type TokenStore interface {
+	SaveRefreshToken(ctx context.Context, hash string, record RefreshRecord) error
+	ConsumeRefreshToken(ctx context.Context, hash string) (*RefreshRecord, error)
+	GetRefreshToken(ctx context.Context, hash string) (*RefreshRecord, error)
+	DeleteRefreshToken(ctx context.Context, hash string) error
+	Revoke(ctx context.Context, jti string, until time.Time) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+type refreshEntry struct {
+	record   RefreshRecord
+	consumed bool
+}
+
+type inMemoryTokenStore struct {
+	mu            sync.Mutex
+	refreshTokens map[string]*refreshEntry
+	revoked       map[string]time.Time
+	now           func() time.Time
+}
+
+func NewInMemoryTokenStore() *inMemoryTokenStore {
+	return &inMemoryTokenStore{
+		refreshTokens: make(map[string]*refreshEntry),
+		revoked:       make(map[string]time.Time),
+		now:           time.Now,
+	}
+}
+
+func (s *inMemoryTokenStore) SaveRefreshToken(ctx context.Context, hash string, record RefreshRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purgeExpired()
+	s.refreshTokens[hash] = &refreshEntry{record: record}
+	return nil
+}
+
+// ConsumeRefreshToken redeems a refresh token exactly once. Presenting an
+// already redeemed token means it leaked, so its whole family is dropped.
+func (s *inMemoryTokenStore) ConsumeRefreshToken(ctx context.Context, hash string) (*RefreshRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.refreshTokens[hash]
+	switch {
+	case !ok:
+		return nil, utils.ErrInvalidRefreshToken
+	case entry.consumed:
+		s.dropFamily(entry.record.FamilyID)
+		return nil, utils.ErrRefreshTokenReused
+	case !s.now().Before(entry.record.ExpiresAt):
+		delete(s.refreshTokens, hash)
+		return nil, utils.ErrRefreshTokenExpired
+	}
+	entry.consumed = true
+	record := entry.record
+	return &record, nil
+}
+
+// GetRefreshToken returns the record of a refresh token without redeeming it.
+func (s *inMemoryTokenStore) GetRefreshToken(ctx context.Context, hash string) (*RefreshRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.refreshTokens[hash]
+	if !ok {
+		return nil, utils.ErrInvalidRefreshToken
+	}
+	record := entry.record
+	return &record, nil
+}
+
+func (s *inMemoryTokenStore) DeleteRefreshToken(ctx context.Context, hash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.refreshTokens[hash]; ok {
+		s.dropFamily(entry.record.FamilyID)
+	}
+	return nil
+}
+
+func (s *inMemoryTokenStore) Revoke(ctx context.Context, jti string, until time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revoked[jti] = until
+	return nil
+}
+
+func (s *inMemoryTokenStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.revoked[jti]
+	return ok, nil
+}
+
+func (s *inMemoryTokenStore) dropFamily(familyID string) {
+	for hash, entry := range s.refreshTokens {
+		if entry.record.FamilyID == familyID {
+			delete(s.refreshTokens, hash)
+		}
+	}
+}
+
+// purgeExpired forgets refresh tokens and revocations that can no longer be
+// used because the underlying token has expired anyway.
+func (s *inMemoryTokenStore) purgeExpired() {
+	now := s.now()
+	for hash, entry := range s.refreshTokens {
+		if !now.Before(entry.record.ExpiresAt) {
+			delete(s.refreshTokens, hash)
+		}
+	}
+	for jti, until := range s.revoked {
+		if !now.Before(until) {
+			delete(s.revoked, jti)
+		}
+	}
+}
+
+type TokenService interface {
+	Issue(ctx context.Context, user *models.User) (*TokenPair, error)
+	Refresh(ctx context.Context, refreshToken string) (*TokenPair, error)
+	Revoke(ctx context.Context, claims *custom_middleware.JwtCustomClaims, refreshToken string) error
+}
+
+type tokenService struct {
+	repo   UserRepository
+	store  TokenStore
+	keys   *signing.KeySet
+	config TokenConfig
+}
+
+func NewTokenService(repo UserRepository, store TokenStore, keys *signing.KeySet, config TokenConfig) *tokenService {
+	return &tokenService{
+		repo:   repo,
+		store:  store,
+		keys:   keys,
+		config: config,
+	}
+}
+
+func (t *tokenService) Issue(ctx context.Context, user *models.User) (*TokenPair, error) {
+	return t.issue(ctx, user, uuid.NewString())
+}
+
+func (t *tokenService) issue(ctx context.Context, user *models.User, familyID string) (*TokenPair, error) {
+	key, err := t.keys.Current()
+	if err != nil {
+		return nil, utils.WrapError(err, "Failed to sign access token")
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	accessExpiresAt := now.Add(t.config.AccessTTL)
+	claims := &custom_middleware.JwtCustomClaims{
+		UserInfo: custom_middleware.UserInfo{
+			UserID:    user.ID,
+			Publickey: user.PublicKey,
+		},
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   user.ID,
+			Issuer:    t.config.Issuer,
+			Audience:  jwt.ClaimStrings{t.config.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(accessExpiresAt),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = key.ID
+	accessToken, err := token.SignedString(key.PrivateKey)
+	if err != nil {
+		return nil, utils.WrapError(err, "Failed to sign access token")
+	}
+
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return nil, utils.WrapError(err, "Failed to generate refresh token")
+	}
+	refreshExpiresAt := now.Add(t.config.RefreshTTL)
+	err = t.store.SaveRefreshToken(ctx, hashRefreshToken(refreshToken), RefreshRecord{
+		UserID:    user.ID,
+		PublicKey: user.PublicKey,
+		FamilyID:  familyID,
+		ExpiresAt: refreshExpiresAt,
+	})
+	if err != nil {
+		return nil, utils.WrapError(err, "Failed to store refresh token")
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  accessExpiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, nil
+}
+
+// Refresh rotates a refresh token: the presented token is consumed and a new
+// access/refresh pair in the same family is returned.
+func (t *tokenService) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	if refreshToken == "" {
+		return nil, utils.ErrInvalidRefreshToken
+	}
+	record, err := t.store.ConsumeRefreshToken(ctx, hashRefreshToken(refreshToken))
+	if err != nil {
+		return nil, utils.WrapError(err, "Cannot refresh token")
+	}
+
+	user, err := t.repo.GetByID(ctx, record.UserID)
+	if err != nil {
+		return nil, utils.WrapError(utils.ErrUserNotFound, "Cannot refresh token")
+	}
+	// Sessions end when the key they were opened with is rotated away or
+	// its device is revoked.
+	if !activeKey(user, record.PublicKey) {
+		return nil, utils.WrapError(utils.ErrInvalidRefreshToken, "Cannot refresh token")
+	}
+	return t.issue(ctx, sessionUser(user, record.PublicKey), record.FamilyID)
+}
+
+// Revoke kills the access token described by claims until it expires, and
+// the refresh token family of refreshToken when one is given. A refresh token
+// is only revoked for the user it was issued to; an unknown one is ignored.
+func (t *tokenService) Revoke(ctx context.Context, claims *custom_middleware.JwtCustomClaims, refreshToken string) error {
+	if claims == nil || claims.ID == "" {
+		return utils.ErrInvalidToken
+	}
+	var hash string
+	if refreshToken != "" {
+		hash = hashRefreshToken(refreshToken)
+		record, err := t.store.GetRefreshToken(ctx, hash)
+		switch {
+		case errors.Is(err, utils.ErrInvalidRefreshToken):
+			hash = ""
+		case err != nil:
+			return utils.WrapError(err, "Cannot revoke refresh token")
+		case record.UserID != claims.UserID:
+			return utils.WrapError(utils.ErrInvalidRefreshToken, "Cannot revoke refresh token")
+		}
+	}
+	until := time.Now().Add(t.config.AccessTTL)
+	if claims.ExpiresAt != nil {
+		until = claims.ExpiresAt.Time
+	}
+	if err := t.store.Revoke(ctx, claims.ID, until); err != nil {
+		return utils.WrapError(err, "Cannot revoke access token")
+	}
+
+	if hash != "" {
+		if err := t.store.DeleteRefreshToken(ctx, hash); err != nil {
+			return utils.WrapError(err, "Cannot revoke refresh token")
+		}
+	}
+	return nil
+}
+
+func newRefreshToken() (string, error) {
+	buf := make([]byte, refreshTokenSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sessionUser is user as seen by a session opened with publicKey, which may
+// be the key of one of the user's devices rather than the primary key. The
+// token carries that key for signature checks and the user ID for the rest.
+func sessionUser(user *models.User, publicKey string) *models.User {
+	session := *user
+	session.PublicKey = publicKey
+	return &session
+}
@@ -0,0 +1,236 @@
+package user
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	custom_middleware "Drop-Key/internal/middleware"
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/signing"
+	"Drop-Key/internal/transparency"
+	"Drop-Key/internal/utils"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestUserRepository returns an in-memory repository holding users.
+func newTestUserRepository(t *testing.T, users ...*models.User) *inMemoryUserRepository {
+	t.Helper()
+	repo := NewInMemoryUserRepository(transparency.NewInMemoryLogRepository())
+	for _, user := range users {
+		assert.NoError(t, repo.Create(context.Background(), user), "should create user")
+	}
+	return repo
+}
+
+var testSigningKeys = newTestKeySet()
+
+func newTestKeySet() *signing.KeySet {
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	return signing.NewKeySet(signing.NewKey(privateKey))
+}
+
+func setupTokenService(t *testing.T) (*tokenService, *inMemoryTokenStore, *models.User) {
+	t.Helper()
+
+	user := &models.User{ID: "user-id", PublicKey: "public-key"}
+	repo := newTestUserRepository(t, user)
+	store := NewInMemoryTokenStore()
+	config := TokenConfig{
+		Issuer:     DefaultTokenIssuer,
+		Audience:   DefaultTokenAudience,
+		AccessTTL:  time.Minute,
+		RefreshTTL: time.Hour,
+	}
+	return NewTokenService(repo, store, testSigningKeys, config), store, user
+}
+
+func authenticatedRequest(t *testing.T, store *inMemoryTokenStore, token string) int {
+	t.Helper()
+	e := echo.New()
+	jwtAuth := custom_middleware.JwtAuth(custom_middleware.JwtConfig{
+		Keys:        testSigningKeys,
+		Issuer:      DefaultTokenIssuer,
+		Audience:    DefaultTokenAudience,
+		Revocations: store,
+	})
+	e.GET("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, jwtAuth)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestTokenService(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("issue", func(t *testing.T) {
+		tokens, store, user := setupTokenService(t)
+		pair, err := tokens.Issue(ctx, user)
+		assert.NoError(t, err, "should issue tokens")
+		assert.NotEmpty(t, pair.AccessToken, "should return an access token")
+		assert.NotEmpty(t, pair.RefreshToken, "should return a refresh token")
+		assert.WithinDuration(t, time.Now().Add(time.Minute), pair.AccessExpiresAt, 2*time.Second, "access token should expire after the configured ttl")
+
+		assert.Equal(t, http.StatusOK, authenticatedRequest(t, store, pair.AccessToken), "middleware should accept issued token")
+	})
+
+	t.Run("wrong audience", func(t *testing.T) {
+		tokens, store, user := setupTokenService(t)
+		tokens.config.Audience = "another-service"
+		pair, err := tokens.Issue(ctx, user)
+		assert.NoError(t, err, "should issue tokens")
+
+		assert.Equal(t, http.StatusUnauthorized, authenticatedRequest(t, store, pair.AccessToken), "middleware should reject foreign audience")
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		tokens, store, user := setupTokenService(t)
+		tokens.config.AccessTTL = -time.Minute
+		pair, err := tokens.Issue(ctx, user)
+		assert.NoError(t, err, "should issue tokens")
+
+		assert.Equal(t, http.StatusUnauthorized, authenticatedRequest(t, store, pair.AccessToken), "middleware should reject expired token")
+	})
+
+	t.Run("unknown signing key", func(t *testing.T) {
+		tokens, store, user := setupTokenService(t)
+		tokens.keys = newTestKeySet()
+		pair, err := tokens.Issue(ctx, user)
+		assert.NoError(t, err, "should issue tokens")
+
+		assert.Equal(t, http.StatusUnauthorized, authenticatedRequest(t, store, pair.AccessToken), "middleware should reject token signed by an unknown key")
+	})
+
+	t.Run("refresh rotates", func(t *testing.T) {
+		tokens, _, user := setupTokenService(t)
+		pair, err := tokens.Issue(ctx, user)
+		assert.NoError(t, err, "should issue tokens")
+
+		refreshed, err := tokens.Refresh(ctx, pair.RefreshToken)
+		assert.NoError(t, err, "should refresh tokens")
+		assert.NotEqual(t, pair.RefreshToken, refreshed.RefreshToken, "should rotate refresh token")
+
+		_, err = tokens.Refresh(ctx, pair.RefreshToken)
+		assert.ErrorIs(t, err, utils.ErrRefreshTokenReused, "should detect refresh token reuse")
+
+		_, err = tokens.Refresh(ctx, refreshed.RefreshToken)
+		assert.ErrorIs(t, err, utils.ErrInvalidRefreshToken, "reuse should revoke the whole family")
+	})
+
+	t.Run("refresh unknown", func(t *testing.T) {
+		tokens, _, _ := setupTokenService(t)
+		_, err := tokens.Refresh(ctx, "unknown")
+		assert.ErrorIs(t, err, utils.ErrInvalidRefreshToken, "should reject unknown refresh token")
+	})
+
+	t.Run("refresh expired", func(t *testing.T) {
+		tokens, _, user := setupTokenService(t)
+		tokens.config.RefreshTTL = -time.Minute
+		pair, err := tokens.Issue(ctx, user)
+		assert.NoError(t, err, "should issue tokens")
+
+		_, err = tokens.Refresh(ctx, pair.RefreshToken)
+		assert.ErrorIs(t, err, utils.ErrRefreshTokenExpired, "should reject expired refresh token")
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		tokens, store, user := setupTokenService(t)
+		pair, err := tokens.Issue(ctx, user)
+		assert.NoError(t, err, "should issue tokens")
+
+		claims := &custom_middleware.JwtCustomClaims{}
+		claims.ID = "some-jti"
+		claims.UserID = user.ID
+		err = tokens.Revoke(ctx, claims, pair.RefreshToken)
+		assert.NoError(t, err, "should revoke tokens")
+
+		revoked, err := store.IsRevoked(ctx, "some-jti")
+		assert.NoError(t, err)
+		assert.True(t, revoked, "jti should be on the revocation list")
+
+		_, err = tokens.Refresh(ctx, pair.RefreshToken)
+		assert.ErrorIs(t, err, utils.ErrInvalidRefreshToken, "revoked refresh token should not be usable")
+	})
+
+	t.Run("revoke refresh token of another user", func(t *testing.T) {
+		tokens, store, user := setupTokenService(t)
+		pair, err := tokens.Issue(ctx, user)
+		assert.NoError(t, err, "should issue tokens")
+
+		claims := &custom_middleware.JwtCustomClaims{}
+		claims.ID = "other-jti"
+		claims.UserID = "other-user-id"
+		err = tokens.Revoke(ctx, claims, pair.RefreshToken)
+		assert.ErrorIs(t, err, utils.ErrInvalidRefreshToken, "should not revoke a refresh token of another user")
+
+		revoked, err := store.IsRevoked(ctx, "other-jti")
+		assert.NoError(t, err)
+		assert.False(t, revoked, "should not revoke the access token of a rejected request")
+
+		_, err = tokens.Refresh(ctx, pair.RefreshToken)
+		assert.NoError(t, err, "the refresh token should still be usable by its owner")
+	})
+
+	t.Run("revoked token rejected by middleware", func(t *testing.T) {
+		tokens, store, user := setupTokenService(t)
+		pair, err := tokens.Issue(ctx, user)
+		assert.NoError(t, err, "should issue tokens")
+
+		e := echo.New()
+		jwtAuth := custom_middleware.JwtAuth(custom_middleware.JwtConfig{
+			Keys:        testSigningKeys,
+			Issuer:      DefaultTokenIssuer,
+			Audience:    DefaultTokenAudience,
+			Revocations: store,
+		})
+		e.POST("/logout", NewUserHandler(nil, tokens, nil).LogoutHandler, jwtAuth)
+
+		req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+		req.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code, "should log out")
+
+		assert.Equal(t, http.StatusUnauthorized, authenticatedRequest(t, store, pair.AccessToken), "middleware should reject revoked token")
+	})
+}
+
+func TestRefreshAfterKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	service, _, user := setupTokenService(t)
+
+	pair, err := service.Issue(ctx, user)
+	assert.NoError(t, err, "should issue tokens")
+
+	err = service.repo.RotateKey(ctx, user, &models.UserKey{UserID: user.ID, Sequence: 2, PublicKey: "rotated-public-key", CreatedAt: time.Now()})
+	assert.NoError(t, err, "should rotate key")
+	_, err = service.Refresh(ctx, pair.RefreshToken)
+	assert.ErrorIs(t, err, utils.ErrInvalidRefreshToken, "sessions of a retired key should not be refreshed")
+}
+
+func TestRefreshAfterDeviceRevoked(t *testing.T) {
+	ctx := context.Background()
+	service, _, user := setupTokenService(t)
+	device := &models.DeviceKey{ID: "device-id", UserID: user.ID, PublicKey: "device-key"}
+	assert.NoError(t, service.repo.CreateDevice(ctx, device), "should add device")
+
+	pair, err := service.Issue(ctx, sessionUser(user, device.PublicKey))
+	assert.NoError(t, err, "should issue tokens to the device")
+
+	pair, err = service.Refresh(ctx, pair.RefreshToken)
+	assert.NoError(t, err, "active device should refresh")
+
+	assert.NoError(t, service.repo.RevokeDevice(ctx, user.ID, device.ID, time.Now()), "should revoke device")
+	_, err = service.Refresh(ctx, pair.RefreshToken)
+	assert.ErrorIs(t, err, utils.ErrInvalidRefreshToken, "sessions of a revoked device should not be refreshed")
+}
@@ -0,0 +1,579 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+	"Drop-Key/internal/verifier"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const (
+	DefaultWebAuthnRPID   = "localhost"
+	DefaultWebAuthnRPName = "DropKey"
+
+	UserVerificationRequired    = "required"
+	UserVerificationPreferred   = "preferred"
+	UserVerificationDiscouraged = "discouraged"
+
+	// COSE algorithm identifiers of the passkeys we accept.
+	COSEAlgorithmES256 = -7
+	COSEAlgorithmEdDSA = -8
+
+	maxPasskeys         = 10
+	maxCredentialIDSize = 255
+
+	ceremonyRegister = "webauthn.create"
+	ceremonyLogin    = "webauthn.get"
+	credentialType   = "public-key"
+
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagAttestedCredData = 0x40
+)
+
+// WebAuthnConfig identifies this server as a WebAuthn relying party. Passkeys
+// are scoped to RPID, and only responses collected by a page on one of
+// Origins are accepted.
+type WebAuthnConfig struct {
+	RPID             string
+	RPName           string
+	Origins          []string
+	UserVerification string
+	Timeout          time.Duration
+}
+
+// LoadWebAuthnConfig reads relying party settings from the environment,
+// falling back to the defaults for anything unset. Origins default to
+// https://<rp id>.
+func LoadWebAuthnConfig() WebAuthnConfig {
+	config := WebAuthnConfig{
+		RPID:             DefaultWebAuthnRPID,
+		RPName:           DefaultWebAuthnRPName,
+		UserVerification: UserVerificationPreferred,
+		Timeout:          DefaultChallengeTTL,
+	}
+	if rpID := os.Getenv("WEBAUTHN_RP_ID"); rpID != "" {
+		config.RPID = rpID
+	}
+	if rpName := os.Getenv("WEBAUTHN_RP_NAME"); rpName != "" {
+		config.RPName = rpName
+	}
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			config.Origins = append(config.Origins, origin)
+		}
+	}
+	if len(config.Origins) == 0 {
+		config.Origins = []string{"https://" + config.RPID}
+	}
+	switch uv := os.Getenv("WEBAUTHN_USER_VERIFICATION"); uv {
+	case "":
+	case UserVerificationRequired, UserVerificationPreferred, UserVerificationDiscouraged:
+		config.UserVerification = uv
+	default:
+		slog.Error("Invalid WebAuthn user verification, using default", "value", uv, "default", config.UserVerification)
+	}
+	return config
+}
+
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CredentialCreationOptions is the JSON form of PublicKeyCredentialCreationOptions,
+// as accepted by PublicKeyCredential.parseCreationOptionsFromJSON. Binary
+// fields are base64url encoded.
+type CredentialCreationOptions struct {
+	RP                     RelyingParty           `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	Challenge              string                 `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// CredentialRequestOptions is the JSON form of PublicKeyCredentialRequestOptions,
+// as accepted by PublicKeyCredential.parseRequestOptionsFromJSON.
+type CredentialRequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// PublicKeyCredential is the JSON form of a credential returned by
+// navigator.credentials.create or get, as produced by its toJSON method.
+type PublicKeyCredential struct {
+	ID       string                `json:"id"`
+	Type     string                `json:"type"`
+	Response AuthenticatorResponse `json:"response"`
+}
+
+// AuthenticatorResponse holds the fields of either an attestation (on
+// registration) or an assertion (on login) response.
+type AuthenticatorResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject,omitempty"`
+	AuthenticatorData string `json:"authenticatorData,omitempty"`
+	Signature         string `json:"signature,omitempty"`
+	UserHandle        string `json:"userHandle,omitempty"`
+}
+
+type WebAuthnService interface {
+	BeginRegistration(ctx context.Context, userID string) (*CredentialCreationOptions, error)
+	FinishRegistration(ctx context.Context, userID string, credential *PublicKeyCredential) (*models.Passkey, error)
+	BeginLogin(ctx context.Context, userID string) (*CredentialRequestOptions, error)
+	FinishLogin(ctx context.Context, userID string, credential *PublicKeyCredential) (*models.User, error)
+}
+
+type webAuthnService struct {
+	repo       UserRepository
+	challenges ChallengeStore
+	config     WebAuthnConfig
+}
+
+func NewWebAuthnService(repo UserRepository, challenges ChallengeStore, config WebAuthnConfig) *webAuthnService {
+	return &webAuthnService{
+		repo:       repo,
+		challenges: challenges,
+		config:     config,
+	}
+}
+
+// BeginRegistration starts binding a new passkey to the user, who must
+// already be logged in.
+func (w *webAuthnService) BeginRegistration(ctx context.Context, userID string) (*CredentialCreationOptions, error) {
+	user, passkeys, err := w.loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(passkeys) >= maxPasskeys {
+		return nil, utils.WrapError(utils.ErrTooManyPasskeys, "Cannot register passkey")
+	}
+	challenge, err := w.issueChallenge(ctx, ceremonyRegister, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CredentialCreationOptions{
+		RP: RelyingParty{ID: w.config.RPID, Name: w.config.RPName},
+		User: UserEntity{
+			ID:          base64.RawURLEncoding.EncodeToString([]byte(user.ID)),
+			Name:        user.ID,
+			DisplayName: user.ID,
+		},
+		Challenge: challenge,
+		PubKeyCredParams: []CredentialParameter{
+			{Type: credentialType, Alg: COSEAlgorithmEdDSA},
+			{Type: credentialType, Alg: COSEAlgorithmES256},
+		},
+		Timeout:            w.config.Timeout.Milliseconds(),
+		ExcludeCredentials: credentialDescriptors(passkeys),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: w.config.UserVerification,
+		},
+		Attestation: "none",
+	}, nil
+}
+
+// FinishRegistration verifies the authenticator's attestation response and
+// stores the passkey. Attestation statements are not checked: we only need
+// the key, not proof of which authenticator model holds it.
+func (w *webAuthnService) FinishRegistration(ctx context.Context, userID string, credential *PublicKeyCredential) (*models.Passkey, error) {
+	user, passkeys, err := w.loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(passkeys) >= maxPasskeys {
+		return nil, utils.WrapError(utils.ErrTooManyPasskeys, "Cannot register passkey")
+	}
+	credentialID, err := credentialIDOf(credential)
+	if err != nil {
+		return nil, err
+	}
+	clientData, err := w.verifyClientData(credential.Response.ClientDataJSON, ceremonyRegister)
+	if err != nil {
+		return nil, err
+	}
+
+	rawAttestation, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(credential.Response.AttestationObject, "="))
+	if err != nil {
+		return nil, utils.WrapError(utils.ErrInvalidPasskey, "Cannot register passkey, invalid attestation object")
+	}
+	var attestation struct {
+		Format   string          `cbor:"fmt"`
+		AttStmt  cbor.RawMessage `cbor:"attStmt"`
+		AuthData []byte          `cbor:"authData"`
+	}
+	if err := cbor.Unmarshal(rawAttestation, &attestation); err != nil {
+		return nil, utils.WrapError(utils.ErrInvalidPasskey, "Cannot register passkey, invalid attestation object")
+	}
+	authData, err := w.verifyAuthenticatorData(attestation.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if authData.Flags&flagAttestedCredData == 0 {
+		return nil, utils.WrapError(utils.ErrInvalidPasskey, "Cannot register passkey, no attested credential")
+	}
+	if !bytes.Equal(authData.CredentialID, credentialID) {
+		return nil, utils.WrapError(utils.ErrInvalidPasskey, "Cannot register passkey, credential ID mismatch")
+	}
+	algorithm, err := parseCOSEKey(authData.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.challenges.Consume(ctx, ceremonyKey(ceremonyRegister, user.ID), clientData.Challenge); err != nil {
+		return nil, utils.WrapError(err, "Cannot register passkey, challenge rejected")
+	}
+
+	passkey := &models.Passkey{
+		ID:        base64.RawURLEncoding.EncodeToString(credentialID),
+		UserID:    user.ID,
+		PublicKey: base64.StdEncoding.EncodeToString(authData.PublicKey),
+		Algorithm: algorithm,
+		SignCount: authData.SignCount,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := w.repo.CreatePasskey(ctx, passkey); err != nil {
+		return nil, utils.WrapError(err, "Cannot register passkey")
+	}
+	slog.Info("Registered passkey", "userID", user.ID, "algorithm", algorithm)
+	return passkey, nil
+}
+
+// BeginLogin issues an assertion challenge for the passkeys of the user.
+func (w *webAuthnService) BeginLogin(ctx context.Context, userID string) (*CredentialRequestOptions, error) {
+	user, passkeys, err := w.loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(passkeys) == 0 {
+		return nil, utils.WrapError(utils.ErrPasskeyNotFound, "Cannot log in with passkey")
+	}
+	challenge, err := w.issueChallenge(ctx, ceremonyLogin, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CredentialRequestOptions{
+		Challenge:        challenge,
+		Timeout:          w.config.Timeout.Milliseconds(),
+		RPID:             w.config.RPID,
+		AllowCredentials: credentialDescriptors(passkeys),
+		UserVerification: w.config.UserVerification,
+	}, nil
+}
+
+// FinishLogin verifies an assertion by one of the user's passkeys and returns
+// the user to issue a session to.
+func (w *webAuthnService) FinishLogin(ctx context.Context, userID string, credential *PublicKeyCredential) (*models.User, error) {
+	user, passkeys, err := w.loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	credentialID, err := credentialIDOf(credential)
+	if err != nil {
+		return nil, err
+	}
+	var passkey *models.Passkey
+	for _, candidate := range passkeys {
+		if candidate.ID == base64.RawURLEncoding.EncodeToString(credentialID) {
+			passkey = candidate
+		}
+	}
+	if passkey == nil {
+		return nil, utils.WrapError(utils.ErrPasskeyNotFound, "Cannot log in with passkey")
+	}
+	if handle := credential.Response.UserHandle; handle != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(handle, "="))
+		if err != nil || string(decoded) != user.ID {
+			return nil, utils.WrapError(utils.ErrInvalidPasskey, "Cannot log in with passkey, user handle mismatch")
+		}
+	}
+
+	clientData, err := w.verifyClientData(credential.Response.ClientDataJSON, ceremonyLogin)
+	if err != nil {
+		return nil, err
+	}
+	rawAuthData, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(credential.Response.AuthenticatorData, "="))
+	if err != nil {
+		return nil, utils.WrapError(utils.ErrInvalidPasskey, "Cannot log in with passkey, invalid authenticator data")
+	}
+	authData, err := w.verifyAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(credential.Response.Signature, "="))
+	if err != nil {
+		return nil, utils.WrapError(utils.ErrInvalidSignature, "Cannot log in with passkey")
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(passkey.PublicKey)
+	if err != nil {
+		return nil, utils.WrapError(utils.ErrInvalidPasskey, "Cannot log in with passkey, stored key is invalid")
+	}
+	clientDataHash := sha256.Sum256(clientData.raw)
+	signed := append(rawAuthData[:len(rawAuthData):len(rawAuthData)], clientDataHash[:]...)
+	if err := verifyCOSESignature(publicKey, signed, signature); err != nil {
+		return nil, utils.WrapError(err, "Cannot log in with passkey")
+	}
+
+	// Authenticators that count signatures must always count up; a counter
+	// that does not suggests the credential was cloned.
+	if (passkey.SignCount != 0 || authData.SignCount != 0) && authData.SignCount <= passkey.SignCount {
+		slog.Warn("Passkey signature counter did not increase", "userID", user.ID, "stored", passkey.SignCount, "received", authData.SignCount)
+		return nil, utils.WrapError(utils.ErrPasskeyCloned, "Cannot log in with passkey")
+	}
+
+	if err := w.challenges.Consume(ctx, ceremonyKey(ceremonyLogin, user.ID), clientData.Challenge); err != nil {
+		return nil, utils.WrapError(err, "Cannot log in with passkey, challenge rejected")
+	}
+	if err := w.repo.UpdatePasskeyUse(ctx, passkey, authData.SignCount, time.Now().UTC()); err != nil {
+		return nil, utils.WrapError(err, "Cannot log in with passkey")
+	}
+	return user, nil
+}
+
+func (w *webAuthnService) loadUser(ctx context.Context, userID string) (*models.User, []*models.Passkey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if userID == "" {
+		return nil, nil, utils.WrapError(utils.ErrEmptyUserID, "Passkey ceremony failed")
+	}
+	user, err := w.repo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, nil, utils.WrapError(utils.ErrUserNotFound, "Passkey ceremony failed")
+	}
+	passkeys, err := w.repo.ListPasskeys(ctx, user.ID)
+	if err != nil {
+		return nil, nil, utils.WrapError(err, "Cannot list passkeys")
+	}
+	return user, passkeys, nil
+}
+
+// issueChallenge stores a fresh challenge for one ceremony of the user, so
+// that a registration challenge cannot be redeemed by a login and vice versa.
+func (w *webAuthnService) issueChallenge(ctx context.Context, ceremony, userID string) (string, error) {
+	buf := make([]byte, challengeSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", utils.WrapError(err, "Cannot generate challenge")
+	}
+	challenge := base64.RawURLEncoding.EncodeToString(buf)
+	if err := w.challenges.Save(ctx, ceremonyKey(ceremony, userID), challenge, time.Now().Add(w.config.Timeout)); err != nil {
+		return "", utils.WrapError(err, "Cannot store challenge")
+	}
+	return challenge, nil
+}
+
+func ceremonyKey(ceremony, userID string) string {
+	return ceremony + ":" + userID
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+
+	raw []byte
+}
+
+func (w *webAuthnService) verifyClientData(encoded, ceremony string) (*clientData, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return nil, utils.WrapError(utils.ErrInvalidPasskey, "Invalid client data")
+	}
+	data := &clientData{raw: raw}
+	if err := json.Unmarshal(raw, data); err != nil {
+		return nil, utils.WrapError(utils.ErrInvalidPasskey, "Invalid client data")
+	}
+	if data.Type != ceremony {
+		return nil, utils.WrapError(utils.ErrInvalidPasskey, "Client data is for another ceremony")
+	}
+	if data.CrossOrigin || !slices.Contains(w.config.Origins, data.Origin) {
+		return nil, utils.WrapError(utils.ErrInvalidPasskey, "Client data is from an unexpected origin")
+	}
+	if data.Challenge == "" {
+		return nil, utils.WrapError(utils.ErrValidationError, "Client data has no challenge")
+	}
+	return data, nil
+}
+
+type authenticatorData struct {
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// verifyAuthenticatorData parses authenticator data and checks that it was
+// made for our relying party with the user present, and verified if
+// required.
+func (w *webAuthnService) verifyAuthenticatorData(data []byte) (*authenticatorData, error) {
+	const headerSize = sha256.Size + 1 + 4
+	if len(data) < headerSize {
+		return nil, utils.WrapError(utils.ErrInvalidPasskey, "Authenticator data is too short")
+	}
+	rpIDHash := sha256.Sum256([]byte(w.config.RPID))
+	if subtle.ConstantTimeCompare(data[:sha256.Size], rpIDHash[:]) != 1 {
+		return nil, utils.WrapError(utils.ErrInvalidPasskey, "Authenticator data is for another relying party")
+	}
+	authData := &authenticatorData{
+		Flags:     data[sha256.Size],
+		SignCount: binary.BigEndian.Uint32(data[sha256.Size+1:]),
+	}
+	if authData.Flags&flagUserPresent == 0 {
+		return nil, utils.WrapError(utils.ErrInvalidPasskey, "User was not present")
+	}
+	if w.config.UserVerification == UserVerificationRequired && authData.Flags&flagUserVerified == 0 {
+		return nil, utils.WrapError(utils.ErrInvalidPasskey, "User was not verified")
+	}
+	if authData.Flags&flagAttestedCredData == 0 {
+		return authData, nil
+	}
+
+	// Attested credential data: AAGUID, credential ID length and ID, then
+	// the COSE key, possibly followed by extensions.
+	rest := data[headerSize:]
+	if len(rest) < 16+2 {
+		return nil, utils.WrapError(utils.ErrInvalidPasskey, "Attested credential data is too short")
+	}
+	idSize := int(binary.BigEndian.Uint16(rest[16:]))
+	rest = rest[16+2:]
+	if idSize == 0 || idSize > maxCredentialIDSize || len(rest) < idSize {
+		return nil, utils.WrapError(utils.ErrInvalidPasskey, "Invalid credential ID")
+	}
+	authData.CredentialID = rest[:idSize]
+	rest = rest[idSize:]
+	var key cbor.RawMessage
+	trailing, err := cbor.UnmarshalFirst(rest, &key)
+	if err != nil {
+		return nil, utils.WrapError(utils.ErrInvalidPasskey, "Invalid credential public key")
+	}
+	authData.PublicKey = rest[:len(rest)-len(trailing)]
+	return authData, nil
+}
+
+func credentialIDOf(credential *PublicKeyCredential) ([]byte, error) {
+	if credential == nil || credential.Type != credentialType {
+		return nil, utils.WrapError(utils.ErrInvalidPasskey, "Not a public key credential")
+	}
+	id, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(credential.ID, "="))
+	if err != nil || len(id) == 0 || len(id) > maxCredentialIDSize {
+		return nil, utils.WrapError(utils.ErrInvalidPasskey, "Invalid credential ID")
+	}
+	return id, nil
+}
+
+func credentialDescriptors(passkeys []*models.Passkey) []CredentialDescriptor {
+	descriptors := make([]CredentialDescriptor, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		descriptors = append(descriptors, CredentialDescriptor{Type: credentialType, ID: passkey.ID})
+	}
+	return descriptors
+}
+
+// coseKey holds the parameters of the COSE_Key types we accept (RFC 9053):
+// EC2 keys on P-256 for ES256 and OKP keys on Ed25519 for EdDSA.
+type coseKey struct {
+	KeyType   int    `cbor:"1,keyasint"`
+	Algorithm int    `cbor:"3,keyasint"`
+	Curve     int    `cbor:"-1,keyasint"`
+	X         []byte `cbor:"-2,keyasint"`
+	Y         []byte `cbor:"-3,keyasint,omitempty"`
+}
+
+const (
+	coseKeyTypeOKP   = 1
+	coseKeyTypeEC2   = 2
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+func parseCOSEKey(raw []byte) (int, error) {
+	_, _, algorithm, err := decodeCOSEKey(raw)
+	return algorithm, err
+}
+
+// decodeCOSEKey returns the verifier algorithm and raw public key of a COSE
+// credential key, along with its COSE algorithm.
+func decodeCOSEKey(raw []byte) (string, []byte, int, error) {
+	var key coseKey
+	if err := cbor.Unmarshal(raw, &key); err != nil {
+		return "", nil, 0, utils.WrapError(utils.ErrInvalidPasskey, "Invalid credential public key")
+	}
+	var algorithm string
+	var pub []byte
+	switch {
+	case key.Algorithm == COSEAlgorithmEdDSA && key.KeyType == coseKeyTypeOKP && key.Curve == coseCurveEd25519:
+		algorithm, pub = verifier.AlgorithmEd25519, key.X
+
+	case key.Algorithm == COSEAlgorithmES256 && key.KeyType == coseKeyTypeEC2 && key.Curve == coseCurveP256:
+		if len(key.X) != 32 || len(key.Y) != 32 {
+			break
+		}
+		algorithm, pub = verifier.AlgorithmECDSAP256, append(append([]byte{4}, key.X...), key.Y...)
+	}
+	v, err := verifier.Default.Lookup(algorithm)
+	if err != nil || v.ValidatePublicKey(pub) != nil {
+		return "", nil, 0, utils.WrapError(utils.ErrInvalidPasskey, "Unsupported credential public key")
+	}
+	return algorithm, pub, key.Algorithm, nil
+}
+
+func verifyCOSESignature(raw, message, signature []byte) error {
+	algorithm, pub, _, err := decodeCOSEKey(raw)
+	if err != nil {
+		return err
+	}
+	v, err := verifier.Default.Lookup(algorithm)
+	if err != nil {
+		return err
+	}
+	if v.ValidateSignature(signature) != nil || !v.Verify(pub, message, signature) {
+		return utils.ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,431 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	custom_middleware "Drop-Key/internal/middleware"
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testRPID   = "dropkey.test"
+	testOrigin = "https://dropkey.test"
+)
+
+var testWebAuthnConfig = WebAuthnConfig{
+	RPID:             testRPID,
+	RPName:           DefaultWebAuthnRPName,
+	Origins:          []string{testOrigin},
+	UserVerification: UserVerificationPreferred,
+	Timeout:          time.Minute,
+}
+
+// softAuthenticator is a WebAuthn authenticator in software, standing in for
+// the browser and security key in tests.
+type softAuthenticator struct {
+	rpID         string
+	origin       string
+	credentialID []byte
+	coseKey      []byte
+	sign         func(message []byte) []byte
+	counting     bool
+	signCount    uint32
+	verified     bool
+	userHandle   []byte
+}
+
+func newSoftAuthenticator(t *testing.T, algorithm int) *softAuthenticator {
+	t.Helper()
+	a := &softAuthenticator{
+		rpID:         testRPID,
+		origin:       testOrigin,
+		credentialID: make([]byte, 32),
+		verified:     true,
+	}
+	_, err := rand.Read(a.credentialID)
+	assert.NoError(t, err)
+
+	switch algorithm {
+	case COSEAlgorithmEdDSA:
+		pub, priv, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+		a.coseKey, err = cbor.Marshal(coseKey{KeyType: coseKeyTypeOKP, Algorithm: algorithm, Curve: coseCurveEd25519, X: pub})
+		assert.NoError(t, err)
+		a.sign = func(message []byte) []byte {
+			return ed25519.Sign(priv, message)
+		}
+	case COSEAlgorithmES256:
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NoError(t, err)
+		a.coseKey, err = cbor.Marshal(coseKey{
+			KeyType:   coseKeyTypeEC2,
+			Algorithm: algorithm,
+			Curve:     coseCurveP256,
+			X:         priv.PublicKey.X.FillBytes(make([]byte, 32)),
+			Y:         priv.PublicKey.Y.FillBytes(make([]byte, 32)),
+		})
+		assert.NoError(t, err)
+		a.sign = func(message []byte) []byte {
+			digest := sha256.Sum256(message)
+			sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+			assert.NoError(t, err)
+			return sig
+		}
+	default:
+		t.Fatalf("unsupported algorithm %d", algorithm)
+	}
+	return a
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremony, challenge string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{
+		"type":        ceremony,
+		"challenge":   challenge,
+		"origin":      a.origin,
+		"crossOrigin": false,
+	})
+	assert.NoError(t, err)
+	return data
+}
+
+func (a *softAuthenticator) authenticatorData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	flags := byte(flagUserPresent)
+	if a.verified {
+		flags |= flagUserVerified
+	}
+	if attested {
+		flags |= flagAttestedCredData
+	}
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.coseKey...)
+	}
+	return data
+}
+
+func (a *softAuthenticator) create(t *testing.T, options *CredentialCreationOptions) *PublicKeyCredential {
+	t.Helper()
+	var err error
+	a.userHandle, err = base64.RawURLEncoding.DecodeString(options.User.ID)
+	assert.NoError(t, err)
+
+	attestation, err := cbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authenticatorData(true),
+	})
+	assert.NoError(t, err)
+	return &PublicKeyCredential{
+		ID:   base64.RawURLEncoding.EncodeToString(a.credentialID),
+		Type: credentialType,
+		Response: AuthenticatorResponse{
+			ClientDataJSON:    base64.RawURLEncoding.EncodeToString(a.clientData(t, ceremonyRegister, options.Challenge)),
+			AttestationObject: base64.RawURLEncoding.EncodeToString(attestation),
+		},
+	}
+}
+
+func (a *softAuthenticator) get(t *testing.T, challenge string) *PublicKeyCredential {
+	t.Helper()
+	if a.counting {
+		a.signCount++
+	}
+	authData := a.authenticatorData(false)
+	clientData := a.clientData(t, ceremonyLogin, challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	return &PublicKeyCredential{
+		ID:   base64.RawURLEncoding.EncodeToString(a.credentialID),
+		Type: credentialType,
+		Response: AuthenticatorResponse{
+			ClientDataJSON:    base64.RawURLEncoding.EncodeToString(clientData),
+			AuthenticatorData: base64.RawURLEncoding.EncodeToString(authData),
+			Signature:         base64.RawURLEncoding.EncodeToString(a.sign(append(authData, clientDataHash[:]...))),
+			UserHandle:        base64.RawURLEncoding.EncodeToString(a.userHandle),
+		},
+	}
+}
+
+func setupWebAuthn(t *testing.T) (*webAuthnService, *inMemoryUserRepository, *models.User) {
+	t.Helper()
+	publicKey, _ := newIdentityKey(t)
+	user := &models.User{ID: "user-id", PublicKey: publicKey}
+	repo := newTestUserRepository(t, user)
+	return NewWebAuthnService(repo, NewInMemoryChallengeStore(), testWebAuthnConfig), repo, user
+}
+
+// registerPasskey runs a registration ceremony with a and returns the stored
+// passkey.
+func registerPasskey(t *testing.T, service *webAuthnService, userID string, a *softAuthenticator) *models.Passkey {
+	t.Helper()
+	ctx := context.Background()
+	options, err := service.BeginRegistration(ctx, userID)
+	assert.NoError(t, err, "should begin registration")
+	passkey, err := service.FinishRegistration(ctx, userID, a.create(t, options))
+	assert.NoError(t, err, "should register passkey")
+	return passkey
+}
+
+func TestPasskeyLoginHandlers(t *testing.T) {
+	for name, algorithm := range map[string]int{"EdDSA": COSEAlgorithmEdDSA, "ES256": COSEAlgorithmES256} {
+		t.Run(name, func(t *testing.T) {
+			service, repo, user := setupWebAuthn(t)
+			store := NewInMemoryTokenStore()
+			tokens := NewTokenService(repo, store, testSigningKeys, TokenConfig{
+				Issuer:     DefaultTokenIssuer,
+				Audience:   DefaultTokenAudience,
+				AccessTTL:  time.Minute,
+				RefreshTTL: time.Hour,
+			})
+			jwtAuth := custom_middleware.JwtAuth(custom_middleware.JwtConfig{
+				Keys:        testSigningKeys,
+				Issuer:      DefaultTokenIssuer,
+				Audience:    DefaultTokenAudience,
+				Revocations: store,
+			})
+			handler := NewWebAuthnHandler(service, tokens)
+			e := echo.New()
+			e.POST("/register/begin", handler.BeginRegistrationHandler, jwtAuth)
+			e.POST("/register/finish", handler.FinishRegistrationHandler, jwtAuth)
+			e.POST("/login/begin", handler.BeginLoginHandler)
+			e.POST("/login/finish", handler.FinishLoginHandler)
+
+			post := func(path, token string, body any) *httptest.ResponseRecorder {
+				payload, err := json.Marshal(body)
+				assert.NoError(t, err)
+				req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(payload))
+				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+				if token != "" {
+					req.Header.Set("Authorization", "Bearer "+token)
+				}
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+				return rec
+			}
+
+			authenticator := newSoftAuthenticator(t, algorithm)
+			authenticator.counting = algorithm == COSEAlgorithmES256
+
+			rec := post("/register/begin", "", nil)
+			assert.Equal(t, http.StatusUnauthorized, rec.Code, "registration should require a session")
+
+			session, err := tokens.Issue(context.Background(), user)
+			assert.NoError(t, err)
+			rec = post("/register/begin", session.AccessToken, nil)
+			assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+			creation := &CredentialCreationOptions{}
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), creation))
+			assert.Equal(t, testRPID, creation.RP.ID)
+			assert.Equal(t, user.ID, creation.User.Name)
+
+			rec = post("/register/finish", session.AccessToken, authenticator.create(t, creation))
+			assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+			passkey := &models.Passkey{}
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), passkey))
+			assert.Equal(t, algorithm, passkey.Algorithm)
+
+			rec = post("/login/begin", "", &ID{Id: user.ID})
+			assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+			request := &CredentialRequestOptions{}
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), request))
+			assert.Equal(t, []CredentialDescriptor{{Type: credentialType, ID: passkey.ID}}, request.AllowCredentials)
+
+			credential := authenticator.get(t, request.Challenge)
+			rec = post("/login/finish", "", &PasskeyLoginRequest{ID: user.ID, Credential: credential})
+			assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+			response := &TokenResponse{}
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), response))
+			assert.Equal(t, http.StatusOK, authenticatedRequest(t, store, response.Token), "passkey login should issue a valid session")
+			assert.NotEmpty(t, response.RefreshToken)
+
+			rec = post("/login/finish", "", &PasskeyLoginRequest{ID: user.ID, Credential: credential})
+			assert.Equal(t, http.StatusUnauthorized, rec.Code, "assertions should not be replayable")
+		})
+	}
+}
+
+func TestWebAuthnService(t *testing.T) {
+	ctx := context.Background()
+
+	login := func(t *testing.T, service *webAuthnService, userID string, a *softAuthenticator) error {
+		t.Helper()
+		options, err := service.BeginLogin(ctx, userID)
+		assert.NoError(t, err, "should begin login")
+		_, err = service.FinishLogin(ctx, userID, a.get(t, options.Challenge))
+		return err
+	}
+
+	t.Run("wrong origin", func(t *testing.T) {
+		service, _, user := setupWebAuthn(t)
+		a := newSoftAuthenticator(t, COSEAlgorithmEdDSA)
+		a.origin = "https://evil.test"
+		options, err := service.BeginRegistration(ctx, user.ID)
+		assert.NoError(t, err)
+		_, err = service.FinishRegistration(ctx, user.ID, a.create(t, options))
+		assert.ErrorIs(t, err, utils.ErrInvalidPasskey, "responses from other origins should be rejected")
+	})
+
+	t.Run("wrong relying party", func(t *testing.T) {
+		service, _, user := setupWebAuthn(t)
+		a := newSoftAuthenticator(t, COSEAlgorithmEdDSA)
+		a.rpID = "evil.test"
+		options, err := service.BeginRegistration(ctx, user.ID)
+		assert.NoError(t, err)
+		_, err = service.FinishRegistration(ctx, user.ID, a.create(t, options))
+		assert.ErrorIs(t, err, utils.ErrInvalidPasskey, "credentials scoped to other sites should be rejected")
+	})
+
+	t.Run("unsupported algorithm", func(t *testing.T) {
+		service, _, user := setupWebAuthn(t)
+		a := newSoftAuthenticator(t, COSEAlgorithmES256)
+		var err error
+		a.coseKey, err = cbor.Marshal(coseKey{KeyType: coseKeyTypeEC2, Algorithm: -35, Curve: 2, X: make([]byte, 48), Y: make([]byte, 48)})
+		assert.NoError(t, err)
+		options, err := service.BeginRegistration(ctx, user.ID)
+		assert.NoError(t, err)
+		_, err = service.FinishRegistration(ctx, user.ID, a.create(t, options))
+		assert.ErrorIs(t, err, utils.ErrInvalidPasskey, "P-384 keys should be rejected")
+	})
+
+	t.Run("registration replay", func(t *testing.T) {
+		service, _, user := setupWebAuthn(t)
+		a := newSoftAuthenticator(t, COSEAlgorithmEdDSA)
+		options, err := service.BeginRegistration(ctx, user.ID)
+		assert.NoError(t, err)
+		credential := a.create(t, options)
+		_, err = service.FinishRegistration(ctx, user.ID, credential)
+		assert.NoError(t, err)
+		_, err = service.FinishRegistration(ctx, user.ID, credential)
+		assert.ErrorIs(t, err, utils.ErrChallengeConsumed, "registration challenge should be single use")
+	})
+
+	t.Run("user verification required", func(t *testing.T) {
+		service, _, user := setupWebAuthn(t)
+		service.config.UserVerification = UserVerificationRequired
+		a := newSoftAuthenticator(t, COSEAlgorithmEdDSA)
+		a.verified = false
+		options, err := service.BeginRegistration(ctx, user.ID)
+		assert.NoError(t, err)
+		_, err = service.FinishRegistration(ctx, user.ID, a.create(t, options))
+		assert.ErrorIs(t, err, utils.ErrInvalidPasskey, "unverified users should be rejected when verification is required")
+	})
+
+	t.Run("registration challenge cannot log in", func(t *testing.T) {
+		service, _, user := setupWebAuthn(t)
+		a := newSoftAuthenticator(t, COSEAlgorithmEdDSA)
+		registerPasskey(t, service, user.ID, a)
+		options, err := service.BeginRegistration(ctx, user.ID)
+		assert.NoError(t, err)
+		_, err = service.FinishLogin(ctx, user.ID, a.get(t, options.Challenge))
+		assert.ErrorIs(t, err, utils.ErrChallengeNotIssued)
+	})
+
+	t.Run("login challenges are capped", func(t *testing.T) {
+		service, _, user := setupWebAuthn(t)
+		a := newSoftAuthenticator(t, COSEAlgorithmEdDSA)
+		registerPasskey(t, service, user.ID, a)
+		// Beginning a login needs no credentials, so anyone can issue
+		// challenges for a user in a loop.
+		first, err := service.BeginLogin(ctx, user.ID)
+		assert.NoError(t, err)
+		for i := 0; i < 3*MaxChallengesPerUser; i++ {
+			_, err := service.BeginLogin(ctx, user.ID)
+			assert.NoError(t, err)
+		}
+		store := service.challenges.(*inMemoryChallengeStore)
+		assert.Len(t, store.challenges[ceremonyKey(ceremonyLogin, user.ID)], MaxChallengesPerUser, "should keep only the newest login challenges")
+		_, err = service.FinishLogin(ctx, user.ID, a.get(t, first.Challenge))
+		assert.ErrorIs(t, err, utils.ErrChallengeNotIssued, "evicted challenge should be rejected")
+		assert.NoError(t, login(t, service, user.ID, a), "a fresh challenge should still log in")
+	})
+
+	t.Run("no passkeys", func(t *testing.T) {
+		service, _, user := setupWebAuthn(t)
+		_, err := service.BeginLogin(ctx, user.ID)
+		assert.ErrorIs(t, err, utils.ErrPasskeyNotFound)
+		_, err = service.BeginLogin(ctx, "unknown-user")
+		assert.ErrorIs(t, err, utils.ErrUserNotFound)
+	})
+
+	t.Run("bad signature", func(t *testing.T) {
+		service, _, user := setupWebAuthn(t)
+		a := newSoftAuthenticator(t, COSEAlgorithmES256)
+		registerPasskey(t, service, user.ID, a)
+		a.sign = newSoftAuthenticator(t, COSEAlgorithmES256).sign
+		assert.ErrorIs(t, login(t, service, user.ID, a), utils.ErrInvalidSignature)
+	})
+
+	t.Run("passkey of another user", func(t *testing.T) {
+		service, repo, user := setupWebAuthn(t)
+		a := newSoftAuthenticator(t, COSEAlgorithmEdDSA)
+		registerPasskey(t, service, user.ID, a)
+		other := &models.User{ID: "other-id", PublicKey: "other-key"}
+		assert.NoError(t, repo.Create(context.Background(), other))
+		registerPasskey(t, service, other.ID, newSoftAuthenticator(t, COSEAlgorithmEdDSA))
+
+		err := login(t, service, other.ID, a)
+		assert.ErrorIs(t, err, utils.ErrPasskeyNotFound, "a passkey should only log in its own user")
+	})
+
+	t.Run("signature counter", func(t *testing.T) {
+		service, repo, user := setupWebAuthn(t)
+		a := newSoftAuthenticator(t, COSEAlgorithmES256)
+		a.counting = true
+		passkey := registerPasskey(t, service, user.ID, a)
+
+		assert.NoError(t, login(t, service, user.ID, a))
+		assert.NoError(t, login(t, service, user.ID, a))
+		assert.Equal(t, uint32(2), repo.passkeys[passkey.ID].SignCount, "should record the counter")
+		assert.False(t, repo.passkeys[passkey.ID].LastUsedAt.IsZero(), "should record the last use")
+
+		a.signCount = 0
+		assert.ErrorIs(t, login(t, service, user.ID, a), utils.ErrPasskeyCloned, "a counter going backwards should be rejected")
+	})
+
+	t.Run("duplicate credential", func(t *testing.T) {
+		service, _, user := setupWebAuthn(t)
+		a := newSoftAuthenticator(t, COSEAlgorithmEdDSA)
+		registerPasskey(t, service, user.ID, a)
+		options, err := service.BeginRegistration(ctx, user.ID)
+		assert.NoError(t, err)
+		assert.Len(t, options.ExcludeCredentials, 1, "registered passkeys should be excluded")
+		_, err = service.FinishRegistration(ctx, user.ID, a.create(t, options))
+		assert.ErrorIs(t, err, utils.ErrDuplicatePasskey)
+	})
+
+	t.Run("padded encoding", func(t *testing.T) {
+		service, _, user := setupWebAuthn(t)
+		a := newSoftAuthenticator(t, COSEAlgorithmEdDSA)
+		options, err := service.BeginRegistration(ctx, user.ID)
+		assert.NoError(t, err)
+		credential := a.create(t, options)
+		credential.ID = base64.URLEncoding.EncodeToString(a.credentialID)
+		credential.Response.ClientDataJSON += strings.Repeat("=", (4-len(credential.Response.ClientDataJSON)%4)%4)
+		_, err = service.FinishRegistration(ctx, user.ID, credential)
+		assert.NoError(t, err, "padded base64url should be accepted")
+	})
+}
@@ -0,0 +1,42 @@
+package user
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+
+	"Drop-Key/internal/models"
+	"Drop-Key/internal/utils"
+	"Drop-Key/internal/verifier"
+
+	"filippo.io/edwards25519"
+)
+
+// X25519PublicKey maps an Ed25519 identity key to the X25519 key of the same
+// identity (the birational map of RFC 7748), which clients wrap paste content
+// keys to. The owner derives the matching private key from the Ed25519 seed.
+func X25519PublicKey(publicKey ed25519.PublicKey) ([]byte, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, utils.ErrInvalidPublicKey
+	}
+	point, err := new(edwards25519.Point).SetBytes(publicKey)
+	if err != nil {
+		return nil, utils.ErrInvalidPublicKey
+	}
+	return point.BytesMontgomery(), nil
+}
+
+// withX25519Key fills in the X25519 key of user for API responses. Only
+// Ed25519 identities have one.
+func withX25519Key(user *models.User) *models.User {
+	if verifier.Normalize(user.Algorithm) != verifier.AlgorithmEd25519 {
+		return user
+	}
+	pub, err := base64.StdEncoding.DecodeString(user.PublicKey)
+	if err != nil {
+		return user
+	}
+	if x25519Key, err := X25519PublicKey(pub); err == nil {
+		user.X25519PublicKey = base64.StdEncoding.EncodeToString(x25519Key)
+	}
+	return user
+}
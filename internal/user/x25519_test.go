@@ -0,0 +1,39 @@
+package user
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestX25519PublicKey(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err, "should generate key")
+
+	converted, err := X25519PublicKey(pub)
+	assert.NoError(t, err, "should convert public key")
+
+	// The owner's X25519 private key is the clamped first half of
+	// SHA-512(seed), as in RFC 8032; ecdh clamps it for us.
+	digest := sha512.Sum512(priv.Seed())
+	ownerKey, err := ecdh.X25519().NewPrivateKey(digest[:32])
+	assert.NoError(t, err)
+	assert.Equal(t, ownerKey.PublicKey().Bytes(), converted, "should match the key derived from the seed")
+
+	sender, err := ecdh.X25519().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	recipientKey, err := ecdh.X25519().NewPublicKey(converted)
+	assert.NoError(t, err)
+	sent, err := sender.ECDH(recipientKey)
+	assert.NoError(t, err)
+	received, err := ownerKey.ECDH(sender.PublicKey())
+	assert.NoError(t, err)
+	assert.Equal(t, sent, received, "sender and owner should agree on the wrapping secret")
+
+	_, err = X25519PublicKey(pub[:16])
+	assert.Error(t, err, "should reject short keys")
+}
@@ -10,6 +10,7 @@ var (
 	ErrPasteExpiryTooLong                = errors.New("paste expiry date is too long")
 	ErrPasteEmptyCiphertext              = errors.New("paste has empty ciphertext")
 	ErrPasteInvalidCiphertext            = errors.New("paste ciphertext is not base64 encoded")
+	ErrPasteTooLarge                     = errors.New("paste ciphertext is larger than allowed")
 	ErrPasteEmptySignature               = errors.New("paste has empty signature")
 	ErrPasteInvalidSignature             = errors.New("paste signature is not base64 encoded or has invalid size")
 	ErrPasteInvalidPublicKey             = errors.New("paste has empty or invalid public key")
@@ -18,6 +19,24 @@ var (
 	ErrPasteInvalidID                    = errors.New("invalid paste ID")
 	ErrPasteNotFound                     = errors.New("paste not found")
 	ErrPasteInvalidExpiryTime            = errors.New("paste has invalid expiry time")
+	ErrPasteForbidden                    = errors.New("paste is owned by another user")
+	ErrPasteDeleted                      = errors.New("paste was deleted by its owner")
+	ErrPasteBurned                       = errors.New("paste was burned after being read")
+	ErrPasteViewsExhausted               = errors.New("paste has reached its maximum number of views")
+	ErrPasteInvalidMaxViews              = errors.New("paste max views must not be negative")
+	ErrPasteUnsupportedSignatureVersion  = errors.New("paste signature version is not supported")
+	ErrPasteLegacySignatureDisabled      = errors.New("legacy ciphertext-only paste signatures are disabled")
+	ErrPasteInvalidNonce                 = errors.New("paste nonce is empty or too long")
+	ErrPasteNonceReused                  = errors.New("paste nonce has already been used")
+	ErrPasteInvalidRevision              = errors.New("paste revision must be a positive number")
+	ErrPasteRevisionNotFound             = errors.New("paste revision not found")
+	ErrPasteRevisionConflict             = errors.New("paste was updated concurrently")
+	ErrPasteTooManyRecipients            = errors.New("paste has too many recipients")
+	ErrPasteInvalidRecipient             = errors.New("paste recipient has an invalid public key or envelope")
+	ErrPasteDuplicateRecipient           = errors.New("paste recipient is listed more than once")
+	ErrPasteRecipientNotFound            = errors.New("paste recipient is not a registered user")
+	ErrPasteNotRecipient                 = errors.New("paste is not addressed to this user")
+	ErrInvalidPagination                 = errors.New("limit must be between 1 and 100 and offset must not be negative")
 )
 
 var (
@@ -28,8 +47,9 @@ var (
 )
 
 var (
-	ErrInvalidSignature = errors.New("Invalid signature")
-	ErrEmptySignature   = errors.New("Empty signature")
+	ErrInvalidSignature     = errors.New("Invalid signature")
+	ErrEmptySignature       = errors.New("Empty signature")
+	ErrUnsupportedAlgorithm = errors.New("signature algorithm is not supported")
 )
 
 var (
@@ -40,14 +60,61 @@ var (
 )
 
 var (
-	ErrDuplicatePublicKey   = errors.New("public key already exists")
-	ErrUserNotFound         = errors.New("user not found")
-	ErrInvalidUserID        = errors.New("invalid User ID")
-	ErrEmptyUserID          = errors.New("empty user ID")
-	ErrAuthenticationFailed = errors.New("authentication failed")
-	ErrValidationError      = errors.New("validation error")
-	ErrUserCreationFailed   = errors.New("user creation failed")
-	ErrUserAlreadyExists    = errors.New("user already exists, perform login instead")
+	ErrDuplicatePublicKey     = errors.New("public key already exists")
+	ErrUserNotFound           = errors.New("user not found")
+	ErrInvalidUserID          = errors.New("invalid User ID")
+	ErrEmptyUserID            = errors.New("empty user ID")
+	ErrAuthenticationFailed   = errors.New("authentication failed")
+	ErrValidationError        = errors.New("validation error")
+	ErrUserCreationFailed     = errors.New("user creation failed")
+	ErrUserAlreadyExists      = errors.New("user already exists, perform login instead")
+	ErrInvalidEncryptionKey   = errors.New("encryption key is not a base64 encoded x25519 public key")
+	ErrDuplicateEncryptionKey = errors.New("encryption key is already registered")
+	ErrTooManyEncryptionKeys  = errors.New("user has too many encryption keys")
+	ErrKeyRotationConflict    = errors.New("identity key was rotated concurrently")
+	ErrDeviceNotFound         = errors.New("device not found")
+	ErrDeviceRevoked          = errors.New("device key has been revoked")
+	ErrTooManyDevices         = errors.New("user has too many devices")
+	ErrInvalidDeviceName      = errors.New("device name is empty or too long")
+	ErrInvalidPasskey         = errors.New("passkey response is malformed or not made for this site")
+	ErrDuplicatePasskey       = errors.New("passkey is already registered")
+	ErrPasskeyNotFound        = errors.New("passkey not found")
+	ErrPasskeyCloned          = errors.New("passkey signature counter did not increase")
+	ErrTooManyPasskeys        = errors.New("user has too many passkeys")
+	ErrInvalidFingerprint     = errors.New("fingerprint must be 30 digits")
+)
+
+var (
+	ErrChallengeNotIssued = errors.New("challenge was not issued by the server")
+	ErrChallengeExpired   = errors.New("challenge has expired")
+	ErrChallengeConsumed  = errors.New("challenge has already been used")
+)
+
+var (
+	ErrInvalidToken        = errors.New("invalid token")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token has expired")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+)
+
+var (
+	ErrNoSigningKey        = errors.New("no signing key configured")
+	ErrUnknownSigningKeyID = errors.New("unknown signing key id")
+	ErrInvalidSigningKey   = errors.New("signing key is not a base64 encoded ed25519 seed or private key")
+	ErrDuplicateSigningKey = errors.New("signing key already in key set")
+)
+
+var (
+	ErrLogEntryNotFound = errors.New("no transparency log entry for this key")
+	ErrInvalidLogRange  = errors.New("log index or tree size is out of range")
+	ErrInvalidLogProof  = errors.New("transparency log proof does not verify")
+	ErrInvalidTreeHead  = errors.New("signed tree head does not verify")
+)
+
+var (
+	ErrBlobNotFound    = errors.New("blob not found")
+	ErrInvalidBlobKey  = errors.New("blob key is empty or leaves the store")
+	ErrBlobSizeInvalid = errors.New("blob does not have the expected size")
 )
 
 func WrapError(err error, message string) error {
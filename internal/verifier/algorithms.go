@@ -0,0 +1,93 @@
+package verifier
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"math/big"
+
+	"Drop-Key/internal/utils"
+)
+
+// Ed25519 verifies RFC 8032 signatures by 32-byte Ed25519 keys.
+type Ed25519 struct{}
+
+func (Ed25519) ValidatePublicKey(publicKey []byte) error {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return utils.ErrInvalidPublicKey
+	}
+	return nil
+}
+
+func (Ed25519) ValidateSignature(signature []byte) error {
+	if len(signature) != ed25519.SignatureSize {
+		return utils.ErrInvalidSignature
+	}
+	return nil
+}
+
+func (Ed25519) Verify(publicKey, message, signature []byte) bool {
+	return len(publicKey) == ed25519.PublicKeySize && ed25519.Verify(publicKey, message, signature)
+}
+
+const (
+	p256CoordinateSize = 32
+	p256PublicKeySize  = 1 + 2*p256CoordinateSize
+	// An ASN.1 ECDSA P-256 signature is a SEQUENCE of two INTEGERs of at
+	// most 33 bytes each.
+	maxP256ASN1SignatureSize = 72
+)
+
+// ECDSAP256 verifies ECDSA signatures over SHA-256 by P-256 keys, the
+// signatures TPMs, smart cards and WebCrypto produce. Keys are uncompressed
+// SEC 1 points (0x04 || X || Y). Signatures are accepted either ASN.1 DER
+// encoded or as the 64-byte concatenation r || s.
+type ECDSAP256 struct{}
+
+func (ECDSAP256) ValidatePublicKey(publicKey []byte) error {
+	_, err := parseP256PublicKey(publicKey)
+	return err
+}
+
+func (ECDSAP256) ValidateSignature(signature []byte) error {
+	if len(signature) < 8 || len(signature) > maxP256ASN1SignatureSize {
+		return utils.ErrInvalidSignature
+	}
+	return nil
+}
+
+func (ECDSAP256) Verify(publicKey, message, signature []byte) bool {
+	pub, err := parseP256PublicKey(publicKey)
+	if err != nil {
+		return false
+	}
+	digest := sha256.Sum256(message)
+	if ecdsa.VerifyASN1(pub, digest[:], signature) {
+		return true
+	}
+	// A few DER signatures are 64 bytes long too, so r || s is only tried
+	// once DER failed.
+	if len(signature) != 2*p256CoordinateSize {
+		return false
+	}
+	r := new(big.Int).SetBytes(signature[:p256CoordinateSize])
+	s := new(big.Int).SetBytes(signature[p256CoordinateSize:])
+	return ecdsa.Verify(pub, digest[:], r, s)
+}
+
+func parseP256PublicKey(publicKey []byte) (*ecdsa.PublicKey, error) {
+	if len(publicKey) != p256PublicKeySize {
+		return nil, utils.ErrInvalidPublicKey
+	}
+	// ecdh rejects points that are not on the curve.
+	if _, err := ecdh.P256().NewPublicKey(publicKey); err != nil {
+		return nil, utils.ErrInvalidPublicKey
+	}
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(publicKey[1 : 1+p256CoordinateSize]),
+		Y:     new(big.Int).SetBytes(publicKey[1+p256CoordinateSize:]),
+	}, nil
+}
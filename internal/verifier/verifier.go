@@ -0,0 +1,145 @@
+// Package verifier checks signatures made by user keys. Keys and signatures
+// travel base64 encoded and are tagged with an algorithm identifier; the
+// Registry maps each identifier to the Verifier that understands it.
+package verifier
+
+import (
+	"encoding/base64"
+	"sort"
+	"sync"
+
+	"Drop-Key/internal/utils"
+)
+
+// Algorithm identifiers stored with users and pastes. Records that predate
+// algorithm identifiers have none and are Ed25519.
+const (
+	AlgorithmEd25519   = "ed25519"
+	AlgorithmECDSAP256 = "ecdsa-p256"
+)
+
+// Verifier handles keys and signatures of one algorithm.
+type Verifier interface {
+	// ValidatePublicKey checks that publicKey is a well formed key in the
+	// encoding this algorithm stores keys in.
+	ValidatePublicKey(publicKey []byte) error
+	// ValidateSignature checks the shape of a signature without a key, so
+	// that malformed requests are told apart from forged ones.
+	ValidateSignature(signature []byte) error
+	Verify(publicKey, message, signature []byte) bool
+}
+
+type Registry struct {
+	mu        sync.RWMutex
+	verifiers map[string]Verifier
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		verifiers: make(map[string]Verifier),
+	}
+}
+
+// Default knows Ed25519 and ECDSA P-256. Further algorithms can be added with
+// Register.
+var Default = func() *Registry {
+	r := NewRegistry()
+	r.Register(AlgorithmEd25519, Ed25519{})
+	r.Register(AlgorithmECDSAP256, ECDSAP256{})
+	return r
+}()
+
+// Register makes v handle algorithm, replacing any earlier verifier.
+func (r *Registry) Register(algorithm string, v Verifier) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.verifiers[algorithm] = v
+}
+
+// Normalize returns the identifier algorithm is stored under.
+func Normalize(algorithm string) string {
+	if algorithm == "" {
+		return AlgorithmEd25519
+	}
+	return algorithm
+}
+
+func (r *Registry) Lookup(algorithm string) (Verifier, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	v, ok := r.verifiers[Normalize(algorithm)]
+	if !ok {
+		return nil, utils.ErrUnsupportedAlgorithm
+	}
+	return v, nil
+}
+
+// Algorithms lists the registered algorithm identifiers.
+func (r *Registry) Algorithms() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	algorithms := make([]string, 0, len(r.verifiers))
+	for algorithm := range r.verifiers {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Strings(algorithms)
+	return algorithms
+}
+
+// DecodePublicKey decodes a standard base64 public key of algorithm.
+func (r *Registry) DecodePublicKey(algorithm, publicKey string) ([]byte, error) {
+	v, err := r.Lookup(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || v.ValidatePublicKey(pub) != nil {
+		return nil, utils.ErrInvalidPublicKey
+	}
+	return pub, nil
+}
+
+// DecodeSignature decodes a standard base64 signature of algorithm.
+func (r *Registry) DecodeSignature(algorithm, signature string) ([]byte, error) {
+	v, err := r.Lookup(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || v.ValidateSignature(sig) != nil {
+		return nil, utils.ErrInvalidSignature
+	}
+	return sig, nil
+}
+
+// IsPublicKey reports whether publicKey is a valid key of any registered
+// algorithm, for lookups that do not say which algorithm they expect.
+func (r *Registry) IsPublicKey(publicKey string) bool {
+	for _, algorithm := range r.Algorithms() {
+		if _, err := r.DecodePublicKey(algorithm, publicKey); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks a base64 signature of message by a base64 public key of
+// algorithm.
+func (r *Registry) Verify(algorithm, publicKey string, message []byte, signature string) error {
+	v, err := r.Lookup(algorithm)
+	if err != nil {
+		return err
+	}
+	pub, err := r.DecodePublicKey(algorithm, publicKey)
+	if err != nil {
+		return err
+	}
+	sig, err := r.DecodeSignature(algorithm, signature)
+	if err != nil {
+		return err
+	}
+	if !v.Verify(pub, message, sig) {
+		return utils.ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package verifier
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+
+	"Drop-Key/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encode(b []byte) string {
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func TestVerify(t *testing.T) {
+	message := []byte("DropKey test message")
+
+	edPub, edPriv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	edSig := ed25519.Sign(edPriv, message)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	ecdhKey, err := ecKey.PublicKey.ECDH()
+	assert.NoError(t, err)
+	ecPub := ecdhKey.Bytes()
+	digest := sha256.Sum256(message)
+	derSig, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	assert.NoError(t, err)
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+	assert.NoError(t, err)
+	rawSig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+
+	tests := []struct {
+		name      string
+		algorithm string
+		publicKey []byte
+		signature []byte
+		message   []byte
+		want      error
+	}{
+		{"ed25519", AlgorithmEd25519, edPub, edSig, message, nil},
+		{"empty algorithm is ed25519", "", edPub, edSig, message, nil},
+		{"ed25519 wrong message", AlgorithmEd25519, edPub, edSig, []byte("other"), utils.ErrInvalidSignature},
+		{"ed25519 short signature", AlgorithmEd25519, edPub, edSig[:32], message, utils.ErrInvalidSignature},
+		{"p256 der", AlgorithmECDSAP256, ecPub, derSig, message, nil},
+		{"p256 raw", AlgorithmECDSAP256, ecPub, rawSig, message, nil},
+		{"p256 wrong message", AlgorithmECDSAP256, ecPub, derSig, []byte("other"), utils.ErrInvalidSignature},
+		{"p256 key as ed25519", AlgorithmEd25519, ecPub, derSig, message, utils.ErrInvalidPublicKey},
+		{"ed25519 key as p256", AlgorithmECDSAP256, edPub, edSig, message, utils.ErrInvalidPublicKey},
+		{"point not on curve", AlgorithmECDSAP256, append([]byte{4}, make([]byte, 64)...), derSig, message, utils.ErrInvalidPublicKey},
+		{"unknown algorithm", "rsa", edPub, edSig, message, utils.ErrUnsupportedAlgorithm},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Default.Verify(tt.algorithm, encode(tt.publicKey), tt.message, encode(tt.signature))
+			if tt.want == nil {
+				assert.NoError(t, err, "signature should verify")
+			} else {
+				assert.ErrorIs(t, err, tt.want)
+			}
+		})
+	}
+
+	assert.True(t, Default.IsPublicKey(encode(edPub)), "ed25519 key should be recognised")
+	assert.True(t, Default.IsPublicKey(encode(ecPub)), "p256 key should be recognised")
+	assert.False(t, Default.IsPublicKey("not a key"), "garbage should not be a key")
+}
+
+type acceptAll struct{}
+
+func (acceptAll) ValidatePublicKey([]byte) error { return nil }
+func (acceptAll) ValidateSignature([]byte) error { return nil }
+func (acceptAll) Verify(_, _, _ []byte) bool     { return true }
+
+func TestRegister(t *testing.T) {
+	r := NewRegistry()
+	_, err := r.Lookup(AlgorithmEd25519)
+	assert.ErrorIs(t, err, utils.ErrUnsupportedAlgorithm, "a new registry should be empty")
+
+	r.Register("test", acceptAll{})
+	r.Register(AlgorithmEd25519, Ed25519{})
+	assert.Equal(t, []string{AlgorithmEd25519, "test"}, r.Algorithms())
+	assert.NoError(t, r.Verify("test", encode([]byte("key")), nil, encode([]byte("sig"))))
+	assert.Equal(t, []string{AlgorithmECDSAP256, AlgorithmEd25519}, Default.Algorithms(), "registering elsewhere should not change Default")
+}